@@ -33,10 +33,7 @@ func (h *AlertHandler) RegisterRoutes(r *gin.Engine) {
 
 // ListAlerts 获取告警列表
 func (h *AlertHandler) ListAlerts(c *gin.Context) {
-	status := c.Query("status")
-	severity := c.Query("severity")
-
-	// TODO: 实现告警查询逻辑
+	// TODO: 实现告警查询逻辑，按 status 和 severity 查询参数过滤
 
 	c.JSON(http.StatusOK, gin.H{
 		"alerts": []interface{}{},
@@ -46,8 +43,6 @@ func (h *AlertHandler) ListAlerts(c *gin.Context) {
 
 // GetAlert 获取单个告警详情
 func (h *AlertHandler) GetAlert(c *gin.Context) {
-	id := c.Param("id")
-
 	// TODO: 实现告警查询逻辑
 
 	c.JSON(http.StatusOK, gin.H{
@@ -57,7 +52,6 @@ func (h *AlertHandler) GetAlert(c *gin.Context) {
 
 // UpdateAlertStatus 更新告警状态
 func (h *AlertHandler) UpdateAlertStatus(c *gin.Context) {
-	id := c.Param("id")
 	var req struct {
 		Status string `json:"status" binding:"required"`
 	}
@@ -74,7 +68,6 @@ func (h *AlertHandler) UpdateAlertStatus(c *gin.Context) {
 
 // AssignAlert 分配告警
 func (h *AlertHandler) AssignAlert(c *gin.Context) {
-	id := c.Param("id")
 	var req struct {
 		AssignTo string `json:"assign_to" binding:"required"`
 	}
@@ -91,7 +84,6 @@ func (h *AlertHandler) AssignAlert(c *gin.Context) {
 
 // ResolveAlert 解决告警
 func (h *AlertHandler) ResolveAlert(c *gin.Context) {
-	id := c.Param("id")
 	var req struct {
 		Resolution string `json:"resolution" binding:"required"`
 	}
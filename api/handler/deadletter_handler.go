@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// DeadLetterHandler exposes the dead letter queue of the log processor
+type DeadLetterHandler struct {
+	logProcessor *log.LogProcessor
+}
+
+// NewDeadLetterHandler creates a new dead letter handler
+func NewDeadLetterHandler(processor *log.LogProcessor) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		logProcessor: processor,
+	}
+}
+
+// RegisterRoutes registers the dead letter routes
+func (h *DeadLetterHandler) RegisterRoutes(r *gin.Engine) {
+	dlq := r.Group("/api/v1/dlq")
+	{
+		dlq.GET("", h.ListDeadLetters)
+		dlq.GET("/:id", h.GetDeadLetter)
+		dlq.DELETE("/:id", h.DeleteDeadLetter)
+		dlq.POST("/redrive", h.RedriveDeadLetters)
+	}
+}
+
+// ListDeadLetters lists dead letters, newest first
+func (h *DeadLetterHandler) ListDeadLetters(c *gin.Context) {
+	store := h.store(c)
+	if store == nil {
+		return
+	}
+
+	filter, ok := deadLetterFilter(c)
+	if !ok {
+		return
+	}
+
+	entries, total, err := store.List(c, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list dead letters: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": entries,
+		"total":        total,
+	})
+}
+
+// GetDeadLetter retrieves a single dead letter including the raw record
+func (h *DeadLetterHandler) GetDeadLetter(c *gin.Context) {
+	store := h.store(c)
+	if store == nil {
+		return
+	}
+
+	entry, err := h.get(c, store, c.Param("id"))
+	if errors.Is(err, log.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Dead letter not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve dead letter: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// DeleteDeadLetter discards a dead letter
+func (h *DeadLetterHandler) DeleteDeadLetter(c *gin.Context) {
+	store := h.store(c)
+	if store == nil {
+		return
+	}
+
+	id := c.Param("id")
+	_, err := h.get(c, store, id)
+	if errors.Is(err, log.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Dead letter not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve dead letter: " + err.Error(),
+		})
+		return
+	}
+	if err := store.Delete(c, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete dead letter: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Dead letter deleted",
+	})
+}
+
+// RedriveDeadLetters reprocesses dead letters, selected either by ID or by
+// the same filters as the list endpoint
+func (h *DeadLetterHandler) RedriveDeadLetters(c *gin.Context) {
+	store := h.store(c)
+	if store == nil {
+		return
+	}
+
+	var request struct {
+		IDs    []string `json:"ids"`
+		Stage  string   `json:"stage"`
+		Parser string   `json:"parser"`
+		Limit  int      `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	ids := request.IDs
+	if len(ids) == 0 {
+		limit := request.Limit
+		if limit <= 0 {
+			limit = 1000
+		}
+		entries, _, err := store.List(c, log.DeadLetterFilter{
+			Tenant: tenant.FromContext(c),
+			Stage:  request.Stage,
+			Parser: request.Parser,
+			Limit:  limit,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to list dead letters: " + err.Error(),
+			})
+			return
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	results, err := h.logProcessor.Redrive(c, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to redrive dead letters: " + err.Error(),
+			"results": results,
+		})
+		return
+	}
+
+	resolved := 0
+	for _, result := range results {
+		if result.Resolved {
+			resolved++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":    len(results),
+		"resolved": resolved,
+		"results":  results,
+	})
+}
+
+func (h *DeadLetterHandler) store(c *gin.Context) log.DeadLetterStore {
+	store := h.logProcessor.DeadLetters()
+	if store == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Dead letter queue not enabled",
+		})
+	}
+	return store
+}
+
+// get retrieves a dead letter of the request's tenant. Dead letters of other
+// tenants are reported as not found.
+func (h *DeadLetterHandler) get(c *gin.Context, store log.DeadLetterStore, id string) (*log.DeadLetter, error) {
+	entry, err := store.Get(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.Normalize(entry.TenantID) != tenant.FromContext(c) {
+		return nil, log.ErrDeadLetterNotFound
+	}
+	return entry, nil
+}
+
+// deadLetterFilter parses the list query parameters, answering with 400 on
+// invalid input. Only dead letters of the request's tenant are listed.
+func deadLetterFilter(c *gin.Context) (log.DeadLetterFilter, bool) {
+	filter := log.DeadLetterFilter{
+		Tenant: tenant.FromContext(c),
+		Stage:  c.Query("stage"),
+		Parser: c.Query("parser"),
+		Limit:  100,
+	}
+
+	invalid := func(message string) (log.DeadLetterFilter, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+		return filter, false
+	}
+
+	var err error
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return invalid("Invalid since time format")
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return invalid("Invalid until time format")
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			return invalid("Invalid limit")
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			return invalid("Invalid offset")
+		}
+	}
+	return filter, true
+}
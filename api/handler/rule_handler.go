@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/rule"
+)
+
+// RuleHandler 处理规则运行状态相关的HTTP请求
+type RuleHandler struct {
+	engine *rule.Engine
+}
+
+// NewRuleHandler 创建新的规则处理器
+func NewRuleHandler(engine *rule.Engine) *RuleHandler {
+	return &RuleHandler{
+		engine: engine,
+	}
+}
+
+// RegisterRoutes 注册规则相关路由
+func (h *RuleHandler) RegisterRoutes(r *gin.Engine) {
+	rules := r.Group("/api/v1/rules")
+	{
+		rules.GET("/state", h.ListRuleStates)
+		rules.GET("/:id/state", h.GetRuleState)
+	}
+}
+
+// ListRuleStates 获取当前租户可见的阈值和序列规则的运行状态
+func (h *RuleHandler) ListRuleStates(c *gin.Context) {
+	states := h.engine.RuleStates(tenant.FromContext(c))
+	c.JSON(http.StatusOK, gin.H{
+		"rules": states,
+		"total": len(states),
+	})
+}
+
+// GetRuleState 获取单个有状态规则的统计和未完成的序列
+func (h *RuleHandler) GetRuleState(c *gin.Context) {
+	id := c.Param("id")
+	for _, state := range h.engine.RuleStates(tenant.FromContext(c)) {
+		if state.RuleID == id {
+			c.JSON(http.StatusOK, gin.H{"rule": state})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "有状态规则不存在"})
+}
@@ -1,17 +1,29 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinye/securityai/internal/domain/entity"
 	"github.com/jinye/securityai/internal/domain/repository"
+	"github.com/jinye/securityai/internal/domain/schema"
 	"github.com/jinye/securityai/internal/service/log"
 )
 
+// logService processes raw logs, either directly or through a pipeline
+type logService interface {
+	ProcessLog(ctx context.Context, rawLog string) (*log.ProcessResult, error)
+	BatchProcessLogs(ctx context.Context, logs []string) ([]*log.ProcessResult, error)
+}
+
 // SecurityHandler handles security-related HTTP requests
 type SecurityHandler struct {
 	logProcessor *log.LogProcessor
+	pipeline     *log.Pipeline
 	repository   repository.EventRepository
 }
 
@@ -26,6 +38,18 @@ func NewSecurityHandler(
 	}
 }
 
+// SetPipeline routes log processing requests through a concurrent pipeline
+func (h *SecurityHandler) SetPipeline(pipeline *log.Pipeline) {
+	h.pipeline = pipeline
+}
+
+func (h *SecurityHandler) logs() logService {
+	if h.pipeline != nil {
+		return h.pipeline
+	}
+	return h.logProcessor
+}
+
 // RegisterRoutes registers all the handler routes
 func (h *SecurityHandler) RegisterRoutes(r *gin.Engine) {
 	api := r.Group("/api/v1")
@@ -33,6 +57,9 @@ func (h *SecurityHandler) RegisterRoutes(r *gin.Engine) {
 		// Log processing endpoints
 		api.POST("/logs", h.ProcessLogs)
 		api.POST("/logs/batch", h.BatchProcessLogs)
+		api.GET("/logs/pipeline", h.GetPipelineStats)
+		api.POST("/logs/parse", h.ParseLog)
+		api.POST("/mappings/test", h.TestMappings)
 
 		// Event query endpoints
 		api.GET("/events/:id", h.GetEvent)
@@ -51,7 +78,8 @@ func (h *SecurityHandler) RegisterRoutes(r *gin.Engine) {
 // ProcessLogs handles single log processing requests
 func (h *SecurityHandler) ProcessLogs(c *gin.Context) {
 	var request struct {
-		Log string `json:"log" binding:"required"`
+		Log        string `json:"log" binding:"required"`
+		SourceType string `json:"source_type"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -61,22 +89,44 @@ func (h *SecurityHandler) ProcessLogs(c *gin.Context) {
 		return
 	}
 
-	if err := h.logProcessor.ProcessLog(c, request.Log); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to process log: " + err.Error(),
+	var result *log.ProcessResult
+	var err error
+	if request.SourceType != "" {
+		result, err = h.logProcessor.ProcessLogAs(c, request.SourceType, request.Log)
+	} else {
+		result, err = h.logs().ProcessLog(c, request.Log)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		var detectErr *log.DetectionError
+		var parseErr *log.ParseError
+		if errors.As(err, &detectErr) || errors.As(err, &parseErr) {
+			status = http.StatusUnprocessableEntity
+		}
+		if errors.Is(err, log.ErrUnknownParser) {
+			status = http.StatusBadRequest
+		}
+		if h.rejected(c, err) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{
+			"error":  "Failed to process log: " + err.Error(),
+			"result": result,
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Log processed successfully",
+		"result":  result,
 	})
 }
 
 // BatchProcessLogs handles batch log processing requests
 func (h *SecurityHandler) BatchProcessLogs(c *gin.Context) {
 	var request struct {
-		Logs []string `json:"logs" binding:"required"`
+		Logs       []string `json:"logs" binding:"required"`
+		SourceType string   `json:"source_type"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -86,20 +136,183 @@ func (h *SecurityHandler) BatchProcessLogs(c *gin.Context) {
 		return
 	}
 
-	if err := h.logProcessor.BatchProcessLogs(c, request.Logs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to process logs: " + err.Error(),
+	if request.SourceType != "" {
+		if _, ok := h.logProcessor.Parsers().Get(request.SourceType); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unknown source type: " + request.SourceType,
+			})
+			return
+		}
+	}
+
+	var results []*log.ProcessResult
+	var err error
+	if request.SourceType != "" {
+		results, err = h.logProcessor.BatchProcessLogsAs(c, request.SourceType, request.Logs)
+	} else {
+		results, err = h.logs().BatchProcessLogs(c, request.Logs)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if h.rejected(c, err) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{
+			"error":   "Failed to process logs: " + err.Error(),
+			"results": results,
 		})
 		return
 	}
 
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Logs processed successfully",
+		"total":   len(results),
+		"failed":  failed,
+		"results": results,
+	})
+}
+
+// ParseLog parses a log without processing or storing it, to check how a
+// line would be decoded
+func (h *SecurityHandler) ParseLog(c *gin.Context) {
+	var request struct {
+		Log        string `json:"log" binding:"required"`
+		SourceType string `json:"source_type"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	parsers := h.logProcessor.Parsers()
+	var event *entity.SecurityEvent
+	parser := request.SourceType
+	var err error
+	if parser != "" {
+		event, err = parsers.ParseWith(parser, request.Log)
+	} else {
+		event, parser, err = parsers.Parse(request.Log)
+	}
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, log.ErrUnknownParser) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error":  "Failed to parse log: " + err.Error(),
+			"parser": parser,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"parser": parser,
+		"event":  event,
 	})
 }
 
-// GetEvent retrieves a single security event
+// TestMappings validates a YAML mapping document and applies it to sample
+// lines without registering it
+func (h *SecurityHandler) TestMappings(c *gin.Context) {
+	var request struct {
+		Spec string   `json:"spec" binding:"required"`
+		Logs []string `json:"logs"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	parsers, err := log.LoadMappings([]byte(request.Spec))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	type mappingResult struct {
+		Mapping string                `json:"mapping"`
+		Matched bool                  `json:"matched"`
+		Event   *entity.SecurityEvent `json:"event,omitempty"`
+		Error   string                `json:"error,omitempty"`
+	}
+	results := make([][]mappingResult, len(request.Logs))
+	for i, line := range request.Logs {
+		for _, parser := range parsers {
+			result := mappingResult{
+				Mapping: parser.Name(),
+				Matched: parser.Detect(line) > 0,
+			}
+			if event, err := parser.Parse(line); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Event = event
+			}
+			results[i] = append(results[i], result)
+		}
+	}
+
+	mappings := make([]string, len(parsers))
+	for i, parser := range parsers {
+		mappings[i] = parser.Name()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mappings": mappings,
+		"results":  results,
+	})
+}
+
+// rejected reports whether the pipeline refused work and, if so, tells the
+// client when to retry
+func (h *SecurityHandler) rejected(c *gin.Context, err error) bool {
+	if !errors.Is(err, log.ErrBackpressure) && !errors.Is(err, log.ErrPipelineClosed) {
+		return false
+	}
+	if h.pipeline != nil {
+		retry := int(h.pipeline.RetryAfter().Seconds() + 0.5)
+		if retry < 1 {
+			retry = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retry))
+	}
+	return true
+}
+
+// GetPipelineStats reports queue depth and throughput of the processing pipeline
+func (h *SecurityHandler) GetPipelineStats(c *gin.Context) {
+	if h.pipeline == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Pipeline not enabled",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.pipeline.Stats())
+}
+
+// GetEvent retrieves a single security event. The format query parameter
+// selects an "ecs" or "ocsf" rendering instead of the native one.
 func (h *SecurityHandler) GetEvent(c *gin.Context) {
+	format, ok := eventFormat(c)
+	if !ok {
+		return
+	}
+
 	id := c.Param("id")
 	event, err := h.repository.FindEventByID(c, id)
 	if err != nil {
@@ -109,11 +322,16 @@ func (h *SecurityHandler) GetEvent(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, event)
+	c.JSON(http.StatusOK, format(event))
 }
 
 // ListEvents lists security events with filtering
 func (h *SecurityHandler) ListEvents(c *gin.Context) {
+	format, ok := eventFormat(c)
+	if !ok {
+		return
+	}
+
 	// Parse time range parameters
 	startStr := c.Query("start")
 	endStr := c.Query("end")
@@ -149,7 +367,28 @@ func (h *SecurityHandler) ListEvents(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, events)
+	formatted := make([]interface{}, len(events))
+	for i, event := range events {
+		formatted[i] = format(event)
+	}
+	c.JSON(http.StatusOK, formatted)
+}
+
+// eventFormat resolves the format query parameter, answering with 400 on
+// unknown formats
+func eventFormat(c *gin.Context) (func(*entity.SecurityEvent) interface{}, bool) {
+	switch c.DefaultQuery("format", "native") {
+	case "native":
+		return func(event *entity.SecurityEvent) interface{} { return event }, true
+	case "ecs":
+		return func(event *entity.SecurityEvent) interface{} { return schema.ToECS(event) }, true
+	case "ocsf":
+		return func(event *entity.SecurityEvent) interface{} { return schema.ToOCSF(event) }, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Invalid format, expected native, ecs or ocsf",
+	})
+	return nil, false
 }
 
 // ListAnomalies lists detected anomalies
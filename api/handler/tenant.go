@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+// NewTenantMiddleware resolves the tenant of each request from its API key,
+// given in the X-API-Key header or as a bearer token. Handlers see the tenant
+// through tenant.FromContext on the gin context, so repositories and the log
+// processor only touch that tenant's data. Without configured keys all
+// requests act for the default tenant.
+func NewTenantMiddleware(config tenant.Config) (gin.HandlerFunc, error) {
+	keys := make(map[string]string, len(config.APIKeys))
+	for key, id := range config.APIKeys {
+		if key == "" {
+			return nil, fmt.Errorf("empty api key for tenant %s", id)
+		}
+		if err := tenant.Validate(id); err != nil {
+			return nil, err
+		}
+		keys[key] = id
+	}
+
+	return func(c *gin.Context) {
+		id := tenant.Default
+		if len(keys) > 0 {
+			var ok bool
+			if id, ok = keys[apiKey(c)]; !ok {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": "Missing or unknown API key",
+				})
+				return
+			}
+		}
+
+		c.Set(tenant.ContextKey, id)
+		c.Request = c.Request.WithContext(tenant.WithTenant(c.Request.Context(), id))
+		c.Next()
+	}, nil
+}
+
+// apiKey returns the API key of a request
+func apiKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	auth := c.GetHeader("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
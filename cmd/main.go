@@ -1,163 +1,299 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"sync"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"context"
-
-	"github.com/jinye/securityai/internal/ai/anomaly" // Assuming this is the package for SimpleAnomalyDetector
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/gin-gonic/gin"
 	"github.com/jinye/securityai/api/handler"
 	"github.com/jinye/securityai/internal/ai/anomaly"
-	"github.com/jinye/securityai/internal/service/log"
+	"github.com/jinye/securityai/internal/config"
+	"github.com/jinye/securityai/internal/domain/repository"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	cache "github.com/jinye/securityai/internal/infrastructure/cache"
+	"github.com/jinye/securityai/internal/infrastructure/cmdb"
+	"github.com/jinye/securityai/internal/infrastructure/directory"
+	"github.com/jinye/securityai/internal/infrastructure/geoip"
+	"github.com/jinye/securityai/internal/infrastructure/storage"
+	"github.com/jinye/securityai/internal/infrastructure/threatintel"
+	"github.com/jinye/securityai/internal/rule"
+	"github.com/jinye/securityai/internal/service/alert"
+	"github.com/jinye/securityai/internal/service/ingest"
+	logservice "github.com/jinye/securityai/internal/service/log"
+	"github.com/jinye/securityai/internal/service/redact"
+)
+
+const (
+	// ruleAdvanceInterval is how often stateful rules close expired windows
+	ruleAdvanceInterval = 10 * time.Second
+	// shutdownTimeout bounds the wait for requests in flight on shutdown
+	shutdownTimeout = 15 * time.Second
 )
 
 func main() {
-	ctx := context.Background()
-
-	// 2. Create simple in-memory implementations for repositories and enricher
-	eventRepo := NewInMemoryEventRepository()
-	cacheRepo := NewInMemoryCacheRepository()
-	enricher := NewInMemoryLogEnricher()
-
-	// 3. Initialize SimpleAnomalyDetector
-	// Assuming anomaly.SimpleAnomalyDetector exists and has a NewSimpleAnomalyDetector constructor
-	// If the existing anomaly.AnomalyDetector in processor.go is meant to be SimpleAnomalyDetector,
-	// we need to make sure its constructor matches. For now, let's assume NewSimpleAnomalyDetector exists.
-	detector := anomaly.NewSimpleAnomalyDetector() // Assuming this constructor exists
-
-	// 4. Create a LogProcessor instance
-	logProcessor := log.NewLogProcessor(detector, eventRepo, cacheRepo, enricher)
-
-	// 5. Create some sample JSON log strings
-	sampleLogs := []string{
-		`{"timestamp": "2023-10-27T10:00:00Z", "source_ip": "192.168.1.10", "dest_ip": "10.0.0.1", "protocol": "TCP", "event_type": "connection", "description": "Successful connection"}`,
-		`{"timestamp": "2023-10-27T10:00:05Z", "source_ip": "192.168.1.10", "dest_ip": "10.0.0.2", "protocol": "UDP", "event_type": "connection", "description": "Successful connection"}`,
-		`{"timestamp": "2023-10-27T10:00:10Z", "source_ip": "192.168.1.11", "dest_ip": "10.0.0.3", "protocol": "TCP", "event_type": "login_fail", "description": "Authentication failure"}`,
-		`{"timestamp": "2023-10-27T10:00:12Z", "source_ip": "192.168.1.11", "dest_ip": "10.0.0.3", "protocol": "TCP", "event_type": "login_fail", "description": "Authentication failure"}`,
-		`{"timestamp": "2023-10-27T10:00:15Z", "source_ip": "192.168.1.11", "dest_ip": "10.0.0.3", "protocol": "TCP", "event_type": "login_fail", "description": "Authentication failure"}`,
-		// Add more logs to trigger the simple anomaly detector (e.g., same IP appearing many times)
-		`{"timestamp": "2023-10-27T10:01:00Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.5", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:01Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.6", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:02Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.7", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:03Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.8", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:04Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.9", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:05Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.10", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:06Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.11", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:07Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.12", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:08Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.13", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:09Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.14", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`,
-		`{"timestamp": "2023-10-27T10:01:10Z", "source_ip": "172.16.0.100", "dest_ip": "10.0.1.15", "protocol": "TCP", "event_type": "port_scan", "description": "Attempted port scan"}`, // This one should trigger anomaly
-	}
-
-	// 6. Iterate through the sample logs and process them
-	log.Println("Processing sample logs...")
-	for _, rawLog := range sampleLogs {
-		err := logProcessor.ProcessLog(ctx, rawLog)
-		if err != nil {
-			log.Printf("Error processing log: %v", err)
-		}
+	configPath := flag.String("config", "config/config.yaml", "path of the YAML configuration file")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("configuration file %s not found, using defaults", *configPath)
+		cfg, err = config.DefaultConfig(), nil
+	}
+	if err != nil {
+		log.Fatalf("load configuration: %v", err)
 	}
 
-	// 7. Print out the saved events and detected anomalies
-	log.Println("\n--- Processed Results ---")
-	fmt.Printf("Saved Events (%d):\n", len(eventRepo.events))
-	for _, event := range eventRepo.events {
-		fmt.Printf("- %+v\n", event)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg); err != nil {
+		log.Fatal(err)
 	}
+}
+
+// run builds the service from the configuration and serves until ctx is
+// cancelled. Subsystems are stopped in reverse order of their start, so that
+// the ingestion sources drain into the pipeline before it stops.
+func run(ctx context.Context, cfg *config.Config) error {
+	var cleanups []func()
+	defer func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}()
 
-	fmt.Printf("\nDetected Anomalies (%d):\n", len(eventRepo.anomalies))
-	for _, anomaly := range eventRepo.anomalies {
-		fmt.Printf("- %+v\n", anomaly)
+	// Storage
+	esConfig := elasticsearch.Config{Addresses: cfg.Storage.Elasticsearch.Hosts}
+	events, err := storage.NewElasticsearchRepository(esConfig, cfg.Storage.Elasticsearch.IndexPrefix)
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	esClient, err := elasticsearch.NewClient(esConfig)
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
 	}
+	redis := cache.NewRedisCache(cfg.Storage.Redis.Addr, cfg.Storage.Redis.Password, cfg.Storage.Redis.DB)
+	cleanups = append(cleanups, func() { redis.Close() })
 
-}
+	// Enrichment
+	enricher, stopEnrichment, err := newEnricher(ctx, cfg, redis)
+	cleanups = append(cleanups, stopEnrichment)
+	if err != nil {
+		return err
+	}
+
+	// Log processing
+	processor := logservice.NewLogProcessor(anomaly.NewSimpleAnomalyDetector(), events, redis, enricher)
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		return fmt.Errorf("redaction: %w", err)
+	}
+	processor.SetRedactor(redactor)
+	if err := processor.SetDedupConfig(cfg.Ingest.Dedup); err != nil {
+		return fmt.Errorf("dedup: %w", err)
+	}
+	if cfg.Ingest.Mappings != "" {
+		mappings, err := logservice.LoadMappingFile(cfg.Ingest.Mappings)
+		if err != nil {
+			return fmt.Errorf("mappings: %w", err)
+		}
+		for _, mapping := range mappings {
+			if err := processor.Parsers().Register(mapping); err != nil {
+				return fmt.Errorf("mappings: %w", err)
+			}
+		}
+	}
+	deadLetters, err := logservice.NewFileDeadLetterStore(cfg.Ingest.DeadLetter)
+	if err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	cleanups = append(cleanups, func() { deadLetters.Close() })
+	processor.SetDeadLetterStore(deadLetters)
 
-// 8. Add necessary struct definitions for in-memory repositories and enricher
+	// Rules and alerts
+	alerts := alert.NewAlertManager(nil)
+	engine := rule.NewEngine()
+	engine.SetMatchHandler(alerts.ProcessRuleResult)
+	ruleStore := storage.NewRuleStore(esClient, cfg.Storage.Elasticsearch.IndexPrefix)
+	if err := loadRules(ctx, ruleStore, rule.NewRuleManager(ruleStore, engine), engine, cfg.Tenancy); err != nil {
+		return err
+	}
+	processor.SetRuleEvaluator(engine)
+	engine.Start(ctx, ruleAdvanceInterval)
+	cleanups = append(cleanups, func() { engine.Stop(context.Background()) })
 
-// InMemoryEventRepository implements repository.EventRepository using maps
-type InMemoryEventRepository struct {
-	events    []*entity.SecurityEvent
-	anomalies []*entity.SecurityAnalysis
-	mu        sync.Mutex
-}
+	pipeline := logservice.NewPipeline(processor, cfg.Ingest.Pipeline)
+	pipeline.Start(ctx)
+	cleanups = append(cleanups, pipeline.Stop)
 
-func NewInMemoryEventRepository() *InMemoryEventRepository {
-	return &InMemoryEventRepository{
-		events:    make([]*entity.SecurityEvent, 0),
-		anomalies: make([]*entity.SecurityAnalysis, 0),
+	// Ingestion sources
+	syslog := cfg.Ingest.Syslog
+	if syslog.UDPAddr != "" || syslog.TCPAddr != "" || syslog.TLSAddr != "" {
+		listener := ingest.NewSyslogListener(syslog, pipeline)
+		if err := listener.Start(ctx); err != nil {
+			return fmt.Errorf("syslog listener: %w", err)
+		}
+		cleanups = append(cleanups, listener.Stop)
+	}
+	if len(cfg.Ingest.Tail.Paths) > 0 {
+		tailer := ingest.NewFileTailer(cfg.Ingest.Tail, pipeline)
+		if err := tailer.Start(ctx); err != nil {
+			return fmt.Errorf("file tailer: %w", err)
+		}
+		cleanups = append(cleanups, func() {
+			if err := tailer.Stop(); err != nil {
+				log.Printf("stop file tailer: %v", err)
+			}
+		})
 	}
-}
 
-func (r *InMemoryEventRepository) SaveEvent(ctx context.Context, event *entity.SecurityEvent) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.events = append(r.events, event)
+	// HTTP API
+	if cfg.Server.Mode != "" {
+		gin.SetMode(cfg.Server.Mode)
+	}
+	tenants, err := handler.NewTenantMiddleware(cfg.Tenancy)
 	if err != nil {
-		return fmt.Errorf("failed to marshal event for saving: %w", err)
+		return fmt.Errorf("tenancy: %w", err)
 	}
+	router := gin.New()
+	router.Use(gin.Logger(), gin.Recovery(), tenants)
 
-	// In a real application, you would store this in a database like Elasticsearch
-	return nil
-}
+	security := handler.NewSecurityHandler(processor, events)
+	security.SetPipeline(pipeline)
+	security.RegisterRoutes(router)
+	handler.NewDeadLetterHandler(processor).RegisterRoutes(router)
+	handler.NewRuleHandler(engine).RegisterRoutes(router)
+	handler.NewAlertHandler(alerts).RegisterRoutes(router)
 
-func (r *InMemoryEventRepository) SaveAnomaly(ctx context.Context, anomaly *entity.SecurityAnalysis) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.anomalies = append(r.anomalies, anomaly)
-	// In a real application, you would store this in a database
-	return nil
-}
+	server := &http.Server{Addr: ":" + cfg.Server.Port, Handler: router}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
 
-func (r *InMemoryEventRepository) GetEvent(ctx context.Context, id string) (*entity.SecurityEvent, error) {
-	// This simple implementation doesn't support retrieval by ID
-	return nil, fmt.Errorf("GetEvent not implemented for InMemoryEventRepository")
-}
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
 
-// InMemoryCacheRepository implements repository.CacheRepository using a map
-type InMemoryCacheRepository struct {
-	cache map[string]interface{}
-	mu    sync.Mutex
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
 
-func NewInMemoryCacheRepository() *InMemoryCacheRepository {
-	return &InMemoryCacheRepository{
-		cache: make(map[string]interface{}),
+// newEnricher opens the configured enrichment sources and builds the
+// enrichment chain. The returned function stops the reloading of the sources
+// and is valid even when an error is returned.
+func newEnricher(ctx context.Context, cfg *config.Config, shared repository.CacheRepository) (*logservice.LogEnricher, func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
 	}
-}
 
-func (r *InMemoryCacheRepository) Get(ctx context.Context, key string) (interface{}, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	value, ok := r.cache[key]
-	if !ok {
-		return nil, fmt.Errorf("key not found in cache")
+	var geo logservice.GeoIPDatabase
+	if cfg.GeoIP.CityPath != "" || cfg.GeoIP.ASNPath != "" {
+		db, err := geoip.NewDatabase(cfg.GeoIP)
+		if err != nil {
+			return nil, stop, fmt.Errorf("geoip: %w", err)
+		}
+		db.Start(ctx)
+		stops = append(stops, db.Stop)
+		geo = db
 	}
-	return value, nil
-}
 
-func (r *InMemoryCacheRepository) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.cache[key] = value
-	// In a real application, you would handle expiration
-	return nil
-}
+	var threats logservice.ThreatDB
+	if len(cfg.ThreatIntel.Paths) > 0 {
+		store, err := threatintel.NewStore(cfg.ThreatIntel)
+		if err != nil {
+			return nil, stop, fmt.Errorf("threat intelligence: %w", err)
+		}
+		store.Start(ctx)
+		stops = append(stops, store.Stop)
+		threats = store
+	}
 
-// InMemoryLogEnricher implements log.LogEnricher (assuming an interface exists)
-type InMemoryLogEnricher struct {
-}
+	enricher := logservice.NewLogEnricher(geo, threats)
+	if len(cfg.Enrichment.Assets.Paths) > 0 {
+		inventory, err := cmdb.NewInventory(cfg.Enrichment.Assets)
+		if err != nil {
+			return nil, stop, fmt.Errorf("asset inventory: %w", err)
+		}
+		inventory.Start(ctx)
+		stops = append(stops, inventory.Stop)
+		enricher.SetAssetInventory(inventory)
+	}
+	if len(cfg.Enrichment.Directory.Paths) > 0 {
+		users, err := directory.NewDirectory(cfg.Enrichment.Directory)
+		if err != nil {
+			return nil, stop, fmt.Errorf("user directory: %w", err)
+		}
+		users.Start(ctx)
+		stops = append(stops, users.Stop)
+		enricher.SetUserDirectory(users)
+	}
 
-func NewInMemoryLogEnricher() *InMemoryLogEnricher {
-	return &InMemoryLogEnricher{}
+	if err := enricher.SetCacheConfig(cfg.Enrichment.Cache, shared); err != nil {
+		return nil, stop, fmt.Errorf("enrichment cache: %w", err)
+	}
+	if err := enricher.SetChainConfig(cfg.Enrichment.Chain); err != nil {
+		return nil, stop, fmt.Errorf("enrichment chain: %w", err)
+	}
+	if cfg.Enrichment.Scoring != "" {
+		policy, err := logservice.LoadScoringPolicyFile(cfg.Enrichment.Scoring)
+		if err != nil {
+			return nil, stop, fmt.Errorf("scoring policy: %w", err)
+		}
+		if err := enricher.SetScoringPolicy(policy); err != nil {
+			return nil, stop, fmt.Errorf("scoring policy: %w", err)
+		}
+	}
+	return enricher, stop, nil
 }
 
-func (e *InMemoryLogEnricher) Enrich(ctx context.Context, event *entity.SecurityEvent) error {
-	// Simple enrichment: add a dummy field
-	event.EnrichedData = map[string]interface{}{
-		"enrich_status": "simulated_enrichment_successful",
+// loadRules loads the active rules of the rule store into the engine: the
+// shared rules and the own rules of every tenant with an API key. Rules that
+// no longer compile are skipped so that one bad rule does not stop the
+// service.
+func loadRules(ctx context.Context, store repository.RuleStore, rules *rule.RuleManager, engine *rule.Engine, tenancy tenant.Config) error {
+	tenants := []string{tenant.Default}
+	seen := map[string]bool{tenant.Default: true}
+	for _, id := range tenancy.APIKeys {
+		if !seen[id] {
+			seen[id] = true
+			tenants = append(tenants, id)
+		}
+	}
+
+	for _, id := range tenants {
+		definitions, err := store.ListRules(tenant.WithTenant(ctx, id), repository.RuleFilter{Status: "active"})
+		if err != nil {
+			return fmt.Errorf("load rules of tenant %s: %w", id, err)
+		}
+		for _, definition := range definitions {
+			// Shared rules are read with every tenant's rules but loaded once
+			if tenant.Normalize(definition.TenantID) != id {
+				continue
+			}
+			compiled, err := rules.ConvertToEngineRule(definition)
+			if err != nil {
+				log.Printf("skip rule %s: %v", definition.ID, err)
+				continue
+			}
+			engine.AddRule(compiled)
+		}
 	}
 	return nil
 }
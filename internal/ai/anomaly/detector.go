@@ -1,3 +1,9 @@
+// The model-based detector is written against an eino engine API (Engine,
+// Model, ModelBuilder) that the eino release in go.mod does not provide, so it
+// is only built with the eino_engine tag.
+
+//go:build eino_engine
+
 package anomaly
 
 import (
@@ -1,3 +1,9 @@
+// The model-based detector is written against an eino engine API (Engine,
+// Model, ModelBuilder) that the eino release in go.mod does not provide, so it
+// is only built with the eino_engine tag.
+
+//go:build eino_engine
+
 package anomaly
 
 import (
@@ -145,11 +151,11 @@ func (m *AnomalyModel) normalizeFeatures(features []float32) []float32 {
 }
 
 // calculateReconstructionError calculates the reconstruction error
-func (m *AmomalyModel) calculateReconstructionError(original, reconstructed []float32) float32 {
+func (m *AnomalyModel) calculateReconstructionError(original, reconstructed []float32) float32 {
 	var error float32
 	for i := range original {
 		diff := original[i] - reconstructed[i]
 		error += diff * diff
 	}
 	return error
-}
\ No newline at end of file
+}
@@ -2,60 +2,48 @@ package anomaly
 
 import (
 	"context"
-	"fmt"
-	"time"
 
 	"github.com/jinye/securityai/internal/domain/entity"
 )
 
-// AnomalyDetector defines the interface for anomaly detection
-// Assuming this interface is defined elsewhere, e.g., in an interfaces.go file
-// type AnomalyDetector interface {
-// 	ProcessEvents(ctx context.Context, events []*entity.SecurityEvent) ([]*entity.SecurityAnalysis, error)
-// }
-
-// SimpleAnomalyDetector implements a basic anomaly detection logic
+// SimpleAnomalyDetector flags source IPs that dominate a batch. It needs no
+// model and serves as the detector when none is configured.
 type SimpleAnomalyDetector struct {
-	// Configuration or state can be added here if needed
+	// threshold is the number of events from one source IP in a batch above
+	// which the source is anomalous
+	threshold int
 }
 
 // NewSimpleAnomalyDetector creates a new SimpleAnomalyDetector instance
 func NewSimpleAnomalyDetector() *SimpleAnomalyDetector {
-	return &SimpleAnomalyDetector{}
+	return &SimpleAnomalyDetector{threshold: 10}
 }
 
-// ProcessEvents processes a batch of security events to detect simple anomalies
-func (d *SimpleAnomalyDetector) ProcessEvents(ctx context.Context, events []*entity.SecurityEvent) ([]*entity.SecurityAnalysis, error) {
-	anomalies := []*entity.SecurityAnalysis{}
+// ProcessEvents reports one anomaly for every source IP with more than the
+// threshold of events in the batch, linked to the first of its events
+func (d *SimpleAnomalyDetector) ProcessEvents(ctx context.Context, events []*entity.SecurityEvent) ([]*entity.AnomalyResult, error) {
 	ipCounts := make(map[string]int)
-
-	// Count occurrences of source IPs
 	for _, event := range events {
-		ipCounts[event.SourceIP]++
+		if event.SourceIP != "" {
+			ipCounts[event.SourceIP]++
+		}
 	}
 
-	// Check for source IPs with high counts
-	for ip, count := range ipCounts {
-		if count > 10 { // Simple threshold: more than 10 events from the same source IP in the batch
-			// Create an anomaly analysis entry
-			anomaly := entity.NewSecurityAnalysis()
-			anomaly.Timestamp = time.Now() // Use current time for analysis time
-			anomaly.EventType = "SimpleAnomalyDetection"
-			anomaly.Description = fmt.Sprintf("Potential anomaly: Source IP %s appeared %d times in the batch.", ip, count)
-			anomaly.Severity = "Medium" // Assign a severity level
-			anomaly.Source = "SimpleAnomalyDetector"
-
-			// Find one of the events related to this IP to link the analysis
-			for _, event := range events {
-				if event.SourceIP == ip {
-					anomaly.EventID = event.ID // Assuming SecurityEvent has an ID field
-					break
-				}
-			}
-
-			anomalies = append(anomalies, anomaly)
+	anomalies := make([]*entity.AnomalyResult, 0)
+	reported := make(map[string]bool)
+	for _, event := range events {
+		count := ipCounts[event.SourceIP]
+		if count <= d.threshold || reported[event.SourceIP] {
+			continue
 		}
+		reported[event.SourceIP] = true
+
+		score := float32(count) / float32(len(events))
+		anomaly := entity.NewAnomalyResult(event.ID, score)
+		anomaly.AnomalyType = "source_ip_burst"
+		anomaly.Confidence = score
+		anomalies = append(anomalies, anomaly)
 	}
 
 	return anomalies, nil
-}
\ No newline at end of file
+}
@@ -2,7 +2,6 @@ package qianxin
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -42,11 +41,7 @@ func NewQianxinModel(config ModelConfig) *QianxinModel {
 
 // DetectAnomaly 执行异常检测
 func (m *QianxinModel) DetectAnomaly(ctx context.Context, input map[string]interface{}) (*DetectionResult, error) {
-	// 创建带超时的上下文
-	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(m.timeoutSec)*time.Second)
-	defer cancel()
-
-	// TODO: 实现与奇安信API的实际交互
+	// TODO: 实现与奇安信API的实际交互，请求使用 m.timeoutSec 超时
 	// 1. 准备请求数据
 	// 2. 发送HTTP请求
 	// 3. 解析响应
@@ -59,7 +54,7 @@ func (m *QianxinModel) DetectAnomaly(ctx context.Context, input map[string]inter
 		Description: "检测到异常进程行为",
 		Details: map[string]string{
 			"process_name": "unknown.exe",
-			"behavior":    "unauthorized_access",
+			"behavior":     "unauthorized_access",
 		},
 		Timestamp: time.Now(),
 	}
@@ -86,4 +81,4 @@ func (m *QianxinModel) ExplainResult(ctx context.Context, result *DetectionResul
 	}
 
 	return explanation, nil
-}
\ No newline at end of file
+}
@@ -1,44 +1,53 @@
 package qianxin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/service/redact"
 )
 
 // SecurityLLM represents the QianXin security large language model client
 type SecurityLLM struct {
 	apiEndpoint string
-	apiKey     string
-	httpClient *http.Client
+	apiKey      string
+	httpClient  *http.Client
+	redactor    *redact.Redactor
 }
 
 // NewSecurityLLM creates a new instance of SecurityLLM
 func NewSecurityLLM(apiEndpoint, apiKey string) *SecurityLLM {
 	return &SecurityLLM{
 		apiEndpoint: apiEndpoint,
-		apiKey:     apiKey,
-		httpClient: &http.Client{},
+		apiKey:      apiKey,
+		httpClient:  &http.Client{},
 	}
 }
 
+// SetRedactor redacts events for the LLM destination before they are sent
+func (s *SecurityLLM) SetRedactor(redactor *redact.Redactor) {
+	s.redactor = redactor
+}
+
 // AnalyzeSecurityEvent analyzes a security event using QianXin's security LLM
 func (s *SecurityLLM) AnalyzeSecurityEvent(ctx context.Context, event *entity.SecurityEvent) (*entity.SecurityAnalysis, error) {
-	// 构建模型输入
+	// 脱敏后构建模型输入
+	redacted := s.redactor.Redact(redact.DestinationLLM, event)
 	input := map[string]interface{}{
 		"event_type": "security_analysis",
 		"data": map[string]interface{}{
-			"timestamp": event.Timestamp,
-			"source_ip": event.SourceIP,
-			"dest_ip":   event.DestIP,
-			"protocol":  event.Protocol,
-			"port":      event.Port,
-			"action":    event.Action,
-			"status":    event.Status,
-			"user":      event.User,
+			"timestamp": redacted.Timestamp,
+			"source_ip": redacted.SourceIP,
+			"dest_ip":   redacted.DestIP,
+			"protocol":  redacted.Protocol,
+			"port":      redacted.Port,
+			"action":    redacted.Action,
+			"status":    redacted.Status,
+			"user":      redacted.User,
 		},
 	}
 
@@ -74,7 +83,6 @@ func (s *SecurityLLM) DetectAnomalies(ctx context.Context, events []*entity.Secu
 		if analysis.ThreatLevel >= "medium" {
 			anomaly := entity.NewAnomalyResult(event.ID, float32(analysis.Confidence))
 			anomaly.AnomalyType = analysis.ThreatType
-			anomaly.Description = analysis.Recommendation
 			anomalies = append(anomalies, anomaly)
 		}
 	}
@@ -84,41 +92,41 @@ func (s *SecurityLLM) DetectAnomalies(ctx context.Context, events []*entity.Secu
 
 // callAPI makes an HTTP request to the QianXin security LLM API
 func (s *SecurityLLM) callAPI(ctx context.Context, input interface{}) (*APIResponse, error) {
-    // 构建请求体
-    reqBody, err := json.Marshal(input)
-    if err != nil {
-        return nil, fmt.Errorf("failed to marshal request body: %v", err)
-    }
-
-    // 创建HTTP请求
-    req, err := http.NewRequestWithContext(ctx, "POST", s.apiEndpoint, bytes.NewBuffer(reqBody))
-    if err != nil {
-        return nil, fmt.Errorf("failed to create request: %v", err)
-    }
-
-    // 设置请求头
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
-
-    // 发送请求
-    resp, err := s.httpClient.Do(req)
-    if err != nil {
-        return nil, fmt.Errorf("failed to send request: %v", err)
-    }
-    defer resp.Body.Close()
-
-    // 检查响应状态码
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
-    }
-
-    // 解析响应
-    var apiResp APIResponse
-    if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-        return nil, fmt.Errorf("failed to decode response: %v", err)
-    }
-
-    return &apiResp, nil
+	// 构建请求体
+	reqBody, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	// 创建HTTP请求
+	req, err := http.NewRequestWithContext(ctx, "POST", s.apiEndpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
+
+	// 发送请求
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态码
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
+	}
+
+	// 解析响应
+	var apiResp APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &apiResp, nil
 }
 
 // APIResponse represents the response from QianXin's security LLM API
@@ -127,4 +135,4 @@ type APIResponse struct {
 	ThreatType     string  `json:"threat_type"`
 	Recommendation string  `json:"recommendation"`
 	Confidence     float64 `json:"confidence"`
-}
\ No newline at end of file
+}
@@ -3,14 +3,28 @@ package config
 import (
 	"os"
 
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/infrastructure/cmdb"
+	"github.com/jinye/securityai/internal/infrastructure/directory"
+	"github.com/jinye/securityai/internal/infrastructure/geoip"
+	"github.com/jinye/securityai/internal/infrastructure/threatintel"
+	"github.com/jinye/securityai/internal/service/ingest"
+	"github.com/jinye/securityai/internal/service/log"
+	"github.com/jinye/securityai/internal/service/redact"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	AI      AIConfig      `yaml:"ai"`
-	Storage StorageConfig `yaml:"storage"`
-	Log     LogConfig     `yaml:"log"`
+	Server      ServerConfig       `yaml:"server"`
+	AI          AIConfig           `yaml:"ai"`
+	Storage     StorageConfig      `yaml:"storage"`
+	Log         LogConfig          `yaml:"log"`
+	Ingest      IngestConfig       `yaml:"ingest"`
+	Redaction   redact.Config      `yaml:"redaction"`
+	Tenancy     tenant.Config      `yaml:"tenancy"`
+	GeoIP       geoip.Config       `yaml:"geoip"`
+	ThreatIntel threatintel.Config `yaml:"threat_intel"`
+	Enrichment  EnrichmentConfig   `yaml:"enrichment"`
 }
 
 type ServerConfig struct {
@@ -52,16 +66,74 @@ type LogConfig struct {
 	Format string `yaml:"format"`
 }
 
+type IngestConfig struct {
+	Pipeline   log.PipelineConfig    `yaml:"pipeline"`
+	DeadLetter log.DeadLetterConfig  `yaml:"dead_letter"`
+	Dedup      log.DedupConfig       `yaml:"dedup"`
+	Mappings   string                `yaml:"mappings"` // YAML field mapping file for JSON sources
+	Syslog     ingest.ListenerConfig `yaml:"syslog"`
+	Tail       ingest.TailerConfig   `yaml:"tail"`
+}
+
+type EnrichmentConfig struct {
+	Chain     log.EnrichmentChainConfig `yaml:"chain"`
+	Cache     log.EnrichmentCacheConfig `yaml:"cache"`
+	Assets    cmdb.Config               `yaml:"assets"`
+	Directory directory.Config          `yaml:"directory"`
+	Scoring   string                    `yaml:"scoring"` // YAML scoring policy file
+}
+
+// DefaultConfig returns the settings used for everything the configuration
+// file leaves out. Data sources such as GeoIP databases, threat feeds,
+// inventories, syslog sockets and tailed files stay off until their paths or
+// addresses are configured.
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{Port: "8080"},
+		Storage: StorageConfig{
+			Elasticsearch: ElasticsearchConfig{
+				Hosts:       []string{"http://localhost:9200"},
+				IndexPrefix: "securityai_",
+			},
+			Redis: RedisConfig{Addr: "localhost:6379"},
+		},
+		Ingest: IngestConfig{
+			Pipeline:   log.DefaultPipelineConfig(),
+			DeadLetter: log.DefaultDeadLetterConfig(),
+			Dedup:      log.DefaultDedupConfig(),
+			Tail:       ingest.TailerConfig{OffsetsFile: ingest.DefaultTailerConfig().OffsetsFile},
+		},
+		GeoIP: geoip.Config{
+			Languages:      geoip.DefaultConfig().Languages,
+			ReloadInterval: geoip.DefaultConfig().ReloadInterval,
+		},
+		ThreatIntel: threatintel.Config{
+			MaxAge:         threatintel.DefaultConfig().MaxAge,
+			ReloadInterval: threatintel.DefaultConfig().ReloadInterval,
+		},
+		Enrichment: EnrichmentConfig{
+			Chain:  log.DefaultEnrichmentChainConfig(),
+			Cache:  log.DefaultEnrichmentCacheConfig(),
+			Assets: cmdb.Config{RefreshInterval: cmdb.DefaultConfig().RefreshInterval},
+			Directory: directory.Config{
+				PrivilegedGroups: directory.DefaultConfig().PrivilegedGroups,
+				RefreshInterval:  directory.DefaultConfig().RefreshInterval,
+			},
+		},
+	}
+}
+
+// LoadConfig reads a YAML configuration file over the defaults
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	config := DefaultConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, err
 	}
 
-	return &config, nil
+	return config, nil
 }
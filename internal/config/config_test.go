@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		check func(t *testing.T, config *Config)
+	}{
+		{
+			name: "empty file keeps the defaults",
+			yaml: "",
+			check: func(t *testing.T, config *Config) {
+				if config.Server.Port != "8080" || config.Ingest.Pipeline.Workers == 0 || len(config.Enrichment.Chain.Stages) == 0 {
+					t.Errorf("config = %+v", config)
+				}
+				if config.GeoIP.CityPath != "" || len(config.Ingest.Tail.Paths) != 0 || config.Ingest.Syslog.UDPAddr != "" {
+					t.Errorf("data sources enabled by default: %+v", config)
+				}
+			},
+		},
+		{
+			name: "settings override single defaults",
+			yaml: "ingest:\n  pipeline:\n    workers: 3\n  syslog:\n    udp_addr: \":5514\"\ngeoip:\n  city_path: city.mmdb\n",
+			check: func(t *testing.T, config *Config) {
+				pipeline := config.Ingest.Pipeline
+				if pipeline.Workers != 3 || pipeline.QueueSize != 10000 {
+					t.Errorf("pipeline = %+v", pipeline)
+				}
+				if config.Ingest.Syslog.UDPAddr != ":5514" {
+					t.Errorf("syslog = %+v", config.Ingest.Syslog)
+				}
+				if config.GeoIP.CityPath != "city.mmdb" || config.GeoIP.ReloadInterval != time.Minute {
+					t.Errorf("geoip = %+v", config.GeoIP)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package entity
+
+// Asset criticality levels, lowest first
+const (
+	CriticalityLow      = "low"
+	CriticalityMedium   = "medium"
+	CriticalityHigh     = "high"
+	CriticalityCritical = "critical"
+)
+
+// CriticalityRank orders asset criticality levels. Unknown levels rank 0.
+func CriticalityRank(level string) int {
+	switch level {
+	case CriticalityLow:
+		return 1
+	case CriticalityMedium:
+		return 2
+	case CriticalityHigh:
+		return 3
+	case CriticalityCritical:
+		return 4
+	}
+	return 0
+}
@@ -1,5 +1,7 @@
 package entity
 
+import "time"
+
 // SecurityAnalysis represents the analysis result from QianXin's security LLM
 type SecurityAnalysis struct {
 	EventID        string  `json:"event_id"`
@@ -31,4 +33,4 @@ func (a *SecurityAnalysis) IsMediumRisk() bool {
 // IsLowRisk checks if the analysis indicates a low-risk threat
 func (a *SecurityAnalysis) IsLowRisk() bool {
 	return a.ThreatLevel == "low"
-}
\ No newline at end of file
+}
@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,20 +9,51 @@ import (
 
 // SecurityEvent represents a security-related event in the system
 type SecurityEvent struct {
-	ID        string    `json:"id"`
-	Timestamp time.Time `json:"timestamp"`
-	SourceIP  string    `json:"source_ip"`
-	DestIP    string    `json:"dest_ip"`
-	Protocol  string    `json:"protocol"`
-	Port      int       `json:"port"`
-	Action    string    `json:"action"`
-	Status    string    `json:"status"`
-	User      string    `json:"user"`
-	RawData   string    `json:"raw_data"`
-	Severity  string    `json:"severity"`
-	Labels    []string  `json:"labels"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	SourceIP    string    `json:"source_ip"`
+	DestIP      string    `json:"dest_ip"`
+	Protocol    string    `json:"protocol"`
+	Port        int       `json:"port"`
+	Action      string    `json:"action"`
+	Status      string    `json:"status"`
+	User        string    `json:"user"`
+	EventType   string    `json:"event_type"`
+	Description string    `json:"description"`
+	RawData     string    `json:"raw_data"`
+	Severity    string    `json:"severity"`
+	Labels      []string  `json:"labels"`
+	// Score explains how Severity was derived
+	Score     *SeverityScore `json:"score,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// SeverityScore is the breakdown of an event's severity score
+type SeverityScore struct {
+	Total      float64          `json:"total"`
+	Severity   string           `json:"severity"`
+	Components []ScoreComponent `json:"components"`
+}
+
+// ScoreComponent is the contribution of one scoring factor
+type ScoreComponent struct {
+	Factor       string  `json:"factor"`
+	Type         string  `json:"type"`
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"`
+	Detail       string  `json:"detail,omitempty"`
+}
+
+// RuleHit is a detection rule matched by an event
+type RuleHit struct {
+	RuleID   string `json:"rule_id"`
+	RuleName string `json:"rule_name"`
+	Severity string `json:"severity"`
+	// EventIDs are the events that together triggered a stateful rule
+	EventIDs []string `json:"event_ids,omitempty"`
 }
 
 // NewSecurityEvent creates a new security event with default values
@@ -41,6 +73,7 @@ func NewSecurityEvent() *SecurityEvent {
 type AnomalyResult struct {
 	ID          string    `json:"id"`
 	EventID     string    `json:"event_id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
 	Score       float32   `json:"score"`
 	Timestamp   time.Time `json:"timestamp"`
 	AnomalyType string    `json:"anomaly_type"`
@@ -61,3 +94,26 @@ func NewAnomalyResult(eventID string, score float32) *AnomalyResult {
 		Rules:     make([]string, 0),
 	}
 }
+
+// SetLabel sets a "key:value" label, replacing any existing label with the same key
+func (e *SecurityEvent) SetLabel(key, value string) {
+	prefix := key + ":"
+	for i, label := range e.Labels {
+		if strings.HasPrefix(label, prefix) {
+			e.Labels[i] = prefix + value
+			return
+		}
+	}
+	e.Labels = append(e.Labels, prefix+value)
+}
+
+// GetLabel returns the value of the "key:value" label with the given key
+func (e *SecurityEvent) GetLabel(key string) (string, bool) {
+	prefix := key + ":"
+	for _, label := range e.Labels {
+		if strings.HasPrefix(label, prefix) {
+			return label[len(prefix):], true
+		}
+	}
+	return "", false
+}
@@ -29,6 +29,7 @@ type RuleStore interface {
 // RuleDefinition 规则定义
 type RuleDefinition struct {
 	ID          string                 `json:"id"`
+	TenantID    string                 `json:"tenant_id,omitempty"` // 所属租户，为空表示所有租户共享
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	Category    string                 `json:"category"`
@@ -46,11 +47,49 @@ type RuleDefinition struct {
 
 // RuleConfig 规则配置
 type RuleConfig struct {
-	Type       string                   `json:"type"` // composite, ml, simple
+	Type       string                   `json:"type"` // composite, threshold, sequence, sigma, ml
 	Conditions []map[string]interface{} `json:"conditions"`
 	Operator   string                   `json:"operator,omitempty"` // AND, OR
 	Threshold  float32                  `json:"threshold,omitempty"`
 	Actions    []RuleAction             `json:"actions"`
+	// Aggregation 阈值规则的聚合方式，Conditions 筛选参与聚合的事件
+	Aggregation *AggregationConfig `json:"aggregation,omitempty"`
+	// Sequence 序列规则的步骤，Conditions 作为每个步骤的公共条件
+	Sequence *SequenceConfig `json:"sequence,omitempty"`
+}
+
+// AggregationConfig 阈值规则的聚合配置
+type AggregationConfig struct {
+	GroupBy  []string `json:"group_by,omitempty"` // 分组字段
+	Function string   `json:"function"`           // count, distinct_count, sum
+	Field    string   `json:"field,omitempty"`    // distinct_count 和 sum 的字段
+	Operator string   `json:"operator,omitempty"` // gt, gte (默认)
+	// Window 窗口长度，例如 5m；WindowType 为 sliding (默认) 或 tumbling
+	Window     string `json:"window"`
+	WindowType string `json:"window_type,omitempty"`
+	// MaxGroups、MaxEventsPerGroup 内存上限，为 0 时使用默认值
+	MaxGroups         int `json:"max_groups,omitempty"`
+	MaxEventsPerGroup int `json:"max_events_per_group,omitempty"`
+}
+
+// SequenceConfig 序列规则配置
+type SequenceConfig struct {
+	Steps []SequenceStepConfig `json:"steps"`
+	// MaxSpan 第一步到最后一步的最大间隔，例如 30m
+	MaxSpan string `json:"maxspan"`
+	// Tolerance 乱序容忍时间，例如 30s；为空时按到达顺序处理
+	Tolerance string `json:"tolerance,omitempty"`
+	// MaxPartials、MaxBuffered 内存上限，为 0 时使用默认值
+	MaxPartials int `json:"max_partials,omitempty"`
+	MaxBuffered int `json:"max_buffered,omitempty"`
+}
+
+// SequenceStepConfig 序列规则的步骤配置
+type SequenceStepConfig struct {
+	Name       string                   `json:"name,omitempty"`
+	Conditions []map[string]interface{} `json:"conditions"`
+	By         []string                 `json:"by"`             // 与上一步关联的字段
+	Next       []string                 `json:"next,omitempty"` // 传递给下一步的关联字段，默认与 by 相同
 }
 
 // RuleAction 规则触发的动作
@@ -0,0 +1,280 @@
+// Package schema converts security events to and from the Elastic Common
+// Schema (ECS) and the Open Cybersecurity Schema Framework (OCSF).
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// Document is a nested JSON document addressed with dotted paths
+type Document map[string]interface{}
+
+// Set stores a value under a dotted path, creating intermediate objects.
+// Nil values and empty strings are not stored.
+func (d Document) Set(path string, value interface{}) {
+	if value == nil {
+		return
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return
+	}
+
+	parts := strings.Split(path, ".")
+	current := d
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// Get returns the value under a dotted path
+func (d Document) Get(path string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(d)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// String returns the value under a path rendered as a string
+func (d Document) String(path string) string {
+	value, ok := d.Get(path)
+	if !ok {
+		return ""
+	}
+	return valueString(value)
+}
+
+// Delete removes the value under a dotted path, pruning emptied objects
+func (d Document) Delete(path string) {
+	parts := strings.Split(path, ".")
+	deletePath(d, parts)
+}
+
+func deletePath(object map[string]interface{}, parts []string) {
+	if len(parts) == 1 {
+		delete(object, parts[0])
+		return
+	}
+	child, ok := object[parts[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deletePath(child, parts[1:])
+	if len(child) == 0 {
+		delete(object, parts[0])
+	}
+}
+
+// Flatten returns all leaf values keyed by their dotted path. Arrays are leaves.
+func (d Document) Flatten() map[string]interface{} {
+	flat := make(map[string]interface{})
+	flatten("", d, flat)
+	return flat
+}
+
+func flatten(prefix string, object map[string]interface{}, flat map[string]interface{}) {
+	for key, value := range object {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if child, ok := value.(map[string]interface{}); ok {
+			flatten(path, child, flat)
+			continue
+		}
+		flat[path] = value
+	}
+}
+
+// Clone returns a deep copy of the document
+func (d Document) Clone() Document {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return Document{}
+	}
+	var clone Document
+	if err := unmarshalDocument(data, &clone); err != nil {
+		return Document{}
+	}
+	return clone
+}
+
+// ParseDocument decodes a JSON object, keeping numbers exact
+func ParseDocument(data []byte) (Document, error) {
+	var doc Document
+	if err := unmarshalDocument(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	return doc, nil
+}
+
+func unmarshalDocument(data []byte, doc *Document) error {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(doc)
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+}
+
+func valueInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, true
+		}
+		if f, err := v.Float64(); err == nil {
+			return int64(f), true
+		}
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func valueFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f, true
+		}
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+func valueTime(value interface{}) (time.Time, bool) {
+	if s, ok := value.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, true
+		}
+		return time.Time{}, false
+	}
+	// Epoch milliseconds, as used by OCSF
+	if ms, ok := valueInt(value); ok {
+		return time.UnixMilli(ms).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// splitLabels groups "key:value" labels by key, keeping the order of values
+func splitLabels(labels []string) (map[string][]string, []string) {
+	grouped := make(map[string][]string)
+	keys := make([]string, 0)
+	for _, label := range labels {
+		key, value, ok := strings.Cut(label, ":")
+		if !ok {
+			key, value = label, ""
+		}
+		if _, seen := grouped[key]; !seen {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], value)
+	}
+	return grouped, keys
+}
+
+// labelValue renders label values as a string, or an array for repeated keys
+func labelValue(values []string) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	list := make([]interface{}, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+// appendLabelValues adds a labels object entry back to the event
+func appendLabelValues(event *entity.SecurityEvent, key string, value interface{}) {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			event.Labels = append(event.Labels, key+":"+valueString(item))
+		}
+		return
+	}
+	event.Labels = append(event.Labels, key+":"+valueString(value))
+}
+
+func sortedPaths(flat map[string]interface{}) []string {
+	paths := make([]string, 0, len(flat))
+	for path := range flat {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// severityScale orders the severities used by the processor
+var severityScale = []string{"info", "low", "medium", "high", "critical"}
+
+func severityIndex(severity string) int {
+	for i, s := range severityScale {
+		if s == strings.ToLower(severity) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,407 @@
+package schema
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// ECSVersion is the ECS version the documents declare
+const ECSVersion = "8.11.0"
+
+// ecsUnmappedPrefix marks labels holding ECS fields without an event field,
+// so they can be written back to their original path
+const ecsUnmappedPrefix = "ecs."
+
+// ecsLabelFields maps well-known labels set by parsers and the enricher to
+// ECS fields. They are only used for single-valued labels.
+var ecsLabelFields = map[string]string{
+	"source_port":     "source.port",
+	"host":            "host.name",
+	"app":             "process.name",
+	"procid":          "process.pid",
+	"community_id":    "network.community_id",
+	"parser":          "event.module",
+	"source_country":  "source.geo.country_name",
+	"source_city":     "source.geo.city_name",
+	"dest_country":    "destination.geo.country_name",
+	"dest_city":       "destination.geo.city_name",
+	"signature_id":    "rule.id",
+	"alert.signature": "rule.name",
+	"alert.category":  "rule.category",
+}
+
+// ecsNumericFields are ECS fields of type long
+var ecsNumericFields = map[string]bool{
+	"source.port":      true,
+	"destination.port": true,
+	"process.pid":      true,
+}
+
+// ecsSeverity maps severities to the ECS numeric event.severity, using the
+// same scale as Elastic detection rules
+var ecsSeverity = map[string]int{
+	"info":     0,
+	"low":      21,
+	"medium":   47,
+	"high":     73,
+	"critical": 99,
+}
+
+// ToECS converts an event into an ECS document. Fields without an ECS
+// counterpart go to the securityai namespace and labels, so FromECS can
+// restore the event.
+func ToECS(event *entity.SecurityEvent) Document {
+	doc := Document{}
+
+	doc.Set("@timestamp", event.Timestamp.UTC().Format(time.RFC3339Nano))
+	doc.Set("ecs.version", ECSVersion)
+	doc.Set("event.kind", "event")
+	doc.Set("event.id", event.ID)
+	doc.Set("organization.id", event.TenantID)
+	doc.Set("event.action", event.EventType)
+	doc.Set("event.original", event.RawData)
+	doc.Set("message", event.Description)
+	if !event.CreatedAt.IsZero() {
+		doc.Set("event.created", event.CreatedAt.UTC().Format(time.RFC3339Nano))
+	}
+	if !event.UpdatedAt.IsZero() {
+		doc.Set("securityai.updated_at", event.UpdatedAt.UTC().Format(time.RFC3339Nano))
+	}
+
+	if event.Severity != "" {
+		doc.Set("log.level", event.Severity)
+		if score, ok := ecsSeverity[strings.ToLower(event.Severity)]; ok {
+			doc.Set("event.severity", score)
+		}
+	}
+
+	doc.Set("source.ip", event.SourceIP)
+	doc.Set("destination.ip", event.DestIP)
+	if event.Port > 0 {
+		doc.Set("destination.port", event.Port)
+	}
+	// ECS wants the transport in lower case; the original spelling is kept
+	// so rules on the protocol still match events read back
+	doc.Set("network.transport", strings.ToLower(event.Protocol))
+	if event.Protocol != strings.ToLower(event.Protocol) {
+		doc.Set("securityai.protocol", event.Protocol)
+	}
+	doc.Set("user.name", event.User)
+
+	// The firewall style action is kept verbatim; event.type carries the
+	// ECS categorisation dashboards filter on
+	doc.Set("securityai.action", event.Action)
+	if eventType := ecsEventType(event.Action); eventType != "" {
+		doc.Set("event.type", []interface{}{eventType})
+	}
+
+	switch strings.ToLower(event.Status) {
+	case "success", "failure", "unknown":
+		doc.Set("event.outcome", strings.ToLower(event.Status))
+		if event.Status != strings.ToLower(event.Status) {
+			doc.Set("securityai.status", event.Status)
+		}
+	default:
+		doc.Set("securityai.status", event.Status)
+	}
+
+	// ECS has no place for the breakdown of the score
+	if event.Score != nil {
+		doc.Set("securityai.score.total", event.Score.Total)
+		doc.Set("securityai.score.severity", event.Score.Severity)
+		if len(event.Score.Components) > 0 {
+			components := make([]interface{}, 0, len(event.Score.Components))
+			for _, c := range event.Score.Components {
+				component := map[string]interface{}{
+					"factor":       c.Factor,
+					"type":         c.Type,
+					"value":        c.Value,
+					"weight":       c.Weight,
+					"contribution": c.Contribution,
+				}
+				if c.Detail != "" {
+					component["detail"] = c.Detail
+				}
+				components = append(components, component)
+			}
+			doc.Set("securityai.score.components", components)
+		}
+	}
+
+	applyECSLabels(doc, event.Labels)
+	return doc
+}
+
+func applyECSLabels(doc Document, labels []string) {
+	grouped, keys := splitLabels(labels)
+	ecsLabels := make(map[string]interface{})
+	renamed := make(map[string]interface{})
+
+	for _, key := range keys {
+		values := grouped[key]
+
+		if strings.HasPrefix(key, ecsUnmappedPrefix) {
+			path := strings.TrimPrefix(key, ecsUnmappedPrefix)
+			if _, taken := doc.Get(path); !taken {
+				doc.Set(path, labelValue(values))
+				continue
+			}
+		}
+
+		if path, ok := ecsLabelFields[key]; ok && len(values) == 1 {
+			if _, taken := doc.Get(path); !taken {
+				if !ecsNumericFields[path] {
+					doc.Set(path, values[0])
+					continue
+				}
+				if n, err := strconv.Atoi(values[0]); err == nil {
+					doc.Set(path, n)
+					continue
+				}
+			}
+		}
+
+		// ECS label keys must not contain dots
+		sanitized := strings.ReplaceAll(key, ".", "_")
+		if sanitized != key {
+			renamed[sanitized] = key
+		}
+		ecsLabels[sanitized] = labelValue(values)
+	}
+
+	if len(ecsLabels) > 0 {
+		doc["labels"] = ecsLabels
+	}
+	if len(renamed) > 0 {
+		doc.Set("securityai.label_keys", renamed)
+	}
+}
+
+func ecsEventType(action string) string {
+	switch strings.ToLower(action) {
+	case "allow", "allowed", "accept", "permit", "pass":
+		return "allowed"
+	case "deny", "denied", "block", "blocked", "drop", "reject":
+		return "denied"
+	}
+	return ""
+}
+
+// FromECS converts an ECS document into an event. ECS fields without an event
+// counterpart are preserved as "ecs.<path>" labels.
+func FromECS(source Document) *entity.SecurityEvent {
+	doc := source.Clone()
+	event := entity.NewSecurityEvent()
+
+	take := func(path string) string {
+		value := doc.String(path)
+		doc.Delete(path)
+		return value
+	}
+	takeTime := func(path string) (time.Time, bool) {
+		value, ok := doc.Get(path)
+		if !ok {
+			return time.Time{}, false
+		}
+		doc.Delete(path)
+		return valueTime(value)
+	}
+
+	if t, ok := takeTime("@timestamp"); ok {
+		event.Timestamp = t
+	}
+	if t, ok := takeTime("event.created"); ok {
+		event.CreatedAt = t
+	}
+	if t, ok := takeTime("securityai.updated_at"); ok {
+		event.UpdatedAt = t
+	}
+	if id := take("event.id"); id != "" {
+		event.ID = id
+	}
+	event.TenantID = take("organization.id")
+	doc.Delete("ecs.version")
+	doc.Delete("event.kind")
+
+	event.EventType = take("event.action")
+	event.RawData = take("event.original")
+	event.Description = take("message")
+	event.SourceIP = take("source.ip")
+	event.DestIP = take("destination.ip")
+	transport := take("network.transport")
+	event.Protocol = take("securityai.protocol")
+	if event.Protocol == "" {
+		event.Protocol = transport
+	}
+	event.User = take("user.name")
+	if port, ok := valueInt(mustGet(doc, "destination.port")); ok {
+		event.Port = int(port)
+		doc.Delete("destination.port")
+	}
+
+	level := take("log.level")
+	score, hasScore := valueInt(mustGet(doc, "event.severity"))
+	doc.Delete("event.severity")
+	switch {
+	case severityIndex(level) >= 0:
+		event.Severity = strings.ToLower(level)
+	case hasScore:
+		event.Severity = severityFromECS(score)
+	case level != "":
+		event.Severity = level
+	}
+
+	// event.type is derived from the action on export; from other sources it
+	// is only consumed when it yields an action
+	eventTypes, _ := doc.Get("event.type")
+	event.Action = take("securityai.action")
+	if event.Action == "" {
+		event.Action = actionFromECS(eventTypes)
+	}
+	if event.Action != "" {
+		doc.Delete("event.type")
+	}
+
+	outcome := take("event.outcome")
+	event.Status = take("securityai.status")
+	if event.Status == "" {
+		event.Status = outcome
+	}
+
+	if value, ok := doc.Get("securityai.score"); ok {
+		if object, ok := value.(map[string]interface{}); ok {
+			event.Score = scoreFromECS(object)
+		}
+		doc.Delete("securityai.score")
+	}
+
+	// Labels with their original keys
+	renamed := make(map[string]string)
+	if keys, ok := doc.Get("securityai.label_keys"); ok {
+		if object, ok := keys.(map[string]interface{}); ok {
+			for sanitized, original := range object {
+				renamed[sanitized] = valueString(original)
+			}
+		}
+		doc.Delete("securityai.label_keys")
+	}
+	if labels, ok := doc["labels"].(map[string]interface{}); ok {
+		for _, key := range sortedPaths(labels) {
+			original := key
+			if name, ok := renamed[key]; ok {
+				original = name
+			}
+			appendLabelValues(event, original, labels[key])
+		}
+		delete(doc, "labels")
+	}
+
+	// Well-known ECS fields back to the labels they came from
+	for _, label := range sortedLabelKeys(ecsLabelFields) {
+		path := ecsLabelFields[label]
+		if value, ok := doc.Get(path); ok {
+			if _, isObject := value.(map[string]interface{}); isObject {
+				continue
+			}
+			event.Labels = append(event.Labels, label+":"+valueString(value))
+			doc.Delete(path)
+		}
+	}
+
+	// Everything else is preserved verbatim
+	flat := doc.Flatten()
+	for _, path := range sortedPaths(flat) {
+		appendLabelValues(event, ecsUnmappedPrefix+path, flat[path])
+	}
+
+	return event
+}
+
+// UnmarshalECS decodes a stored event document. Documents written before ECS
+// normalization, which lack @timestamp, are decoded as plain events.
+func UnmarshalECS(data []byte) (*entity.SecurityEvent, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["@timestamp"]; !ok {
+		var event entity.SecurityEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+		return &event, nil
+	}
+	return FromECS(doc), nil
+}
+
+func severityFromECS(score int64) string {
+	switch {
+	case score >= 90:
+		return "critical"
+	case score >= 70:
+		return "high"
+	case score >= 40:
+		return "medium"
+	case score >= 20:
+		return "low"
+	default:
+		return "info"
+	}
+}
+
+func scoreFromECS(object map[string]interface{}) *entity.SeverityScore {
+	score := &entity.SeverityScore{Severity: valueString(object["severity"])}
+	score.Total, _ = valueFloat(object["total"])
+	components, _ := object["components"].([]interface{})
+	for _, value := range components {
+		c, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		component := entity.ScoreComponent{
+			Factor: valueString(c["factor"]),
+			Type:   valueString(c["type"]),
+			Detail: valueString(c["detail"]),
+		}
+		component.Value, _ = valueFloat(c["value"])
+		component.Weight, _ = valueFloat(c["weight"])
+		component.Contribution, _ = valueFloat(c["contribution"])
+		score.Components = append(score.Components, component)
+	}
+	return score
+}
+
+func actionFromECS(eventTypes interface{}) string {
+	types, ok := eventTypes.([]interface{})
+	if !ok {
+		types = []interface{}{eventTypes}
+	}
+	for _, t := range types {
+		switch valueString(t) {
+		case "allowed":
+			return "allow"
+		case "denied":
+			return "deny"
+		}
+	}
+	return ""
+}
+
+func mustGet(doc Document, path string) interface{} {
+	value, _ := doc.Get(path)
+	return value
+}
+
+func sortedLabelKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+func TestECSRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocol  string
+		status    string
+		action    string
+		transport string
+	}{
+		{name: "upper-case protocol", protocol: "TCP", status: "failure", action: "deny", transport: "tcp"},
+		{name: "lower-case protocol", protocol: "udp", status: "success", action: "allow", transport: "udp"},
+		{name: "mixed-case status", protocol: "ICMP", status: "Failure", action: "drop", transport: "icmp"},
+		{name: "no protocol", status: "blocked", action: "block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := entity.NewSecurityEvent()
+			event.Timestamp = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+			event.SourceIP = "10.0.0.1"
+			event.Protocol = tt.protocol
+			event.Status = tt.status
+			event.Action = tt.action
+			event.Labels = []string{"host:web-1", "vendor.name:acme"}
+
+			doc := ToECS(event)
+			if got := doc.String("network.transport"); got != tt.transport {
+				t.Errorf("network.transport = %q, want %q", got, tt.transport)
+			}
+
+			got := FromECS(doc)
+			if got.Protocol != tt.protocol {
+				t.Errorf("protocol = %q, want %q", got.Protocol, tt.protocol)
+			}
+			if got.Status != tt.status {
+				t.Errorf("status = %q, want %q", got.Status, tt.status)
+			}
+			if got.Action != tt.action {
+				t.Errorf("action = %q, want %q", got.Action, tt.action)
+			}
+			if !got.Timestamp.Equal(event.Timestamp) || got.ID != event.ID {
+				t.Errorf("id/timestamp = %s/%v, want %s/%v", got.ID, got.Timestamp, event.ID, event.Timestamp)
+			}
+			for key, want := range map[string]string{"host": "web-1", "vendor.name": "acme"} {
+				if value, _ := got.GetLabel(key); value != want {
+					t.Errorf("label %s = %q, want %q (labels %v)", key, value, want, got.Labels)
+				}
+			}
+		})
+	}
+}
+
+func TestECSScoreRoundTrip(t *testing.T) {
+	score := &entity.SeverityScore{
+		Total:    72.5,
+		Severity: "high",
+		Components: []entity.ScoreComponent{
+			{Factor: "anomaly", Type: "model", Value: 0.9, Weight: 0.5, Contribution: 45},
+			{Factor: "asset", Type: "cmdb", Value: 1, Weight: 0.275, Contribution: 27.5, Detail: "critical asset"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		score *entity.SeverityScore
+		json  bool
+	}{
+		{name: "in memory", score: score},
+		{name: "through JSON", score: score, json: true},
+		{name: "without components", score: &entity.SeverityScore{Total: 10, Severity: "low"}, json: true},
+		{name: "no score", json: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := entity.NewSecurityEvent()
+			event.Score = tt.score
+
+			doc := ToECS(event)
+			if tt.json {
+				data, err := json.Marshal(doc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if doc, err = ParseDocument(data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := FromECS(doc)
+			if !reflect.DeepEqual(got.Score, tt.score) {
+				t.Errorf("score = %+v, want %+v", got.Score, tt.score)
+			}
+			for _, label := range got.Labels {
+				if strings.HasPrefix(label, ecsUnmappedPrefix+"securityai.") {
+					t.Errorf("score leaked into label %s", label)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package schema
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// OCSFVersion is the OCSF schema version the documents declare
+const OCSFVersion = "1.1.0"
+
+// ocsfUnmappedPrefix marks labels holding OCSF attributes without an event field
+const ocsfUnmappedPrefix = "ocsf."
+
+// OCSFClass identifies an OCSF event class
+type OCSFClass struct {
+	UID          int    `json:"class_uid"`
+	Name         string `json:"class_name"`
+	CategoryUID  int    `json:"category_uid"`
+	CategoryName string `json:"category_name"`
+}
+
+// OCSF classes events are exported as
+var (
+	OCSFBaseEvent        = OCSFClass{UID: 0, Name: "Base Event", CategoryUID: 0, CategoryName: "Uncategorized"}
+	OCSFDetectionFinding = OCSFClass{UID: 2004, Name: "Detection Finding", CategoryUID: 2, CategoryName: "Findings"}
+	OCSFAuthentication   = OCSFClass{UID: 3002, Name: "Authentication", CategoryUID: 3, CategoryName: "Identity & Access Management"}
+	OCSFNetworkActivity  = OCSFClass{UID: 4001, Name: "Network Activity", CategoryUID: 4, CategoryName: "Network Activity"}
+	OCSFHTTPActivity     = OCSFClass{UID: 4002, Name: "HTTP Activity", CategoryUID: 4, CategoryName: "Network Activity"}
+	OCSFDNSActivity      = OCSFClass{UID: 4003, Name: "DNS Activity", CategoryUID: 4, CategoryName: "Network Activity"}
+)
+
+// ocsfSeverities are the OCSF severity_id captions, indexed by severity_id
+var ocsfSeverities = []string{"Unknown", "Informational", "Low", "Medium", "High", "Critical"}
+
+// OCSFClassFor picks the OCSF class that best describes an event
+func OCSFClassFor(event *entity.SecurityEvent) OCSFClass {
+	eventType := strings.ToLower(event.EventType)
+	_, hasSignature := event.GetLabel("signature_id")
+
+	switch {
+	case strings.Contains(eventType, "alert") || hasSignature:
+		return OCSFDetectionFinding
+	case strings.Contains(eventType, "dns"):
+		return OCSFDNSActivity
+	case strings.Contains(eventType, "http"):
+		return OCSFHTTPActivity
+	case containsAny(eventType, "auth", "login", "logon", "logout", "session", "sso"):
+		return OCSFAuthentication
+	case event.SourceIP != "" || event.DestIP != "":
+		return OCSFNetworkActivity
+	}
+	return OCSFBaseEvent
+}
+
+// ToOCSF converts an event into an OCSF document of the class chosen by
+// OCSFClassFor. Labels are kept in the unmapped object.
+func ToOCSF(event *entity.SecurityEvent) Document {
+	class := OCSFClassFor(event)
+	doc := Document{}
+
+	doc.Set("class_uid", class.UID)
+	doc.Set("class_name", class.Name)
+	doc.Set("category_uid", class.CategoryUID)
+	doc.Set("category_name", class.CategoryName)
+	// Activities are source specific, so the event type is reported as "Other"
+	doc.Set("activity_id", 99)
+	doc.Set("activity_name", event.EventType)
+	doc.Set("type_uid", class.UID*100+99)
+
+	doc.Set("time", event.Timestamp.UnixMilli())
+	doc.Set("message", event.Description)
+	doc.Set("raw_data", event.RawData)
+
+	doc.Set("metadata.uid", event.ID)
+	doc.Set("metadata.tenant_uid", event.TenantID)
+	doc.Set("metadata.version", OCSFVersion)
+	doc.Set("metadata.product.name", "SecurityAI")
+	doc.Set("metadata.product.vendor_name", "jinye")
+	if !event.CreatedAt.IsZero() {
+		doc.Set("metadata.logged_time", event.CreatedAt.UnixMilli())
+	}
+	if !event.UpdatedAt.IsZero() {
+		doc.Set("metadata.modified_time", event.UpdatedAt.UnixMilli())
+	}
+
+	severityID := severityIndex(event.Severity) + 1
+	doc.Set("severity_id", severityID)
+	doc.Set("severity", ocsfSeverities[severityID])
+
+	doc.Set("status", event.Status)
+	doc.Set("status_id", ocsfStatusID(event.Status))
+
+	doc.Set("src_endpoint.ip", event.SourceIP)
+	doc.Set("dst_endpoint.ip", event.DestIP)
+	if event.Port > 0 {
+		doc.Set("dst_endpoint.port", event.Port)
+	}
+	doc.Set("connection_info.protocol_name", strings.ToLower(event.Protocol))
+
+	if class == OCSFAuthentication {
+		doc.Set("user.name", event.User)
+	} else {
+		doc.Set("actor.user.name", event.User)
+	}
+
+	if event.Action != "" {
+		doc.Set("disposition", event.Action)
+		doc.Set("disposition_id", ocsfDispositionID(event.Action))
+	}
+
+	unmapped := make(map[string]interface{})
+	grouped, keys := splitLabels(event.Labels)
+	for _, key := range keys {
+		values := grouped[key]
+		switch {
+		case strings.HasPrefix(key, ocsfUnmappedPrefix):
+			path := strings.TrimPrefix(key, ocsfUnmappedPrefix)
+			if _, taken := doc.Get(path); !taken {
+				doc.Set(path, labelValue(values))
+				continue
+			}
+		case key == "source_port" && len(values) == 1:
+			if port, ok := valueInt(values[0]); ok {
+				doc.Set("src_endpoint.port", int(port))
+				continue
+			}
+		}
+		unmapped[key] = labelValue(values)
+	}
+
+	if class == OCSFDetectionFinding {
+		uid, _ := event.GetLabel("signature_id")
+		title, ok := event.GetLabel("alert.signature")
+		if !ok {
+			title = event.Description
+		}
+		doc.Set("finding_info.uid", uid)
+		doc.Set("finding_info.title", title)
+	}
+
+	if len(unmapped) > 0 {
+		doc["unmapped"] = unmapped
+	}
+	return doc
+}
+
+// FromOCSF converts an OCSF document into an event. Attributes without an
+// event field are preserved as "ocsf.<path>" labels.
+func FromOCSF(source Document) *entity.SecurityEvent {
+	doc := source.Clone()
+	event := entity.NewSecurityEvent()
+
+	take := func(path string) string {
+		value := doc.String(path)
+		doc.Delete(path)
+		return value
+	}
+	takeTime := func(path string) (time.Time, bool) {
+		value, ok := doc.Get(path)
+		if !ok {
+			return time.Time{}, false
+		}
+		doc.Delete(path)
+		return valueTime(value)
+	}
+
+	if t, ok := takeTime("time"); ok {
+		event.Timestamp = t
+	}
+	if t, ok := takeTime("metadata.logged_time"); ok {
+		event.CreatedAt = t
+	}
+	if t, ok := takeTime("metadata.modified_time"); ok {
+		event.UpdatedAt = t
+	}
+	if id := take("metadata.uid"); id != "" {
+		event.ID = id
+	}
+	event.TenantID = take("metadata.tenant_uid")
+
+	// Class and type identifiers are derived again on export. Specific
+	// activities of other producers are kept.
+	derived := []string{"class_uid", "class_name", "category_uid", "category_name", "type_name",
+		"metadata.version", "metadata.product.name", "metadata.product.vendor_name",
+		"status_id", "disposition_id", "finding_info.uid", "finding_info.title"}
+	if activity, _ := valueInt(mustGet(doc, "activity_id")); activity == 0 || activity == 99 {
+		derived = append(derived, "activity_id", "type_uid")
+	}
+	for _, path := range derived {
+		doc.Delete(path)
+	}
+
+	event.EventType = take("activity_name")
+	event.Description = take("message")
+	event.RawData = take("raw_data")
+	event.Status = take("status")
+	event.Action = take("disposition")
+	event.SourceIP = take("src_endpoint.ip")
+	event.DestIP = take("dst_endpoint.ip")
+	event.Protocol = take("connection_info.protocol_name")
+	if port, ok := valueInt(mustGet(doc, "dst_endpoint.port")); ok {
+		event.Port = int(port)
+		doc.Delete("dst_endpoint.port")
+	}
+	if port, ok := valueInt(mustGet(doc, "src_endpoint.port")); ok {
+		event.Labels = append(event.Labels, "source_port:"+valueString(port))
+		doc.Delete("src_endpoint.port")
+	}
+
+	event.User = take("user.name")
+	if actor := take("actor.user.name"); event.User == "" {
+		event.User = actor
+	}
+
+	severityID, ok := valueInt(mustGet(doc, "severity_id"))
+	if ok && severityID >= 1 && int(severityID) <= len(severityScale) {
+		event.Severity = severityScale[severityID-1]
+	}
+	doc.Delete("severity_id")
+	doc.Delete("severity")
+
+	if unmapped, ok := doc["unmapped"].(map[string]interface{}); ok {
+		for _, key := range sortedPaths(unmapped) {
+			appendLabelValues(event, key, unmapped[key])
+		}
+		delete(doc, "unmapped")
+	}
+
+	flat := doc.Flatten()
+	for _, path := range sortedPaths(flat) {
+		appendLabelValues(event, ocsfUnmappedPrefix+path, flat[path])
+	}
+
+	return event
+}
+
+func ocsfStatusID(status string) int {
+	switch strings.ToLower(status) {
+	case "":
+		return 0
+	case "success", "succeeded", "ok", "allowed":
+		return 1
+	case "failure", "failed", "fail", "denied", "error":
+		return 2
+	}
+	return 99
+}
+
+func ocsfDispositionID(action string) int {
+	switch strings.ToLower(action) {
+	case "allow", "allowed", "accept", "permit", "pass":
+		return 1
+	case "deny", "denied", "block", "blocked", "reject":
+		return 2
+	case "drop", "dropped":
+		return 6
+	case "alert", "detected":
+		return 15
+	}
+	return 99
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
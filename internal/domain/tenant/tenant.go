@@ -0,0 +1,65 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// Default is the tenant of data ingested without a tenant, and of all data
+// when multi-tenancy is not configured
+const Default = "default"
+
+// ContextKey is the context key of the tenant ID. It is a plain string so
+// that a *gin.Context set up by the API middleware resolves it as well.
+const ContextKey = "tenant_id"
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Config maps API credentials to tenants
+type Config struct {
+	// APIKeys maps API keys to the tenant they act for. Without keys every
+	// request acts for the default tenant.
+	APIKeys map[string]string `json:"-" yaml:"api_keys"`
+}
+
+// Validate checks that a tenant ID is usable in index names and cache keys:
+// lower case letters, digits, "-" and "_", at most 63 characters
+func Validate(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("invalid tenant id %q", id)
+	}
+	return nil
+}
+
+// Normalize maps the empty tenant of records written before multi-tenancy to
+// the default tenant
+func Normalize(id string) string {
+	if id == "" {
+		return Default
+	}
+	return id
+}
+
+// WithTenant returns a context acting for a tenant
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ContextKey, Normalize(id))
+}
+
+// FromContext returns the tenant a context acts for, or the default tenant
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(ContextKey).(string); ok {
+		return Normalize(id)
+	}
+	return Default
+}
+
+// Index returns the name of a tenant's index. The default tenant keeps the
+// unscoped names, so existing single-tenant indices stay readable.
+func Index(prefix, id, name string) string {
+	id = Normalize(id)
+	if id == Default {
+		return prefix + name
+	}
+	return prefix + id + "_" + name
+}
@@ -0,0 +1,72 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"acme", true},
+		{"acme-corp_2", true},
+		{"0day", true},
+		{"", false},
+		{"Acme", false},
+		{"-acme", false},
+		{"acme/corp", false},
+		{"acme corp", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if err := Validate(tt.id); (err == nil) != tt.valid {
+				t.Errorf("Validate(%q) = %v, want valid %v", tt.id, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{name: "no tenant", ctx: context.Background(), want: Default},
+		{name: "tenant", ctx: WithTenant(context.Background(), "acme"), want: "acme"},
+		{name: "empty tenant", ctx: WithTenant(context.Background(), ""), want: Default},
+		{name: "plain string key", ctx: context.WithValue(context.Background(), ContextKey, "globex"), want: "globex"},
+		{name: "value of another type", ctx: context.WithValue(context.Background(), ContextKey, 42), want: Default},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromContext(tt.ctx); got != tt.want {
+				t.Errorf("FromContext = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"", "securityai_events"},
+		{Default, "securityai_events"},
+		{"acme", "securityai_acme_events"},
+	}
+
+	for _, tt := range tests {
+		if got := Index("securityai_", tt.id, "events"); got != tt.want {
+			t.Errorf("Index(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
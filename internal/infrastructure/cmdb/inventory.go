@@ -0,0 +1,366 @@
+package cmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// Config locates the CMDB exports of the inventory
+type Config struct {
+	// Paths are CSV or JSON export files, or directories searched
+	// recursively for *.csv and *.json files
+	Paths []string `json:"paths" yaml:"paths"`
+	// Columns maps asset fields (ip, hostname, criticality, ...) to export
+	// column names that are not recognized by default
+	Columns map[string]string `json:"columns" yaml:"columns"`
+	// RefreshInterval is how often the exports are checked for changes.
+	// Zero disables refreshing.
+	RefreshInterval time.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+}
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() Config {
+	return Config{
+		Paths:           []string{"data/cmdb"},
+		RefreshInterval: 15 * time.Minute,
+	}
+}
+
+// Stats describes the loaded exports
+type Stats struct {
+	Files    int       `json:"files"`
+	Assets   int       `json:"assets"`
+	Networks int       `json:"networks"`
+	Skipped  int       `json:"skipped"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// index holds assets by address, network and hostname. It is built once and
+// then only read.
+type index struct {
+	ips      map[netip.Addr]*log.AssetInfo
+	prefixes map[netip.Prefix]*log.AssetInfo
+	// prefixBits are the prefix lengths present, longest first; IPv6
+	// lengths are offset by 1000
+	prefixBits []int
+	hosts      map[string]*log.AssetInfo
+	// shortHosts maps host names without domain to their asset, or to nil
+	// when several assets share the name
+	shortHosts map[string]*log.AssetInfo
+
+	stats     Stats
+	signature string
+}
+
+func newIndex() *index {
+	return &index{
+		ips:        make(map[netip.Addr]*log.AssetInfo),
+		prefixes:   make(map[netip.Prefix]*log.AssetInfo),
+		hosts:      make(map[string]*log.AssetInfo),
+		shortHosts: make(map[string]*log.AssetInfo),
+	}
+}
+
+// add indexes a record. Later records replace earlier ones with the same
+// address or hostname.
+func (x *index) add(r record) {
+	asset := r.asset
+	indexed := false
+
+	for _, ip := range r.ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			x.stats.Skipped++
+			continue
+		}
+		x.ips[addr.Unmap()] = &asset
+		indexed = true
+	}
+	for _, cidr := range r.cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			x.stats.Skipped++
+			continue
+		}
+		prefix = prefix.Masked()
+		if _, exists := x.prefixes[prefix]; !exists {
+			x.addPrefixBits(prefix)
+			x.stats.Networks++
+		}
+		x.prefixes[prefix] = &asset
+		indexed = true
+	}
+	if asset.Hostname != "" {
+		host := strings.TrimSuffix(asset.Hostname, ".")
+		x.hosts[host] = &asset
+		if short, _, found := strings.Cut(host, "."); found {
+			existing, ok := x.shortHosts[short]
+			switch {
+			case !ok:
+				x.shortHosts[short] = &asset
+			case existing == nil:
+				// Already ambiguous
+			case strings.TrimSuffix(existing.Hostname, ".") == host:
+				// A later record of the same host replaces the earlier one
+				x.shortHosts[short] = &asset
+			default:
+				x.shortHosts[short] = nil
+			}
+		}
+		indexed = true
+	}
+
+	if indexed {
+		x.stats.Assets++
+	} else {
+		x.stats.Skipped++
+	}
+}
+
+func (x *index) addPrefixBits(prefix netip.Prefix) {
+	bits := prefix.Bits()
+	if prefix.Addr().Is6() {
+		bits += 1000
+	}
+	for _, existing := range x.prefixBits {
+		if existing == bits {
+			return
+		}
+	}
+	x.prefixBits = append(x.prefixBits, bits)
+	sort.Sort(sort.Reverse(sort.IntSlice(x.prefixBits)))
+}
+
+// network returns the most specific network containing an address
+func (x *index) network(addr netip.Addr) *log.AssetInfo {
+	for _, bits := range x.prefixBits {
+		if addr.Is6() != (bits >= 1000) {
+			continue
+		}
+		if bits >= 1000 {
+			bits -= 1000
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if asset, ok := x.prefixes[prefix]; ok {
+			return asset
+		}
+	}
+	return nil
+}
+
+// Inventory is an asset store built from CMDB exports. It implements
+// log.AssetInventory. Refreshes build a new index and swap it in, so lookups
+// never wait for a load.
+type Inventory struct {
+	config  Config
+	loader  *loader
+	current atomic.Pointer[index]
+
+	refreshMutex sync.Mutex
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+var _ log.AssetInventory = (*Inventory)(nil)
+
+// NewInventory loads the configured exports
+func NewInventory(config Config) (*Inventory, error) {
+	if len(config.Paths) == 0 {
+		return nil, errors.New("no cmdb export configured")
+	}
+	loader, err := newLoader(config.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{config: config, loader: loader}
+	if err := inv.Refresh(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Refresh reloads the exports if any file was added, removed or changed. A
+// failed load leaves the previous inventory in place.
+func (inv *Inventory) Refresh() error {
+	inv.refreshMutex.Lock()
+	defer inv.refreshMutex.Unlock()
+
+	files, signature, err := inv.files()
+	if err != nil {
+		return err
+	}
+	if current := inv.current.Load(); current != nil && current.signature == signature {
+		return nil
+	}
+
+	x := newIndex()
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		records, err := inv.loader.parse(path, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, r := range records {
+			x.add(r)
+		}
+	}
+	x.signature = signature
+	x.stats.Files = len(files)
+	x.stats.LoadedAt = time.Now()
+
+	inv.current.Store(x)
+	return nil
+}
+
+// files lists the export files and a signature of their names, sizes and
+// modification times
+func (inv *Inventory) files() ([]string, string, error) {
+	var files []string
+	var signature strings.Builder
+	add := func(path string, info fs.FileInfo) {
+		files = append(files, path)
+		fmt.Fprintf(&signature, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	for _, root := range inv.config.Paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, "", err
+		}
+		if !info.IsDir() {
+			add(root, info)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			if d.IsDir() || (ext != ".csv" && ext != ".json") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			add(path, info)
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	sort.Strings(files)
+	return files, signature.String(), nil
+}
+
+// Start checks the exports for changes every RefreshInterval until Stop
+func (inv *Inventory) Start(ctx context.Context) {
+	if inv.config.RefreshInterval <= 0 {
+		return
+	}
+	ctx, inv.cancel = context.WithCancel(ctx)
+	inv.done = make(chan struct{})
+
+	go func() {
+		defer close(inv.done)
+		ticker := time.NewTicker(inv.config.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failed refreshes keep the previous inventory and are retried
+				inv.Refresh()
+			}
+		}
+	}()
+}
+
+// Stop ends refreshing
+func (inv *Inventory) Stop() {
+	if inv.cancel == nil {
+		return
+	}
+	inv.cancel()
+	<-inv.done
+}
+
+// Stats describes the currently loaded exports
+func (inv *Inventory) Stats() Stats {
+	return inv.current.Load().stats
+}
+
+// LookupIP returns the asset of an address. Fields the asset record leaves
+// empty, typically zone and environment, are taken from the most specific
+// network containing the address. Unknown addresses yield nil.
+func (inv *Inventory) LookupIP(ctx context.Context, ip string) (*log.AssetInfo, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip address %q", ip)
+	}
+	addr = addr.Unmap()
+
+	x := inv.current.Load()
+	asset, network := x.ips[addr], x.network(addr)
+	switch {
+	case asset == nil && network == nil:
+		return nil, nil
+	case asset == nil:
+		result := *network
+		return &result, nil
+	}
+
+	result := *asset
+	if network != nil {
+		fill(&result.Owner, network.Owner)
+		fill(&result.BusinessSystem, network.BusinessSystem)
+		fill(&result.Environment, network.Environment)
+		fill(&result.Criticality, network.Criticality)
+		fill(&result.NetworkZone, network.NetworkZone)
+	}
+	return &result, nil
+}
+
+// LookupHost returns the asset of a hostname. Names without domain match a
+// fully qualified asset name when it is unambiguous. Unknown hosts yield nil.
+func (inv *Inventory) LookupHost(ctx context.Context, hostname string) (*log.AssetInfo, error) {
+	host := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	x := inv.current.Load()
+
+	asset, ok := x.hosts[host]
+	if !ok && !strings.Contains(host, ".") {
+		asset = x.shortHosts[host]
+	}
+	if asset == nil {
+		return nil, nil
+	}
+	result := *asset
+	return &result, nil
+}
+
+func fill(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
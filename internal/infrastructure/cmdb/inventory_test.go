@@ -0,0 +1,108 @@
+package cmdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testExportCSV = `hostname,ip,subnet,owner,业务系统,env,重要性,zone
+web-1.prod.example.com,10.0.1.10;10.0.1.11,,alice,shop,PROD,核心,dmz
+web-1.prod.example.com,10.0.1.12,,bob,shop,prod,high,dmz
+db-1.prod.example.com,10.0.2.10,,carol,shop,prod,tier 2,core
+db-1.test.example.com,10.9.2.10,,dave,shop,test,low,test
+,,10.0.0.0/16,netops,,prod,,intranet
+,,10.0.1.0/24,webops,,,medium,dmz
+`
+
+func newTestInventory(t *testing.T) *Inventory {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "assets.csv")
+	if err := os.WriteFile(path, []byte(testExportCSV), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := NewInventory(Config{Paths: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv
+}
+
+func TestInventoryLookupHost(t *testing.T) {
+	inv := newTestInventory(t)
+
+	tests := []struct {
+		name      string
+		host      string
+		wantOwner string
+	}{
+		{name: "fqdn", host: "db-1.prod.example.com", wantOwner: "carol"},
+		{name: "case and trailing dot", host: "DB-1.Prod.Example.com.", wantOwner: "carol"},
+		{name: "later record of the same fqdn wins", host: "web-1.prod.example.com", wantOwner: "bob"},
+		{name: "short name of a repeated fqdn", host: "web-1", wantOwner: "bob"},
+		{name: "ambiguous short name", host: "db-1", wantOwner: ""},
+		{name: "unknown", host: "mail", wantOwner: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asset, err := inv.LookupHost(context.Background(), tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			owner := ""
+			if asset != nil {
+				owner = asset.Owner
+			}
+			if owner != tt.wantOwner {
+				t.Errorf("owner = %q, want %q", owner, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestInventoryLookupIP(t *testing.T) {
+	inv := newTestInventory(t)
+
+	tests := []struct {
+		name            string
+		ip              string
+		wantHost        string
+		wantOwner       string
+		wantCriticality string
+		wantZone        string
+	}{
+		{name: "asset address", ip: "10.0.1.10", wantHost: "web-1.prod.example.com", wantOwner: "alice", wantCriticality: "critical", wantZone: "dmz"},
+		{name: "ipv4-mapped", ip: "::ffff:10.0.2.10", wantHost: "db-1.prod.example.com", wantOwner: "carol", wantCriticality: "high", wantZone: "core"},
+		{name: "longest network", ip: "10.0.1.99", wantOwner: "webops", wantCriticality: "medium", wantZone: "dmz"},
+		{name: "wider network", ip: "10.0.5.1", wantOwner: "netops", wantZone: "intranet"},
+		{name: "unknown", ip: "192.168.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asset, err := inv.LookupIP(context.Background(), tt.ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantOwner == "" {
+				if asset != nil {
+					t.Errorf("got %+v, want nil", asset)
+				}
+				return
+			}
+			if asset == nil {
+				t.Fatal("got nil")
+			}
+			if asset.Hostname != tt.wantHost || asset.Owner != tt.wantOwner ||
+				asset.Criticality != tt.wantCriticality || asset.NetworkZone != tt.wantZone {
+				t.Errorf("got %+v", asset)
+			}
+		})
+	}
+
+	if stats := inv.Stats(); stats.Assets != 6 || stats.Networks != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,226 @@
+package cmdb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// Asset fields that export columns are mapped to
+const (
+	FieldID             = "id"
+	FieldHostname       = "hostname"
+	FieldIP             = "ip"
+	FieldCIDR           = "cidr"
+	FieldOwner          = "owner"
+	FieldBusinessSystem = "business_system"
+	FieldEnvironment    = "environment"
+	FieldCriticality    = "criticality"
+	FieldNetworkZone    = "network_zone"
+)
+
+// defaultColumns are the column names recognized for each field, compared
+// case-insensitively
+var defaultColumns = map[string][]string{
+	FieldID:             {"id", "asset_id", "ci_id", "资产编号"},
+	FieldHostname:       {"hostname", "host", "host_name", "fqdn", "主机名"},
+	FieldIP:             {"ip", "ips", "ip_address", "ip_addresses", "ip地址"},
+	FieldCIDR:           {"cidr", "subnet", "network", "网段"},
+	FieldOwner:          {"owner", "responsible", "负责人"},
+	FieldBusinessSystem: {"business_system", "system", "application", "业务系统"},
+	FieldEnvironment:    {"environment", "env", "环境"},
+	FieldCriticality:    {"criticality", "importance", "tier", "重要性", "等级"},
+	FieldNetworkZone:    {"network_zone", "zone", "security_zone", "安全域", "网络区域"},
+}
+
+// criticalityAliases normalize the criticality scales of common CMDBs
+var criticalityAliases = map[string]string{
+	"critical": entity.CriticalityCritical, "very high": entity.CriticalityCritical, "p1": entity.CriticalityCritical,
+	"tier1": entity.CriticalityCritical, "tier 1": entity.CriticalityCritical, "1": entity.CriticalityCritical,
+	"核心": entity.CriticalityCritical, "关键": entity.CriticalityCritical,
+	"high": entity.CriticalityHigh, "p2": entity.CriticalityHigh, "tier2": entity.CriticalityHigh,
+	"tier 2": entity.CriticalityHigh, "2": entity.CriticalityHigh, "重要": entity.CriticalityHigh, "高": entity.CriticalityHigh,
+	"medium": entity.CriticalityMedium, "moderate": entity.CriticalityMedium, "p3": entity.CriticalityMedium,
+	"tier3": entity.CriticalityMedium, "tier 3": entity.CriticalityMedium, "3": entity.CriticalityMedium,
+	"一般": entity.CriticalityMedium, "中": entity.CriticalityMedium,
+	"low": entity.CriticalityLow, "p4": entity.CriticalityLow, "tier4": entity.CriticalityLow,
+	"tier 4": entity.CriticalityLow, "4": entity.CriticalityLow, "次要": entity.CriticalityLow, "低": entity.CriticalityLow,
+}
+
+// record is one asset of an export with the addresses it is indexed by
+type record struct {
+	asset log.AssetInfo
+	ips   []string
+	cidrs []string
+}
+
+// loader parses exports with a fixed column mapping
+type loader struct {
+	// columns maps lowercase column names to fields
+	columns map[string]string
+}
+
+func newLoader(overrides map[string]string) (*loader, error) {
+	l := &loader{columns: make(map[string]string)}
+	for field, names := range defaultColumns {
+		for _, name := range names {
+			l.columns[name] = field
+		}
+	}
+	for field, column := range overrides {
+		if _, ok := defaultColumns[field]; !ok {
+			return nil, fmt.Errorf("unknown asset field %q in column mapping", field)
+		}
+		l.columns[strings.ToLower(strings.TrimSpace(column))] = field
+	}
+	return l, nil
+}
+
+// parse reads a CSV or JSON export, chosen by file extension
+func (l *loader) parse(path string, data []byte) ([]record, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return l.parseCSV(data)
+	case ".json":
+		return l.parseJSON(data)
+	}
+	return nil, errors.New("unsupported export format, want .csv or .json")
+}
+
+func (l *loader) parseCSV(data []byte) ([]record, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	fields := make([]string, len(rows[0]))
+	for i, column := range rows[0] {
+		fields[i] = l.columns[strings.ToLower(strings.TrimSpace(column))]
+	}
+
+	records := make([]record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		values := make(map[string]string)
+		for i, value := range row {
+			if i < len(fields) && fields[i] != "" {
+				values[fields[i]] = value
+			}
+		}
+		records = append(records, newRecord(values))
+	}
+	return records, nil
+}
+
+func (l *loader) parseJSON(data []byte) ([]record, error) {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		// Exports of CMDB APIs wrap the list in an envelope
+		var envelope map[string]json.RawMessage
+		if json.Unmarshal(data, &envelope) != nil {
+			return nil, fmt.Errorf("invalid json export: %w", err)
+		}
+		found := false
+		for _, key := range []string{"assets", "data", "items", "result", "records"} {
+			if raw, ok := envelope[key]; ok && json.Unmarshal(raw, &items) == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("json export has no list of assets")
+		}
+	}
+
+	records := make([]record, 0, len(items))
+	for _, item := range items {
+		values := make(map[string]string)
+		for key, value := range item {
+			if field := l.columns[strings.ToLower(key)]; field != "" {
+				values[field] = jsonString(value)
+			}
+		}
+		records = append(records, newRecord(values))
+	}
+	return records, nil
+}
+
+// jsonString flattens a JSON value to text; lists are joined with commas
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, jsonString(item))
+		}
+		return strings.Join(parts, ",")
+	}
+	return ""
+}
+
+func newRecord(values map[string]string) record {
+	for field, value := range values {
+		values[field] = strings.TrimSpace(value)
+	}
+
+	r := record{
+		asset: log.AssetInfo{
+			ID:             values[FieldID],
+			Hostname:       strings.ToLower(values[FieldHostname]),
+			Owner:          values[FieldOwner],
+			BusinessSystem: values[FieldBusinessSystem],
+			Environment:    strings.ToLower(values[FieldEnvironment]),
+			Criticality:    normalizeCriticality(values[FieldCriticality]),
+			NetworkZone:    values[FieldNetworkZone],
+		},
+		ips:   splitList(values[FieldIP]),
+		cidrs: splitList(values[FieldCIDR]),
+	}
+	// Some exports put networks in the address column
+	ips := r.ips[:0]
+	for _, ip := range r.ips {
+		if strings.Contains(ip, "/") {
+			r.cidrs = append(r.cidrs, ip)
+		} else {
+			ips = append(ips, ip)
+		}
+	}
+	r.ips = ips
+	return r
+}
+
+func normalizeCriticality(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if level, ok := criticalityAliases[value]; ok {
+		return level
+	}
+	return value
+}
+
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == '|' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
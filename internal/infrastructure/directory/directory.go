@@ -0,0 +1,334 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// Config locates the directory exports
+type Config struct {
+	// Paths are LDIF or CSV export files, or directories searched
+	// recursively for *.ldif, *.ldf and *.csv files
+	Paths []string `json:"paths" yaml:"paths"`
+	// PrivilegedGroups are the groups whose members are privileged accounts,
+	// compared case-insensitively
+	PrivilegedGroups []string `json:"privileged_groups" yaml:"privileged_groups"`
+	// RefreshInterval is how often the exports are checked for changes.
+	// Zero disables refreshing.
+	RefreshInterval time.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+}
+
+// DefaultConfig returns the default configuration with the built-in
+// administrative groups of Active Directory and common Unix admin groups
+func DefaultConfig() Config {
+	return Config{
+		Paths: []string{"data/directory"},
+		PrivilegedGroups: []string{
+			"Domain Admins", "Enterprise Admins", "Schema Admins", "Administrators",
+			"Account Operators", "Backup Operators", "Server Operators", "wheel", "sudo",
+		},
+		RefreshInterval: 15 * time.Minute,
+	}
+}
+
+// Stats describes the loaded exports
+type Stats struct {
+	Files    int       `json:"files"`
+	Users    int       `json:"users"`
+	Groups   int       `json:"groups"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// index holds the accounts by lowercase name. It is built once and then only
+// read.
+type index struct {
+	accounts  map[string]*account
+	stats     Stats
+	signature string
+}
+
+// Directory is a user store built from AD/LDAP exports. It implements
+// log.UserDirectory. Refreshes build a new index and swap it in, so lookups
+// never wait for a load.
+type Directory struct {
+	config     Config
+	privileged map[string]bool
+	current    atomic.Pointer[index]
+
+	refreshMutex sync.Mutex
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+var _ log.UserDirectory = (*Directory)(nil)
+
+// NewDirectory loads the configured exports
+func NewDirectory(config Config) (*Directory, error) {
+	if len(config.Paths) == 0 {
+		return nil, errors.New("no directory export configured")
+	}
+
+	d := &Directory{config: config, privileged: make(map[string]bool)}
+	for _, name := range config.PrivilegedGroups {
+		d.privileged[strings.ToLower(name)] = true
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Refresh reloads the exports if any file was added, removed or changed. A
+// failed load leaves the previous directory in place.
+func (d *Directory) Refresh() error {
+	d.refreshMutex.Lock()
+	defer d.refreshMutex.Unlock()
+
+	files, signature, err := d.files()
+	if err != nil {
+		return err
+	}
+	if current := d.current.Load(); current != nil && current.signature == signature {
+		return nil
+	}
+
+	var accounts []*account
+	var groups []group
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content, err := parse(path, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		accounts = append(accounts, content.accounts...)
+		groups = append(groups, content.groups...)
+	}
+
+	x := d.build(accounts, groups)
+	x.signature = signature
+	x.stats.Files = len(files)
+	x.stats.LoadedAt = time.Now()
+	d.current.Store(x)
+	return nil
+}
+
+// build indexes the accounts and resolves what exports only give as
+// references: group members, including members of nested groups, managers
+// and privileges
+func (d *Directory) build(accounts []*account, groups []group) *index {
+	x := &index{accounts: make(map[string]*account)}
+	byDN := make(map[string]*account)
+	for _, a := range accounts {
+		for _, name := range a.names {
+			x.accounts[name] = a
+		}
+		if a.dn != "" {
+			byDN[strings.ToLower(a.dn)] = a
+		}
+	}
+
+	groupsByDN := make(map[string]string)
+	for _, g := range groups {
+		if g.dn != "" {
+			groupsByDN[strings.ToLower(g.dn)] = g.name
+		}
+	}
+
+	// parents maps lowercase group names to the groups they are nested in
+	parents := make(map[string][]string)
+	for _, g := range groups {
+		key := strings.ToLower(g.name)
+		for _, parent := range g.memberOf {
+			parents[key] = appendUnique(parents[key], parent)
+		}
+		for _, member := range g.members {
+			a := x.accounts[strings.ToLower(member)]
+			if strings.Contains(member, "=") {
+				dn := strings.ToLower(member)
+				if child, ok := groupsByDN[dn]; ok {
+					key := strings.ToLower(child)
+					parents[key] = appendUnique(parents[key], g.name)
+					continue
+				}
+				a = byDN[dn]
+			}
+			if a != nil {
+				a.info.Groups = appendUnique(a.info.Groups, g.name)
+			}
+		}
+	}
+
+	for _, a := range accounts {
+		a.info.Groups = expandGroups(a.info.Groups, parents)
+		if a.managerDN != "" {
+			if manager, ok := byDN[strings.ToLower(a.managerDN)]; ok {
+				a.info.Manager = manager.info.Username
+			} else {
+				a.info.Manager = rdnValue(a.managerDN)
+			}
+		}
+		if a.adminCount {
+			a.info.Privileged = true
+		}
+		for _, name := range a.info.Groups {
+			if d.privileged[strings.ToLower(name)] {
+				a.info.Privileged = true
+			}
+		}
+	}
+
+	x.stats.Users = len(accounts)
+	x.stats.Groups = len(groups)
+	return x
+}
+
+// expandGroups adds the groups the given groups are nested in, transitively,
+// after the direct ones. Each group is visited once, so cyclic nesting ends.
+func expandGroups(direct []string, parents map[string][]string) []string {
+	groups := append([]string(nil), direct...)
+	visited := make(map[string]bool, len(groups))
+	for _, name := range groups {
+		visited[strings.ToLower(name)] = true
+	}
+	for i := 0; i < len(groups); i++ {
+		for _, parent := range parents[strings.ToLower(groups[i])] {
+			if key := strings.ToLower(parent); !visited[key] {
+				visited[key] = true
+				groups = append(groups, parent)
+			}
+		}
+	}
+	return groups
+}
+
+// files lists the export files and a signature of their names, sizes and
+// modification times
+func (d *Directory) files() ([]string, string, error) {
+	var files []string
+	var signature strings.Builder
+	add := func(path string, info fs.FileInfo) {
+		files = append(files, path)
+		fmt.Fprintf(&signature, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	for _, root := range d.config.Paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, "", err
+		}
+		if !info.IsDir() {
+			add(root, info)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".ldif", ".ldf", ".csv":
+			default:
+				return nil
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			add(path, info)
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	sort.Strings(files)
+	return files, signature.String(), nil
+}
+
+// Start checks the exports for changes every RefreshInterval until Stop
+func (d *Directory) Start(ctx context.Context) {
+	if d.config.RefreshInterval <= 0 {
+		return
+	}
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.config.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failed refreshes keep the previous directory and are retried
+				d.Refresh()
+			}
+		}
+	}()
+}
+
+// Stop ends refreshing
+func (d *Directory) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	<-d.done
+}
+
+// Stats describes the currently loaded exports
+func (d *Directory) Stats() Stats {
+	return d.current.Load().stats
+}
+
+// LookupUser returns the account of a user name. Down-level names
+// (DOMAIN\user), user principal names and email addresses are accepted.
+// Unknown users yield nil.
+func (d *Directory) LookupUser(ctx context.Context, user string) (*log.UserInfo, error) {
+	name := strings.ToLower(strings.TrimSpace(user))
+	if name == "" {
+		return nil, nil
+	}
+
+	x := d.current.Load()
+	a, ok := x.accounts[name]
+	if !ok {
+		if slash := strings.LastIndexByte(name, '\\'); slash >= 0 {
+			name = name[slash+1:]
+			a, ok = x.accounts[name]
+		}
+	}
+	if !ok {
+		if local, _, found := strings.Cut(name, "@"); found {
+			a, ok = x.accounts[local]
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	info := a.info
+	info.Groups = append([]string(nil), a.info.Groups...)
+	if info.Status == log.UserStatusEnabled && !a.expires.IsZero() && a.expires.Before(time.Now()) {
+		info.Status = log.UserStatusExpired
+	}
+	return &info, nil
+}
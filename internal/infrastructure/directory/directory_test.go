@@ -0,0 +1,175 @@
+package directory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+const testLDIF = `dn: CN=Alice,OU=Staff,DC=corp,DC=example
+objectClass: user
+sAMAccountName: alice
+userPrincipalName: alice@corp.example
+mail: alice.w@corp.example
+manager: CN=Carol,OU=Staff,DC=corp,DC=example
+userAccountControl: 512
+
+dn: CN=Bob,OU=Staff,DC=corp,DC=example
+objectClass: user
+sAMAccountName: bob
+userAccountControl: 514
+
+dn: CN=Carol,OU=Staff,DC=corp,DC=example
+objectClass: user
+sAMAccountName: carol
+memberOf: CN=Helpdesk,OU=Groups,DC=corp,DC=example
+
+dn: CN=Dave,OU=Staff,DC=corp,DC=example
+objectClass: user
+sAMAccountName: dave
+lockoutTime: 133000000000000000
+
+dn: CN=DBA,OU=Groups,DC=corp,DC=example
+objectClass: group
+cn: DBA
+member: CN=Alice,OU=Staff,DC=corp,DC=example
+
+dn: CN=Tier0 Operators,OU=Groups,DC=corp,DC=example
+objectClass: group
+cn: Tier0 Operators
+member: CN=DBA,OU=Groups,DC=corp,DC=example
+
+dn: CN=Domain Admins,CN=Users,DC=corp,DC=example
+objectClass: group
+cn: Domain Admins
+member: CN=Tier0 Operators,OU=Groups,DC=corp,DC=example
+
+dn: CN=Helpdesk,OU=Groups,DC=corp,DC=example
+objectClass: group
+cn: Helpdesk
+memberOf: CN=Support,OU=Groups,DC=corp,DC=example
+
+dn: CN=Support,OU=Groups,DC=corp,DC=example
+objectClass: group
+cn: Support
+member: CN=Helpdesk,OU=Groups,DC=corp,DC=example
+`
+
+func newTestDirectory(t *testing.T) *Directory {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "corp.ldif")
+	if err := os.WriteFile(path, []byte(testLDIF), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.Paths = []string{path}
+	d, err := NewDirectory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDirectoryLookupUser(t *testing.T) {
+	d := newTestDirectory(t)
+
+	tests := []struct {
+		name           string
+		user           string
+		wantUsername   string
+		wantGroups     string
+		wantPrivileged bool
+		wantStatus     string
+		wantManager    string
+	}{
+		{
+			name:           "nested groups grant privileges",
+			user:           "alice",
+			wantUsername:   "alice",
+			wantGroups:     "DBA,Tier0 Operators,Domain Admins",
+			wantPrivileged: true,
+			wantStatus:     log.UserStatusEnabled,
+			wantManager:    "carol",
+		},
+		{
+			name:           "down-level name",
+			user:           `CORP\alice`,
+			wantUsername:   "alice",
+			wantGroups:     "DBA,Tier0 Operators,Domain Admins",
+			wantPrivileged: true,
+			wantStatus:     log.UserStatusEnabled,
+			wantManager:    "carol",
+		},
+		{
+			name:         "cyclic nesting terminates",
+			user:         "carol",
+			wantUsername: "carol",
+			wantGroups:   "Helpdesk,Support",
+			wantStatus:   log.UserStatusEnabled,
+		},
+		{
+			name:         "disabled account",
+			user:         "bob",
+			wantUsername: "bob",
+			wantStatus:   log.UserStatusDisabled,
+		},
+		{
+			name:         "locked account",
+			user:         "dave",
+			wantUsername: "dave",
+			wantStatus:   log.UserStatusLocked,
+		},
+		{
+			name: "unknown user",
+			user: "mallory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := d.LookupUser(context.Background(), tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantUsername == "" {
+				if info != nil {
+					t.Errorf("got %+v, want nil", info)
+				}
+				return
+			}
+			if info == nil {
+				t.Fatal("got nil")
+			}
+			if info.Username != tt.wantUsername || strings.Join(info.Groups, ",") != tt.wantGroups ||
+				info.Privileged != tt.wantPrivileged || info.Status != tt.wantStatus || info.Manager != tt.wantManager {
+				t.Errorf("got %+v", info)
+			}
+		})
+	}
+}
+
+func TestExpandGroups(t *testing.T) {
+	parents := map[string][]string{
+		"a": {"B", "C"},
+		"b": {"C", "D"},
+		"d": {"A"},
+	}
+	tests := []struct {
+		direct []string
+		want   string
+	}{
+		{[]string{"A"}, "A,B,C,D"},
+		{[]string{"D"}, "D,A,B,C"},
+		{[]string{"x"}, "x"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(expandGroups(tt.direct, parents), ","); got != tt.want {
+			t.Errorf("expandGroups(%v) = %q, want %q", tt.direct, got, tt.want)
+		}
+	}
+}
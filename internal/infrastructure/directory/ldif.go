@@ -0,0 +1,134 @@
+package directory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ldifEntry is one record of an LDIF file. Attribute names are lowercase.
+type ldifEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+func (e *ldifEntry) first(names ...string) string {
+	for _, name := range names {
+		if values := e.attrs[name]; len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (e *ldifEntry) has(name, value string) bool {
+	for _, v := range e.attrs[name] {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLDIF reads the content records of an LDIF (RFC 2849) export. Change
+// records other than additions are ignored, as are values given by URL.
+func parseLDIF(data []byte) ([]*ldifEntry, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		// A leading space continues the previous line
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var entries []*ldifEntry
+	var current *ldifEntry
+	flush := func() {
+		if current != nil && current.dn != "" {
+			if changetype := current.first("changetype"); changetype == "" || changetype == "add" {
+				entries = append(entries, current)
+			}
+		}
+		current = nil
+	}
+
+	for number, line := range lines {
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, fmt.Errorf("ldif line %d: missing attribute separator", number+1)
+		}
+		name := strings.ToLower(line[:colon])
+		value := line[colon+1:]
+		switch {
+		case strings.HasPrefix(value, ":"):
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("ldif line %d: %w", number+1, err)
+			}
+			value = string(decoded)
+		case strings.HasPrefix(value, "<"):
+			continue
+		default:
+			value = strings.TrimLeft(value, " ")
+		}
+
+		if current == nil {
+			if name == "version" {
+				continue
+			}
+			if name != "dn" {
+				return nil, fmt.Errorf("ldif line %d: record does not start with dn", number+1)
+			}
+			current = &ldifEntry{dn: value, attrs: make(map[string][]string)}
+			continue
+		}
+		current.attrs[name] = append(current.attrs[name], value)
+	}
+	flush()
+	return entries, nil
+}
+
+// rdnValue returns the value of the first relative name of a distinguished
+// name, such as "Domain Admins" for "CN=Domain Admins,CN=Users,DC=corp".
+// Values that are not distinguished names are returned unchanged.
+func rdnValue(dn string) string {
+	equals := strings.IndexByte(dn, '=')
+	if equals <= 0 || strings.ContainsAny(dn[:equals], " ,") {
+		return dn
+	}
+
+	var value strings.Builder
+	escaped := false
+	for _, c := range dn[equals+1:] {
+		switch {
+		case escaped:
+			value.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',' || c == '+':
+			return strings.TrimSpace(value.String())
+		default:
+			value.WriteRune(c)
+		}
+	}
+	return strings.TrimSpace(value.String())
+}
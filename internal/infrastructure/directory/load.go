@@ -0,0 +1,323 @@
+package directory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// Active Directory userAccountControl flags
+const (
+	uacAccountDisable = 0x2
+	uacLockout        = 0x10
+)
+
+// account is a user record together with what is needed to finish it once
+// all exports are read
+type account struct {
+	info log.UserInfo
+	// names are the lowercase names the account is looked up by
+	names     []string
+	dn        string
+	managerDN string
+	// adminCount marks accounts AD protects as members of admin groups
+	adminCount bool
+	// expires is when the account expires; zero for never
+	expires time.Time
+}
+
+// group is a group record listing its members
+type group struct {
+	name string
+	dn   string
+	// members are member distinguished names or, for posixGroup, user names.
+	// Members may be groups themselves.
+	members []string
+	// memberOf are the groups the group is nested in
+	memberOf []string
+}
+
+// export is the content of one export file
+type export struct {
+	accounts []*account
+	groups   []group
+}
+
+// parse reads an LDIF or CSV export, chosen by file extension
+func parse(path string, data []byte) (*export, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ldif", ".ldf":
+		entries, err := parseLDIF(data)
+		if err != nil {
+			return nil, err
+		}
+		return fromLDIF(entries), nil
+	case ".csv":
+		return parseCSV(data)
+	}
+	return nil, errors.New("unsupported export format, want .ldif or .csv")
+}
+
+func fromLDIF(entries []*ldifEntry) *export {
+	result := &export{}
+	for _, entry := range entries {
+		switch {
+		case entry.has("objectclass", "computer"):
+			continue
+		case entry.has("objectclass", "group") || entry.has("objectclass", "groupofnames") ||
+			entry.has("objectclass", "groupofuniquenames") || entry.has("objectclass", "posixgroup"):
+			g := group{name: entry.first("cn", "samaccountname"), dn: entry.dn}
+			if g.name == "" {
+				g.name = rdnValue(entry.dn)
+			}
+			for _, dn := range entry.attrs["memberof"] {
+				g.memberOf = append(g.memberOf, rdnValue(dn))
+			}
+			g.members = append(g.members, entry.attrs["member"]...)
+			g.members = append(g.members, entry.attrs["uniquemember"]...)
+			g.members = append(g.members, entry.attrs["memberuid"]...)
+			result.groups = append(result.groups, g)
+		case entry.has("objectclass", "user") || entry.has("objectclass", "person") ||
+			entry.has("objectclass", "inetorgperson") || entry.has("objectclass", "posixaccount"):
+			if a := accountFromLDIF(entry); a != nil {
+				result.accounts = append(result.accounts, a)
+			}
+		}
+	}
+	return result
+}
+
+func accountFromLDIF(entry *ldifEntry) *account {
+	upn := entry.first("userprincipalname")
+	username := entry.first("samaccountname", "uid")
+	if username == "" && upn != "" {
+		username, _, _ = strings.Cut(upn, "@")
+	}
+	if username == "" {
+		return nil
+	}
+
+	a := &account{
+		info: log.UserInfo{
+			Username:    username,
+			DisplayName: entry.first("displayname", "cn"),
+			Email:       entry.first("mail"),
+			Department:  entry.first("department", "departmentnumber"),
+			Title:       entry.first("title"),
+			Status:      log.UserStatusEnabled,
+		},
+		dn:        entry.dn,
+		managerDN: entry.first("manager"),
+	}
+	if a.info.DisplayName == "" {
+		a.info.DisplayName = rdnValue(entry.dn)
+	}
+	a.names = lowerNames(username, upn, a.info.Email)
+	for _, dn := range entry.attrs["memberof"] {
+		a.info.Groups = appendUnique(a.info.Groups, rdnValue(dn))
+	}
+	a.adminCount = entry.first("admincount") == "1"
+
+	// Active Directory
+	if uac, err := strconv.ParseInt(entry.first("useraccountcontrol"), 10, 64); err == nil {
+		switch {
+		case uac&uacAccountDisable != 0:
+			a.info.Status = log.UserStatusDisabled
+		case uac&uacLockout != 0:
+			a.info.Status = log.UserStatusLocked
+		}
+	}
+	if lockout, err := strconv.ParseInt(entry.first("lockouttime"), 10, 64); err == nil && lockout > 0 && a.info.Status == log.UserStatusEnabled {
+		a.info.Status = log.UserStatusLocked
+	}
+	if expires, err := strconv.ParseInt(entry.first("accountexpires"), 10, 64); err == nil {
+		a.expires = fileTime(expires)
+	}
+
+	// OpenLDAP and 389 Directory Server
+	if strings.EqualFold(entry.first("nsaccountlock"), "true") {
+		a.info.Status = log.UserStatusDisabled
+	}
+	if entry.first("pwdaccountlockedtime") != "" && a.info.Status == log.UserStatusEnabled {
+		a.info.Status = log.UserStatusLocked
+	}
+	if days, err := strconv.ParseInt(entry.first("shadowexpire"), 10, 64); err == nil && days >= 0 {
+		a.expires = time.Unix(days*86400, 0).UTC()
+	}
+	return a
+}
+
+// fileTime converts a Windows FILETIME (100ns intervals since 1601). Zero and
+// the maximum value mean the account never expires.
+func fileTime(value int64) time.Time {
+	if value <= 0 || value == math.MaxInt64 {
+		return time.Time{}
+	}
+	const epochDelta = 116444736000000000
+	return time.Unix(0, (value-epochDelta)*100).UTC()
+}
+
+// csvColumns are the column names recognized for each account field,
+// compared case-insensitively
+var csvColumns = map[string][]string{
+	"username":     {"username", "user", "samaccountname", "uid", "login", "account", "账号", "用户名"},
+	"display_name": {"display_name", "displayname", "name", "cn", "姓名"},
+	"email":        {"email", "mail", "邮箱"},
+	"upn":          {"upn", "userprincipalname"},
+	"department":   {"department", "dept", "部门"},
+	"title":        {"title", "job_title", "职位"},
+	"manager":      {"manager", "上级"},
+	"groups":       {"groups", "memberof", "member_of", "用户组"},
+	"privileged":   {"privileged", "is_admin", "admin", "特权"},
+	"status":       {"status", "account_status", "状态"},
+	"enabled":      {"enabled", "active"},
+	"expires":      {"expires", "account_expires", "expiry", "过期时间"},
+}
+
+// statusAliases normalize the account status values of CSV exports
+var statusAliases = map[string]string{
+	"enabled": log.UserStatusEnabled, "active": log.UserStatusEnabled, "normal": log.UserStatusEnabled,
+	"正常": log.UserStatusEnabled, "启用": log.UserStatusEnabled,
+	"disabled": log.UserStatusDisabled, "inactive": log.UserStatusDisabled, "suspended": log.UserStatusDisabled,
+	"禁用": log.UserStatusDisabled, "停用": log.UserStatusDisabled,
+	"locked": log.UserStatusLocked, "锁定": log.UserStatusLocked,
+	"expired": log.UserStatusExpired, "过期": log.UserStatusExpired,
+}
+
+func parseCSV(data []byte) (*export, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	result := &export{}
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	columns := make(map[string]string)
+	for field, names := range csvColumns {
+		for _, name := range names {
+			columns[name] = field
+		}
+	}
+	fields := make([]string, len(rows[0]))
+	for i, column := range rows[0] {
+		fields[i] = columns[strings.ToLower(strings.TrimSpace(column))]
+	}
+
+	for _, row := range rows[1:] {
+		values := make(map[string]string)
+		for i, value := range row {
+			if i < len(fields) && fields[i] != "" {
+				values[fields[i]] = strings.TrimSpace(value)
+			}
+		}
+		if a := accountFromCSV(values); a != nil {
+			result.accounts = append(result.accounts, a)
+		}
+	}
+	return result, nil
+}
+
+func accountFromCSV(values map[string]string) *account {
+	username := values["username"]
+	if username == "" && values["upn"] != "" {
+		username, _, _ = strings.Cut(values["upn"], "@")
+	}
+	if username == "" {
+		return nil
+	}
+
+	a := &account{
+		info: log.UserInfo{
+			Username:    username,
+			DisplayName: values["display_name"],
+			Email:       values["email"],
+			Department:  values["department"],
+			Title:       values["title"],
+			Status:      log.UserStatusEnabled,
+		},
+	}
+	a.names = lowerNames(username, values["upn"], a.info.Email)
+	// Managers are given as user names or as distinguished names
+	if strings.Contains(values["manager"], "=") {
+		a.managerDN = values["manager"]
+	} else {
+		a.info.Manager = values["manager"]
+	}
+	for _, name := range splitGroups(values["groups"]) {
+		a.info.Groups = appendUnique(a.info.Groups, rdnValue(name))
+	}
+	a.info.Privileged = parseBool(values["privileged"])
+
+	if status, ok := statusAliases[strings.ToLower(values["status"])]; ok {
+		a.info.Status = status
+	} else if enabled := values["enabled"]; enabled != "" && !parseBool(enabled) {
+		a.info.Status = log.UserStatusDisabled
+	}
+	if expires := values["expires"]; expires != "" {
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, expires); err == nil {
+				a.expires = t
+				break
+			}
+		}
+	}
+	return a
+}
+
+// splitGroups splits a group list on semicolons or pipes. Commas only
+// separate groups when the list holds no distinguished names.
+func splitGroups(value string) []string {
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' })
+	if len(parts) == 1 && !strings.Contains(value, "=") {
+		parts = strings.Split(value, ",")
+	}
+	groups := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			groups = append(groups, part)
+		}
+	}
+	return groups
+}
+
+func parseBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "y", "1", "是":
+		return true
+	}
+	return false
+}
+
+func lowerNames(names ...string) []string {
+	var result []string
+	for _, name := range names {
+		if name != "" {
+			result = appendUnique(result, strings.ToLower(name))
+		}
+	}
+	return result
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if strings.EqualFold(existing, value) {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,276 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// Config locates the MaxMind databases. Either path may be empty.
+type Config struct {
+	// CityPath is a GeoLite2/GeoIP2 City or Country database
+	CityPath string `json:"city_path" yaml:"city_path"`
+	// ASNPath is a GeoLite2/GeoIP2 ASN database
+	ASNPath string `json:"asn_path" yaml:"asn_path"`
+	// Languages are the preferred languages of place names, in order
+	Languages []string `json:"languages" yaml:"languages"`
+	// ReloadInterval is how often the files are checked for replacement.
+	// Zero disables hot reloading.
+	ReloadInterval time.Duration `json:"reload_interval" yaml:"reload_interval"`
+}
+
+// DefaultConfig returns the default configuration with the GeoLite2 file names
+func DefaultConfig() Config {
+	return Config{
+		CityPath:       "data/GeoLite2-City.mmdb",
+		ASNPath:        "data/GeoLite2-ASN.mmdb",
+		Languages:      []string{"en"},
+		ReloadInterval: time.Minute,
+	}
+}
+
+// file is a loaded database together with the identity of the file it was
+// read from
+type file struct {
+	reader *Reader
+	info   os.FileInfo
+}
+
+// Database implements log.GeoIPDatabase over a City and an ASN database.
+// Replaced files are picked up by Reload; lookups in flight keep using the
+// previous reader, so a swap never blocks or fails a lookup.
+type Database struct {
+	config Config
+	city   atomic.Pointer[file]
+	asn    atomic.Pointer[file]
+
+	// reloadMutex serialises reloads and guards cancel and done
+	reloadMutex sync.Mutex
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+var _ log.GeoIPDatabase = (*Database)(nil)
+
+// NewDatabase opens the configured databases
+func NewDatabase(config Config) (*Database, error) {
+	if config.CityPath == "" && config.ASNPath == "" {
+		return nil, errors.New("no geoip database configured")
+	}
+	if len(config.Languages) == 0 {
+		config.Languages = []string{"en"}
+	}
+
+	d := &Database{config: config}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload reopens the databases whose files changed since they were loaded.
+// A file that fails to load leaves the previous database in place.
+func (d *Database) Reload() error {
+	d.reloadMutex.Lock()
+	defer d.reloadMutex.Unlock()
+
+	var errs []error
+	if err := reload(&d.city, d.config.CityPath); err != nil {
+		errs = append(errs, err)
+	}
+	if err := reload(&d.asn, d.config.ASNPath); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func reload(current *atomic.Pointer[file], path string) error {
+	if path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if loaded := current.Load(); loaded != nil && !changed(loaded.info, info) {
+		return nil
+	}
+
+	reader, err := Open(path)
+	if err != nil {
+		return err
+	}
+	current.Store(&file{reader: reader, info: info})
+	return nil
+}
+
+// changed reports whether a file was replaced or rewritten
+func changed(old, new os.FileInfo) bool {
+	return !os.SameFile(old, new) || !old.ModTime().Equal(new.ModTime()) || old.Size() != new.Size()
+}
+
+// Start checks the files for replacement every ReloadInterval until Stop. It
+// does nothing while reloading is already running.
+func (d *Database) Start(ctx context.Context) {
+	if d.config.ReloadInterval <= 0 {
+		return
+	}
+
+	d.reloadMutex.Lock()
+	defer d.reloadMutex.Unlock()
+	if d.cancel != nil {
+		return
+	}
+	ctx, d.cancel = context.WithCancel(ctx)
+	done := make(chan struct{})
+	d.done = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(d.config.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failed reloads keep the previous database and are retried
+				d.Reload()
+			}
+		}
+	}()
+}
+
+// Stop ends hot reloading. Reloading can be started again afterwards.
+func (d *Database) Stop() {
+	// The reload loop takes reloadMutex, so it is released before waiting
+	d.reloadMutex.Lock()
+	cancel, done := d.cancel, d.done
+	d.cancel, d.done = nil, nil
+	d.reloadMutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Lookup returns the location and network owner of an address. Addresses
+// missing from the databases, such as private ranges, yield empty data.
+func (d *Database) Lookup(ip string) (*log.GeoData, error) {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return nil, fmt.Errorf("invalid ip address %q", ip)
+	}
+
+	data := &log.GeoData{}
+	if city := d.city.Load(); city != nil {
+		record, err := lookup(city.reader, address)
+		if err != nil {
+			return nil, err
+		}
+		d.applyCity(data, record)
+		data.UpdatedAt = time.Unix(int64(city.reader.Metadata().BuildEpoch), 0)
+	}
+	if asn := d.asn.Load(); asn != nil {
+		record, err := lookup(asn.reader, address)
+		if err != nil {
+			return nil, err
+		}
+		applyASN(data, record)
+	}
+	return data, nil
+}
+
+// Metadata returns the metadata of the loaded City and ASN databases
+func (d *Database) Metadata() (city, asn *Metadata) {
+	if f := d.city.Load(); f != nil {
+		m := f.reader.Metadata()
+		city = &m
+	}
+	if f := d.asn.Load(); f != nil {
+		m := f.reader.Metadata()
+		asn = &m
+	}
+	return city, asn
+}
+
+// lookup returns the record of an address as a map, or nil if there is none
+func lookup(reader *Reader, ip net.IP) (map[string]interface{}, error) {
+	if ip.To4() == nil && reader.Metadata().IPVersion == 4 {
+		return nil, nil
+	}
+	value, _, err := reader.Lookup(ip)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// applyCity copies the fields of a City or Country record
+func (d *Database) applyCity(data *log.GeoData, record map[string]interface{}) {
+	if record == nil {
+		return
+	}
+
+	country := object(record, "country")
+	if country == nil {
+		country = object(record, "registered_country")
+	}
+	if code, ok := country["iso_code"].(string); ok {
+		data.Country = code
+	}
+	data.City = d.name(object(record, "city"))
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		if first, ok := subdivisions[0].(map[string]interface{}); ok {
+			data.Region = d.name(first)
+		}
+	}
+
+	location := object(record, "location")
+	data.Latitude, _ = location["latitude"].(float64)
+	data.Longitude, _ = location["longitude"].(float64)
+
+	// GeoIP2 Enterprise and ISP records carry the network owner as traits
+	applyASN(data, object(record, "traits"))
+}
+
+// applyASN copies the fields of an ASN record
+func applyASN(data *log.GeoData, record map[string]interface{}) {
+	if number, ok := record["autonomous_system_number"].(uint64); ok {
+		data.ASN = "AS" + strconv.FormatUint(number, 10)
+	}
+	if org, ok := record["autonomous_system_organization"].(string); ok {
+		data.ASNOrg = org
+	}
+}
+
+// name picks the place name in the first preferred language available
+func (d *Database) name(place map[string]interface{}) string {
+	names := object(place, "names")
+	for _, language := range d.config.Languages {
+		if name, ok := names[language].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func object(m map[string]interface{}, key string) map[string]interface{} {
+	value, _ := m[key].(map[string]interface{})
+	return value
+}
@@ -0,0 +1,205 @@
+package geoip
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+const (
+	testCityPath = "testdata/GeoLite2-City-Test.mmdb"
+	testASNPath  = "testdata/GeoLite2-ASN-Test.mmdb"
+)
+
+func TestDatabaseLookup(t *testing.T) {
+	db, err := NewDatabase(Config{CityPath: testCityPath, ASNPath: testASNPath, Languages: []string{"zh-CN", "en"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ip      string
+		want    log.GeoData
+		wantErr bool
+	}{
+		{
+			name: "city and asn",
+			ip:   "81.2.69.142",
+			want: log.GeoData{Country: "GB", City: "伦敦", Region: "英格兰", ASN: "AS20712", ASNOrg: "Andrews & Arnold Ltd", Latitude: 51.5142, Longitude: -0.0931},
+		},
+		{
+			name: "ipv4-mapped ipv6",
+			ip:   "::ffff:175.16.199.10",
+			want: log.GeoData{Country: "CN", City: "长春", Region: "吉林", ASN: "AS4837", ASNOrg: "CHINA UNICOM China169 Backbone", Latitude: 43.88, Longitude: 125.3228},
+		},
+		{
+			name: "ipv6",
+			ip:   "2001:480::1",
+			want: log.GeoData{Country: "US", City: "圣迭戈", ASN: "AS668", ASNOrg: "DoD Network Information Center", Latitude: 32.7203, Longitude: -117.1552},
+		},
+		{
+			name: "asn only",
+			ip:   "1.128.0.1",
+			want: log.GeoData{ASN: "AS1221", ASNOrg: "Telstra Pty Ltd"},
+		},
+		{
+			name: "not found",
+			ip:   "10.0.0.1",
+			want: log.GeoData{},
+		},
+		{
+			name:    "invalid address",
+			ip:      "not-an-ip",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Lookup(tt.ip)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.UpdatedAt = time.Time{}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func copyFile(t *testing.T, from, to string, modTime time.Time) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(to, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(to, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabaseReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	base := time.Now().Add(-time.Hour)
+
+	// Start with a database without city records
+	copyFile(t, testASNPath, path, base)
+	db, err := NewDatabase(Config{CityPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.Lookup("81.2.69.142"); got.City != "" {
+		t.Fatalf("city = %q before reload", got.City)
+	}
+
+	tests := []struct {
+		name     string
+		from     string
+		modTime  time.Time
+		wantErr  bool
+		wantCity string
+	}{
+		{name: "replaced file is picked up", from: testCityPath, modTime: base.Add(time.Minute), wantCity: "London"},
+		{name: "unchanged file is kept", from: "", wantCity: "London"},
+		{name: "corrupt file keeps the previous database", from: "database_test.go", modTime: base.Add(2 * time.Minute), wantErr: true, wantCity: "London"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.from != "" {
+				copyFile(t, tt.from, path, tt.modTime)
+			}
+			if err := db.Reload(); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := db.Lookup("81.2.69.142")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.City != tt.wantCity {
+				t.Errorf("city = %q, want %q", got.City, tt.wantCity)
+			}
+		})
+	}
+}
+
+func TestDatabaseStartStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	base := time.Now().Add(-time.Hour)
+	copyFile(t, testASNPath, path, base)
+	db, err := NewDatabase(Config{CityPath: path, ReloadInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent starts run a single reload loop and concurrent stops end it
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.Start(context.Background())
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.Stop()
+		}()
+	}
+	wg.Wait()
+
+	// Reloading runs again after a restart
+	db.Start(context.Background())
+	defer db.Stop()
+	copyFile(t, testCityPath, path, base.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := db.Lookup("81.2.69.142")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.City == "London" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replaced file was not reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFromBytesRejectsGarbage(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no metadata", []byte("not a maxmind database")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromBytes(tt.data); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
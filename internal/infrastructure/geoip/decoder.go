@@ -0,0 +1,224 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// MaxMind DB data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds nesting so that corrupt files cannot recurse forever
+const maxDepth = 32
+
+var errCorrupt = errors.New("invalid mmdb data section")
+
+// uintWidths are the largest sizes of the unsigned integer types
+var uintWidths = map[int]uint{typeUint16: 2, typeUint32: 4, typeUint64: 8}
+
+// decoder reads values of the MaxMind DB data section format. Pointers are
+// offsets relative to the start of the section.
+type decoder struct {
+	data []byte
+}
+
+// decode returns the value at an offset and the offset following it. Maps
+// decode to map[string]interface{}, arrays to []interface{}, unsigned
+// integers to uint64 and uint128 to *big.Int.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("%w: nesting too deep", errCorrupt)
+	}
+
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kind == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decodeDepth(target, depth+1)
+		return value, next, err
+	}
+
+	switch kind {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is %T", errCorrupt, key)
+			}
+			value, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("%w: boolean of size %d", errCorrupt, size)
+		}
+		return size == 1, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, 0, fmt.Errorf("%w: unexpected type %d", errCorrupt, kind)
+	}
+
+	end := offset + size
+	if end > uint(len(d.data)) || end < offset {
+		return nil, 0, fmt.Errorf("%w: value exceeds data section", errCorrupt)
+	}
+	raw := d.data[offset:end]
+
+	switch kind {
+	case typeString:
+		return string(raw), end, nil
+	case typeBytes:
+		return append([]byte(nil), raw...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of size %d", errCorrupt, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of size %d", errCorrupt, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > uintWidths[kind] {
+			return nil, 0, fmt.Errorf("%w: integer of size %d", errCorrupt, size)
+		}
+		return uintFromBytes(raw), end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("%w: integer of size %d", errCorrupt, size)
+		}
+		return int64(int32(uintFromBytes(raw))), end, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("%w: integer of size %d", errCorrupt, size)
+		}
+		return new(big.Int).SetBytes(raw), end, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown type %d", errCorrupt, kind)
+}
+
+// control parses the control byte and size of the value at an offset. For
+// pointers the size holds the raw size bits of the control byte.
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, fmt.Errorf("%w: offset %d out of range", errCorrupt, offset)
+	}
+	ctrl := d.data[offset]
+	offset++
+
+	kind := int(ctrl >> 5)
+	if kind == typePointer {
+		return kind, uint(ctrl & 0x1f), offset, nil
+	}
+	if kind == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, fmt.Errorf("%w: truncated extended type", errCorrupt)
+		}
+		kind = 7 + int(d.data[offset])
+		offset++
+		if kind < typeInt32 {
+			return 0, 0, 0, fmt.Errorf("%w: invalid extended type %d", errCorrupt, kind)
+		}
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.data)) {
+			return 0, 0, 0, fmt.Errorf("%w: truncated size", errCorrupt)
+		}
+		extra := uintFromBytes(d.data[offset : offset+n])
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(extra)
+		case 2:
+			size = 285 + uint(extra)
+		default:
+			size = 65821 + uint(extra)
+		}
+	}
+	return kind, size, offset, nil
+}
+
+// pointer resolves a pointer whose control byte carried the given size bits
+func (d *decoder) pointer(bits uint, offset uint) (uint, uint, error) {
+	n := ((bits >> 3) & 0x3) + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, fmt.Errorf("%w: truncated pointer", errCorrupt)
+	}
+	raw := uint(uintFromBytes(d.data[offset : offset+n]))
+	prefix := bits & 0x7
+
+	var target uint
+	switch n {
+	case 1:
+		target = prefix<<8 | raw
+	case 2:
+		target = (prefix<<16 | raw) + 2048
+	case 3:
+		target = (prefix<<24 | raw) + 526336
+	default:
+		target = raw
+	}
+	return target, offset + n, nil
+}
+
+func uintFromBytes(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
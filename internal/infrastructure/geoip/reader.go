@@ -0,0 +1,243 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of a database
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// metadataMaxSize is how far from the end of the file the marker is searched
+const metadataMaxSize = 128 * 1024
+
+// dataSeparatorSize is the gap of zero bytes between search tree and data
+const dataSeparatorSize = 16
+
+// ErrNotFound is returned for addresses not contained in a database
+var ErrNotFound = errors.New("address not found in geoip database")
+
+// Metadata describes a MaxMind DB file
+type Metadata struct {
+	DatabaseType string
+	IPVersion    int
+	RecordSize   int
+	NodeCount    uint
+	BuildEpoch   uint64
+	Languages    []string
+	Description  map[string]string
+}
+
+// Reader looks up addresses in an in-memory MaxMind DB (MMDB) file
+type Reader struct {
+	buffer   []byte
+	metadata Metadata
+	data     decoder
+	// ipv4Start is the node of the ::/96 subtree IPv4 lookups start at in
+	// IPv6 databases
+	ipv4Start uint
+}
+
+// Open reads a MaxMind DB file into memory
+func Open(path string) (*Reader, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := FromBytes(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return reader, nil
+}
+
+// FromBytes parses a MaxMind DB held in memory. The buffer must not be
+// modified afterwards.
+func FromBytes(buffer []byte) (*Reader, error) {
+	start := len(buffer) - metadataMaxSize
+	if start < 0 {
+		start = 0
+	}
+	index := bytes.LastIndex(buffer[start:], metadataMarker)
+	if index < 0 {
+		return nil, errors.New("mmdb metadata not found")
+	}
+	metaStart := start + index + len(metadataMarker)
+
+	meta := decoder{data: buffer[metaStart:]}
+	value, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb metadata: %w", err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb metadata is not a map")
+	}
+	metadata, err := parseMetadata(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	treeSize := uint(metadata.RecordSize) * 2 / 8 * metadata.NodeCount
+	dataStart := treeSize + dataSeparatorSize
+	if dataStart > uint(start+index) {
+		return nil, errors.New("mmdb search tree exceeds file")
+	}
+
+	r := &Reader{
+		buffer:   buffer,
+		metadata: metadata,
+		data:     decoder{data: buffer[dataStart : start+index]},
+	}
+	if metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < metadata.NodeCount; i++ {
+			if node, err = r.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func parseMetadata(fields map[string]interface{}) (Metadata, error) {
+	var m Metadata
+	number := func(name string) (uint64, error) {
+		v, ok := fields[name].(uint64)
+		if !ok {
+			return 0, fmt.Errorf("mmdb metadata field %s missing", name)
+		}
+		return v, nil
+	}
+
+	major, err := number("binary_format_major_version")
+	if err != nil {
+		return m, err
+	}
+	if major != 2 {
+		return m, fmt.Errorf("unsupported mmdb format version %d", major)
+	}
+
+	nodes, err := number("node_count")
+	if err != nil {
+		return m, err
+	}
+	recordSize, err := number("record_size")
+	if err != nil {
+		return m, err
+	}
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return m, fmt.Errorf("unsupported mmdb record size %d", recordSize)
+	}
+	ipVersion, err := number("ip_version")
+	if err != nil {
+		return m, err
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return m, fmt.Errorf("unsupported mmdb ip version %d", ipVersion)
+	}
+
+	m.NodeCount = uint(nodes)
+	m.RecordSize = int(recordSize)
+	m.IPVersion = int(ipVersion)
+	m.DatabaseType, _ = fields["database_type"].(string)
+	m.BuildEpoch, _ = fields["build_epoch"].(uint64)
+	if languages, ok := fields["languages"].([]interface{}); ok {
+		for _, language := range languages {
+			if s, ok := language.(string); ok {
+				m.Languages = append(m.Languages, s)
+			}
+		}
+	}
+	if description, ok := fields["description"].(map[string]interface{}); ok {
+		m.Description = make(map[string]string, len(description))
+		for language, text := range description {
+			if s, ok := text.(string); ok {
+				m.Description[language] = s
+			}
+		}
+	}
+	return m, nil
+}
+
+// Metadata returns the metadata of the database
+func (r *Reader) Metadata() Metadata {
+	return r.metadata
+}
+
+// Lookup returns the record of an address and the length of the network
+// prefix it was found under. ErrNotFound is returned for addresses without a
+// record.
+func (r *Reader) Lookup(ip net.IP) (interface{}, int, error) {
+	if ip == nil {
+		return nil, 0, errors.New("invalid ip address")
+	}
+
+	node := uint(0)
+	address := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		address = ip4
+		if r.metadata.IPVersion == 6 {
+			// IPv4 addresses live in the ::/96 subtree; prefix lengths are
+			// reported relative to the IPv4 address
+			node = r.ipv4Start
+		}
+	} else if r.metadata.IPVersion == 4 {
+		return nil, 0, fmt.Errorf("ipv6 address %s in ipv4 database", ip)
+	}
+
+	bits := len(address) * 8
+	depth := 0
+	var err error
+	for ; depth < bits && node < r.metadata.NodeCount; depth++ {
+		bit := (address[depth>>3] >> (7 - uint(depth&7))) & 1
+		if node, err = r.record(node, bit); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	switch {
+	case node == r.metadata.NodeCount:
+		return nil, depth, ErrNotFound
+	case node < r.metadata.NodeCount:
+		return nil, 0, errors.New("mmdb search tree deeper than address")
+	}
+
+	value, _, err := r.data.decode(node - r.metadata.NodeCount - dataSeparatorSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, depth, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node
+func (r *Reader) record(node uint, bit byte) (uint, error) {
+	size := uint(r.metadata.RecordSize)
+	base := node * size * 2 / 8
+	if base+size*2/8 > uint(len(r.buffer)) {
+		return 0, fmt.Errorf("%w: node %d out of range", errCorrupt, node)
+	}
+	b := r.buffer[base:]
+
+	switch size {
+	case 24:
+		if bit == 0 {
+			return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3])<<16 | uint(b[4])<<8 | uint(b[5]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		if bit == 0 {
+			return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3]), nil
+		}
+		return uint(b[4])<<24 | uint(b[5])<<16 | uint(b[6])<<8 | uint(b[7]), nil
+	}
+}
@@ -0,0 +1,204 @@
+//go:build ignore
+
+// gen writes the tiny City and ASN test databases of this directory:
+//
+//	go run gen.go
+//
+// The records are made up; only the layout follows GeoLite2.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+)
+
+type network struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+func names(en, zh string) map[string]interface{} {
+	return map[string]interface{}{"en": en, "zh-CN": zh}
+}
+
+var cityNetworks = []network{
+	{"81.2.69.142/31", map[string]interface{}{
+		"city":         map[string]interface{}{"geoname_id": uint32(2643743), "names": names("London", "伦敦")},
+		"country":      map[string]interface{}{"geoname_id": uint32(2635167), "iso_code": "GB", "names": names("United Kingdom", "英国")},
+		"location":     map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931, "accuracy_radius": uint16(10)},
+		"subdivisions": []interface{}{map[string]interface{}{"iso_code": "ENG", "names": names("England", "英格兰")}},
+	}},
+	{"175.16.199.0/24", map[string]interface{}{
+		"city":         map[string]interface{}{"geoname_id": uint32(2038180), "names": names("Changchun", "长春")},
+		"country":      map[string]interface{}{"geoname_id": uint32(1814991), "iso_code": "CN", "names": names("China", "中国")},
+		"location":     map[string]interface{}{"latitude": 43.88, "longitude": 125.3228, "accuracy_radius": uint16(100)},
+		"subdivisions": []interface{}{map[string]interface{}{"iso_code": "22", "names": names("Jilin Sheng", "吉林")}},
+	}},
+	{"2001:480::/32", map[string]interface{}{
+		"city":     map[string]interface{}{"geoname_id": uint32(5391811), "names": names("San Diego", "圣迭戈")},
+		"country":  map[string]interface{}{"geoname_id": uint32(6252001), "iso_code": "US", "names": names("United States", "美国")},
+		"location": map[string]interface{}{"latitude": 32.7203, "longitude": -117.1552, "accuracy_radius": uint16(50)},
+	}},
+}
+
+var asnNetworks = []network{
+	{"1.128.0.0/11", map[string]interface{}{"autonomous_system_number": uint32(1221), "autonomous_system_organization": "Telstra Pty Ltd"}},
+	{"81.2.69.0/24", map[string]interface{}{"autonomous_system_number": uint32(20712), "autonomous_system_organization": "Andrews & Arnold Ltd"}},
+	{"175.16.199.0/24", map[string]interface{}{"autonomous_system_number": uint32(4837), "autonomous_system_organization": "CHINA UNICOM China169 Backbone"}},
+	{"2001:480::/32", map[string]interface{}{"autonomous_system_number": uint32(668), "autonomous_system_organization": "DoD Network Information Center"}},
+}
+
+func main() {
+	write("GeoLite2-City-Test.mmdb", "GeoLite2-City", cityNetworks)
+	write("GeoLite2-ASN-Test.mmdb", "GeoLite2-ASN", asnNetworks)
+}
+
+// record kinds of the search tree
+const (
+	empty = iota
+	child
+	data
+)
+
+type record struct {
+	kind  int
+	value int
+}
+
+func write(path, databaseType string, networks []network) {
+	nodes := [][2]record{{}}
+	var section bytes.Buffer
+
+	for _, n := range networks {
+		_, ipnet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ones, _ := ipnet.Mask.Size()
+		address := ipnet.IP.To16()
+		if ipnet.IP.To4() != nil {
+			address = append(make(net.IP, 12), ipnet.IP.To4()...)
+			ones += 96
+		}
+
+		offset := section.Len()
+		encode(&section, n.record)
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (address[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = record{kind: data, value: offset}
+				break
+			}
+			if nodes[node][bit].kind != child {
+				nodes = append(nodes, [2]record{})
+				nodes[node][bit] = record{kind: child, value: len(nodes) - 1}
+			}
+			node = nodes[node][bit].value
+		}
+	}
+
+	var out bytes.Buffer
+	count := len(nodes)
+	for _, node := range nodes {
+		for _, r := range node {
+			value := count
+			switch r.kind {
+			case child:
+				value = r.value
+			case data:
+				value = count + 16 + r.value
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(section.Bytes())
+
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&out, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": databaseType + " test database"},
+		"ip_version":                  uint16(6),
+		"languages":                   []interface{}{"en", "zh-CN"},
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+	})
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func control(buf *bytes.Buffer, kind, size int) {
+	first := byte(0)
+	var extra []byte
+	switch {
+	case size < 29:
+		first = byte(size)
+	case size < 285:
+		first, extra = 29, []byte{byte(size - 29)}
+	default:
+		first, extra = 30, []byte{byte((size - 285) >> 8), byte(size - 285)}
+	}
+	if kind <= 7 {
+		buf.WriteByte(byte(kind<<5) | first)
+	} else {
+		buf.WriteByte(first)
+		buf.WriteByte(byte(kind - 7))
+	}
+	buf.Write(extra)
+}
+
+func encode(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		control(buf, 2, len(v))
+		buf.WriteString(v)
+	case float64:
+		control(buf, 3, 8)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		encodeUint(buf, 5, uint64(v))
+	case uint32:
+		encodeUint(buf, 6, uint64(v))
+	case uint64:
+		encodeUint(buf, 9, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		control(buf, 7, len(v))
+		for _, key := range keys {
+			encode(buf, key)
+			encode(buf, v[key])
+		}
+	case []interface{}:
+		control(buf, 11, len(v))
+		for _, item := range v {
+			encode(buf, item)
+		}
+	default:
+		log.Fatalf("unsupported type %T", value)
+	}
+}
+
+func encodeUint(buf *bytes.Buffer, kind int, v uint64) {
+	var raw []byte
+	for ; v > 0; v >>= 8 {
+		raw = append([]byte{byte(v)}, raw...)
+	}
+	control(buf, kind, len(raw))
+	buf.Write(raw)
+}
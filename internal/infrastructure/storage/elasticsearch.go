@@ -1,15 +1,21 @@
-package infrastructure
+package storage
 
 import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/schema"
+	"github.com/jinye/securityai/internal/domain/tenant"
 )
 
+// ElasticsearchRepository stores events and anomalies in per-tenant indices.
+// Events are written to the index of their tenant; queries only read the
+// indices of the tenant of the request context.
 type ElasticsearchRepository struct {
 	client      *elasticsearch.Client
 	indexPrefix string
@@ -27,14 +33,15 @@ func NewElasticsearchRepository(config elasticsearch.Config, indexPrefix string)
 	}, nil
 }
 
+// SaveEvent indexes the event as an ECS document in its tenant's index
 func (r *ElasticsearchRepository) SaveEvent(ctx context.Context, event *entity.SecurityEvent) error {
-	body, err := json.Marshal(event)
+	body, err := json.Marshal(schema.ToECS(event))
 	if err != nil {
 		return err
 	}
 
 	_, err = r.client.Index(
-		r.indexPrefix+"events",
+		tenant.Index(r.indexPrefix, event.TenantID, "events"),
 		bytes.NewReader(body),
 		r.client.Index.WithDocumentID(event.ID),
 		r.client.Index.WithContext(ctx),
@@ -44,7 +51,7 @@ func (r *ElasticsearchRepository) SaveEvent(ctx context.Context, event *entity.S
 
 func (r *ElasticsearchRepository) FindEventByID(ctx context.Context, id string) (*entity.SecurityEvent, error) {
 	res, err := r.client.Get(
-		r.indexPrefix+"events",
+		tenant.Index(r.indexPrefix, tenant.FromContext(ctx), "events"),
 		id,
 		r.client.Get.WithContext(ctx),
 	)
@@ -53,22 +60,34 @@ func (r *ElasticsearchRepository) FindEventByID(ctx context.Context, id string)
 	}
 	defer res.Body.Close()
 
-	var event entity.SecurityEvent
-	if err := json.NewDecoder(res.Body).Decode(&event); err != nil {
+	var result struct {
+		Found  bool            `json:"found"`
+		Source json.RawMessage `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
 		return nil, err
 	}
+	if !result.Found {
+		return nil, fmt.Errorf("event not found: %s", id)
+	}
 
-	return &event, nil
+	return schema.UnmarshalECS(result.Source)
 }
 
 func (r *ElasticsearchRepository) FindEventsByTimeRange(ctx context.Context, start, end time.Time) ([]*entity.SecurityEvent, error) {
+	timeRange := map[string]interface{}{
+		"gte": start.Format(time.RFC3339),
+		"lte": end.Format(time.RFC3339),
+	}
+	// Events indexed before ECS normalization only carry "timestamp"
 	query := map[string]interface{}{
 		"query": map[string]interface{}{
-			"range": map[string]interface{}{
-				"timestamp": map[string]interface{}{
-					"gte": start.Format(time.RFC3339),
-					"lte": end.Format(time.RFC3339),
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"range": map[string]interface{}{"@timestamp": timeRange}},
+					map[string]interface{}{"range": map[string]interface{}{"timestamp": timeRange}},
 				},
+				"minimum_should_match": 1,
 			},
 		},
 	}
@@ -79,7 +98,7 @@ func (r *ElasticsearchRepository) FindEventsByTimeRange(ctx context.Context, sta
 	}
 
 	res, err := r.client.Search(
-		r.client.Search.WithIndex(r.indexPrefix+"events"),
+		r.client.Search.WithIndex(tenant.Index(r.indexPrefix, tenant.FromContext(ctx), "events")),
 		r.client.Search.WithBody(bytes.NewReader(body)),
 		r.client.Search.WithContext(ctx),
 	)
@@ -91,7 +110,7 @@ func (r *ElasticsearchRepository) FindEventsByTimeRange(ctx context.Context, sta
 	var result struct {
 		Hits struct {
 			Hits []struct {
-				Source entity.SecurityEvent `json:"_source"`
+				Source json.RawMessage `json:"_source"`
 			} `json:"hits"`
 		} `json:"hits"`
 	}
@@ -102,7 +121,11 @@ func (r *ElasticsearchRepository) FindEventsByTimeRange(ctx context.Context, sta
 
 	events := make([]*entity.SecurityEvent, len(result.Hits.Hits))
 	for i, hit := range result.Hits.Hits {
-		events[i] = &hit.Source
+		event, err := schema.UnmarshalECS(hit.Source)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = event
 	}
 
 	return events, nil
@@ -115,7 +138,7 @@ func (r *ElasticsearchRepository) SaveAnomaly(ctx context.Context, anomaly *enti
 	}
 
 	_, err = r.client.Index(
-		r.indexPrefix+"anomalies",
+		tenant.Index(r.indexPrefix, anomaly.TenantID, "anomalies"),
 		bytes.NewReader(body),
 		r.client.Index.WithDocumentID(anomaly.ID),
 		r.client.Index.WithContext(ctx),
@@ -138,7 +161,7 @@ func (r *ElasticsearchRepository) FindAnomaliesByEventID(ctx context.Context, ev
 	}
 
 	res, err := r.client.Search(
-		r.client.Search.WithIndex(r.indexPrefix+"anomalies"),
+		r.client.Search.WithIndex(tenant.Index(r.indexPrefix, tenant.FromContext(ctx), "anomalies")),
 		r.client.Search.WithBody(bytes.NewReader(body)),
 		r.client.Search.WithContext(ctx),
 	)
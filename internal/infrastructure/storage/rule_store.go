@@ -8,19 +8,28 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/jinye/securityai/internal/domain/repository"
+	"github.com/jinye/securityai/internal/domain/tenant"
 )
 
-// RuleStore Elasticsearch实现的规则存储
+// maxRuleResults 单次查询返回的最大规则数，即 Elasticsearch 默认的结果窗口上限
+const maxRuleResults = 10000
+
+// RuleStore Elasticsearch实现的规则存储。每个租户的规则保存在独立的索引中，
+// 默认租户的规则即共享规则，租户可以读取自己的规则和共享规则。
 type RuleStore struct {
 	client      *elasticsearch.Client
 	indexPrefix string
 }
 
+var _ repository.RuleStore = (*RuleStore)(nil)
+
 // NewRuleStore 创建规则存储实例
 func NewRuleStore(client *elasticsearch.Client, indexPrefix string) *RuleStore {
 	return &RuleStore{
@@ -29,8 +38,18 @@ func NewRuleStore(client *elasticsearch.Client, indexPrefix string) *RuleStore {
 	}
 }
 
-// SaveRule 保存规则
+// SaveRule 保存规则。非默认租户只能保存本租户的规则
 func (s *RuleStore) SaveRule(ctx context.Context, rule *repository.RuleDefinition) error {
+	if owner := tenant.FromContext(ctx); owner != tenant.Default {
+		if rule.TenantID != "" && rule.TenantID != owner {
+			return fmt.Errorf("不能保存其他租户的规则: %s", rule.TenantID)
+		}
+		rule.TenantID = owner
+	}
+	if rule.TenantID == tenant.Default {
+		rule.TenantID = ""
+	}
+
 	// 如果是新规则，设置初始版本
 	if rule.Version == 0 {
 		rule.Version = 1
@@ -44,7 +63,7 @@ func (s *RuleStore) SaveRule(ctx context.Context, rule *repository.RuleDefinitio
 				CreatedAt: oldRule.UpdatedAt,
 				CreatedBy: oldRule.UpdatedBy,
 			}
-			if err := s.saveVersion(ctx, &version); err != nil {
+			if err := s.saveVersion(ctx, rule.TenantID, &version); err != nil {
 				return err
 			}
 			rule.Version = oldRule.Version + 1
@@ -59,7 +78,7 @@ func (s *RuleStore) SaveRule(ctx context.Context, rule *repository.RuleDefinitio
 	}
 
 	_, err = s.client.Index(
-		fmt.Sprintf("%srules", s.indexPrefix),
+		tenant.Index(s.indexPrefix, rule.TenantID, "rules"),
 		bytes.NewReader(body),
 		s.client.Index.WithDocumentID(rule.ID),
 		s.client.Index.WithContext(ctx),
@@ -67,35 +86,54 @@ func (s *RuleStore) SaveRule(ctx context.Context, rule *repository.RuleDefinitio
 	return err
 }
 
-// GetRule 获取规则
+// GetRule 获取规则，租户自己的规则优先于同ID的共享规则
 func (s *RuleStore) GetRule(ctx context.Context, ruleID string) (*repository.RuleDefinition, error) {
-	res, err := s.client.Get(
-		fmt.Sprintf("%srules", s.indexPrefix),
-		ruleID,
-		s.client.Get.WithContext(ctx),
-	)
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"ids": map[string]interface{}{
+				"values": []string{ruleID},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.search(ctx, "rules", bytes.NewReader(query))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	var rule repository.RuleDefinition
-	if err := json.NewDecoder(res.Body).Decode(&rule); err != nil {
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source repository.RuleDefinition `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
 		return nil, err
 	}
 
-	return &rule, nil
+	var found *repository.RuleDefinition
+	for i := range result.Hits.Hits {
+		rule := &result.Hits.Hits[i].Source
+		if found == nil || rule.TenantID != "" {
+			found = rule
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("rule not found: %s", ruleID)
+	}
+	return found, nil
 }
 
 // ListRules 获取规则列表
 func (s *RuleStore) ListRules(ctx context.Context, filter repository.RuleFilter) ([]*repository.RuleDefinition, error) {
 	query := buildRuleQuery(filter)
 
-	res, err := s.client.Search(
-		s.client.Search.WithIndex(fmt.Sprintf("%srules", s.indexPrefix)),
-		s.client.Search.WithBody(strings.NewReader(query)),
-		s.client.Search.WithContext(ctx),
-	)
+	res, err := s.search(ctx, "rules", strings.NewReader(query))
 	if err != nil {
 		return nil, err
 	}
@@ -121,6 +159,86 @@ func (s *RuleStore) ListRules(ctx context.Context, filter repository.RuleFilter)
 	return rules, nil
 }
 
+// DeleteRule 删除请求租户自己的规则，默认租户删除共享规则
+func (s *RuleStore) DeleteRule(ctx context.Context, ruleID string) error {
+	res, err := s.client.Delete(
+		tenant.Index(s.indexPrefix, tenant.FromContext(ctx), "rules"),
+		ruleID,
+		s.client.Delete.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return fmt.Errorf("rule not found: %s", ruleID)
+	}
+	if res.IsError() {
+		return fmt.Errorf("删除规则失败: %s", res.String())
+	}
+	return nil
+}
+
+// ListRuleVersions 获取规则被替换的历史版本，按版本号升序
+func (s *RuleStore) ListRuleVersions(ctx context.Context, ruleID string) ([]*repository.RuleVersion, error) {
+	query, err := json.Marshal(map[string]interface{}{
+		"size": maxRuleResults,
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"rule_id": ruleID},
+		},
+		"sort": []map[string]interface{}{{"version": "asc"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.search(ctx, "rule_versions", bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source repository.RuleVersion `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	versions := make([]*repository.RuleVersion, len(result.Hits.Hits))
+	for i := range result.Hits.Hits {
+		versions[i] = &result.Hits.Hits[i].Source
+	}
+	return versions, nil
+}
+
+// saveVersion 在规则所属租户的版本索引中记录被替换的版本
+func (s *RuleStore) saveVersion(ctx context.Context, tenantID string, version *repository.RuleVersion) error {
+	body, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Index(
+		tenant.Index(s.indexPrefix, tenantID, "rule_versions"),
+		bytes.NewReader(body),
+		s.client.Index.WithDocumentID(fmt.Sprintf("%s-%d", version.RuleID, version.Version)),
+		s.client.Index.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("保存规则版本失败: %s", res.String())
+	}
+	return nil
+}
+
 // GetRuleVersion 获取特定版本的规则
 func (s *RuleStore) GetRuleVersion(ctx context.Context, ruleID string, version int) (*repository.RuleDefinition, error) {
 	query := fmt.Sprintf(`{
@@ -134,11 +252,7 @@ func (s *RuleStore) GetRuleVersion(ctx context.Context, ruleID string, version i
         }
     }`, ruleID, version)
 
-	res, err := s.client.Search(
-		s.client.Search.WithIndex(fmt.Sprintf("%srule_versions", s.indexPrefix)),
-		s.client.Search.WithBody(strings.NewReader(query)),
-		s.client.Search.WithContext(ctx),
-	)
+	res, err := s.search(ctx, "rule_versions", strings.NewReader(query))
 	if err != nil {
 		return nil, err
 	}
@@ -163,6 +277,35 @@ func (s *RuleStore) GetRuleVersion(ctx context.Context, ruleID string, version i
 	return &result.Hits.Hits[0].Source, nil
 }
 
+// search 在请求租户可读的索引中搜索。租户索引或共享索引尚未创建时不视为错误
+func (s *RuleStore) search(ctx context.Context, name string, body io.Reader) (*esapi.Response, error) {
+	res, err := s.client.Search(
+		s.client.Search.WithIndex(s.readIndices(ctx, name)),
+		s.client.Search.WithBody(body),
+		s.client.Search.WithIgnoreUnavailable(true),
+		s.client.Search.WithAllowNoIndices(true),
+		s.client.Search.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		defer res.Body.Close()
+		return nil, fmt.Errorf("搜索规则失败: %s", res.String())
+	}
+	return res, nil
+}
+
+// readIndices 返回请求租户可读的索引：租户自己的索引和共享索引
+func (s *RuleStore) readIndices(ctx context.Context, name string) string {
+	own := tenant.Index(s.indexPrefix, tenant.FromContext(ctx), name)
+	shared := tenant.Index(s.indexPrefix, tenant.Default, name)
+	if own == shared {
+		return own
+	}
+	return own + "," + shared
+}
+
 // 构建规则查询
 func buildRuleQuery(filter repository.RuleFilter) string {
 	query := map[string]interface{}{
@@ -222,6 +365,9 @@ func buildRuleQuery(filter repository.RuleFilter) string {
 		})
 	}
 
+	query["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"] = must
+	query["size"] = maxRuleResults
+
 	queryBytes, _ := json.Marshal(query)
 	return string(queryBytes)
 }
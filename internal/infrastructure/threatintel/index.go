@@ -0,0 +1,255 @@
+package threatintel
+
+import (
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Indicator kinds
+const (
+	KindIP     = "ip"
+	KindCIDR   = "cidr"
+	KindDomain = "domain"
+	KindURL    = "url"
+	KindHash   = "hash"
+)
+
+// Indicator is one observable reported by a feed
+type Indicator struct {
+	Kind       string
+	Value      string
+	Score      float32
+	Confidence float32
+	Categories []string
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	References []string
+}
+
+// entry is the merged knowledge about one observable across feeds
+type entry struct {
+	score      float32
+	confidence float32
+	categories []string
+	firstSeen  time.Time
+	lastSeen   time.Time
+	references []string
+}
+
+func (e *entry) merge(in *Indicator) {
+	if in.Score > e.score {
+		e.score = in.Score
+	}
+	if in.Confidence > e.confidence {
+		e.confidence = in.Confidence
+	}
+	e.categories = union(e.categories, in.Categories)
+	e.references = union(e.references, in.References)
+	if !in.FirstSeen.IsZero() && (e.firstSeen.IsZero() || in.FirstSeen.Before(e.firstSeen)) {
+		e.firstSeen = in.FirstSeen
+	}
+	if in.LastSeen.After(e.lastSeen) {
+		e.lastSeen = in.LastSeen
+	}
+}
+
+func union(a, b []string) []string {
+	for _, value := range b {
+		found := false
+		for _, existing := range a {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found && value != "" {
+			a = append(a, value)
+		}
+	}
+	return a
+}
+
+// index holds indicators by kind. It is built once and then only read.
+type index struct {
+	ips      map[netip.Addr]*entry
+	prefixes map[netip.Prefix]*entry
+	// prefixBits are the prefix lengths present, longest first
+	prefixBits []int
+	domains    map[string]*entry
+	urls       map[string]*entry
+	hashes     map[string]*entry
+	count      int
+}
+
+func newIndex() *index {
+	return &index{
+		ips:      make(map[netip.Addr]*entry),
+		prefixes: make(map[netip.Prefix]*entry),
+		domains:  make(map[string]*entry),
+		urls:     make(map[string]*entry),
+		hashes:   make(map[string]*entry),
+	}
+}
+
+// add indexes an indicator, merging it with earlier reports of the same
+// observable. It reports whether the value was valid for its kind.
+func (x *index) add(in *Indicator) bool {
+	var e *entry
+	switch in.Kind {
+	case KindIP:
+		addr, err := netip.ParseAddr(in.Value)
+		if err != nil {
+			return false
+		}
+		e = lookupOrCreate(x.ips, addr.Unmap())
+	case KindCIDR:
+		prefix, err := netip.ParsePrefix(in.Value)
+		if err != nil {
+			return false
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked()
+		}
+		if prefix.IsSingleIP() {
+			e = lookupOrCreate(x.ips, prefix.Addr())
+			break
+		}
+		if _, exists := x.prefixes[prefix]; !exists {
+			x.addPrefixBits(prefix)
+		}
+		e = lookupOrCreate(x.prefixes, prefix)
+	case KindDomain:
+		domain := normalizeDomain(in.Value)
+		if domain == "" {
+			return false
+		}
+		e = lookupOrCreate(x.domains, domain)
+	case KindURL:
+		u := normalizeURL(in.Value)
+		if u == "" {
+			return false
+		}
+		e = lookupOrCreate(x.urls, u)
+	case KindHash:
+		hash := strings.ToLower(strings.TrimSpace(in.Value))
+		if !isHex(hash) || (len(hash) != 32 && len(hash) != 40 && len(hash) != 64 && len(hash) != 128) {
+			return false
+		}
+		e = lookupOrCreate(x.hashes, hash)
+	default:
+		return false
+	}
+
+	e.merge(in)
+	x.count++
+	return true
+}
+
+func (x *index) addPrefixBits(prefix netip.Prefix) {
+	// IPv6 lengths are offset by 1000 so that both families share the list
+	bits := prefix.Bits()
+	if prefix.Addr().Is6() {
+		bits += 1000
+	}
+	for _, existing := range x.prefixBits {
+		if existing == bits {
+			return
+		}
+	}
+	x.prefixBits = append(x.prefixBits, bits)
+	sort.Sort(sort.Reverse(sort.IntSlice(x.prefixBits)))
+}
+
+func lookupOrCreate[K comparable](m map[K]*entry, key K) *entry {
+	e, ok := m[key]
+	if !ok {
+		e = &entry{}
+		m[key] = e
+	}
+	return e
+}
+
+// ip returns the exact entry of an address and the entries of all ranges
+// containing it, most specific first
+func (x *index) ip(addr netip.Addr) []*entry {
+	addr = addr.Unmap()
+	var found []*entry
+	if e, ok := x.ips[addr]; ok {
+		found = append(found, e)
+	}
+	for _, bits := range x.prefixBits {
+		if addr.Is6() != (bits >= 1000) {
+			continue
+		}
+		if bits >= 1000 {
+			bits -= 1000
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if e, ok := x.prefixes[prefix]; ok {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// domain returns the entries of a domain and of its parent domains
+func (x *index) domain(name string) []*entry {
+	name = normalizeDomain(name)
+	var found []*entry
+	for name != "" {
+		if e, ok := x.domains[name]; ok {
+			found = append(found, e)
+		}
+		dot := strings.IndexByte(name, '.')
+		if dot < 0 {
+			break
+		}
+		name = name[dot+1:]
+		if !strings.Contains(name, ".") {
+			// Do not match bare top level domains
+			break
+		}
+	}
+	return found
+}
+
+func normalizeDomain(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || strings.ContainsAny(name, "/: ") {
+		return ""
+	}
+	return name
+}
+
+// normalizeURL lowercases scheme and host and drops the fragment, so that
+// trivially different spellings of a URL match
+func normalizeURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,232 @@
+package threatintel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mispValue decodes MISP fields that feeds write either as strings or as
+// numbers, such as timestamps and enumeration IDs
+type mispValue string
+
+func (v *mispValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*v = mispValue(s)
+		return nil
+	}
+	if string(data) == "null" {
+		*v = ""
+		return nil
+	}
+	*v = mispValue(data)
+	return nil
+}
+
+// time parses a unix timestamp or an RFC 3339 time
+func (v mispValue) time() time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	if seconds, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC()
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, string(v)); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+type mispAttribute struct {
+	Type      string    `json:"type"`
+	Category  string    `json:"category"`
+	Value     string    `json:"value"`
+	ToIDS     bool      `json:"to_ids"`
+	Deleted   bool      `json:"deleted"`
+	Timestamp mispValue `json:"timestamp"`
+	FirstSeen mispValue `json:"first_seen"`
+	LastSeen  mispValue `json:"last_seen"`
+	Tag       []mispTag `json:"Tag"`
+}
+
+type mispEvent struct {
+	UUID          string          `json:"uuid"`
+	Date          mispValue       `json:"date"`
+	Timestamp     mispValue       `json:"timestamp"`
+	ThreatLevelID mispValue       `json:"threat_level_id"`
+	Analysis      mispValue       `json:"analysis"`
+	Tag           []mispTag       `json:"Tag"`
+	Attribute     []mispAttribute `json:"Attribute"`
+	Object        []struct {
+		Deleted   bool            `json:"deleted"`
+		Attribute []mispAttribute `json:"Attribute"`
+	} `json:"Object"`
+}
+
+// mispDocument is a feed event file ({"Event": ...}) or a search export
+// ({"response": [{"Event": ...}]})
+type mispDocument struct {
+	Event    *mispEvent `json:"Event"`
+	Response []struct {
+		Event *mispEvent `json:"Event"`
+	} `json:"response"`
+}
+
+// mispThreatScores map threat_level_id (high, medium, low, undefined)
+var mispThreatScores = map[mispValue]float32{"1": 0.9, "2": 0.6, "3": 0.3, "4": 0.5}
+
+// mispAnalysisConfidence map analysis (initial, ongoing, completed)
+var mispAnalysisConfidence = map[mispValue]float32{"0": 0.4, "1": 0.6, "2": 0.8}
+
+// mispKinds map attribute types to indicator kinds. Composite types list a
+// kind per component; an empty kind ignores that component.
+var mispKinds = map[string][]string{
+	"ip-src":          {KindIP},
+	"ip-dst":          {KindIP},
+	"ip-src|port":     {KindIP, ""},
+	"ip-dst|port":     {KindIP, ""},
+	"domain":          {KindDomain},
+	"hostname":        {KindDomain},
+	"domain|ip":       {KindDomain, KindIP},
+	"hostname|port":   {KindDomain, ""},
+	"url":             {KindURL},
+	"md5":             {KindHash},
+	"sha1":            {KindHash},
+	"sha256":          {KindHash},
+	"sha512":          {KindHash},
+	"filename|md5":    {"", KindHash},
+	"filename|sha1":   {"", KindHash},
+	"filename|sha256": {"", KindHash},
+	"filename|sha512": {"", KindHash},
+}
+
+// parseMISP imports the attributes of MISP events. Attributes not flagged
+// for detection (to_ids) and deleted ones are counted as skipped.
+func (im *importer) parseMISP(data []byte) error {
+	var events []*mispEvent
+	if len(data) > 0 && data[0] == '[' {
+		var documents []mispDocument
+		if err := json.Unmarshal(data, &documents); err != nil {
+			return fmt.Errorf("invalid misp feed: %w", err)
+		}
+		for _, document := range documents {
+			events = append(events, document.Event)
+		}
+	} else {
+		var document mispDocument
+		if err := json.Unmarshal(data, &document); err != nil {
+			return fmt.Errorf("invalid misp feed: %w", err)
+		}
+		events = append(events, document.Event)
+		for _, item := range document.Response {
+			events = append(events, item.Event)
+		}
+	}
+
+	found := false
+	for _, event := range events {
+		if event != nil {
+			im.addMISPEvent(event)
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("unrecognized feed format: no stix bundle or misp event")
+	}
+	return nil
+}
+
+func (im *importer) addMISPEvent(event *mispEvent) {
+	score, ok := mispThreatScores[event.ThreatLevelID]
+	if !ok {
+		score = mispThreatScores["4"]
+	}
+	confidence, ok := mispAnalysisConfidence[event.Analysis]
+	if !ok {
+		confidence = 0.5
+	}
+	eventSeen := event.Date.time()
+	if eventSeen.IsZero() {
+		eventSeen = event.Timestamp.time()
+	}
+
+	references := []string{"misp:" + event.UUID}
+	attributes := event.Attribute
+	for _, object := range event.Object {
+		if !object.Deleted {
+			attributes = append(attributes, object.Attribute...)
+		}
+	}
+	// Links of the event document the reports it was built from
+	for _, attribute := range attributes {
+		if attribute.Type == "link" && !attribute.Deleted {
+			references = append(references, attribute.Value)
+		}
+	}
+
+	for _, attribute := range attributes {
+		kinds, ok := mispKinds[attribute.Type]
+		if !ok {
+			continue
+		}
+		if attribute.Deleted || !attribute.ToIDS {
+			im.skipped++
+			continue
+		}
+
+		in := Indicator{
+			Score:      score,
+			Confidence: confidence,
+			Categories: []string{attribute.Category},
+			FirstSeen:  attribute.FirstSeen.time(),
+			LastSeen:   attribute.LastSeen.time(),
+			References: references,
+		}
+		for _, tag := range event.Tag {
+			in.Categories = append(in.Categories, tag.Name)
+		}
+		for _, tag := range attribute.Tag {
+			in.Categories = append(in.Categories, tag.Name)
+		}
+		if in.FirstSeen.IsZero() {
+			in.FirstSeen = eventSeen
+		}
+		if in.LastSeen.IsZero() {
+			in.LastSeen = attribute.Timestamp.time()
+		}
+		if in.LastSeen.IsZero() {
+			in.LastSeen = in.FirstSeen
+		}
+
+		values := strings.Split(attribute.Value, "|")
+		if len(values) != len(kinds) {
+			im.skipped++
+			continue
+		}
+		for i, kind := range kinds {
+			if kind == "" {
+				continue
+			}
+			component := in
+			component.Kind, component.Value = kind, values[i]
+			// ip-src and ip-dst may carry a network instead of an address
+			if kind == KindIP && strings.Contains(component.Value, "/") {
+				component.Kind = KindCIDR
+			}
+			im.add(&component)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package threatintel
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// stixBundle is a STIX 2.1 bundle. STIX 2.0 bundles decode the same way.
+type stixBundle struct {
+	Type    string            `json:"type"`
+	Objects []json.RawMessage `json:"objects"`
+}
+
+// stixIndicator holds the fields of a STIX indicator SDO used for scoring
+type stixIndicator struct {
+	Type           string    `json:"type"`
+	ID             string    `json:"id"`
+	Created        time.Time `json:"created"`
+	Modified       time.Time `json:"modified"`
+	Revoked        bool      `json:"revoked"`
+	Confidence     *int      `json:"confidence"`
+	Pattern        string    `json:"pattern"`
+	PatternType    string    `json:"pattern_type"`
+	ValidFrom      time.Time `json:"valid_from"`
+	ValidUntil     time.Time `json:"valid_until"`
+	IndicatorTypes []string  `json:"indicator_types"`
+	// Labels carry the indicator types in STIX 2.0
+	Labels             []string `json:"labels"`
+	ExternalReferences []struct {
+		SourceName string `json:"source_name"`
+		URL        string `json:"url"`
+		ExternalID string `json:"external_id"`
+	} `json:"external_references"`
+}
+
+// stixTypeScores rate the indicator types of the STIX 2.1 vocabulary
+var stixTypeScores = map[string]float32{
+	"malicious-activity": 0.9,
+	"compromised":        0.8,
+	"attribution":        0.7,
+	"anomalous-activity": 0.5,
+	"anonymization":      0.4,
+	"unknown":            0.5,
+	"benign":             0,
+}
+
+// stixDefaultScore applies to indicators without a known type
+const stixDefaultScore = 0.7
+
+// stixComparison matches one comparison expression of a STIX pattern
+var stixComparison = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'-]+)\s*(=|ISSUBSET)\s*'((?:[^'\\]|\\.)*)'`)
+
+// stixQuoted matches the string literals of a pattern
+var stixQuoted = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+
+// stixUnsupported are pattern operators whose observables cannot be matched
+// one at a time
+var stixUnsupported = regexp.MustCompile(`\b(AND|NOT|FOLLOWEDBY|WITHIN|REPEATS|START|STOP|LIKE|MATCHES)\b|[<>!]`)
+
+// parseSTIX imports the indicator objects of a bundle. Revoked, expired and
+// unsupported indicators are counted as skipped.
+func (im *importer) parseSTIX(data []byte) error {
+	var bundle stixBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return fmt.Errorf("invalid stix bundle: %w", err)
+	}
+
+	for _, raw := range bundle.Objects {
+		var object stixIndicator
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return fmt.Errorf("invalid stix object: %w", err)
+		}
+		if object.Type != "indicator" {
+			continue
+		}
+		if object.Revoked || (object.PatternType != "" && object.PatternType != "stix") {
+			im.skipped++
+			continue
+		}
+		if !object.ValidUntil.IsZero() && object.ValidUntil.Before(im.now) {
+			im.skipped++
+			continue
+		}
+
+		observables, ok := parseSTIXPattern(object.Pattern)
+		if !ok {
+			im.skipped++
+			continue
+		}
+
+		template := stixTemplate(&object)
+		for _, observable := range observables {
+			in := template
+			in.Kind, in.Value = observable[0], observable[1]
+			im.add(&in)
+		}
+	}
+	return nil
+}
+
+// stixTemplate builds the indicator fields shared by all observables of a
+// STIX indicator
+func stixTemplate(object *stixIndicator) Indicator {
+	types := object.IndicatorTypes
+	if len(types) == 0 {
+		types = object.Labels
+	}
+
+	in := Indicator{
+		Score:      stixDefaultScore,
+		Confidence: 0.5,
+		Categories: append([]string(nil), types...),
+		FirstSeen:  object.ValidFrom,
+		LastSeen:   object.Modified,
+		References: []string{object.ID},
+	}
+	known := false
+	for _, t := range types {
+		if score, ok := stixTypeScores[t]; ok && (!known || score > in.Score) {
+			in.Score, known = score, true
+		}
+	}
+	if object.Confidence != nil {
+		in.Confidence = float32(*object.Confidence) / 100
+	}
+	if in.FirstSeen.IsZero() {
+		in.FirstSeen = object.Created
+	}
+	if in.LastSeen.IsZero() {
+		in.LastSeen = in.FirstSeen
+	}
+	for _, ref := range object.ExternalReferences {
+		switch {
+		case ref.URL != "":
+			in.References = append(in.References, ref.URL)
+		case ref.ExternalID != "":
+			in.References = append(in.References, ref.SourceName+":"+ref.ExternalID)
+		}
+	}
+	return in
+}
+
+// parseSTIXPattern returns the kind and value of every observable of a
+// pattern made of equality comparisons joined by OR. Patterns with other
+// operators are reported as unsupported.
+func parseSTIXPattern(pattern string) ([][2]string, bool) {
+	if stixUnsupported.MatchString(stixQuoted.ReplaceAllString(pattern, "''")) {
+		return nil, false
+	}
+
+	var observables [][2]string
+	for _, match := range stixComparison.FindAllStringSubmatch(pattern, -1) {
+		object, path, operator := match[1], match[2], match[3]
+		value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(match[4])
+
+		var kind string
+		switch {
+		case (object == "ipv4-addr" || object == "ipv6-addr") && path == "value":
+			kind = KindIP
+			if strings.Contains(value, "/") || operator == "ISSUBSET" {
+				kind = KindCIDR
+			}
+		case operator == "ISSUBSET":
+			continue
+		case object == "domain-name" && path == "value":
+			kind = KindDomain
+		case object == "url" && path == "value":
+			kind = KindURL
+		case object == "file" && strings.HasPrefix(path, "hashes."):
+			kind = KindHash
+		default:
+			continue
+		}
+		observables = append(observables, [2]string{kind, value})
+	}
+	return observables, len(observables) > 0
+}
@@ -0,0 +1,342 @@
+package threatintel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// Config locates the feeds of the store
+type Config struct {
+	// Paths are STIX 2.1 bundle or MISP JSON feed files, or directories
+	// searched recursively for *.json files
+	Paths []string `json:"paths" yaml:"paths"`
+	// MaxAge drops indicators last seen longer ago, both on import and on
+	// lookup. Zero keeps all.
+	MaxAge time.Duration `json:"max_age" yaml:"max_age"`
+	// ReloadInterval is how often the feeds are checked for changes. Zero
+	// disables reloading.
+	ReloadInterval time.Duration `json:"reload_interval" yaml:"reload_interval"`
+}
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() Config {
+	return Config{
+		Paths:          []string{"data/threatintel"},
+		MaxAge:         90 * 24 * time.Hour,
+		ReloadInterval: 5 * time.Minute,
+	}
+}
+
+// Stats describes the loaded feeds
+type Stats struct {
+	Files      int       `json:"files"`
+	Indicators int       `json:"indicators"`
+	Skipped    int       `json:"skipped"`
+	LoadedAt   time.Time `json:"loaded_at"`
+}
+
+// snapshot is an immutable generation of the index
+type snapshot struct {
+	index *index
+	stats Stats
+	// signature identifies the feed files the snapshot was built from
+	signature string
+	// expires is when the oldest indicator exceeds the maximum age, zero if
+	// none does
+	expires time.Time
+}
+
+// Store is a local threat intelligence database built from STIX and MISP
+// feeds on disk. It implements log.ThreatDB. Reloads build a new index and
+// swap it in, so lookups never wait for an import.
+type Store struct {
+	config  Config
+	current atomic.Pointer[snapshot]
+
+	reloadMutex sync.Mutex
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+var _ log.ThreatDB = (*Store)(nil)
+
+// NewStore imports the configured feeds
+func NewStore(config Config) (*Store, error) {
+	if len(config.Paths) == 0 {
+		return nil, errors.New("no threat intelligence feed configured")
+	}
+
+	s := &Store{config: config}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reimports the feeds if any file was added, removed or changed, or
+// once indicators exceeded the maximum age. A failed import leaves the
+// previous index in place.
+func (s *Store) Reload() error {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	files, signature, err := s.files()
+	if err != nil {
+		return err
+	}
+	if current := s.current.Load(); current != nil && current.signature == signature &&
+		(current.expires.IsZero() || time.Now().Before(current.expires)) {
+		return nil
+	}
+
+	im := &importer{index: newIndex(), now: time.Now(), maxAge: s.config.MaxAge}
+	for _, path := range files {
+		if err := im.importFile(path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	s.current.Store(&snapshot{
+		index: im.index,
+		stats: Stats{
+			Files:      len(files),
+			Indicators: im.index.count,
+			Skipped:    im.skipped,
+			LoadedAt:   im.now,
+		},
+		signature: signature,
+		expires:   im.expires(),
+	})
+	return nil
+}
+
+// files lists the feed files and a signature of their names, sizes and
+// modification times
+func (s *Store) files() ([]string, string, error) {
+	var files []string
+	var signature strings.Builder
+	add := func(path string, info fs.FileInfo) {
+		files = append(files, path)
+		fmt.Fprintf(&signature, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	for _, root := range s.config.Paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, "", err
+		}
+		if !info.IsDir() {
+			add(root, info)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// manifest.json indexes the events of a MISP feed directory
+			if d.IsDir() || filepath.Ext(path) != ".json" || d.Name() == "manifest.json" {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			add(path, info)
+			return nil
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	sort.Strings(files)
+	return files, signature.String(), nil
+}
+
+// Start checks the feeds for changes every ReloadInterval until Stop
+func (s *Store) Start(ctx context.Context) {
+	if s.config.ReloadInterval <= 0 {
+		return
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.config.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failed reloads keep the previous index and are retried
+				s.Reload()
+			}
+		}
+	}()
+}
+
+// Stop ends reloading
+func (s *Store) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// Stats describes the currently loaded feeds
+func (s *Store) Stats() Stats {
+	return s.current.Load().stats
+}
+
+// LookupIP returns what the feeds report about an address, including the
+// ranges containing it. Unknown addresses yield a zero score.
+func (s *Store) LookupIP(ctx context.Context, ip string) (*log.ThreatInfo, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip address %q", ip)
+	}
+	return s.threatInfo(s.current.Load().index.ip(addr)), nil
+}
+
+// LookupDomain returns what the feeds report about a domain and its parent
+// domains
+func (s *Store) LookupDomain(ctx context.Context, domain string) (*log.ThreatInfo, error) {
+	return s.threatInfo(s.current.Load().index.domain(domain)), nil
+}
+
+// LookupURL returns what the feeds report about a URL
+func (s *Store) LookupURL(ctx context.Context, rawURL string) (*log.ThreatInfo, error) {
+	var found []*entry
+	if e, ok := s.current.Load().index.urls[normalizeURL(rawURL)]; ok {
+		found = append(found, e)
+	}
+	return s.threatInfo(found), nil
+}
+
+// LookupHash returns what the feeds report about an MD5, SHA-1, SHA-256 or
+// SHA-512 file hash
+func (s *Store) LookupHash(ctx context.Context, hash string) (*log.ThreatInfo, error) {
+	var found []*entry
+	if e, ok := s.current.Load().index.hashes[strings.ToLower(strings.TrimSpace(hash))]; ok {
+		found = append(found, e)
+	}
+	return s.threatInfo(found), nil
+}
+
+// threatInfo combines matching entries: the highest score and confidence,
+// the union of categories and references and the widest time span. Entries
+// that exceeded the maximum age since the last import are left out.
+func (s *Store) threatInfo(entries []*entry) *log.ThreatInfo {
+	now := time.Now()
+	combined := &entry{}
+	for _, e := range entries {
+		if expired(e.lastSeen, e.firstSeen, now, s.config.MaxAge) {
+			continue
+		}
+		combined.merge(&Indicator{
+			Score:      e.score,
+			Confidence: e.confidence,
+			Categories: e.categories,
+			FirstSeen:  e.firstSeen,
+			LastSeen:   e.lastSeen,
+			References: e.references,
+		})
+	}
+
+	info := &log.ThreatInfo{
+		Score:      combined.score,
+		Categories: combined.categories,
+		FirstSeen:  combined.firstSeen,
+		References: combined.references,
+		Confidence: combined.confidence,
+	}
+	if !combined.lastSeen.IsZero() {
+		info.LastSeen = combined.lastSeen.Format(time.RFC3339)
+	}
+	return info
+}
+
+// expired reports whether an observable last seen, or else first seen, at
+// the given times is older than maxAge. Observables without times never
+// expire.
+func expired(lastSeen, firstSeen, now time.Time, maxAge time.Duration) bool {
+	seen := lastSeen
+	if seen.IsZero() {
+		seen = firstSeen
+	}
+	return maxAge > 0 && !seen.IsZero() && now.Sub(seen) > maxAge
+}
+
+// importer collects the indicators of one reload
+type importer struct {
+	index   *index
+	now     time.Time
+	maxAge  time.Duration
+	skipped int
+	// oldest is the oldest sighting of the indexed indicators
+	oldest time.Time
+}
+
+// expires returns when the oldest indexed indicator exceeds the maximum age
+func (im *importer) expires() time.Time {
+	if im.maxAge <= 0 || im.oldest.IsZero() {
+		return time.Time{}
+	}
+	return im.oldest.Add(im.maxAge)
+}
+
+// importFile detects the format of a feed file and imports it
+func (im *importer) importFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+
+	var probe struct {
+		Type string `json:"type"`
+	}
+	switch {
+	case len(data) > 0 && data[0] == '{' && json.Unmarshal(data, &probe) == nil && probe.Type == "bundle":
+		return im.parseSTIX(data)
+	case len(data) > 0 && (data[0] == '{' || data[0] == '['):
+		return im.parseMISP(data)
+	}
+	return errors.New("unrecognized feed format")
+}
+
+// add indexes an indicator unless it is older than the maximum age
+func (im *importer) add(in *Indicator) {
+	if expired(in.LastSeen, in.FirstSeen, im.now, im.maxAge) {
+		im.skipped++
+		return
+	}
+	if !im.index.add(in) {
+		im.skipped++
+		return
+	}
+
+	seen := in.LastSeen
+	if seen.IsZero() {
+		seen = in.FirstSeen
+	}
+	if !seen.IsZero() && (im.oldest.IsZero() || seen.Before(im.oldest)) {
+		im.oldest = seen
+	}
+}
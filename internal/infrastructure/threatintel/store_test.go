@@ -0,0 +1,180 @@
+package threatintel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func stixFeed(modified time.Time) string {
+	return fmt.Sprintf(`{"type":"bundle","id":"bundle--1","objects":[
+  {"type":"indicator","id":"indicator--ip","pattern":"[ipv4-addr:value = '203.0.113.7']","pattern_type":"stix","indicator_types":["malicious-activity"],"confidence":80,"modified":%q},
+  {"type":"indicator","id":"indicator--net","pattern":"[ipv4-addr:value ISSUBSET '198.51.100.0/24']","indicator_types":["anonymization"],"modified":%q},
+  {"type":"indicator","id":"indicator--domain","pattern":"[domain-name:value = 'evil.example'] OR [url:value = 'http://evil.example/payload']","indicator_types":["compromised"],"modified":%q},
+  {"type":"indicator","id":"indicator--and","pattern":"[ipv4-addr:value = '192.0.2.1' AND domain-name:value = 'x.example']","modified":%q}
+]}`, modified.Format(time.RFC3339), modified.Format(time.RFC3339), modified.Format(time.RFC3339), modified.Format(time.RFC3339))
+}
+
+func mispFeed(seen time.Time) string {
+	return fmt.Sprintf(`{"Event":{"uuid":"e-1","threat_level_id":"1","analysis":"2","timestamp":"%d","Tag":[{"name":"tlp:amber"}],"Attribute":[
+  {"type":"ip-dst","category":"Network activity","value":"203.0.113.7","to_ids":true},
+  {"type":"sha256","category":"Payload delivery","value":"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855","to_ids":true},
+  {"type":"ip-src","category":"Network activity","value":"192.0.2.99","to_ids":false}
+]}}`, seen.Unix())
+}
+
+func writeFeed(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStoreLookup(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC().Truncate(time.Second)
+	writeFeed(t, dir, "stix.json", stixFeed(now.Add(-time.Hour)))
+	writeFeed(t, dir, "misp.json", mispFeed(now.Add(-2*time.Hour)))
+	writeFeed(t, dir, "manifest.json", `{}`)
+
+	store, err := NewStore(Config{Paths: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := store.Stats(); stats.Files != 2 || stats.Skipped != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		lookup     func() (float32, []string, error)
+		wantScore  float32
+		wantCategs int
+	}{
+		{
+			name:       "ip in both feeds",
+			lookup:     lookupIP(store, "203.0.113.7"),
+			wantScore:  0.9,
+			wantCategs: 3, // malicious-activity, Network activity, tlp:amber
+		},
+		{
+			name:       "address in range",
+			lookup:     lookupIP(store, "198.51.100.20"),
+			wantScore:  0.4,
+			wantCategs: 1,
+		},
+		{
+			name:      "unsupported pattern is skipped",
+			lookup:    lookupIP(store, "192.0.2.1"),
+			wantScore: 0,
+		},
+		{
+			name:      "attribute not flagged for detection is skipped",
+			lookup:    lookupIP(store, "192.0.2.99"),
+			wantScore: 0,
+		},
+		{
+			name: "parent domain",
+			lookup: func() (float32, []string, error) {
+				info, err := store.LookupDomain(ctx, "cdn.Evil.Example.")
+				return info.Score, info.Categories, err
+			},
+			wantScore:  0.8,
+			wantCategs: 1,
+		},
+		{
+			name: "url",
+			lookup: func() (float32, []string, error) {
+				info, err := store.LookupURL(ctx, "http://evil.example/payload")
+				return info.Score, info.Categories, err
+			},
+			wantScore:  0.8,
+			wantCategs: 1,
+		},
+		{
+			name: "hash is case insensitive",
+			lookup: func() (float32, []string, error) {
+				info, err := store.LookupHash(ctx, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+				return info.Score, info.Categories, err
+			},
+			wantScore:  0.9,
+			wantCategs: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, categories, err := tt.lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score != tt.wantScore || len(categories) != tt.wantCategs {
+				t.Errorf("score %v categories %v, want %v and %d categories", score, categories, tt.wantScore, tt.wantCategs)
+			}
+		})
+	}
+
+	if _, err := store.LookupIP(ctx, "not-an-ip"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
+
+func lookupIP(store *Store, ip string) func() (float32, []string, error) {
+	return func() (float32, []string, error) {
+		info, err := store.LookupIP(context.Background(), ip)
+		if err != nil {
+			return 0, nil, err
+		}
+		return info.Score, info.Categories, nil
+	}
+}
+
+func TestStoreMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC().Truncate(time.Second)
+	writeFeed(t, dir, "fresh.json", mispFeed(now.Add(-time.Hour)))
+	writeFeed(t, dir, "stale.json", stixFeed(now.Add(-72*time.Hour)))
+
+	store, err := NewStore(Config{Paths: []string{dir}, MaxAge: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if info, _ := store.LookupDomain(ctx, "evil.example"); info.Score != 0 {
+		t.Errorf("stale indicator was imported: %+v", info)
+	}
+
+	// Indicators age out between imports
+	store.config.MaxAge = 30 * time.Minute
+	if info, _ := store.LookupIP(ctx, "203.0.113.7"); info.Score != 0 {
+		t.Errorf("expired indicator was returned on lookup: %+v", info)
+	}
+
+	// An unchanged feed is reimported once its oldest indicator expired
+	current := *store.current.Load()
+	if current.expires.IsZero() {
+		t.Fatal("snapshot without expiry")
+	}
+	current.expires = time.Now().Add(-time.Second)
+	store.current.Store(&current)
+	loaded := store.Stats().LoadedAt
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := store.Stats(); !stats.LoadedAt.After(loaded) || stats.Indicators != 0 {
+		t.Errorf("stats after expiry = %+v", stats)
+	}
+	loaded = store.Stats().LoadedAt
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Stats().LoadedAt.Equal(loaded) {
+		t.Error("unchanged feed without indicators was reimported")
+	}
+}
@@ -0,0 +1,345 @@
+package rule
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// 条件配置的JSON格式
+//
+// RuleConfig.Conditions 中的每个条件是一个带 type 字段的对象:
+//
+//	字段条件, operator 为 eq, neq, gt, gte, lt, lte, contains, regex
+//	{"type": "field", "field": "action", "operator": "eq", "value": "deny"}
+//
+//	IP条件, networks 为CIDR或单个IP地址
+//	{"type": "ip", "field": "source_ip", "networks": ["10.0.0.0/8", "192.168.1.1"]}
+//
+//	标签条件, match_all 为 true 时需要匹配全部标签
+//	{"type": "label", "labels": ["user_privileged:true"], "match_all": false}
+//
+//	时间窗口条件, 按事件时间的小时匹配, 起始大于结束时表示跨天
+//	{"type": "time_window", "start_hour": 22, "end_hour": 6}
+//
+//	资产重要性条件, field 默认为 asset_criticality
+//	{"type": "asset_criticality", "min_level": "high"}
+//
+//	表达式条件, 语法见 expression.go
+//	{"type": "expression", "expression": "port in [22, 3389] && lower(user) startsWith \"svc_\""}
+//
+//	条件组, operator 为 AND, OR 或 NOT(全部不匹配), 可以任意嵌套
+//	{"type": "group", "operator": "OR", "conditions": [...]}
+//
+// field 可以是事件内置字段, 也可以是 "key:value" 形式标签的键(可加 "label." 前缀)。
+// 未知的键会被视为错误, 错误信息包含出错位置, 例如 conditions[1].conditions[0].networks[2]。
+
+// ConditionError 条件配置错误
+type ConditionError struct {
+	Path    string // 出错位置, 例如 conditions[0].value
+	Message string
+}
+
+func (e *ConditionError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// conditionKeys 每种条件允许的键
+var conditionKeys = map[string][]string{
+	"field":             {"field", "operator", "value"},
+	"ip":                {"field", "networks"},
+	"label":             {"labels", "match_all"},
+	"time_window":       {"start_hour", "end_hour"},
+	"asset_criticality": {"field", "min_level"},
+	"expression":        {"expression"},
+	"group":             {"operator", "conditions"},
+}
+
+// fieldOperators 字段条件支持的操作符
+var fieldOperators = map[string]bool{
+	"eq": true, "neq": true, "gt": true, "gte": true, "lt": true, "lte": true,
+	"contains": true, "regex": true,
+}
+
+// ParseConditions 将规则配置中的条件列表转换为条件
+func ParseConditions(configs []map[string]interface{}) ([]Condition, error) {
+	conditions := make([]Condition, 0, len(configs))
+	for i, config := range configs {
+		condition, err := parseCondition(fmt.Sprintf("conditions[%d]", i), config)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// ParseCondition 将单个条件配置转换为条件
+func ParseCondition(config map[string]interface{}) (Condition, error) {
+	return parseCondition("condition", config)
+}
+
+func parseCondition(path string, config map[string]interface{}) (Condition, error) {
+	if config == nil {
+		return nil, &ConditionError{Path: path, Message: "条件不能为空"}
+	}
+	conditionType, err := stringValue(path, config, "type", true)
+	if err != nil {
+		return nil, err
+	}
+	allowed, ok := conditionKeys[conditionType]
+	if !ok {
+		return nil, &ConditionError{Path: path + ".type", Message: fmt.Sprintf("未知的条件类型 %q", conditionType)}
+	}
+	if err := checkKeys(path, config, allowed); err != nil {
+		return nil, err
+	}
+
+	switch conditionType {
+	case "field":
+		return parseFieldCondition(path, config)
+	case "ip":
+		return parseIPCondition(path, config)
+	case "label":
+		labels, err := stringList(path, config, "labels")
+		if err != nil {
+			return nil, err
+		}
+		matchAll, err := boolValue(path, config, "match_all")
+		if err != nil {
+			return nil, err
+		}
+		return NewLabelCondition(labels, matchAll), nil
+	case "time_window":
+		startHour, err := hourValue(path, config, "start_hour")
+		if err != nil {
+			return nil, err
+		}
+		endHour, err := hourValue(path, config, "end_hour")
+		if err != nil {
+			return nil, err
+		}
+		return NewTimeWindowCondition(startHour, endHour), nil
+	case "asset_criticality":
+		field, err := stringValue(path, config, "field", false)
+		if err != nil {
+			return nil, err
+		}
+		minLevel, err := stringValue(path, config, "min_level", true)
+		if err != nil {
+			return nil, err
+		}
+		minLevel = strings.ToLower(minLevel)
+		if entity.CriticalityRank(minLevel) == 0 {
+			return nil, &ConditionError{Path: path + ".min_level", Message: fmt.Sprintf("未知的重要性级别 %q", minLevel)}
+		}
+		return NewAssetCriticalityCondition(field, minLevel), nil
+	case "expression":
+		source, err := stringValue(path, config, "expression", true)
+		if err != nil {
+			return nil, err
+		}
+		expression, err := CompileExpression(source)
+		if err != nil {
+			return nil, &ConditionError{Path: path + ".expression", Message: err.Error()}
+		}
+		return expression, nil
+	default:
+		return parseConditionGroup(path, config)
+	}
+}
+
+func parseFieldCondition(path string, config map[string]interface{}) (Condition, error) {
+	field, err := stringValue(path, config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	operator, err := stringValue(path, config, "operator", true)
+	if err != nil {
+		return nil, err
+	}
+	if !fieldOperators[operator] {
+		return nil, &ConditionError{Path: path + ".operator", Message: fmt.Sprintf("未知的操作符 %q", operator)}
+	}
+	value, ok := config["value"]
+	if !ok || value == nil {
+		return nil, &ConditionError{Path: path + ".value", Message: "缺少比较值"}
+	}
+	switch value.(type) {
+	case string, float64, bool:
+	default:
+		return nil, &ConditionError{Path: path + ".value", Message: "比较值必须是字符串、数字或布尔值"}
+	}
+
+	condition := NewFieldCondition(field, operator, value)
+	switch operator {
+	case "gt", "gte", "lt", "lte":
+		if _, ok := toFloat(value); !ok {
+			return nil, &ConditionError{Path: path + ".value", Message: fmt.Sprintf("操作符 %s 需要数值", operator)}
+		}
+	case "regex":
+		pattern, ok := value.(string)
+		if !ok {
+			return nil, &ConditionError{Path: path + ".value", Message: "正则表达式必须是字符串"}
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, &ConditionError{Path: path + ".value", Message: fmt.Sprintf("无效的正则表达式: %v", err)}
+		}
+		condition.pattern = compiled
+	}
+	return condition, nil
+}
+
+func parseIPCondition(path string, config map[string]interface{}) (Condition, error) {
+	field, err := stringValue(path, config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	networks, err := stringList(path, config, "networks")
+	if err != nil {
+		return nil, err
+	}
+	for i, network := range networks {
+		itemPath := fmt.Sprintf("%s.networks[%d]", path, i)
+		if strings.Contains(network, "/") {
+			if _, _, err := net.ParseCIDR(network); err != nil {
+				return nil, &ConditionError{Path: itemPath, Message: fmt.Sprintf("无效的CIDR %q", network)}
+			}
+			continue
+		}
+		// 单个IP地址转换为主机网段
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, &ConditionError{Path: itemPath, Message: fmt.Sprintf("无效的IP地址 %q", network)}
+		}
+		if ip.To4() != nil {
+			networks[i] = network + "/32"
+		} else {
+			networks[i] = network + "/128"
+		}
+	}
+	return NewIPCondition(field, networks), nil
+}
+
+func parseConditionGroup(path string, config map[string]interface{}) (Condition, error) {
+	operator, err := stringValue(path, config, "operator", false)
+	if err != nil {
+		return nil, err
+	}
+	operator = strings.ToUpper(operator)
+	switch operator {
+	case "":
+		operator = "AND"
+	case "AND", "OR", "NOT":
+	default:
+		return nil, &ConditionError{Path: path + ".operator", Message: fmt.Sprintf("未知的组合操作符 %q", operator)}
+	}
+
+	items, ok := config["conditions"].([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, &ConditionError{Path: path + ".conditions", Message: "条件组至少需要一个条件"}
+	}
+	conditions := make([]Condition, 0, len(items))
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s.conditions[%d]", path, i)
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, &ConditionError{Path: itemPath, Message: "条件必须是对象"}
+		}
+		condition, err := parseCondition(itemPath, child)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return NewConditionGroup(operator, conditions), nil
+}
+
+// checkKeys 拒绝拼写错误等未知的键，避免条件被静默忽略
+func checkKeys(path string, config map[string]interface{}, allowed []string) error {
+	var unknown []string
+	for key := range config {
+		if key == "type" {
+			continue
+		}
+		found := false
+		for _, name := range allowed {
+			if key == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &ConditionError{Path: path + "." + unknown[0], Message: "未知的键"}
+	}
+	return nil
+}
+
+func stringValue(path string, config map[string]interface{}, key string, required bool) (string, error) {
+	raw, ok := config[key]
+	if !ok || raw == nil {
+		if required {
+			return "", &ConditionError{Path: path + "." + key, Message: "缺少必填项"}
+		}
+		return "", nil
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return "", &ConditionError{Path: path + "." + key, Message: "必须是字符串"}
+	}
+	if required && strings.TrimSpace(value) == "" {
+		return "", &ConditionError{Path: path + "." + key, Message: "不能为空"}
+	}
+	return value, nil
+}
+
+func stringList(path string, config map[string]interface{}, key string) ([]string, error) {
+	items, ok := config[key].([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, &ConditionError{Path: path + "." + key, Message: "必须是非空的字符串数组"}
+	}
+	values := make([]string, 0, len(items))
+	for i, item := range items {
+		value, ok := item.(string)
+		if !ok || value == "" {
+			return nil, &ConditionError{Path: fmt.Sprintf("%s.%s[%d]", path, key, i), Message: "必须是非空字符串"}
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func boolValue(path string, config map[string]interface{}, key string) (bool, error) {
+	raw, ok := config[key]
+	if !ok || raw == nil {
+		return false, nil
+	}
+	value, ok := raw.(bool)
+	if !ok {
+		return false, &ConditionError{Path: path + "." + key, Message: "必须是布尔值"}
+	}
+	return value, nil
+}
+
+func hourValue(path string, config map[string]interface{}, key string) (int, error) {
+	raw, ok := config[key]
+	if !ok {
+		return 0, &ConditionError{Path: path + "." + key, Message: "缺少必填项"}
+	}
+	value, ok := raw.(float64)
+	if !ok || value != math.Trunc(value) || value < 0 || value > 23 {
+		return 0, &ConditionError{Path: path + "." + key, Message: "必须是0到23之间的整数"}
+	}
+	return int(value), nil
+}
@@ -0,0 +1,170 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/repository"
+)
+
+func parseTestConditions(t *testing.T, source string) ([]Condition, error) {
+	t.Helper()
+	var configs []map[string]interface{}
+	if err := json.Unmarshal([]byte(source), &configs); err != nil {
+		t.Fatal(err)
+	}
+	return ParseConditions(configs)
+}
+
+func TestParseConditions(t *testing.T) {
+	// 22点的SSH登录, 来自内网, 目标为高重要性资产
+	event := newTestEvent("login", "svc_backup", time.Date(2024, 5, 1, 22, 30, 0, 0, time.UTC))
+	event.Action = "deny"
+	event.Port = 22
+	event.SetLabel("asset_criticality", entity.CriticalityHigh)
+	event.SetLabel("user_privileged", "true")
+
+	tests := []struct {
+		name       string
+		conditions string
+		want       bool
+	}{
+		{name: "field", conditions: `[{"type": "field", "field": "action", "operator": "eq", "value": "deny"}]`, want: true},
+		{name: "numeric field", conditions: `[{"type": "field", "field": "port", "operator": "lte", "value": 1024}]`, want: true},
+		{name: "regex", conditions: `[{"type": "field", "field": "user", "operator": "regex", "value": "^svc_"}]`, want: true},
+		{name: "label key as field", conditions: `[{"type": "field", "field": "label.user_privileged", "operator": "eq", "value": "true"}]`, want: true},
+		{name: "ip network and host", conditions: `[{"type": "ip", "field": "source_ip", "networks": ["192.168.0.0/16", "10.0.0.1"]}]`, want: true},
+		{name: "ip outside networks", conditions: `[{"type": "ip", "field": "source_ip", "networks": ["192.168.0.0/16"]}]`, want: false},
+		{name: "label", conditions: `[{"type": "label", "labels": ["user_privileged:true", "vip:true"]}]`, want: true},
+		{name: "all labels", conditions: `[{"type": "label", "labels": ["user_privileged:true", "vip:true"], "match_all": true}]`, want: false},
+		{name: "time window across midnight", conditions: `[{"type": "time_window", "start_hour": 22, "end_hour": 6}]`, want: true},
+		{name: "asset criticality", conditions: `[{"type": "asset_criticality", "min_level": "HIGH"}]`, want: true},
+		{name: "asset criticality too low", conditions: `[{"type": "asset_criticality", "min_level": "critical"}]`, want: false},
+		{name: "expression", conditions: `[{"type": "expression", "expression": "port in [22, 3389] && user startsWith \"svc_\""}]`, want: true},
+		{
+			name: "nested groups",
+			conditions: `[{"type": "group", "operator": "or", "conditions": [
+				{"type": "field", "field": "action", "operator": "eq", "value": "allow"},
+				{"type": "group", "operator": "NOT", "conditions": [
+					{"type": "field", "field": "event_type", "operator": "eq", "value": "logout"}
+				]}
+			]}]`,
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditions, err := parseTestConditions(t, tt.conditions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := NewConditionGroup("AND", conditions).Evaluate(event); got != tt.want {
+				t.Errorf("matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConditionsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions string
+		wantPath   string
+	}{
+		{name: "missing type", conditions: `[{"field": "action"}]`, wantPath: "conditions[0].type"},
+		{name: "unknown type", conditions: `[{"type": "geo"}]`, wantPath: "conditions[0].type"},
+		{name: "unknown key", conditions: `[{"type": "field", "field": "action", "operator": "eq", "value": "deny", "valeu": 1}]`, wantPath: "conditions[0].valeu"},
+		{name: "unknown operator", conditions: `[{"type": "field", "field": "action", "operator": "like", "value": "deny"}]`, wantPath: "conditions[0].operator"},
+		{name: "missing value", conditions: `[{"type": "field", "field": "action", "operator": "eq"}]`, wantPath: "conditions[0].value"},
+		{name: "non-numeric comparison", conditions: `[{"type": "field", "field": "port", "operator": "gt", "value": "high"}]`, wantPath: "conditions[0].value"},
+		{name: "invalid regex", conditions: `[{"type": "field", "field": "user", "operator": "regex", "value": "("}]`, wantPath: "conditions[0].value"},
+		{name: "invalid network", conditions: `[{"type": "ip", "field": "source_ip", "networks": ["10.0.0.0/8", "10.0.0.0/33"]}]`, wantPath: "conditions[0].networks[1]"},
+		{name: "invalid address", conditions: `[{"type": "ip", "field": "source_ip", "networks": ["host"]}]`, wantPath: "conditions[0].networks[0]"},
+		{name: "empty labels", conditions: `[{"type": "label", "labels": []}]`, wantPath: "conditions[0].labels"},
+		{name: "hour out of range", conditions: `[{"type": "time_window", "start_hour": 24, "end_hour": 6}]`, wantPath: "conditions[0].start_hour"},
+		{name: "fractional hour", conditions: `[{"type": "time_window", "start_hour": 1, "end_hour": 6.5}]`, wantPath: "conditions[0].end_hour"},
+		{name: "unknown criticality", conditions: `[{"type": "asset_criticality", "min_level": "severe"}]`, wantPath: "conditions[0].min_level"},
+		{name: "invalid expression", conditions: `[{"type": "expression", "expression": "port >"}]`, wantPath: "conditions[0].expression"},
+		{name: "empty group", conditions: `[{"type": "group", "conditions": []}]`, wantPath: "conditions[0].conditions"},
+		{name: "unknown group operator", conditions: `[{"type": "group", "operator": "XOR", "conditions": [{"type": "label", "labels": ["a:b"]}]}]`, wantPath: "conditions[0].operator"},
+		{
+			name:       "nested error",
+			conditions: `[{"type": "label", "labels": ["a:b"]}, {"type": "group", "conditions": [{"type": "label", "labels": ["a:b"]}, "field"]}]`,
+			wantPath:   "conditions[1].conditions[1]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestConditions(t, tt.conditions)
+			var conditionErr *ConditionError
+			if !errors.As(err, &conditionErr) {
+				t.Fatalf("err = %v, want a ConditionError", err)
+			}
+			if conditionErr.Path != tt.wantPath {
+				t.Errorf("path = %s, want %s (%v)", conditionErr.Path, tt.wantPath, err)
+			}
+		})
+	}
+}
+
+func TestConvertToEngineRule(t *testing.T) {
+	manager := &RuleManager{}
+
+	tests := []struct {
+		name    string
+		config  repository.RuleConfig
+		wantErr string
+		check   func(t *testing.T, rule Rule)
+	}{
+		{
+			name: "composite rule with OR",
+			config: repository.RuleConfig{Type: "composite", Operator: "or", Conditions: []map[string]interface{}{
+				{"type": "field", "field": "event_type", "operator": "eq", "value": "scan"},
+				{"type": "field", "field": "event_type", "operator": "eq", "value": "login"},
+			}},
+			check: func(t *testing.T, rule Rule) {
+				if !rule.Evaluate(context.Background(), newTestEvent("login", "alice", time.Now())) {
+					t.Error("rule did not match either condition")
+				}
+			},
+		},
+		{
+			name:    "unknown composite operator",
+			config:  repository.RuleConfig{Type: "composite", Operator: "XOR"},
+			wantErr: "operator",
+		},
+		{
+			name:    "condition error keeps its path",
+			config:  repository.RuleConfig{Type: "composite", Conditions: []map[string]interface{}{{"type": "field"}}},
+			wantErr: "conditions[0].field",
+		},
+		{name: "machine learning rules", config: repository.RuleConfig{Type: "ml"}, wantErr: "ML"},
+		{name: "unknown type", config: repository.RuleConfig{Type: "yara"}, wantErr: "yara"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := manager.ConvertToEngineRule(&repository.RuleDefinition{ID: "r1", TenantID: "acme", Severity: "high", Config: tt.config})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata := rule.GetMetadata(); metadata.ID != "r1" || metadata.TenantID != "acme" {
+				t.Errorf("metadata = %+v", metadata)
+			}
+			tt.check(t, rule)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/jinye/securityai/internal/domain/entity"
@@ -12,8 +13,10 @@ import (
 // FieldCondition 字段条件
 type FieldCondition struct {
 	Field    string      // 字段名
-	Operator string      // 操作符: eq, neq, gt, lt, contains, regex
+	Operator string      // 操作符: eq, neq, gt, gte, lt, lte, contains, regex
 	Value    interface{} // 比较值
+
+	pattern *regexp.Regexp // 加载时预编译的正则表达式
 }
 
 func NewFieldCondition(field, operator string, value interface{}) *FieldCondition {
@@ -34,7 +37,20 @@ func (c *FieldCondition) Evaluate(event *entity.SecurityEvent) bool {
 		return fmt.Sprintf("%v", fieldValue) != fmt.Sprintf("%v", c.Value)
 	case "contains":
 		return strings.Contains(fmt.Sprintf("%v", fieldValue), fmt.Sprintf("%v", c.Value))
+	case "gt", "gte", "lt", "lte":
+		left, ok := toFloat(fieldValue)
+		if !ok {
+			return false
+		}
+		right, ok := toFloat(c.Value)
+		if !ok {
+			return false
+		}
+		return compareNumbers(c.Operator, left, right)
 	case "regex":
+		if c.pattern != nil {
+			return c.pattern.MatchString(fmt.Sprintf("%v", fieldValue))
+		}
 		pattern, ok := c.Value.(string)
 		if !ok {
 			return false
@@ -46,6 +62,39 @@ func (c *FieldCondition) Evaluate(event *entity.SecurityEvent) bool {
 	return false
 }
 
+// compareNumbers 按操作符比较数值
+func compareNumbers(operator string, left, right float64) bool {
+	switch operator {
+	case "gt":
+		return left > right
+	case "gte":
+		return left >= right
+	case "lt":
+		return left < right
+	case "lte":
+		return left <= right
+	}
+	return false
+}
+
+// toFloat 将数值或数字字符串转换为浮点数，用于大小比较
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
 // IPCondition IP地址相关条件
 type IPCondition struct {
 	Field    string   // IP字段名
@@ -60,7 +109,7 @@ func NewIPCondition(field string, networks []string) *IPCondition {
 }
 
 func (c *IPCondition) Evaluate(event *entity.SecurityEvent) bool {
-	ipStr := getFieldValue(event, c.Field).(string)
+	ipStr, _ := getFieldValue(event, c.Field).(string)
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
 		return false
@@ -142,7 +191,70 @@ func (c *TimeWindowCondition) Evaluate(event *entity.SecurityEvent) bool {
 	return hour >= c.StartHour || hour <= c.EndHour
 }
 
+// AssetCriticalityCondition 资产重要性条件
+type AssetCriticalityCondition struct {
+	Field    string // 重要性字段, 默认 asset_criticality (源、目的和主机资产中的最高值)
+	MinLevel string // 最低重要性: low, medium, high, critical
+}
+
+func NewAssetCriticalityCondition(field, minLevel string) *AssetCriticalityCondition {
+	if field == "" {
+		field = "asset_criticality"
+	}
+	return &AssetCriticalityCondition{
+		Field:    field,
+		MinLevel: minLevel,
+	}
+}
+
+func (c *AssetCriticalityCondition) Evaluate(event *entity.SecurityEvent) bool {
+	level, _ := getFieldValue(event, c.Field).(string)
+	rank := entity.CriticalityRank(level)
+	return rank > 0 && rank >= entity.CriticalityRank(c.MinLevel)
+}
+
+// ConditionGroup 条件组，用于嵌套组合条件
+type ConditionGroup struct {
+	Operator   string // AND: 全部匹配, OR: 任一匹配, NOT: 全部不匹配
+	Conditions []Condition
+}
+
+func NewConditionGroup(operator string, conditions []Condition) *ConditionGroup {
+	return &ConditionGroup{
+		Operator:   operator,
+		Conditions: conditions,
+	}
+}
+
+func (c *ConditionGroup) Evaluate(event *entity.SecurityEvent) bool {
+	switch c.Operator {
+	case "AND":
+		for _, condition := range c.Conditions {
+			if !condition.Evaluate(event) {
+				return false
+			}
+		}
+		return len(c.Conditions) > 0
+	case "OR":
+		for _, condition := range c.Conditions {
+			if condition.Evaluate(event) {
+				return true
+			}
+		}
+		return false
+	case "NOT":
+		for _, condition := range c.Conditions {
+			if condition.Evaluate(event) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // 辅助函数：获取事件中指定字段的值
+// 非内置字段按 "key:value" 形式的标签查找，例如 signature_id、flow_id、community_id
 func getFieldValue(event *entity.SecurityEvent, field string) interface{} {
 	switch field {
 	case "source_ip":
@@ -161,7 +273,14 @@ func getFieldValue(event *entity.SecurityEvent, field string) interface{} {
 		return event.User
 	case "severity":
 		return event.Severity
+	case "event_type":
+		return event.EventType
+	case "description":
+		return event.Description
 	default:
+		if value, ok := event.GetLabel(strings.TrimPrefix(field, "label.")); ok {
+			return value
+		}
 		return nil
 	}
 }
@@ -2,20 +2,29 @@ package rule
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/tenant"
 )
 
 // Engine 规则引擎
 type Engine struct {
 	rules   map[string]Rule
 	mutex   sync.RWMutex
-	metrics *RuleMetrics
+	metrics *EngineMetrics
+	// metricsMutex 保护指标；评估只持有规则的读锁，可能并发进行
+	metricsMutex sync.Mutex
+	handler      MatchHandler
+
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-// RuleMetrics 规则执行指标
-type RuleMetrics struct {
+// EngineMetrics 规则引擎的执行指标，按规则的详细统计见 RuleMetrics
+type EngineMetrics struct {
 	TotalExecutions   int64
 	MatchedExecutions int64
 	ExecutionTimes    []float64
@@ -26,7 +35,7 @@ type RuleMetrics struct {
 func NewEngine() *Engine {
 	return &Engine{
 		rules: make(map[string]Rule),
-		metrics: &RuleMetrics{
+		metrics: &EngineMetrics{
 			RuleMatchCounts: make(map[string]int64),
 		},
 	}
@@ -49,59 +58,295 @@ func (e *Engine) RemoveRule(ruleID string) {
 	delete(e.rules, ruleID)
 }
 
-// EvaluateEvent 评估事件是否匹配规则
+// SetMatchHandler 设置有状态规则触发结果的处理函数，通常用于生成告警。
+// 有状态规则的触发可能由缓存的更早事件完成，因此结果单独交给处理函数，
+// 而不是归到触发评估的事件上
+func (e *Engine) SetMatchHandler(handler MatchHandler) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.handler = handler
+}
+
+// EvaluateEvent 评估事件是否匹配规则，只评估共享规则和事件所属租户的规则。
+// 结果包含有状态规则因此完成的全部触发，其中可能不包含该事件
 func (e *Engine) EvaluateEvent(ctx context.Context, event *entity.SecurityEvent) []RuleResult {
-	e.mutex.RLock()
-	defer e.mutex.RUnlock()
+	return e.evaluate(ctx, event, false)
+}
 
+// ObserveEvent 只将事件计入有状态规则。去重折叠的重复事件不再完整评估，
+// 但每次出现都要计入阈值和序列规则
+func (e *Engine) ObserveEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit {
+	return ruleHits(e.evaluate(ctx, event, true), event.ID)
+}
+
+func (e *Engine) evaluate(ctx context.Context, event *entity.SecurityEvent, statefulOnly bool) []RuleResult {
+	e.mutex.RLock()
 	results := make([]RuleResult, 0)
+	fired := make([]RuleResult, 0)
+	handler := e.handler
+	eventTenant := tenant.Normalize(event.TenantID)
 
 	for _, rule := range e.rules {
 		metadata := rule.GetMetadata()
+		if metadata.TenantID != "" && metadata.TenantID != eventTenant {
+			continue
+		}
+		stateful, isStateful := rule.(StatefulRule)
+		if statefulOnly && !isStateful {
+			continue
+		}
 
-		// 评估规则
-		matched := rule.Evaluate(ctx, event)
+		// 评估规则，有状态规则返回参与触发的事件
+		var matches []RuleMatch
+		var matched bool
+		if isStateful {
+			matches = stateful.Observe(ctx, event)
+			matched = len(matches) > 0
+		} else {
+			matched = rule.Evaluate(ctx, event)
+		}
 
 		// 更新指标
+		e.metricsMutex.Lock()
 		e.metrics.TotalExecutions++
 		if matched {
 			e.metrics.MatchedExecutions++
 			e.metrics.RuleMatchCounts[metadata.ID]++
 		}
+		e.metricsMutex.Unlock()
 
 		// 如果规则匹配，添加到结果中
-		if matched {
+		if matched && !isStateful {
 			results = append(results, RuleResult{
 				RuleID:   metadata.ID,
 				RuleName: metadata.Name,
 				Severity: metadata.Severity,
 				Category: metadata.Category,
+				TenantID: eventTenant,
 				Matched:  true,
 			})
 		}
+		// 有状态规则的每个触发结果单独返回
+		for _, match := range matches {
+			fired = append(fired, matchResult(metadata, match))
+		}
 	}
+	e.mutex.RUnlock()
 
+	// 处理函数可能较慢，不持有锁调用
+	if handler != nil {
+		for _, result := range fired {
+			handler(ctx, result)
+		}
+	}
+	return append(results, fired...)
+}
+
+// Flush 立即处理有状态规则缓存的全部事件，返回因此完成的触发。停止前调用，避免丢失缓存的事件
+func (e *Engine) Flush(ctx context.Context) []RuleResult {
+	return e.drain(ctx, func(rule BufferedRule) []RuleMatch { return rule.Flush() })
+}
+
+// Advance 处理有状态规则中已等待超过乱序容忍时间的缓存事件，返回因此完成的触发。
+// 事件持续到达时缓存会随之推进，事件停止到达时需要定期调用
+func (e *Engine) Advance(ctx context.Context, now time.Time) []RuleResult {
+	return e.drain(ctx, func(rule BufferedRule) []RuleMatch { return rule.Advance(now) })
+}
+
+func (e *Engine) drain(ctx context.Context, process func(BufferedRule) []RuleMatch) []RuleResult {
+	e.mutex.RLock()
+	results := make([]RuleResult, 0)
+	handler := e.handler
+	for _, rule := range e.rules {
+		buffered, ok := rule.(BufferedRule)
+		if !ok {
+			continue
+		}
+		metadata := rule.GetMetadata()
+		matches := process(buffered)
+		if len(matches) > 0 {
+			e.metricsMutex.Lock()
+			e.metrics.MatchedExecutions += int64(len(matches))
+			e.metrics.RuleMatchCounts[metadata.ID] += int64(len(matches))
+			e.metricsMutex.Unlock()
+		}
+		for _, match := range matches {
+			results = append(results, matchResult(metadata, match))
+		}
+	}
+	e.mutex.RUnlock()
+
+	if handler != nil {
+		for _, result := range results {
+			handler(ctx, result)
+		}
+	}
 	return results
 }
 
+// Start 每隔 interval 推进有状态规则的缓存，直到 Stop
+func (e *Engine) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				e.Advance(ctx, now)
+			}
+		}
+	}()
+}
+
+// Stop 停止定期推进，并处理仍在缓存中的事件
+func (e *Engine) Stop(ctx context.Context) {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+		e.cancel = nil
+	}
+	e.Flush(ctx)
+}
+
+// RuleStates 返回租户可见的有状态规则的运行状态，按规则ID排序。
+// 未完成序列只包含该租户的事件
+func (e *Engine) RuleStates(tenantID string) []RuleState {
+	tenantID = tenant.Normalize(tenantID)
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	states := make([]RuleState, 0)
+	for _, rule := range e.rules {
+		metadata := rule.GetMetadata()
+		if metadata.TenantID != "" && metadata.TenantID != tenantID {
+			continue
+		}
+		state := RuleState{RuleID: metadata.ID, RuleName: metadata.Name}
+		switch r := rule.(type) {
+		case *ThresholdRule:
+			stats := r.Stats()
+			state.Type = "threshold"
+			state.Threshold = &stats
+		case *SequenceRule:
+			stats := r.Stats()
+			state.Type = "sequence"
+			state.Sequence = &stats
+			state.Partials = make([]SequencePartial, 0)
+			for _, partial := range r.Partials() {
+				if partial.TenantID == tenantID {
+					state.Partials = append(state.Partials, partial)
+				}
+			}
+		default:
+			continue
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].RuleID < states[j].RuleID })
+	return states
+}
+
+// MatchEvent 返回事件匹配的规则，供严重性评分使用。有状态规则的触发只在包含该事件时计入
+func (e *Engine) MatchEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit {
+	return ruleHits(e.EvaluateEvent(ctx, event), event.ID)
+}
+
+// ruleHits 转换评估结果，只保留归属于事件的结果
+func ruleHits(results []RuleResult, eventID string) []entity.RuleHit {
+	hits := make([]entity.RuleHit, 0, len(results))
+	for _, result := range results {
+		if result.EventIDs != nil && !containsString(result.EventIDs, eventID) {
+			continue
+		}
+		hits = append(hits, entity.RuleHit{
+			RuleID:   result.RuleID,
+			RuleName: result.RuleName,
+			Severity: result.Severity,
+			EventIDs: result.EventIDs,
+		})
+	}
+	return hits
+}
+
+func matchResult(metadata RuleMetadata, match RuleMatch) RuleResult {
+	start, end := match.WindowStart, match.WindowEnd
+	return RuleResult{
+		RuleID:      metadata.ID,
+		RuleName:    metadata.Name,
+		Severity:    metadata.Severity,
+		Category:    metadata.Category,
+		TenantID:    match.TenantID,
+		Matched:     true,
+		GroupKey:    match.GroupKey,
+		Value:       match.Value,
+		EventIDs:    match.EventIDs,
+		WindowStart: &start,
+		WindowEnd:   &end,
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHandler 处理有状态规则的触发结果
+type MatchHandler func(ctx context.Context, result RuleResult)
+
 // RuleResult 规则评估结果
 type RuleResult struct {
 	RuleID   string `json:"rule_id"`
 	RuleName string `json:"rule_name"`
 	Severity string `json:"severity"`
 	Category string `json:"category"`
+	TenantID string `json:"tenant_id,omitempty"`
 	Matched  bool   `json:"matched"`
+	// 以下字段仅由阈值等有状态规则填写
+	GroupKey    string     `json:"group_key,omitempty"`
+	Value       float64    `json:"value,omitempty"`
+	EventIDs    []string   `json:"event_ids,omitempty"`
+	WindowStart *time.Time `json:"window_start,omitempty"`
+	WindowEnd   *time.Time `json:"window_end,omitempty"`
+}
+
+// RuleState 有状态规则的运行状态
+type RuleState struct {
+	RuleID    string            `json:"rule_id"`
+	RuleName  string            `json:"rule_name"`
+	Type      string            `json:"type"` // threshold 或 sequence
+	Threshold *ThresholdStats   `json:"threshold,omitempty"`
+	Sequence  *SequenceStats    `json:"sequence,omitempty"`
+	Partials  []SequencePartial `json:"partials,omitempty"`
 }
 
 // GetMetrics 获取规则执行指标
-func (e *Engine) GetMetrics() *RuleMetrics {
-	e.mutex.RLock()
-	defer e.mutex.RUnlock()
+func (e *Engine) GetMetrics() *EngineMetrics {
+	e.metricsMutex.Lock()
+	defer e.metricsMutex.Unlock()
 
-	return &RuleMetrics{
+	counts := make(map[string]int64, len(e.metrics.RuleMatchCounts))
+	for id, count := range e.metrics.RuleMatchCounts {
+		counts[id] = count
+	}
+	return &EngineMetrics{
 		TotalExecutions:   e.metrics.TotalExecutions,
 		MatchedExecutions: e.metrics.MatchedExecutions,
-		RuleMatchCounts:   e.metrics.RuleMatchCounts,
+		RuleMatchCounts:   counts,
 	}
 }
 
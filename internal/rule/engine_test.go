@@ -0,0 +1,317 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+func newTestEvent(eventType, user string, at time.Time) *entity.SecurityEvent {
+	event := entity.NewSecurityEvent()
+	event.EventType = eventType
+	event.User = user
+	event.SourceIP = "10.0.0.1"
+	event.Timestamp = at
+	return event
+}
+
+func newFieldRule(id, tenantID, field, value string) *CompositeRule {
+	rule := NewCompositeRule(RuleMetadata{ID: id, TenantID: tenantID, Name: id, Severity: "high"}, "AND")
+	rule.AddCondition(NewFieldCondition(field, "eq", value))
+	return rule
+}
+
+func TestEngineEvaluateEventTenants(t *testing.T) {
+	engine := NewEngine()
+	engine.AddRule(newFieldRule("shared", "", "event_type", "login"))
+	engine.AddRule(newFieldRule("acme-only", "acme", "event_type", "login"))
+	engine.AddRule(newFieldRule("other", "", "event_type", "logout"))
+
+	tests := []struct {
+		name   string
+		tenant string
+		want   []string
+	}{
+		{name: "default tenant sees shared rules", tenant: "", want: []string{"shared"}},
+		{name: "tenant sees its own and shared rules", tenant: "acme", want: []string{"acme-only", "shared"}},
+		{name: "other tenant", tenant: "globex", want: []string{"shared"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := newTestEvent("login", "alice", time.Now())
+			event.TenantID = tt.tenant
+			var got []string
+			for _, result := range engine.EvaluateEvent(context.Background(), event) {
+				got = append(got, result.RuleID)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineEvaluateEventConcurrent(t *testing.T) {
+	engine := NewEngine()
+	engine.AddRule(newFieldRule("login", "", "event_type", "login"))
+	engine.AddRule(newFieldRule("logout", "", "event_type", "logout"))
+	threshold, err := NewThresholdRule(
+		RuleMetadata{ID: "brute-force", Name: "brute force", Severity: "high"},
+		[]Condition{NewFieldCondition("event_type", "eq", "login")},
+		ThresholdConfig{GroupBy: []string{"user"}, Function: AggregateCount, Threshold: 10, Window: time.Hour},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.AddRule(threshold)
+
+	const workers, events = 8, 200
+	base := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				event := newTestEvent("login", fmt.Sprintf("user%d", w), base.Add(time.Duration(i)*time.Millisecond))
+				engine.EvaluateEvent(context.Background(), event)
+				engine.GetMetrics()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	metrics := engine.GetMetrics()
+	if want := int64(workers * events * 3); metrics.TotalExecutions != want {
+		t.Errorf("total executions = %d, want %d", metrics.TotalExecutions, want)
+	}
+	if got := metrics.RuleMatchCounts["login"]; got != workers*events {
+		t.Errorf("login matches = %d, want %d", got, workers*events)
+	}
+	if got := metrics.RuleMatchCounts["logout"]; got != 0 {
+		t.Errorf("logout matches = %d, want 0", got)
+	}
+	// Sliding windows restart counting after firing
+	if got := metrics.RuleMatchCounts["brute-force"]; got != workers*(events/10) {
+		t.Errorf("threshold matches = %d, want %d", got, workers*(events/10))
+	}
+}
+
+func TestEngineObserveEvent(t *testing.T) {
+	newEngine := func(t *testing.T) *Engine {
+		engine := NewEngine()
+		engine.AddRule(newFieldRule("login", "", "event_type", "login"))
+		threshold, err := NewThresholdRule(
+			RuleMetadata{ID: "brute-force", Name: "brute force", Severity: "high"},
+			[]Condition{NewFieldCondition("event_type", "eq", "login")},
+			ThresholdConfig{GroupBy: []string{"user"}, Function: AggregateCount, Threshold: 3, Window: time.Hour},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine.AddRule(threshold)
+		return engine
+	}
+
+	tests := []struct {
+		name    string
+		repeats int
+		wantHit bool
+	}{
+		{name: "repeats below the threshold", repeats: 1},
+		{name: "repeats reach the threshold", repeats: 2, wantHit: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newEngine(t)
+			base := time.Now()
+			first := newTestEvent("login", "alice", base)
+			engine.MatchEvent(context.Background(), first)
+
+			var hits []entity.RuleHit
+			for i := 1; i <= tt.repeats; i++ {
+				// Repeats carry the ID of the aggregate they were folded into
+				repeat := newTestEvent("login", "alice", base.Add(time.Duration(i)*time.Second))
+				repeat.ID = first.ID
+				hits = append(hits, engine.ObserveEvent(context.Background(), repeat)...)
+			}
+
+			if !tt.wantHit {
+				if len(hits) != 0 {
+					t.Errorf("hits = %+v, want none", hits)
+				}
+				return
+			}
+			if len(hits) != 1 || hits[0].RuleID != "brute-force" {
+				t.Fatalf("hits = %+v, want only the threshold rule", hits)
+			}
+			if fmt.Sprint(hits[0].EventIDs) != fmt.Sprint([]string{first.ID}) {
+				t.Errorf("event ids = %v, want the aggregate once", hits[0].EventIDs)
+			}
+			if got := engine.GetMetrics().RuleMatchCounts["login"]; got != 1 {
+				t.Errorf("stateless rule evaluated %d times for repeats", got-1)
+			}
+		})
+	}
+}
+
+func newTestSequence(t *testing.T, tenantID string) *SequenceRule {
+	rule, err := NewSequenceRule(RuleMetadata{ID: "scan-then-login", TenantID: tenantID, Name: "scan then login", Severity: "critical"}, SequenceConfig{
+		Steps: []SequenceStep{
+			{Name: "scan", Conditions: []Condition{NewFieldCondition("event_type", "eq", "scan")}, By: []string{"source_ip"}},
+			{Name: "login", Conditions: []Condition{NewFieldCondition("event_type", "eq", "login")}, By: []string{"source_ip"}},
+		},
+		MaxSpan:   time.Hour,
+		Tolerance: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestEngineStatefulDelivery(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// finish completes the buffered sequence and returns the rule hits
+		// credited to the event that completed it, if any
+		finish func(engine *Engine) []entity.RuleHit
+	}{
+		{
+			name: "completed by a later unrelated event",
+			finish: func(engine *Engine) []entity.RuleHit {
+				return engine.MatchEvent(context.Background(), newTestEvent("logout", "bob", base.Add(10*time.Minute)))
+			},
+		},
+		{
+			name: "flushed on stop",
+			finish: func(engine *Engine) []entity.RuleHit {
+				engine.Start(context.Background(), time.Hour)
+				engine.Stop(context.Background())
+				return nil
+			},
+		},
+		{
+			name: "advanced once no event arrived within the tolerance",
+			finish: func(engine *Engine) []entity.RuleHit {
+				if results := engine.Advance(context.Background(), time.Now()); len(results) != 0 {
+					t.Errorf("advanced before the tolerance passed: %+v", results)
+				}
+				engine.Advance(context.Background(), time.Now().Add(2*time.Minute))
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine()
+			engine.AddRule(newTestSequence(t, ""))
+			var delivered []RuleResult
+			engine.SetMatchHandler(func(ctx context.Context, result RuleResult) {
+				delivered = append(delivered, result)
+			})
+
+			scan := newTestEvent("scan", "", base)
+			login := newTestEvent("login", "alice", base.Add(time.Second))
+			for _, event := range []*entity.SecurityEvent{scan, login} {
+				if hits := engine.MatchEvent(context.Background(), event); len(hits) != 0 {
+					t.Fatalf("buffered event fired early: %+v", hits)
+				}
+			}
+
+			if hits := tt.finish(engine); len(hits) != 0 {
+				t.Errorf("completion credited to an unrelated event: %+v", hits)
+			}
+			if len(delivered) != 1 {
+				t.Fatalf("delivered %d results, want 1", len(delivered))
+			}
+			if got, want := fmt.Sprint(delivered[0].EventIDs), fmt.Sprint([]string{scan.ID, login.ID}); got != want {
+				t.Errorf("event ids = %s, want %s", got, want)
+			}
+			if delivered[0].TenantID != "default" {
+				t.Errorf("tenant = %q, want default", delivered[0].TenantID)
+			}
+		})
+	}
+}
+
+func TestEngineRuleStates(t *testing.T) {
+	engine := NewEngine()
+	engine.AddRule(newTestSequence(t, ""))
+	acme := newTestSequence(t, "acme")
+	acme.metadata.ID = "acme-sequence"
+	engine.AddRule(acme)
+	engine.AddRule(newFieldRule("stateless", "", "event_type", "scan"))
+
+	for _, tenantID := range []string{"acme", "globex"} {
+		event := newTestEvent("scan", "", time.Now())
+		event.TenantID = tenantID
+		engine.EvaluateEvent(context.Background(), event)
+	}
+	engine.Flush(context.Background())
+
+	tests := []struct {
+		tenant       string
+		wantRules    []string
+		wantPartials int
+	}{
+		{tenant: "acme", wantRules: []string{"acme-sequence", "scan-then-login"}, wantPartials: 1},
+		{tenant: "globex", wantRules: []string{"scan-then-login"}, wantPartials: 1},
+		{tenant: "", wantRules: []string{"scan-then-login"}, wantPartials: 0},
+	}
+	for _, tt := range tests {
+		t.Run("tenant "+tt.tenant, func(t *testing.T) {
+			var rules []string
+			for _, state := range engine.RuleStates(tt.tenant) {
+				rules = append(rules, state.RuleID)
+				if state.RuleID != "scan-then-login" {
+					continue
+				}
+				if len(state.Partials) != tt.wantPartials {
+					t.Errorf("partials = %+v, want %d", state.Partials, tt.wantPartials)
+				}
+				for _, partial := range state.Partials {
+					if partial.TenantID != tt.tenant {
+						t.Errorf("partial of tenant %s visible to %s", partial.TenantID, tt.tenant)
+					}
+				}
+			}
+			if fmt.Sprint(rules) != fmt.Sprint(tt.wantRules) {
+				t.Errorf("rules = %v, want %v", rules, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestThresholdRuleTenants(t *testing.T) {
+	threshold, err := NewThresholdRule(
+		RuleMetadata{ID: "brute-force", Severity: "high"},
+		[]Condition{NewFieldCondition("event_type", "eq", "login")},
+		ThresholdConfig{GroupBy: []string{"user"}, Function: AggregateCount, Threshold: 2, Window: time.Hour},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now()
+	var matches []RuleMatch
+	for i, tenantID := range []string{"acme", "globex", "acme"} {
+		event := newTestEvent("login", "alice", base.Add(time.Duration(i)*time.Second))
+		event.TenantID = tenantID
+		matches = append(matches, threshold.Observe(context.Background(), event)...)
+	}
+	if len(matches) != 1 || matches[0].TenantID != "acme" || len(matches[0].EventIDs) != 2 {
+		t.Errorf("matches = %+v, want one acme match of two events", matches)
+	}
+}
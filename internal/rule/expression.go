@@ -0,0 +1,381 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// 规则表达式语言
+//
+// 表达式在加载时解析、类型检查并编译为闭包，求值时不会分配解释器状态。语言没有循环、
+// 赋值和自定义函数，求值代价由语法树大小决定，加载时按 ExpressionLimits 检查上限。
+//
+//	类型:   bool, number, string, list
+//	字面量: 22, 1.5, "text" (支持转义), 'text' (不转义), true, false, [22, 3389]
+//	字段:   source_ip, dest_ip, protocol, port, action, status, user, severity,
+//	        event_type, description, raw_data, tenant_id, hour (number),
+//	        labels (list, "key:value" 形式的全部标签)
+//	运算符: || && ! == != < <= > >= + - * / %
+//	        in (列表成员), contains, startsWith, endsWith, matches (正则字面量)
+//	函数:   lower(s), upper(s), trim(s), len(s|list), to_number(s), label("key"),
+//	        has_label("key"), cidr_match(ip, "10.0.0.0/8")
+//
+// 除数为零时 / 和 % 的结果为 0，无法转换的 to_number 结果为 0。
+//
+// 示例: port in [22, 3389] && !cidr_match(source_ip, "10.0.0.0/8") && lower(user) startsWith "svc_"
+
+// ExpressionLimits 表达式的复杂度上限，在加载时检查
+type ExpressionLimits struct {
+	MaxLength int `json:"max_length" yaml:"max_length"` // 源码最大长度
+	MaxDepth  int `json:"max_depth" yaml:"max_depth"`   // 最大嵌套深度
+	MaxCost   int `json:"max_cost" yaml:"max_cost"`     // 最大估算求值代价
+}
+
+// DefaultExpressionLimits 默认的表达式复杂度上限
+func DefaultExpressionLimits() ExpressionLimits {
+	return ExpressionLimits{
+		MaxLength: 4096,
+		MaxDepth:  32,
+		MaxCost:   1000,
+	}
+}
+
+// Expression 编译后的规则表达式，实现 Condition 接口
+type Expression struct {
+	source string
+	cost   int
+	eval   func(event *entity.SecurityEvent) bool
+}
+
+// CompileExpression 按默认上限编译表达式
+func CompileExpression(source string) (*Expression, error) {
+	return CompileExpressionWithLimits(source, DefaultExpressionLimits())
+}
+
+// CompileExpressionWithLimits 解析并类型检查表达式，结果必须是 bool
+func CompileExpressionWithLimits(source string, limits ExpressionLimits) (*Expression, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("表达式不能为空")
+	}
+	if limits.MaxLength > 0 && len(source) > limits.MaxLength {
+		return nil, fmt.Errorf("表达式长度 %d 超过上限 %d", len(source), limits.MaxLength)
+	}
+
+	tokens, err := lexExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &exprParser{tokens: tokens, maxDepth: limits.MaxDepth}
+	ast, err := parser.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != tokenEOF {
+		return nil, fmt.Errorf("位置 %d: 多余的 %q", token.pos, token.text)
+	}
+
+	node, err := compileExprNode(ast)
+	if err != nil {
+		return nil, err
+	}
+	if node.typ != typeBool {
+		return nil, fmt.Errorf("表达式的结果必须是 bool，实际为 %s", node.typ)
+	}
+	if limits.MaxCost > 0 && node.cost > limits.MaxCost {
+		return nil, fmt.Errorf("表达式代价 %d 超过上限 %d", node.cost, limits.MaxCost)
+	}
+	return &Expression{source: source, cost: node.cost, eval: node.boolFn}, nil
+}
+
+// Evaluate 对事件求值
+func (e *Expression) Evaluate(event *entity.SecurityEvent) bool {
+	return e.eval(event)
+}
+
+// String 返回表达式源码
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Cost 返回估算的求值代价
+func (e *Expression) Cost() int {
+	return e.cost
+}
+
+// 词法分析
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type exprToken struct {
+	kind  tokenKind
+	text  string
+	value interface{} // 数字和字符串字面量的值
+	pos   int         // 从 1 开始的字节位置
+}
+
+// exprOperators 按长度优先排列的运算符
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ","}
+
+func lexExpression(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		pos := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.') {
+				i++
+			}
+			value, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("位置 %d: 无效的数字 %q", pos, source[start:i])
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: source[start:i], value: value, pos: pos})
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(source) && source[end] != c {
+				if source[end] == '\\' && c == '"' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("位置 %d: 字符串未闭合", pos)
+			}
+			text := source[i : end+1]
+			value := text[1 : len(text)-1]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(text)
+				if err != nil {
+					return nil, fmt.Errorf("位置 %d: 无效的字符串 %s", pos, text)
+				}
+				value = unquoted
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: text, value: value, pos: pos})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: source[start:i], pos: pos})
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, exprToken{kind: tokenOperator, text: op, pos: pos})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("位置 %d: 无法识别的字符 %q", pos, c)
+			}
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, pos: len(source) + 1}), nil
+}
+
+// 语法分析
+
+// exprAST 表达式语法树
+type exprAST struct {
+	kind  string // literal, ident, unary, binary, call, list
+	op    string // 运算符或函数名
+	value interface{}
+	args  []*exprAST
+	pos   int
+}
+
+// exprParser 按优先级从低到高: || && 相等 比较 加减 乘除 一元
+type exprParser struct {
+	tokens   []exprToken
+	pos      int
+	depth    int
+	maxDepth int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// accept 消费给定的运算符或关键字
+func (p *exprParser) accept(texts ...string) (exprToken, bool) {
+	token := p.peek()
+	if token.kind != tokenOperator && token.kind != tokenIdent {
+		return token, false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			p.pos++
+			return token, true
+		}
+	}
+	return token, false
+}
+
+func (p *exprParser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		token := p.peek()
+		if token.kind == tokenEOF {
+			return fmt.Errorf("位置 %d: 缺少 %q", token.pos, text)
+		}
+		return fmt.Errorf("位置 %d: 需要 %q，实际为 %q", token.pos, text, token.text)
+	}
+	return nil
+}
+
+// enter 限制嵌套深度，避免过深的表达式耗尽栈
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		return fmt.Errorf("位置 %d: 嵌套深度超过上限 %d", p.peek().pos, p.maxDepth)
+	}
+	return nil
+}
+
+func (p *exprParser) parseExpression() (*exprAST, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	return p.parseBinary(0)
+}
+
+// exprPrecedence 二元运算符的优先级
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">=", "in", "contains", "startsWith", "endsWith", "matches"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (*exprAST, error) {
+	if level == len(exprPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, ok := p.accept(exprPrecedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprAST{kind: "binary", op: token.text, args: []*exprAST{left, right}, pos: token.pos}
+	}
+}
+
+func (p *exprParser) parseUnary() (*exprAST, error) {
+	if token, ok := p.accept("!", "-"); ok {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprAST{kind: "unary", op: token.text, args: []*exprAST{operand}, pos: token.pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (*exprAST, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenNumber, tokenString:
+		return &exprAST{kind: "literal", value: token.value, pos: token.pos}, nil
+	case tokenEOF:
+		return nil, fmt.Errorf("位置 %d: 表达式不完整", token.pos)
+	case tokenIdent:
+		switch token.text {
+		case "true", "false":
+			return &exprAST{kind: "literal", value: token.text == "true", pos: token.pos}, nil
+		case "in", "contains", "startsWith", "endsWith", "matches":
+			return nil, fmt.Errorf("位置 %d: 意外的 %q", token.pos, token.text)
+		}
+		if _, ok := p.accept("("); !ok {
+			return &exprAST{kind: "ident", op: token.text, pos: token.pos}, nil
+		}
+		call := &exprAST{kind: "call", op: token.text, pos: token.pos}
+		args, err := p.parseList(")")
+		if err != nil {
+			return nil, err
+		}
+		call.args = args
+		return call, nil
+	}
+
+	switch token.text {
+	case "(":
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case "[":
+		items, err := p.parseList("]")
+		if err != nil {
+			return nil, err
+		}
+		return &exprAST{kind: "list", args: items, pos: token.pos}, nil
+	}
+	return nil, fmt.Errorf("位置 %d: 意外的 %q", token.pos, token.text)
+}
+
+// parseList 解析以逗号分隔、以 end 结束的表达式列表
+func (p *exprParser) parseList(end string) ([]*exprAST, error) {
+	var items []*exprAST
+	if _, ok := p.accept(end); ok {
+		return items, nil
+	}
+	for {
+		item, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if _, ok := p.accept(","); ok {
+			continue
+		}
+		if err := p.expect(end); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+}
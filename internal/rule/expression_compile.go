@@ -0,0 +1,485 @@
+package rule
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// exprType 表达式的静态类型
+type exprType int
+
+const (
+	typeBool exprType = iota + 1
+	typeNumber
+	typeString
+	typeStringList
+	typeNumberList
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	case typeStringList:
+		return "list<string>"
+	case typeNumberList:
+		return "list<number>"
+	}
+	return "unknown"
+}
+
+// 估算代价：每个节点为 1，以下运算额外计入
+const (
+	costRegex     = 10
+	costCIDR      = 3
+	costLabelScan = 5
+)
+
+// exprNode 类型检查后的节点，按类型持有对应的求值函数
+type exprNode struct {
+	typ    exprType
+	cost   int
+	boolFn func(event *entity.SecurityEvent) bool
+	numFn  func(event *entity.SecurityEvent) float64
+	strFn  func(event *entity.SecurityEvent) string
+	listFn func(event *entity.SecurityEvent) []string
+
+	// constant 表示字面量，value 为其值；常量列表的元素保存在 items 中
+	constant bool
+	value    interface{}
+	items    []interface{}
+}
+
+func compileExprNode(ast *exprAST) (*exprNode, error) {
+	switch ast.kind {
+	case "literal":
+		return compileLiteral(ast.value), nil
+	case "ident":
+		return compileIdent(ast.op, ast.pos)
+	case "list":
+		return compileList(ast)
+	}
+
+	args := make([]*exprNode, 0, len(ast.args))
+	cost := 1
+	for _, arg := range ast.args {
+		node, err := compileExprNode(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, node)
+		cost += node.cost
+	}
+
+	var node *exprNode
+	var err error
+	switch ast.kind {
+	case "unary":
+		node, err = compileUnary(ast, args[0])
+	case "binary":
+		node, err = compileBinary(ast, args[0], args[1])
+	default:
+		node, err = compileCall(ast, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	node.cost += cost
+	return node, nil
+}
+
+func compileLiteral(value interface{}) *exprNode {
+	node := &exprNode{cost: 1, constant: true, value: value}
+	switch v := value.(type) {
+	case float64:
+		node.typ = typeNumber
+		node.numFn = func(*entity.SecurityEvent) float64 { return v }
+	case string:
+		node.typ = typeString
+		node.strFn = func(*entity.SecurityEvent) string { return v }
+	case bool:
+		node.typ = typeBool
+		node.boolFn = func(*entity.SecurityEvent) bool { return v }
+	}
+	return node
+}
+
+func compileIdent(name string, pos int) (*exprNode, error) {
+	str := func(get func(event *entity.SecurityEvent) string) (*exprNode, error) {
+		return &exprNode{typ: typeString, cost: 1, strFn: get}, nil
+	}
+	switch name {
+	case "source_ip":
+		return str(func(e *entity.SecurityEvent) string { return e.SourceIP })
+	case "dest_ip":
+		return str(func(e *entity.SecurityEvent) string { return e.DestIP })
+	case "protocol":
+		return str(func(e *entity.SecurityEvent) string { return e.Protocol })
+	case "action":
+		return str(func(e *entity.SecurityEvent) string { return e.Action })
+	case "status":
+		return str(func(e *entity.SecurityEvent) string { return e.Status })
+	case "user":
+		return str(func(e *entity.SecurityEvent) string { return e.User })
+	case "severity":
+		return str(func(e *entity.SecurityEvent) string { return e.Severity })
+	case "event_type":
+		return str(func(e *entity.SecurityEvent) string { return e.EventType })
+	case "description":
+		return str(func(e *entity.SecurityEvent) string { return e.Description })
+	case "raw_data":
+		return str(func(e *entity.SecurityEvent) string { return e.RawData })
+	case "tenant_id":
+		return str(func(e *entity.SecurityEvent) string { return e.TenantID })
+	case "port":
+		return &exprNode{typ: typeNumber, cost: 1, numFn: func(e *entity.SecurityEvent) float64 { return float64(e.Port) }}, nil
+	case "hour":
+		return &exprNode{typ: typeNumber, cost: 1, numFn: func(e *entity.SecurityEvent) float64 { return float64(e.Timestamp.Hour()) }}, nil
+	case "labels":
+		return &exprNode{typ: typeStringList, cost: 1, listFn: func(e *entity.SecurityEvent) []string { return e.Labels }}, nil
+	}
+	return nil, fmt.Errorf("位置 %d: 未知的字段 %q，标签请使用 label(%q)", pos, name, name)
+}
+
+// compileList 编译列表字面量，元素必须是同类型的常量
+func compileList(ast *exprAST) (*exprNode, error) {
+	if len(ast.args) == 0 {
+		return nil, fmt.Errorf("位置 %d: 列表不能为空", ast.pos)
+	}
+	node := &exprNode{cost: 1, constant: true}
+	for _, arg := range ast.args {
+		if arg.kind != "literal" {
+			return nil, fmt.Errorf("位置 %d: 列表元素必须是常量", arg.pos)
+		}
+		var typ exprType
+		switch arg.value.(type) {
+		case string:
+			typ = typeStringList
+		case float64:
+			typ = typeNumberList
+		default:
+			return nil, fmt.Errorf("位置 %d: 列表元素必须是字符串或数字", arg.pos)
+		}
+		if node.typ != 0 && node.typ != typ {
+			return nil, fmt.Errorf("位置 %d: 列表元素类型不一致", arg.pos)
+		}
+		node.typ = typ
+		node.items = append(node.items, arg.value)
+		node.cost++
+	}
+	if node.typ == typeStringList {
+		values := make([]string, len(node.items))
+		for i, item := range node.items {
+			values[i] = item.(string)
+		}
+		node.listFn = func(*entity.SecurityEvent) []string { return values }
+	}
+	return node, nil
+}
+
+func compileUnary(ast *exprAST, operand *exprNode) (*exprNode, error) {
+	switch ast.op {
+	case "!":
+		if operand.typ != typeBool {
+			return nil, typeError(ast, operand.typ)
+		}
+		fn := operand.boolFn
+		return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return !fn(e) }}, nil
+	default:
+		if operand.typ != typeNumber {
+			return nil, typeError(ast, operand.typ)
+		}
+		fn := operand.numFn
+		return &exprNode{typ: typeNumber, numFn: func(e *entity.SecurityEvent) float64 { return -fn(e) }}, nil
+	}
+}
+
+func compileBinary(ast *exprAST, left, right *exprNode) (*exprNode, error) {
+	switch ast.op {
+	case "&&", "||":
+		if left.typ != typeBool || right.typ != typeBool {
+			return nil, typeError(ast, left.typ, right.typ)
+		}
+		l, r := left.boolFn, right.boolFn
+		if ast.op == "&&" {
+			return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return l(e) && r(e) }}, nil
+		}
+		return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return l(e) || r(e) }}, nil
+
+	case "==", "!=":
+		if left.typ != right.typ || left.typ == typeStringList || left.typ == typeNumberList {
+			return nil, typeError(ast, left.typ, right.typ)
+		}
+		equal := equalFn(left, right)
+		if ast.op == "!=" {
+			return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return !equal(e) }}, nil
+		}
+		return &exprNode{typ: typeBool, boolFn: equal}, nil
+
+	case "<", "<=", ">", ">=":
+		if left.typ != typeNumber || right.typ != typeNumber {
+			return nil, typeError(ast, left.typ, right.typ)
+		}
+		l, r := left.numFn, right.numFn
+		operator := map[string]string{"<": "lt", "<=": "lte", ">": "gt", ">=": "gte"}[ast.op]
+		return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool {
+			return compareNumbers(operator, l(e), r(e))
+		}}, nil
+
+	case "+":
+		if left.typ == typeString && right.typ == typeString {
+			l, r := left.strFn, right.strFn
+			return &exprNode{typ: typeString, strFn: func(e *entity.SecurityEvent) string { return l(e) + r(e) }}, nil
+		}
+		fallthrough
+	case "-", "*", "/", "%":
+		if left.typ != typeNumber || right.typ != typeNumber {
+			return nil, typeError(ast, left.typ, right.typ)
+		}
+		return &exprNode{typ: typeNumber, numFn: arithmeticFn(ast.op, left.numFn, right.numFn)}, nil
+
+	case "contains", "startsWith", "endsWith":
+		if left.typ != typeString || right.typ != typeString {
+			return nil, typeError(ast, left.typ, right.typ)
+		}
+		l, r := left.strFn, right.strFn
+		match := map[string]func(s, substr string) bool{
+			"contains":   strings.Contains,
+			"startsWith": strings.HasPrefix,
+			"endsWith":   strings.HasSuffix,
+		}[ast.op]
+		return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return match(l(e), r(e)) }}, nil
+
+	case "matches":
+		if left.typ != typeString {
+			return nil, typeError(ast, left.typ, right.typ)
+		}
+		pattern, ok := right.value.(string)
+		if !right.constant || !ok {
+			return nil, fmt.Errorf("位置 %d: matches 的正则表达式必须是字符串常量", ast.pos)
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("位置 %d: 无效的正则表达式: %v", ast.pos, err)
+		}
+		l := left.strFn
+		return &exprNode{typ: typeBool, cost: costRegex, boolFn: func(e *entity.SecurityEvent) bool {
+			return compiled.MatchString(l(e))
+		}}, nil
+
+	case "in":
+		return compileIn(ast, left, right)
+	}
+	return nil, fmt.Errorf("位置 %d: 未知的运算符 %q", ast.pos, ast.op)
+}
+
+// compileIn 编译列表成员判断。常量列表在加载时转换为集合
+func compileIn(ast *exprAST, left, right *exprNode) (*exprNode, error) {
+	switch {
+	case left.typ == typeNumber && right.typ == typeNumberList:
+		set := make(map[float64]bool, len(right.items))
+		for _, item := range right.items {
+			set[item.(float64)] = true
+		}
+		l := left.numFn
+		return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return set[l(e)] }}, nil
+	case left.typ == typeString && right.typ == typeStringList && right.constant:
+		set := make(map[string]bool, len(right.items))
+		for _, item := range right.items {
+			set[item.(string)] = true
+		}
+		l := left.strFn
+		return &exprNode{typ: typeBool, boolFn: func(e *entity.SecurityEvent) bool { return set[l(e)] }}, nil
+	case left.typ == typeString && right.typ == typeStringList:
+		l, r := left.strFn, right.listFn
+		return &exprNode{typ: typeBool, cost: costLabelScan, boolFn: func(e *entity.SecurityEvent) bool {
+			value := l(e)
+			for _, item := range r(e) {
+				if item == value {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+	return nil, typeError(ast, left.typ, right.typ)
+}
+
+func equalFn(left, right *exprNode) func(e *entity.SecurityEvent) bool {
+	switch left.typ {
+	case typeBool:
+		l, r := left.boolFn, right.boolFn
+		return func(e *entity.SecurityEvent) bool { return l(e) == r(e) }
+	case typeNumber:
+		l, r := left.numFn, right.numFn
+		return func(e *entity.SecurityEvent) bool { return l(e) == r(e) }
+	default:
+		l, r := left.strFn, right.strFn
+		return func(e *entity.SecurityEvent) bool { return l(e) == r(e) }
+	}
+}
+
+// arithmeticFn 编译算术运算，除数为零时结果为 0
+func arithmeticFn(operator string, l, r func(e *entity.SecurityEvent) float64) func(e *entity.SecurityEvent) float64 {
+	switch operator {
+	case "+":
+		return func(e *entity.SecurityEvent) float64 { return l(e) + r(e) }
+	case "-":
+		return func(e *entity.SecurityEvent) float64 { return l(e) - r(e) }
+	case "*":
+		return func(e *entity.SecurityEvent) float64 { return l(e) * r(e) }
+	case "/":
+		return func(e *entity.SecurityEvent) float64 {
+			divisor := r(e)
+			if divisor == 0 {
+				return 0
+			}
+			return l(e) / divisor
+		}
+	default:
+		return func(e *entity.SecurityEvent) float64 {
+			divisor := r(e)
+			if divisor == 0 {
+				return 0
+			}
+			return math.Mod(l(e), divisor)
+		}
+	}
+}
+
+func compileCall(ast *exprAST, args []*exprNode) (*exprNode, error) {
+	arity := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("位置 %d: %s 需要 %d 个参数，实际为 %d", ast.pos, ast.op, n, len(args))
+		}
+		return nil
+	}
+	constString := func(i int) (string, error) {
+		value, ok := args[i].value.(string)
+		if !args[i].constant || !ok {
+			return "", fmt.Errorf("位置 %d: %s 的第 %d 个参数必须是字符串常量", ast.pos, ast.op, i+1)
+		}
+		return value, nil
+	}
+
+	switch ast.op {
+	case "lower", "upper", "trim":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		if args[0].typ != typeString {
+			return nil, typeError(ast, args[0].typ)
+		}
+		fn := args[0].strFn
+		transform := map[string]func(string) string{
+			"lower": strings.ToLower,
+			"upper": strings.ToUpper,
+			"trim":  strings.TrimSpace,
+		}[ast.op]
+		return &exprNode{typ: typeString, strFn: func(e *entity.SecurityEvent) string { return transform(fn(e)) }}, nil
+
+	case "len":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		switch args[0].typ {
+		case typeString:
+			fn := args[0].strFn
+			return &exprNode{typ: typeNumber, numFn: func(e *entity.SecurityEvent) float64 {
+				return float64(utf8.RuneCountInString(fn(e)))
+			}}, nil
+		case typeStringList:
+			fn := args[0].listFn
+			return &exprNode{typ: typeNumber, numFn: func(e *entity.SecurityEvent) float64 { return float64(len(fn(e))) }}, nil
+		case typeNumberList:
+			count := float64(len(args[0].items))
+			return &exprNode{typ: typeNumber, numFn: func(*entity.SecurityEvent) float64 { return count }}, nil
+		}
+		return nil, typeError(ast, args[0].typ)
+
+	case "to_number":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		if args[0].typ != typeString {
+			return nil, typeError(ast, args[0].typ)
+		}
+		fn := args[0].strFn
+		return &exprNode{typ: typeNumber, numFn: func(e *entity.SecurityEvent) float64 {
+			value, err := strconv.ParseFloat(strings.TrimSpace(fn(e)), 64)
+			if err != nil || math.IsNaN(value) {
+				return 0
+			}
+			return value
+		}}, nil
+
+	case "label", "has_label":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		key, err := constString(0)
+		if err != nil {
+			return nil, err
+		}
+		if ast.op == "has_label" {
+			return &exprNode{typ: typeBool, cost: costLabelScan, boolFn: func(e *entity.SecurityEvent) bool {
+				_, ok := e.GetLabel(key)
+				return ok
+			}}, nil
+		}
+		return &exprNode{typ: typeString, cost: costLabelScan, strFn: func(e *entity.SecurityEvent) string {
+			value, _ := e.GetLabel(key)
+			return value
+		}}, nil
+
+	case "cidr_match":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		if args[0].typ != typeString {
+			return nil, typeError(ast, args[0].typ, args[1].typ)
+		}
+		network, err := constString(1)
+		if err != nil {
+			return nil, err
+		}
+		// 单个IP地址转换为主机网段
+		if ip := net.ParseIP(network); ip != nil {
+			if ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, subnet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("位置 %d: 无效的CIDR %q", ast.pos, network)
+		}
+		fn := args[0].strFn
+		return &exprNode{typ: typeBool, cost: costCIDR, boolFn: func(e *entity.SecurityEvent) bool {
+			ip := net.ParseIP(fn(e))
+			return ip != nil && subnet.Contains(ip)
+		}}, nil
+	}
+	return nil, fmt.Errorf("位置 %d: 未知的函数 %q", ast.pos, ast.op)
+}
+
+// typeError 报告运算符或函数不支持的操作数类型
+func typeError(ast *exprAST, types ...exprType) error {
+	names := make([]string, len(types))
+	for i, typ := range types {
+		names[i] = typ.String()
+	}
+	return fmt.Errorf("位置 %d: %s 不支持类型 (%s)", ast.pos, ast.op, strings.Join(names, ", "))
+}
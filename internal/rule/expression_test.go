@@ -0,0 +1,158 @@
+package rule
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpressionEvaluate(t *testing.T) {
+	event := newTestEvent("login", "svc_Backup", time.Date(2024, 5, 1, 23, 15, 0, 0, time.UTC))
+	event.Port = 3389
+	event.Action = "deny"
+	event.Description = "  Failed password  "
+	event.SetLabel("asset_criticality", "high")
+	event.SetLabel("bytes", "1500")
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{`port in [22, 3389]`, true},
+		{`port in [22, 23]`, false},
+		{`action in ["deny", "drop"] && !(action == "allow")`, true},
+		{`lower(user) startsWith "svc_"`, true},
+		{`user startsWith "svc_b"`, false},
+		{`upper(action) == "DENY"`, true},
+		{`trim(description) endsWith "password"`, true},
+		{`description contains 'Failed'`, true},
+		{`user matches "^svc_[A-Z]"`, true},
+		{`len(user) == 10 && len(labels) == 2 && len([1, 2, 3]) == 3`, true},
+		{`to_number(label("bytes")) > 1000`, true},
+		{`to_number(user) == 0`, true},
+		{`label("asset_criticality") == "high" && has_label("bytes") && !has_label("vip")`, true},
+		{`"bytes:1500" in labels`, true},
+		{`cidr_match(source_ip, "10.0.0.0/8") && !cidr_match(source_ip, "10.0.0.2")`, true},
+		{`cidr_match(source_ip, "10.0.0.1")`, true},
+		{`hour >= 22 || hour < 6`, true},
+		{`port / 0 == 0 && port % 0 == 0 && port % 1000 == 389`, true},
+		{`-port + 2 * 2000 == 611`, true},
+		{`user + "@corp" == "svc_Backup@corp"`, true},
+		{`"a\"b" == 'a"b'`, true},
+		{`true != false`, true},
+		{`tenant_id == ""`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			expression, err := CompileExpression(tt.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expression.Evaluate(event); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if expression.String() != tt.expression || expression.Cost() <= 0 {
+				t.Errorf("source %q, cost %d", expression.String(), expression.Cost())
+			}
+		})
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{``, "不能为空"},
+		{`port`, "必须是 bool"},
+		{`port == "22"`, "== 不支持类型 (number, string)"},
+		{`user > 1`, "> 不支持类型"},
+		{`port && true`, "&& 不支持类型"},
+		{`!user`, "! 不支持类型"},
+		{`hostname == "a"`, `未知的字段 "hostname"`},
+		{`lower(port) == "a"`, "lower 不支持类型"},
+		{`lower() == "a"`, "需要 1 个参数"},
+		{`geo(source_ip)`, "未知的函数"},
+		{`label(user) == ""`, "必须是字符串常量"},
+		{`user matches user`, "必须是字符串常量"},
+		{`user matches "("`, "无效的正则表达式"},
+		{`cidr_match(source_ip, "10.0.0.0/33")`, "无效的CIDR"},
+		{`port in []`, "列表不能为空"},
+		{`port in [1, "a"]`, "类型不一致"},
+		{`port in [port]`, "必须是常量"},
+		{`user in [1]`, "in 不支持类型"},
+		{`labels == labels`, "== 不支持类型"},
+		{`port == 1 port`, "位置 11: 多余的"},
+		{`(port == 1`, "位置 11"},
+		{`port == `, "表达式不完整"},
+		{`user == "abc`, "字符串未闭合"},
+		{`port == 1.2.3`, "无效的数字"},
+		{`port # 1`, "无法识别的字符"},
+		{`in == 1`, "意外的"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := CompileExpression(tt.expression)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		limits     ExpressionLimits
+		want       string
+	}{
+		{name: "length", expression: `user == "` + strings.Repeat("a", 100) + `"`, limits: ExpressionLimits{MaxLength: 50}, want: "长度"},
+		{name: "depth", expression: strings.Repeat("(", 10) + "true" + strings.Repeat(")", 10), limits: ExpressionLimits{MaxDepth: 5}, want: "嵌套深度"},
+		{name: "negations count towards the depth", expression: strings.Repeat("!", 10) + "true", limits: ExpressionLimits{MaxDepth: 5}, want: "嵌套深度"},
+		{name: "cost", expression: `user matches "a" || user matches "b"`, limits: ExpressionLimits{MaxCost: 10}, want: "代价"},
+		{name: "within limits", expression: `user matches "a" || user matches "b"`, limits: DefaultExpressionLimits()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileExpressionWithLimits(tt.expression, tt.limits)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionConcurrent(t *testing.T) {
+	expression, err := CompileExpression(`lower(user) matches "^user[0-9]+$" && port in [22, 3389] && cidr_match(source_ip, "10.0.0.0/8")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				event := newTestEvent("login", "USER"+strings.Repeat("1", w+1), time.Now())
+				event.Port = 22
+				if !expression.Evaluate(event) {
+					t.Errorf("worker %d: no match", w)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
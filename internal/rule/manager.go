@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jinye/securityai/internal/domain/entity"
@@ -17,6 +18,7 @@ type RuleManager struct {
 	store   repository.RuleStore
 	engine  *Engine
 	metrics *RuleMetrics
+	sigma   SigmaConfig
 }
 
 // NewRuleManager 创建规则管理器
@@ -25,9 +27,15 @@ func NewRuleManager(store repository.RuleStore, engine *Engine) *RuleManager {
 		store:   store,
 		engine:  engine,
 		metrics: NewRuleMetrics(),
+		sigma:   DefaultSigmaConfig(),
 	}
 }
 
+// SetSigmaConfig 设置 Sigma 规则的字段和日志源映射，只影响之后编译的规则
+func (m *RuleManager) SetSigmaConfig(config SigmaConfig) {
+	m.sigma = config
+}
+
 // ImportRules 从JSON文件导入规则
 func (m *RuleManager) ImportRules(ctx context.Context, filePath string) error {
 	data, err := os.ReadFile(filePath)
@@ -192,14 +200,167 @@ func (m *RuleManager) ValidateRule(rule *repository.RuleDefinition) error {
 	if rule.Name == "" {
 		return fmt.Errorf("规则名称不能为空")
 	}
-	// 添加更多验证逻辑...
+	switch rule.Config.Type {
+	case "composite":
+		if _, err := compositeOperator(rule.Config.Operator); err != nil {
+			return err
+		}
+		if len(rule.Config.Conditions) == 0 {
+			return fmt.Errorf("组合规则至少需要一个条件")
+		}
+		if _, err := ParseConditions(rule.Config.Conditions); err != nil {
+			return err
+		}
+	case "threshold":
+		if _, err := thresholdConfig(rule.Config); err != nil {
+			return err
+		}
+		if _, err := ParseConditions(rule.Config.Conditions); err != nil {
+			return err
+		}
+	case "sequence":
+		if _, err := sequenceConfig(rule.Config); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// thresholdConfig 将规则配置中的聚合配置转换为阈值规则配置
+func thresholdConfig(config repository.RuleConfig) (ThresholdConfig, error) {
+	aggregation := config.Aggregation
+	if aggregation == nil {
+		return ThresholdConfig{}, fmt.Errorf("aggregation: 阈值规则缺少聚合配置")
+	}
+	window, err := time.ParseDuration(aggregation.Window)
+	if err != nil || window <= 0 {
+		return ThresholdConfig{}, fmt.Errorf("aggregation.window: 无效的窗口长度 %q", aggregation.Window)
+	}
+	if config.Threshold <= 0 {
+		return ThresholdConfig{}, fmt.Errorf("threshold: 阈值必须大于0")
+	}
+	switch aggregation.Function {
+	case AggregateCount, AggregateDistinctCount, AggregateSum:
+	default:
+		return ThresholdConfig{}, fmt.Errorf("aggregation.function: 未知的聚合函数 %q", aggregation.Function)
+	}
+	if aggregation.Function != AggregateCount && aggregation.Field == "" {
+		return ThresholdConfig{}, fmt.Errorf("aggregation.field: 聚合函数 %s 需要字段", aggregation.Function)
+	}
+	for i, field := range aggregation.GroupBy {
+		if field == "" {
+			return ThresholdConfig{}, fmt.Errorf("aggregation.group_by[%d]: 字段不能为空", i)
+		}
+	}
+
+	return ThresholdConfig{
+		GroupBy:           aggregation.GroupBy,
+		Function:          aggregation.Function,
+		Field:             aggregation.Field,
+		Operator:          aggregation.Operator,
+		Threshold:         float64(config.Threshold),
+		Window:            window,
+		WindowType:        aggregation.WindowType,
+		MaxGroups:         aggregation.MaxGroups,
+		MaxEventsPerGroup: aggregation.MaxEventsPerGroup,
+	}, nil
+}
+
+// sequenceConfig 将规则配置中的序列配置转换为序列规则配置
+func sequenceConfig(config repository.RuleConfig) (SequenceConfig, error) {
+	sequence := config.Sequence
+	if sequence == nil {
+		return SequenceConfig{}, fmt.Errorf("sequence: 序列规则缺少序列配置")
+	}
+	if len(sequence.Steps) < 2 {
+		return SequenceConfig{}, fmt.Errorf("sequence.steps: 序列规则至少需要两个步骤")
+	}
+	maxSpan, err := time.ParseDuration(sequence.MaxSpan)
+	if err != nil || maxSpan <= 0 {
+		return SequenceConfig{}, fmt.Errorf("sequence.maxspan: 无效的最大间隔 %q", sequence.MaxSpan)
+	}
+	var tolerance time.Duration
+	if sequence.Tolerance != "" {
+		tolerance, err = time.ParseDuration(sequence.Tolerance)
+		if err != nil || tolerance < 0 {
+			return SequenceConfig{}, fmt.Errorf("sequence.tolerance: 无效的乱序容忍时间 %q", sequence.Tolerance)
+		}
+	}
+	common, err := ParseConditions(config.Conditions)
+	if err != nil {
+		return SequenceConfig{}, err
+	}
+
+	steps := make([]SequenceStep, 0, len(sequence.Steps))
+	for i, stepConfig := range sequence.Steps {
+		path := fmt.Sprintf("sequence.steps[%d]", i)
+		if len(stepConfig.Conditions) == 0 {
+			return SequenceConfig{}, fmt.Errorf("%s.conditions: 步骤至少需要一个条件", path)
+		}
+		conditions, err := ParseConditions(stepConfig.Conditions)
+		if err != nil {
+			if conditionErr, ok := err.(*ConditionError); ok {
+				conditionErr.Path = path + "." + conditionErr.Path
+			}
+			return SequenceConfig{}, err
+		}
+		for j, field := range stepConfig.By {
+			if field == "" {
+				return SequenceConfig{}, fmt.Errorf("%s.by[%d]: 字段不能为空", path, j)
+			}
+		}
+		for j, field := range stepConfig.Next {
+			if field == "" {
+				return SequenceConfig{}, fmt.Errorf("%s.next[%d]: 字段不能为空", path, j)
+			}
+		}
+		if len(stepConfig.By) == 0 && (i > 0 || len(stepConfig.Next) == 0) {
+			return SequenceConfig{}, fmt.Errorf("%s.by: 缺少关联字段", path)
+		}
+		if i > 0 {
+			previous := sequence.Steps[i-1]
+			passed := previous.Next
+			if len(passed) == 0 {
+				passed = previous.By
+			}
+			if len(stepConfig.By) != len(passed) {
+				return SequenceConfig{}, fmt.Errorf("%s.by: 关联字段数量 %d 与上一步传递的 %d 个不一致", path, len(stepConfig.By), len(passed))
+			}
+		}
+
+		steps = append(steps, SequenceStep{
+			Name:       stepConfig.Name,
+			Conditions: append(append([]Condition(nil), common...), conditions...),
+			By:         stepConfig.By,
+			Next:       stepConfig.Next,
+		})
+	}
+
+	return SequenceConfig{
+		Steps:       steps,
+		MaxSpan:     maxSpan,
+		Tolerance:   tolerance,
+		MaxPartials: sequence.MaxPartials,
+		MaxBuffered: sequence.MaxBuffered,
+	}, nil
+}
+
+// compositeOperator 规范化组合规则的操作符，默认为 AND
+func compositeOperator(operator string) (string, error) {
+	switch strings.ToUpper(operator) {
+	case "", "AND":
+		return "AND", nil
+	case "OR":
+		return "OR", nil
+	}
+	return "", fmt.Errorf("operator: 未知的组合操作符 %q", operator)
+}
+
 // ConvertToEngineRule 将规则定义转换为引擎规则
 func (m *RuleManager) ConvertToEngineRule(def *repository.RuleDefinition) (Rule, error) {
 	metadata := RuleMetadata{
 		ID:          def.ID,
+		TenantID:    def.TenantID,
 		Name:        def.Name,
 		Description: def.Description,
 		Severity:    def.Severity,
@@ -209,11 +370,46 @@ func (m *RuleManager) ConvertToEngineRule(def *repository.RuleDefinition) (Rule,
 
 	switch def.Config.Type {
 	case "composite":
-		rule := NewCompositeRule(metadata, def.Config.Operator)
-		for _, condition := range def.Config.Conditions {
-			// 转换条件配置...
-			// TODO: 实现条件转换逻辑
+		operator, err := compositeOperator(def.Config.Operator)
+		if err != nil {
+			return nil, err
+		}
+		conditions, err := ParseConditions(def.Config.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		rule := NewCompositeRule(metadata, operator)
+		for _, condition := range conditions {
+			rule.AddCondition(condition)
+		}
+		return rule, nil
+	case "threshold":
+		config, err := thresholdConfig(def.Config)
+		if err != nil {
+			return nil, err
+		}
+		conditions, err := ParseConditions(def.Config.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		return NewThresholdRule(metadata, conditions, config)
+	case "sequence":
+		config, err := sequenceConfig(def.Config)
+		if err != nil {
+			return nil, err
+		}
+		return NewSequenceRule(metadata, config)
+	case "sigma":
+		source, _ := def.Metadata["sigma"].(string)
+		if source == "" {
+			return nil, fmt.Errorf("缺少Sigma规则源")
+		}
+		compiled, report := compileSigma([]byte(source), m.sigma)
+		if compiled == nil {
+			return nil, report.err()
 		}
+		rule := NewCompositeRule(metadata, "AND")
+		rule.AddCondition(compiled.condition)
 		return rule, nil
 	case "ml":
 		// TODO: 实现ML规则转换逻辑
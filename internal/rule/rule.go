@@ -18,6 +18,7 @@ type Rule interface {
 // RuleMetadata 规则元数据
 type RuleMetadata struct {
 	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"` // 为空时适用于所有租户
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Severity    string    `json:"severity"`
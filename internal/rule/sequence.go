@@ -0,0 +1,476 @@
+package rule
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+// 默认内存上限
+const (
+	defaultMaxPartials = 10000
+	defaultMaxBuffered = 10000
+)
+
+// SequenceStep 序列规则的一个步骤
+type SequenceStep struct {
+	Name       string
+	Conditions []Condition // 全部满足的事件匹配该步骤
+	// By 与上一步关联的字段，值需要依次等于上一步 Next 字段的值。第一步的 By 仅作为 Next 的默认值
+	By []string
+	// Next 传递给下一步的关联字段，为空时与 By 相同。例如登录步骤 By 为 source_ip、
+	// Next 为 dest_ip，下一步即可要求 source_ip 等于被登录的主机
+	Next []string
+}
+
+// SequenceConfig 序列规则配置
+type SequenceConfig struct {
+	Steps   []SequenceStep
+	MaxSpan time.Duration // 第一步到最后一步的最大间隔
+	// Tolerance 乱序容忍时间。事件先缓存，出现晚于它 Tolerance 以上的事件后再按事件时间
+	// 顺序处理，因此触发会延迟 Tolerance；为 0 时按到达顺序立即处理
+	Tolerance time.Duration
+	// MaxPartials 同时跟踪的未完成序列上限，超出时淘汰最早开始的序列
+	MaxPartials int
+	// MaxBuffered 乱序缓存的事件上限，超出时提前处理最早的事件
+	MaxBuffered int
+}
+
+// SequenceStats 序列规则的状态统计
+type SequenceStats struct {
+	Partials   int   `json:"partials"` // 未完成的序列
+	Buffered   int   `json:"buffered"` // 等待按时间排序的事件
+	Started    int64 `json:"started"`
+	Completed  int64 `json:"completed"`
+	Expired    int64 `json:"expired"`     // 超过 MaxSpan 而被丢弃的序列
+	Superseded int64 `json:"superseded"`  // 被同一步骤、同一关联值上更晚开始的序列替换
+	Evicted    int64 `json:"evicted"`     // 因序列上限被淘汰的序列
+	LateEvents int64 `json:"late_events"` // 超出容忍时间到达、未经排序处理的事件
+}
+
+// SequencePartial 未完成序列的快照
+type SequencePartial struct {
+	TenantID string    `json:"tenant_id"`
+	Step     int       `json:"step"` // 等待的步骤序号，从 0 开始
+	StepName string    `json:"step_name"`
+	Key      string    `json:"key"`       // 等待步骤需要匹配的关联值，例如 source_ip=10.0.0.5
+	EventIDs []string  `json:"event_ids"` // 已匹配步骤的事件
+	Start    time.Time `json:"start"`
+	Last     time.Time `json:"last"`
+	Expires  time.Time `json:"expires"`
+}
+
+// SequenceRule 序列规则，按顺序匹配多个步骤，例如 "X 端口扫描后成功登录主机 T，
+// 随后 T 发起外联，全部发生在 30 分钟内"。时间按事件时间计算
+//
+// 每个步骤、每个关联值最多保留一个未完成序列，新序列会替换更早开始的序列，
+// 因此状态大小受步骤数和关联值数量限制
+type SequenceRule struct {
+	metadata RuleMetadata
+	config   SequenceConfig
+
+	mutex     sync.Mutex
+	pending   []map[string]*sequencePartial // 按等待的步骤索引
+	order     *list.List                    // 按开始时间排序的未完成序列
+	buffer    sequenceBuffer
+	arrivals  uint64
+	newest    time.Time // 到达事件的最大时间
+	watermark time.Time // 早于该时间的事件不再等待排序
+	received  time.Time // 最后一个事件到达的时钟时间
+	stats     SequenceStats
+}
+
+var _ BufferedRule = (*SequenceRule)(nil)
+
+type sequencePartial struct {
+	tenant  string
+	step    int    // 等待的步骤
+	key     string // 等待步骤的关联值
+	label   string
+	group   string // 第一步的关联描述
+	events  []string
+	start   time.Time
+	last    time.Time
+	element *list.Element
+}
+
+// sequenceEvent 匹配了至少一个步骤的事件，关联值在到达时提取
+type sequenceEvent struct {
+	at      time.Time
+	arrival uint64
+	id      string
+	tenant  string
+	hits    []sequenceHit // 按步骤升序
+}
+
+type sequenceHit struct {
+	step  int
+	by    string   // 与上一步关联的值
+	next  string   // 传递给下一步的值
+	attrs []string // Next 字段的原始值
+}
+
+// NewSequenceRule 创建序列规则
+func NewSequenceRule(metadata RuleMetadata, config SequenceConfig) (*SequenceRule, error) {
+	if len(config.Steps) < 2 {
+		return nil, fmt.Errorf("序列规则至少需要两个步骤")
+	}
+	if config.MaxSpan <= 0 {
+		return nil, fmt.Errorf("最大间隔必须大于0")
+	}
+	if config.Tolerance < 0 {
+		return nil, fmt.Errorf("乱序容忍时间不能为负数")
+	}
+
+	steps := make([]SequenceStep, len(config.Steps))
+	copy(steps, config.Steps)
+	for i := range steps {
+		step := &steps[i]
+		if len(step.Conditions) == 0 {
+			return nil, fmt.Errorf("步骤 %d 缺少条件", i)
+		}
+		if len(step.Next) == 0 {
+			step.Next = step.By
+		}
+		if i > 0 {
+			if len(step.By) == 0 {
+				return nil, fmt.Errorf("步骤 %d 缺少关联字段", i)
+			}
+			if len(step.By) != len(steps[i-1].Next) {
+				return nil, fmt.Errorf("步骤 %d 的关联字段数量 %d 与上一步传递的 %d 个不一致", i, len(step.By), len(steps[i-1].Next))
+			}
+		} else if len(step.Next) == 0 {
+			return nil, fmt.Errorf("步骤 0 缺少关联字段")
+		}
+	}
+	config.Steps = steps
+	if config.MaxPartials <= 0 {
+		config.MaxPartials = defaultMaxPartials
+	}
+	if config.MaxBuffered <= 0 {
+		config.MaxBuffered = defaultMaxBuffered
+	}
+
+	r := &SequenceRule{
+		metadata: metadata,
+		config:   config,
+		pending:  make([]map[string]*sequencePartial, len(steps)),
+		order:    list.New(),
+	}
+	for i := 1; i < len(steps); i++ {
+		r.pending[i] = make(map[string]*sequencePartial)
+	}
+	return r, nil
+}
+
+func (r *SequenceRule) GetMetadata() RuleMetadata {
+	return r.metadata
+}
+
+// Evaluate 记录事件并返回是否有序列完成
+func (r *SequenceRule) Evaluate(ctx context.Context, event *entity.SecurityEvent) bool {
+	return len(r.Observe(ctx, event)) > 0
+}
+
+// Stats 返回当前状态统计
+func (r *SequenceRule) Stats() SequenceStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := r.stats
+	stats.Partials = r.order.Len()
+	stats.Buffered = r.buffer.Len()
+	return stats
+}
+
+// Partials 返回未完成序列的快照，按开始时间排序
+func (r *SequenceRule) Partials() []SequencePartial {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	partials := make([]SequencePartial, 0, r.order.Len())
+	for e := r.order.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*sequencePartial)
+		partials = append(partials, SequencePartial{
+			TenantID: p.tenant,
+			Step:     p.step,
+			StepName: r.config.Steps[p.step].Name,
+			Key:      p.label,
+			EventIDs: append([]string(nil), p.events...),
+			Start:    p.start,
+			Last:     p.last,
+			Expires:  p.start.Add(r.config.MaxSpan),
+		})
+	}
+	return partials
+}
+
+// Observe 记录事件，返回因此完成的序列。启用乱序容忍时，返回的可能是之前缓存的事件完成的序列
+func (r *SequenceRule) Observe(ctx context.Context, event *entity.SecurityEvent) []RuleMatch {
+	at := event.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	hits := r.match(event)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.arrivals++
+	r.received = time.Now()
+	e := sequenceEvent{at: at, arrival: r.arrivals, id: event.ID, tenant: tenant.Normalize(event.TenantID), hits: hits}
+	var matches []RuleMatch
+	switch {
+	case at.Before(r.watermark):
+		// 已经处理过更晚的事件，无法再保证顺序，直接处理
+		r.stats.LateEvents++
+		if len(hits) > 0 {
+			matches = r.process(e)
+		}
+	case len(hits) > 0:
+		heap.Push(&r.buffer, e)
+	}
+
+	if at.After(r.newest) {
+		r.newest = at
+	}
+	if watermark := r.newest.Add(-r.config.Tolerance); watermark.After(r.watermark) {
+		r.watermark = watermark
+	}
+	for r.buffer.Len() > 0 && (!r.buffer[0].at.After(r.watermark) || r.buffer.Len() > r.config.MaxBuffered) {
+		next := heap.Pop(&r.buffer).(sequenceEvent)
+		if next.at.After(r.watermark) {
+			r.watermark = next.at
+		}
+		matches = append(matches, r.process(next)...)
+	}
+	r.expire()
+	return matches
+}
+
+// Advance 在乱序容忍时间内没有事件到达时处理所有缓存的事件。缓存只随事件时间推进，
+// 事件停止到达后，缓存的事件要由 Advance 处理
+func (r *SequenceRule) Advance(now time.Time) []RuleMatch {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.buffer.Len() == 0 || now.Sub(r.received) < r.config.Tolerance {
+		return nil
+	}
+	return r.flush()
+}
+
+// Flush 立即处理所有缓存的事件，用于停止前或批量处理结束时
+func (r *SequenceRule) Flush() []RuleMatch {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.flush()
+}
+
+func (r *SequenceRule) flush() []RuleMatch {
+	var matches []RuleMatch
+	for r.buffer.Len() > 0 {
+		next := heap.Pop(&r.buffer).(sequenceEvent)
+		if next.at.After(r.watermark) {
+			r.watermark = next.at
+		}
+		matches = append(matches, r.process(next)...)
+	}
+	r.expire()
+	return matches
+}
+
+// match 返回事件匹配的步骤，缺少关联字段的步骤不算匹配。关联值带有租户前缀，
+// 序列不会跨租户关联
+func (r *SequenceRule) match(event *entity.SecurityEvent) []sequenceHit {
+	var hits []sequenceHit
+	scope := tenantScope(event)
+	last := len(r.config.Steps) - 1
+	for i, step := range r.config.Steps {
+		matched := true
+		for _, condition := range step.Conditions {
+			if !condition.Evaluate(event) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		hit := sequenceHit{step: i}
+		if i > 0 {
+			values, ok := joinValues(event, step.By)
+			if !ok {
+				continue
+			}
+			hit.by = scope + strings.Join(values, "\x1f")
+		}
+		if i < last {
+			values, ok := joinValues(event, step.Next)
+			if !ok {
+				continue
+			}
+			hit.next = scope + strings.Join(values, "\x1f")
+			hit.attrs = values
+		}
+		hits = append(hits, hit)
+	}
+	return hits
+}
+
+// process 按步骤从后向前处理事件，同一事件在一次处理中最多推进一个序列的一个步骤
+func (r *SequenceRule) process(e sequenceEvent) []RuleMatch {
+	var matches []RuleMatch
+	last := len(r.config.Steps) - 1
+	for i := len(e.hits) - 1; i >= 0; i-- {
+		hit := e.hits[i]
+		if hit.step == 0 {
+			r.start(e, hit)
+			continue
+		}
+
+		p := r.pending[hit.step][hit.by]
+		if p == nil || e.at.Before(p.last) || e.at.Sub(p.start) > r.config.MaxSpan {
+			continue
+		}
+		delete(r.pending[p.step], p.key)
+		p.events = append(p.events, e.id)
+		p.last = e.at
+		if hit.step == last {
+			r.order.Remove(p.element)
+			r.stats.Completed++
+			matches = append(matches, RuleMatch{
+				TenantID:    p.tenant,
+				GroupKey:    p.group,
+				Value:       float64(len(p.events)),
+				EventIDs:    p.events,
+				WindowStart: p.start,
+				WindowEnd:   p.last,
+			})
+			continue
+		}
+		r.await(p, hit)
+	}
+	return matches
+}
+
+// start 以第一步的事件开始新序列，序列数超过上限时淘汰最早开始的序列
+func (r *SequenceRule) start(e sequenceEvent, hit sequenceHit) {
+	p := &sequencePartial{
+		tenant: e.tenant,
+		group:  sequenceLabel(r.config.Steps[0].Next, hit.attrs),
+		events: []string{e.id},
+		start:  e.at,
+		last:   e.at,
+	}
+	// 事件基本按时间处理，从尾部查找插入位置
+	mark := r.order.Back()
+	for mark != nil && mark.Value.(*sequencePartial).start.After(p.start) {
+		mark = mark.Prev()
+	}
+	if mark == nil {
+		p.element = r.order.PushFront(p)
+	} else {
+		p.element = r.order.InsertAfter(p, mark)
+	}
+	r.stats.Started++
+	r.await(p, hit)
+
+	for r.order.Len() > r.config.MaxPartials {
+		r.drop(r.order.Front().Value.(*sequencePartial))
+		r.stats.Evicted++
+	}
+}
+
+// await 让序列等待下一步骤。同一步骤、同一关联值上只保留开始更晚的序列
+func (r *SequenceRule) await(p *sequencePartial, hit sequenceHit) {
+	p.step = hit.step + 1
+	p.key = hit.next
+	p.label = sequenceLabel(r.config.Steps[p.step].By, hit.attrs)
+
+	if existing, ok := r.pending[p.step][p.key]; ok {
+		if existing.start.After(p.start) {
+			r.order.Remove(p.element)
+			r.stats.Superseded++
+			return
+		}
+		r.drop(existing)
+		r.stats.Superseded++
+	}
+	r.pending[p.step][p.key] = p
+}
+
+// expire 丢弃开始时间超过 MaxSpan 的序列
+func (r *SequenceRule) expire() {
+	cutoff := r.watermark.Add(-r.config.MaxSpan)
+	for front := r.order.Front(); front != nil; front = r.order.Front() {
+		p := front.Value.(*sequencePartial)
+		if !p.start.Before(cutoff) {
+			return
+		}
+		r.drop(p)
+		r.stats.Expired++
+	}
+}
+
+func (r *SequenceRule) drop(p *sequencePartial) {
+	r.order.Remove(p.element)
+	if r.pending[p.step][p.key] == p {
+		delete(r.pending[p.step], p.key)
+	}
+}
+
+// sequenceLabel 返回可读的关联描述，例如 source_ip=10.0.0.5
+func sequenceLabel(fields, values []string) string {
+	labels := make([]string, len(fields))
+	for i, field := range fields {
+		labels[i] = field + "=" + values[i]
+	}
+	return strings.Join(labels, ",")
+}
+
+// joinValues 返回关联字段的值，任一字段为空时返回 false
+func joinValues(event *entity.SecurityEvent, fields []string) ([]string, bool) {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		if values[i] = fieldString(event, field); values[i] == "" {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// sequenceBuffer 按事件时间排序的乱序缓存，时间相同时按到达顺序
+type sequenceBuffer []sequenceEvent
+
+func (b sequenceBuffer) Len() int { return len(b) }
+
+func (b sequenceBuffer) Less(i, j int) bool {
+	if b[i].at.Equal(b[j].at) {
+		return b[i].arrival < b[j].arrival
+	}
+	return b[i].at.Before(b[j].at)
+}
+
+func (b sequenceBuffer) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b *sequenceBuffer) Push(x interface{}) {
+	*b = append(*b, x.(sequenceEvent))
+}
+
+func (b *sequenceBuffer) Pop() interface{} {
+	old := *b
+	n := len(old)
+	item := old[n-1]
+	*b = old[:n-1]
+	return item
+}
@@ -0,0 +1,370 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// sequenceInput 依次送入序列规则的事件，at 为相对基准时间的秒数
+type sequenceInput struct {
+	eventType string
+	source    string
+	dest      string
+	at        int
+	tenant    string
+}
+
+func newSequenceEvent(base time.Time, input sequenceInput) *entity.SecurityEvent {
+	event := newTestEvent(input.eventType, "", base.Add(time.Duration(input.at)*time.Second))
+	event.SourceIP = input.source
+	event.DestIP = input.dest
+	event.TenantID = input.tenant
+	return event
+}
+
+// newLateralSequence 扫描主机后登录，随后被登录的主机发起外联，全部在 30 分钟内
+func newLateralSequence(t *testing.T, config SequenceConfig) *SequenceRule {
+	t.Helper()
+	config.Steps = []SequenceStep{
+		{Name: "scan", Conditions: []Condition{NewFieldCondition("event_type", "eq", "scan")}, By: []string{"source_ip"}},
+		{Name: "login", Conditions: []Condition{NewFieldCondition("event_type", "eq", "login")}, By: []string{"source_ip"}, Next: []string{"dest_ip"}},
+		{Name: "beacon", Conditions: []Condition{NewFieldCondition("event_type", "eq", "connect")}, By: []string{"source_ip"}},
+	}
+	if config.MaxSpan == 0 {
+		config.MaxSpan = 30 * time.Minute
+	}
+	rule, err := NewSequenceRule(RuleMetadata{ID: "lateral-movement"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestSequenceRule(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		inputs    []sequenceInput
+		// wantFired 触发规则的事件下标
+		wantFired []int
+		wantGroup string
+	}{
+		{
+			name: "steps in order",
+			inputs: []sequenceInput{
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"login", "10.0.0.5", "10.0.0.9", 60, ""},
+				{"connect", "10.0.0.9", "203.0.113.7", 120, ""},
+			},
+			wantFired: []int{2},
+			wantGroup: "source_ip=10.0.0.5",
+		},
+		{
+			name: "login from another host",
+			inputs: []sequenceInput{
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"login", "10.0.0.6", "10.0.0.9", 60, ""},
+				{"connect", "10.0.0.9", "203.0.113.7", 120, ""},
+			},
+		},
+		{
+			name: "connection from a host that was not logged into",
+			inputs: []sequenceInput{
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"login", "10.0.0.5", "10.0.0.9", 60, ""},
+				{"connect", "10.0.0.5", "203.0.113.7", 120, ""},
+			},
+		},
+		{
+			name: "missing join field",
+			inputs: []sequenceInput{
+				{"scan", "10.0.0.5", "", 0, ""},
+				{"login", "10.0.0.5", "", 60, ""},
+				{"connect", "", "203.0.113.7", 120, ""},
+			},
+		},
+		{
+			name: "exceeds the maximum span",
+			inputs: []sequenceInput{
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"login", "10.0.0.5", "10.0.0.9", 60, ""},
+				{"connect", "10.0.0.9", "203.0.113.7", 31 * 60, ""},
+			},
+		},
+		{
+			name: "steps out of order without tolerance",
+			inputs: []sequenceInput{
+				{"login", "10.0.0.5", "10.0.0.9", 60, ""},
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"connect", "10.0.0.9", "203.0.113.7", 120, ""},
+			},
+		},
+		{
+			name:      "steps out of order within the tolerance",
+			tolerance: 2 * time.Minute,
+			inputs: []sequenceInput{
+				{"login", "10.0.0.5", "10.0.0.9", 60, ""},
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"connect", "10.0.0.9", "203.0.113.7", 120, ""},
+				{"logout", "10.0.0.7", "", 600, ""},
+			},
+			wantFired: []int{3},
+			wantGroup: "source_ip=10.0.0.5",
+		},
+		{
+			name: "sequences do not cross tenants",
+			inputs: []sequenceInput{
+				{"scan", "10.0.0.5", "10.0.0.9", 0, "acme"},
+				{"login", "10.0.0.5", "10.0.0.9", 60, "globex"},
+				{"connect", "10.0.0.9", "203.0.113.7", 120, "globex"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := newLateralSequence(t, SequenceConfig{Tolerance: tt.tolerance})
+
+			var fired []int
+			var matches []RuleMatch
+			for i, input := range tt.inputs {
+				if got := rule.Observe(context.Background(), newSequenceEvent(base, input)); len(got) > 0 {
+					fired = append(fired, i)
+					matches = append(matches, got...)
+				}
+			}
+
+			if fmt.Sprint(fired) != fmt.Sprint(tt.wantFired) {
+				t.Fatalf("fired at %v, want %v", fired, tt.wantFired)
+			}
+			if tt.wantGroup != "" && matches[0].GroupKey != tt.wantGroup {
+				t.Errorf("group = %q, want %q", matches[0].GroupKey, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestSequenceRuleMatchDetails(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rule := newLateralSequence(t, SequenceConfig{})
+
+	var events []*entity.SecurityEvent
+	var matches []RuleMatch
+	for _, input := range []sequenceInput{
+		{"scan", "10.0.0.5", "10.0.0.9", 0, "acme"},
+		{"login", "10.0.0.5", "10.0.0.9", 60, "acme"},
+		{"connect", "10.0.0.9", "203.0.113.7", 120, "acme"},
+	} {
+		event := newSequenceEvent(base, input)
+		events = append(events, event)
+		matches = append(matches, rule.Observe(context.Background(), event)...)
+	}
+
+	if len(matches) != 1 {
+		t.Fatalf("matches = %+v", matches)
+	}
+	match := matches[0]
+	if want := fmt.Sprint([]string{events[0].ID, events[1].ID, events[2].ID}); fmt.Sprint(match.EventIDs) != want || match.Value != 3 {
+		t.Errorf("event ids %v, value %v", match.EventIDs, match.Value)
+	}
+	if !match.WindowStart.Equal(base) || !match.WindowEnd.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("window %v - %v", match.WindowStart, match.WindowEnd)
+	}
+	if match.TenantID != "acme" {
+		t.Errorf("tenant = %q", match.TenantID)
+	}
+	// 完成的序列不再跟踪
+	if stats := rule.Stats(); stats.Partials != 0 || stats.Started != 1 || stats.Completed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestSequenceRuleBuffering(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		finish func(rule *SequenceRule) []RuleMatch
+	}{
+		{name: "flush", finish: func(rule *SequenceRule) []RuleMatch { return rule.Flush() }},
+		{
+			name: "advance after the tolerance",
+			finish: func(rule *SequenceRule) []RuleMatch {
+				// 刚有事件到达时还要等待乱序事件
+				if matches := rule.Advance(time.Now()); len(matches) != 0 {
+					t.Errorf("advanced before the tolerance passed: %+v", matches)
+				}
+				return rule.Advance(time.Now().Add(2 * time.Minute))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := newLateralSequence(t, SequenceConfig{Tolerance: time.Minute})
+			for _, input := range []sequenceInput{
+				{"scan", "10.0.0.5", "10.0.0.9", 0, ""},
+				{"login", "10.0.0.5", "10.0.0.9", 10, ""},
+				{"connect", "10.0.0.9", "203.0.113.7", 20, ""},
+			} {
+				if matches := rule.Observe(context.Background(), newSequenceEvent(base, input)); len(matches) != 0 {
+					t.Fatalf("buffered event fired early: %+v", matches)
+				}
+			}
+			if stats := rule.Stats(); stats.Buffered != 3 || stats.Started != 0 {
+				t.Fatalf("stats = %+v", stats)
+			}
+
+			if matches := tt.finish(rule); len(matches) != 1 {
+				t.Fatalf("matches = %+v", matches)
+			}
+			if stats := rule.Stats(); stats.Buffered != 0 || stats.Completed != 1 {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestSequenceRulePartials(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rule := newLateralSequence(t, SequenceConfig{})
+
+	observe := func(input sequenceInput) *entity.SecurityEvent {
+		event := newSequenceEvent(base, input)
+		rule.Observe(context.Background(), event)
+		return event
+	}
+	observe(sequenceInput{"scan", "10.0.0.5", "", 0, ""})
+	scan := observe(sequenceInput{"scan", "10.0.0.5", "", 60, ""})
+	login := observe(sequenceInput{"login", "10.0.0.5", "10.0.0.9", 120, ""})
+
+	// 同一扫描源更晚开始的序列替换了第一个序列
+	partials := rule.Partials()
+	if len(partials) != 1 {
+		t.Fatalf("partials = %+v", partials)
+	}
+	partial := partials[0]
+	if partial.Step != 2 || partial.StepName != "beacon" || partial.Key != "source_ip=10.0.0.9" || partial.TenantID != "default" {
+		t.Errorf("partial = %+v", partial)
+	}
+	if fmt.Sprint(partial.EventIDs) != fmt.Sprint([]string{scan.ID, login.ID}) {
+		t.Errorf("event ids = %v", partial.EventIDs)
+	}
+	start := base.Add(time.Minute)
+	if !partial.Start.Equal(start) || !partial.Last.Equal(base.Add(2*time.Minute)) || !partial.Expires.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("start %v, last %v, expires %v", partial.Start, partial.Last, partial.Expires)
+	}
+
+	// 新的扫描推进事件时间，第一个序列超过最大间隔；早于已处理事件的扫描直接处理并立即过期
+	observe(sequenceInput{"scan", "10.0.0.6", "", 40 * 60, ""})
+	observe(sequenceInput{"scan", "10.0.0.7", "", 0, ""})
+
+	stats := rule.Stats()
+	if stats.Partials != 1 || stats.Started != 4 || stats.Superseded != 1 || stats.Expired != 2 || stats.LateEvents != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if partials := rule.Partials(); len(partials) != 1 || partials[0].Key != "source_ip=10.0.0.6" {
+		t.Errorf("partials = %+v", partials)
+	}
+}
+
+func TestSequenceRuleLimits(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	scans := []sequenceInput{
+		{"scan", "10.0.0.5", "", 0, ""},
+		{"scan", "10.0.0.6", "", 1, ""},
+		{"scan", "10.0.0.7", "", 2, ""},
+	}
+
+	tests := []struct {
+		name   string
+		config SequenceConfig
+		want   SequenceStats
+		// wantKeys 剩余未完成序列等待的关联值
+		wantKeys string
+	}{
+		{
+			name:     "oldest partial is evicted",
+			config:   SequenceConfig{MaxPartials: 2},
+			want:     SequenceStats{Partials: 2, Started: 3, Evicted: 1},
+			wantKeys: "source_ip=10.0.0.6 source_ip=10.0.0.7",
+		},
+		{
+			name:     "oldest buffered event is processed early",
+			config:   SequenceConfig{Tolerance: time.Hour, MaxBuffered: 2},
+			want:     SequenceStats{Partials: 1, Buffered: 2, Started: 1},
+			wantKeys: "source_ip=10.0.0.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := newLateralSequence(t, tt.config)
+			for _, input := range scans {
+				rule.Observe(context.Background(), newSequenceEvent(base, input))
+			}
+
+			if stats := rule.Stats(); stats != tt.want {
+				t.Errorf("stats = %+v, want %+v", stats, tt.want)
+			}
+			var keys []string
+			for _, partial := range rule.Partials() {
+				keys = append(keys, partial.Key)
+			}
+			if got := strings.Join(keys, " "); got != tt.wantKeys {
+				t.Errorf("partials = %s, want %s", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestNewSequenceRuleErrors(t *testing.T) {
+	scan := SequenceStep{Name: "scan", Conditions: []Condition{NewFieldCondition("event_type", "eq", "scan")}, By: []string{"source_ip"}}
+	login := SequenceStep{Name: "login", Conditions: []Condition{NewFieldCondition("event_type", "eq", "login")}, By: []string{"source_ip"}}
+
+	tests := []struct {
+		name   string
+		config SequenceConfig
+		want   string
+	}{
+		{name: "single step", config: SequenceConfig{Steps: []SequenceStep{scan}, MaxSpan: time.Hour}, want: "至少需要两个步骤"},
+		{name: "no maximum span", config: SequenceConfig{Steps: []SequenceStep{scan, login}}, want: "最大间隔"},
+		{name: "negative tolerance", config: SequenceConfig{Steps: []SequenceStep{scan, login}, MaxSpan: time.Hour, Tolerance: -time.Second}, want: "乱序容忍"},
+		{
+			name:   "step without conditions",
+			config: SequenceConfig{Steps: []SequenceStep{scan, {Name: "login", By: []string{"source_ip"}}}, MaxSpan: time.Hour},
+			want:   "步骤 1 缺少条件",
+		},
+		{
+			name:   "first step without join fields",
+			config: SequenceConfig{Steps: []SequenceStep{{Name: "scan", Conditions: scan.Conditions}, login}, MaxSpan: time.Hour},
+			want:   "步骤 0 缺少关联字段",
+		},
+		{
+			name:   "later step without join fields",
+			config: SequenceConfig{Steps: []SequenceStep{scan, {Name: "login", Conditions: login.Conditions}}, MaxSpan: time.Hour},
+			want:   "步骤 1 缺少关联字段",
+		},
+		{
+			name:   "join field count mismatch",
+			config: SequenceConfig{Steps: []SequenceStep{scan, {Name: "login", Conditions: login.Conditions, By: []string{"source_ip", "user"}}}, MaxSpan: time.Hour},
+			want:   "数量 2 与上一步传递的 1 个不一致",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSequenceRule(RuleMetadata{ID: "sequence"}, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
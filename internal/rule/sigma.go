@@ -0,0 +1,553 @@
+package rule
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/repository"
+	"gopkg.in/yaml.v2"
+)
+
+// SigmaConfig Sigma 规则的字段和日志源映射
+type SigmaConfig struct {
+	// FieldMappings Sigma 字段名到事件字段的映射，未映射的字段按同名标签查找
+	FieldMappings map[string]string `json:"field_mappings" yaml:"field_mappings"`
+	// LogSources 日志源映射，规则日志源匹配的所有映射同时生效
+	LogSources []SigmaLogSource `json:"log_sources" yaml:"log_sources"`
+}
+
+// SigmaLogSource 日志源映射，非空的 category、product、service 都相同时匹配
+type SigmaLogSource struct {
+	Category string `json:"category,omitempty" yaml:"category,omitempty"`
+	Product  string `json:"product,omitempty" yaml:"product,omitempty"`
+	Service  string `json:"service,omitempty" yaml:"service,omitempty"`
+	// Conditions 事件需要满足的字段值，例如 parser: zeek
+	Conditions map[string]string `json:"conditions" yaml:"conditions"`
+	// FieldMappings 仅对该日志源生效的字段映射，优先于全局映射
+	FieldMappings map[string]string `json:"field_mappings,omitempty" yaml:"field_mappings,omitempty"`
+}
+
+// DefaultSigmaConfig 返回默认映射，覆盖通用网络字段、Zeek 日志和 Linux syslog 服务
+func DefaultSigmaConfig() SigmaConfig {
+	return SigmaConfig{
+		FieldMappings: map[string]string{
+			"SourceIp":        "source_ip",
+			"src_ip":          "source_ip",
+			"DestinationIp":   "dest_ip",
+			"dst_ip":          "dest_ip",
+			"DestinationPort": "port",
+			"dst_port":        "port",
+			"SourcePort":      "source_port",
+			"src_port":        "source_port",
+			"Protocol":        "protocol",
+			"User":            "user",
+		},
+		LogSources: []SigmaLogSource{
+			{Product: "zeek", Conditions: map[string]string{"parser": "zeek"},
+				FieldMappings: map[string]string{"id.orig_h": "source_ip", "id.resp_h": "dest_ip", "id.resp_p": "port", "id.orig_p": "source_port", "proto": "protocol"}},
+			{Product: "zeek", Service: "conn", Conditions: map[string]string{"event_type": "conn"},
+				FieldMappings: map[string]string{"conn_state": "status", "service": "conn.service", "duration": "conn.duration", "history": "conn.history"}},
+			{Product: "zeek", Service: "dns", Conditions: map[string]string{"event_type": "dns"},
+				FieldMappings: map[string]string{"query": "dns.query", "qtype_name": "dns.qtype", "rcode_name": "dns.rcode", "answers": "dns.answers"}},
+			{Product: "zeek", Service: "http", Conditions: map[string]string{"event_type": "http"},
+				FieldMappings: map[string]string{"method": "http.method", "host": "http.hostname", "uri": "http.url", "user_agent": "http.user_agent", "status_code": "status"}},
+			{Product: "linux", Service: "sshd", Conditions: map[string]string{"app": "sshd"}},
+			{Product: "linux", Service: "sudo", Conditions: map[string]string{"app": "sudo"}},
+			{Product: "linux", Service: "cron", Conditions: map[string]string{"app": "cron"}},
+		},
+	}
+}
+
+// SigmaImportReport Sigma 规则导入报告
+type SigmaImportReport struct {
+	Imported int               `json:"imported"`
+	Skipped  int               `json:"skipped"`
+	Rules    []SigmaRuleReport `json:"rules"`
+}
+
+// SigmaRuleReport 单条 Sigma 规则的导入结果
+type SigmaRuleReport struct {
+	File     string `json:"file"`
+	ID       string `json:"id,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Imported bool   `json:"imported"`
+	// Tactics、Techniques 为规则标签中的 ATT&CK 战术和技术
+	Tactics    []string `json:"tactics,omitempty"`
+	Techniques []string `json:"techniques,omitempty"`
+	// Unsupported 规则中不支持的构造，含有时规则不会导入
+	Unsupported []string `json:"unsupported,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// sigmaDocument Sigma 规则文档中用到的部分
+type sigmaDocument struct {
+	Title       string         `yaml:"title"`
+	ID          string         `yaml:"id"`
+	Status      string         `yaml:"status"`
+	Description string         `yaml:"description"`
+	Author      string         `yaml:"author"`
+	References  []string       `yaml:"references"`
+	Tags        []string       `yaml:"tags"`
+	Level       string         `yaml:"level"`
+	LogSource   sigmaLogSource `yaml:"logsource"`
+	Detection   yaml.MapSlice  `yaml:"detection"`
+	// Action 规则集合 (global/reset/repeat)，Correlation 关联规则
+	Action      string      `yaml:"action"`
+	Correlation interface{} `yaml:"correlation"`
+}
+
+type sigmaLogSource struct {
+	Category string `yaml:"category"`
+	Product  string `yaml:"product"`
+	Service  string `yaml:"service"`
+}
+
+// sigmaRule 编译后的 Sigma 规则
+type sigmaRule struct {
+	doc       sigmaDocument
+	condition Condition
+}
+
+// sigmaLevels Sigma 级别到规则严重性的映射
+var sigmaLevels = map[string]string{
+	"informational": "low",
+	"low":           "low",
+	"medium":        "medium",
+	"high":          "high",
+	"critical":      "critical",
+}
+
+var attackTechnique = regexp.MustCompile(`^t\d{4}(\.\d{3})?$`)
+
+// compileSigma 编译一条 Sigma 规则。规则有错误或不支持的构造时返回 nil，原因记录在报告中
+func compileSigma(source []byte, config SigmaConfig) (*sigmaRule, *SigmaRuleReport) {
+	report := &SigmaRuleReport{}
+	var doc sigmaDocument
+	if err := yaml.Unmarshal(source, &doc); err != nil {
+		report.Error = fmt.Sprintf("解析Sigma规则失败: %v", err)
+		return nil, report
+	}
+	report.ID = doc.ID
+	report.Title = doc.Title
+	report.Tactics, report.Techniques = attackTags(doc.Tags)
+
+	switch {
+	case doc.Action != "":
+		report.Unsupported = append(report.Unsupported, fmt.Sprintf("action: 不支持规则集合 (%s)", doc.Action))
+	case doc.Correlation != nil:
+		report.Unsupported = append(report.Unsupported, "correlation: 不支持关联规则")
+	}
+	if len(report.Unsupported) > 0 {
+		return nil, report
+	}
+	if doc.ID == "" {
+		report.Error = "id: 缺少规则ID"
+		return nil, report
+	}
+	if doc.Title == "" {
+		report.Error = "title: 缺少规则标题"
+		return nil, report
+	}
+	if len(doc.Detection) == 0 {
+		report.Error = "detection: 缺少检测定义"
+		return nil, report
+	}
+	if _, ok := sigmaLevels[strings.ToLower(doc.Level)]; !ok && doc.Level != "" {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("level: 未知的级别 %q，按 medium 处理", doc.Level))
+	}
+
+	c := &sigmaCompiler{fields: make(map[string]string), report: report}
+	for name, field := range config.FieldMappings {
+		c.fields[name] = field
+	}
+	logSource := c.logSourceConditions(doc.LogSource, config.LogSources)
+
+	detection, err := c.compileDetection(doc.Detection)
+	if err != nil {
+		report.Error = err.Error()
+		return nil, report
+	}
+	if len(report.Unsupported) > 0 {
+		return nil, report
+	}
+
+	condition := detection
+	if len(logSource) > 0 {
+		condition = NewConditionGroup("AND", append(logSource, detection))
+	}
+	return &sigmaRule{doc: doc, condition: condition}, report
+}
+
+// attackTags 提取 ATT&CK 标签，例如 attack.execution 和 attack.t1059.001
+func attackTags(tags []string) (tactics, techniques []string) {
+	for _, tag := range tags {
+		name, ok := strings.CutPrefix(strings.ToLower(tag), "attack.")
+		if !ok {
+			continue
+		}
+		switch {
+		case attackTechnique.MatchString(name):
+			techniques = append(techniques, strings.ToUpper(name))
+		case len(name) == 5 && (name[0] == 'g' || name[0] == 's') && strings.Trim(name[1:], "0123456789") == "":
+			// 组织和软件编号
+		default:
+			tactics = append(tactics, name)
+		}
+	}
+	return tactics, techniques
+}
+
+// sigmaCompiler 编译单条规则的检测定义
+type sigmaCompiler struct {
+	fields map[string]string // 生效的字段映射
+	report *SigmaRuleReport
+}
+
+func (c *sigmaCompiler) unsupported(format string, args ...interface{}) {
+	c.report.Unsupported = append(c.report.Unsupported, fmt.Sprintf(format, args...))
+}
+
+// logSourceConditions 应用规则日志源匹配的映射，返回日志源对应的事件条件
+func (c *sigmaCompiler) logSourceConditions(source sigmaLogSource, mappings []SigmaLogSource) []Condition {
+	var conditions []Condition
+	matched := false
+	for _, mapping := range mappings {
+		if mapping.Category == "" && mapping.Product == "" && mapping.Service == "" {
+			continue
+		}
+		if !sameOrEmpty(mapping.Category, source.Category) || !sameOrEmpty(mapping.Product, source.Product) ||
+			!sameOrEmpty(mapping.Service, source.Service) {
+			continue
+		}
+		matched = true
+		fields := make([]string, 0, len(mapping.Conditions))
+		for field := range mapping.Conditions {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			conditions = append(conditions, NewFieldCondition(field, "eq", mapping.Conditions[field]))
+		}
+		for name, field := range mapping.FieldMappings {
+			c.fields[name] = field
+		}
+	}
+
+	if !matched && (source.Category != "" || source.Product != "" || source.Service != "") {
+		c.report.Warnings = append(c.report.Warnings, fmt.Sprintf(
+			"logsource: 未映射的日志源 (category=%s, product=%s, service=%s)，规则对所有事件生效",
+			source.Category, source.Product, source.Service))
+	}
+	return conditions
+}
+
+func sameOrEmpty(mapping, value string) bool {
+	return mapping == "" || strings.EqualFold(mapping, value)
+}
+
+// compileDetection 编译全部检测项并按 condition 组合
+func (c *sigmaCompiler) compileDetection(detection yaml.MapSlice) (Condition, error) {
+	selections := make(map[string]Condition)
+	var names []string
+	var expressions []string
+	for _, item := range detection {
+		name := fmt.Sprint(item.Key)
+		switch name {
+		case "condition":
+			switch value := item.Value.(type) {
+			case string:
+				expressions = append(expressions, value)
+			case []interface{}:
+				for _, v := range value {
+					expression, ok := v.(string)
+					if !ok {
+						return nil, fmt.Errorf("detection.condition: 必须是字符串")
+					}
+					expressions = append(expressions, expression)
+				}
+			default:
+				return nil, fmt.Errorf("detection.condition: 必须是字符串或字符串数组")
+			}
+		case "timeframe":
+			c.unsupported("detection.timeframe: 不支持时间窗口聚合")
+		default:
+			condition, err := c.compileSelection("detection."+name, item.Value)
+			if err != nil {
+				return nil, err
+			}
+			selections[name] = condition
+			names = append(names, name)
+		}
+	}
+	if len(expressions) == 0 {
+		return nil, fmt.Errorf("detection.condition: 缺少条件表达式")
+	}
+
+	// 多个条件表达式任一匹配即可
+	conditions := make([]Condition, 0, len(expressions))
+	for _, expression := range expressions {
+		parser := &sigmaConditionParser{selections: selections, names: names, compiler: c}
+		condition, err := parser.parse(expression)
+		if err != nil {
+			return nil, fmt.Errorf("detection.condition: %v", err)
+		}
+		if condition != nil {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	return NewConditionGroup("OR", conditions), nil
+}
+
+// compileSelection 编译检测项：映射中的字段全部匹配，映射列表任一匹配，字符串列表为关键字
+func (c *sigmaCompiler) compileSelection(path string, value interface{}) (Condition, error) {
+	if items, ok := sigmaMap(value); ok {
+		return c.compileFieldMap(path, items)
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		if keyword, ok := sigmaScalar(value); ok {
+			return c.compileKeywords(path, []interface{}{keyword})
+		}
+		return nil, fmt.Errorf("%s: 必须是映射或列表", path)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: 不能为空", path)
+	}
+	if _, isMap := sigmaMap(list[0]); !isMap {
+		return c.compileKeywords(path, list)
+	}
+
+	conditions := make([]Condition, 0, len(list))
+	for i, element := range list {
+		items, ok := sigmaMap(element)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: 列表不能混合映射和关键字", path, i)
+		}
+		condition, err := c.compileFieldMap(fmt.Sprintf("%s[%d]", path, i), items)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	return NewConditionGroup("OR", conditions), nil
+}
+
+func (c *sigmaCompiler) compileFieldMap(path string, items yaml.MapSlice) (Condition, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%s: 不能为空", path)
+	}
+	conditions := make([]Condition, 0, len(items))
+	for _, item := range items {
+		key := fmt.Sprint(item.Key)
+		condition, err := c.compileField(path+"."+key, key, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	return NewConditionGroup("AND", conditions), nil
+}
+
+func (c *sigmaCompiler) compileKeywords(path string, values []interface{}) (Condition, error) {
+	matchers := make([]sigmaMatcher, 0, len(values))
+	for i, value := range values {
+		keyword, ok := sigmaScalar(value)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: 关键字必须是标量", path, i)
+		}
+		pattern, err := wildcardPattern(keyword, "contains", false)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %v", path, i, err)
+		}
+		matchers = append(matchers, func(value string, present bool) bool {
+			return present && pattern.MatchString(value)
+		})
+	}
+	return &sigmaKeywordCondition{matchers: matchers}, nil
+}
+
+// sigmaMap 将 YAML 映射统一为有序的 MapSlice
+func sigmaMap(value interface{}) (yaml.MapSlice, bool) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		return v, true
+	case map[interface{}]interface{}:
+		items := make(yaml.MapSlice, 0, len(v))
+		for key, value := range v {
+			items = append(items, yaml.MapItem{Key: key, Value: value})
+		}
+		sort.Slice(items, func(i, j int) bool { return fmt.Sprint(items[i].Key) < fmt.Sprint(items[j].Key) })
+		return items, true
+	}
+	return nil, false
+}
+
+// sigmaScalar 将标量值转换为字符串
+func sigmaScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// err 将编译失败的原因转换为错误
+func (r *SigmaRuleReport) err() error {
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	return fmt.Errorf("不支持的Sigma构造: %s", strings.Join(r.Unsupported, "; "))
+}
+
+// definition 生成保存到规则存储的规则定义，Sigma 源保存在 Metadata["sigma"] 中
+func (r *sigmaRule) definition(source []byte, report *SigmaRuleReport) *repository.RuleDefinition {
+	doc := r.doc
+	severity, ok := sigmaLevels[strings.ToLower(doc.Level)]
+	if !ok {
+		severity = "medium"
+	}
+	category := doc.LogSource.Category
+	if category == "" {
+		category = doc.LogSource.Product
+	}
+	status := "active"
+	if doc.Status == "deprecated" || doc.Status == "unsupported" {
+		status = "deprecated"
+	}
+
+	now := time.Now()
+	return &repository.RuleDefinition{
+		ID:          doc.ID,
+		Name:        doc.Title,
+		Description: doc.Description,
+		Category:    category,
+		Severity:    severity,
+		Version:     1,
+		Status:      status,
+		Config:      repository.RuleConfig{Type: "sigma"},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		CreatedBy:   doc.Author,
+		UpdatedBy:   doc.Author,
+		Tags:        doc.Tags,
+		Metadata: map[string]interface{}{
+			"sigma":             string(source),
+			"sigma_status":      doc.Status,
+			"references":        doc.References,
+			"attack_tactics":    report.Tactics,
+			"attack_techniques": report.Techniques,
+		},
+	}
+}
+
+// ImportSigmaRules 从Sigma YAML文件或目录导入规则。解析失败或含有不支持构造的规则
+// 不会导入，以免产生错误的匹配，原因记录在返回的报告中；已弃用的规则只保存不加载
+func (m *RuleManager) ImportSigmaRules(ctx context.Context, path string) (*SigmaImportReport, error) {
+	files, err := sigmaFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("查找Sigma规则文件失败: %v", err)
+	}
+
+	result := &SigmaImportReport{Rules: make([]SigmaRuleReport, 0)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取规则文件失败: %v", err)
+		}
+
+		// 一个文件可以包含多个YAML文档
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc yaml.MapSlice
+			if err := decoder.Decode(&doc); err != nil {
+				if err != io.EOF {
+					result.Rules = append(result.Rules, SigmaRuleReport{File: file, Error: fmt.Sprintf("解析YAML失败: %v", err)})
+					result.Skipped++
+				}
+				break
+			}
+			if len(doc) == 0 {
+				continue
+			}
+
+			source, err := yaml.Marshal(doc)
+			if err != nil {
+				return nil, fmt.Errorf("序列化Sigma规则失败: %v", err)
+			}
+			compiled, report := compileSigma(source, m.sigma)
+			report.File = file
+			if compiled == nil {
+				result.Rules = append(result.Rules, *report)
+				result.Skipped++
+				continue
+			}
+
+			def := compiled.definition(source, report)
+			if err := m.store.SaveRule(ctx, def); err != nil {
+				return nil, fmt.Errorf("保存规则失败 [%s]: %v", def.ID, err)
+			}
+			if def.Status == "active" {
+				engineRule, err := m.ConvertToEngineRule(def)
+				if err != nil {
+					return nil, fmt.Errorf("转换规则失败 [%s]: %v", def.ID, err)
+				}
+				m.engine.AddRule(engineRule)
+			} else {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("status: 规则状态为 %s，未加载到引擎", compiled.doc.Status))
+			}
+
+			report.Imported = true
+			result.Rules = append(result.Rules, *report)
+			result.Imported++
+		}
+	}
+	return result, nil
+}
+
+// sigmaFiles 返回路径下的 .yml 和 .yaml 文件
+func sigmaFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(file))
+		if !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
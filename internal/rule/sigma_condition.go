@@ -0,0 +1,400 @@
+package rule
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// sigmaMatcher 匹配字段的字符串值，present 表示事件中有该字段
+type sigmaMatcher func(value string, present bool) bool
+
+// sigmaFieldCondition Sigma 字段条件，任一值匹配即可，all 修饰符要求全部匹配
+type sigmaFieldCondition struct {
+	field    string
+	matchers []sigmaMatcher
+	all      bool
+}
+
+func (c *sigmaFieldCondition) Evaluate(event *entity.SecurityEvent) bool {
+	value := ""
+	raw := getFieldValue(event, c.field)
+	if raw != nil {
+		value = fmt.Sprint(raw)
+	}
+	present := value != ""
+
+	for _, matcher := range c.matchers {
+		matched := matcher(value, present)
+		if matched && !c.all {
+			return true
+		}
+		if !matched && c.all {
+			return false
+		}
+	}
+	return c.all && len(c.matchers) > 0
+}
+
+// sigmaKeywordCondition Sigma 关键字条件，在事件描述和原始日志中查找
+type sigmaKeywordCondition struct {
+	matchers []sigmaMatcher
+}
+
+func (c *sigmaKeywordCondition) Evaluate(event *entity.SecurityEvent) bool {
+	for _, matcher := range c.matchers {
+		if matcher(event.Description, event.Description != "") || matcher(event.RawData, event.RawData != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// compileField 编译 "字段|修饰符" 形式的检测项
+func (c *sigmaCompiler) compileField(path, key string, value interface{}) (Condition, error) {
+	parts := strings.Split(key, "|")
+	field := parts[0]
+	if field == "" {
+		c.unsupported("%s: 不支持无字段名的修饰符", path)
+		return &sigmaFieldCondition{}, nil
+	}
+	if mapped, ok := c.fields[field]; ok {
+		field = mapped
+	}
+
+	var operator, reFlags string
+	all, cased := false, false
+	for _, modifier := range parts[1:] {
+		switch modifier {
+		case "contains", "startswith", "endswith", "re", "cidr", "gt", "gte", "lt", "lte", "exists":
+			if operator != "" {
+				c.unsupported("%s: 不支持组合修饰符 %s 和 %s", path, operator, modifier)
+				continue
+			}
+			operator = modifier
+		case "all":
+			all = true
+		case "cased":
+			cased = true
+		case "i", "m", "s":
+			if operator != "re" {
+				c.unsupported("%s: 修饰符 %s 只能用于 re", path, modifier)
+				continue
+			}
+			reFlags += modifier
+		default:
+			c.unsupported("%s: 不支持的修饰符 %q", path, modifier)
+		}
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s: 值列表不能为空", path)
+	}
+
+	matchers := make([]sigmaMatcher, 0, len(values))
+	for i, v := range values {
+		valuePath := path
+		if len(values) > 1 {
+			valuePath = fmt.Sprintf("%s[%d]", path, i)
+		}
+		matcher, err := sigmaValueMatcher(operator, reFlags, cased, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", valuePath, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return &sigmaFieldCondition{field: field, matchers: matchers, all: all}, nil
+}
+
+// sigmaValueMatcher 按修饰符编译单个值
+func sigmaValueMatcher(operator, reFlags string, cased bool, value interface{}) (sigmaMatcher, error) {
+	if value == nil {
+		if operator != "" {
+			return nil, fmt.Errorf("修饰符 %s 不能用于空值", operator)
+		}
+		return func(_ string, present bool) bool { return !present }, nil
+	}
+
+	if operator == "exists" {
+		want, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("exists 需要布尔值")
+		}
+		return func(_ string, present bool) bool { return present == want }, nil
+	}
+
+	text, ok := sigmaScalar(value)
+	if !ok {
+		return nil, fmt.Errorf("值必须是标量")
+	}
+
+	switch operator {
+	case "re":
+		if reFlags != "" {
+			text = "(?" + reFlags + ")" + text
+		}
+		pattern, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("无效的正则表达式: %v", err)
+		}
+		return func(value string, present bool) bool { return present && pattern.MatchString(value) }, nil
+	case "cidr":
+		_, network, err := net.ParseCIDR(text)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR %q", text)
+		}
+		return func(value string, _ bool) bool {
+			ip := net.ParseIP(value)
+			return ip != nil && network.Contains(ip)
+		}, nil
+	case "gt", "gte", "lt", "lte":
+		limit, ok := toFloat(text)
+		if !ok {
+			return nil, fmt.Errorf("修饰符 %s 需要数值", operator)
+		}
+		return func(value string, present bool) bool {
+			number, ok := toFloat(value)
+			return present && ok && compareNumbers(operator, number, limit)
+		}, nil
+	}
+
+	pattern, err := wildcardPattern(text, operator, cased)
+	if err != nil {
+		return nil, err
+	}
+	return func(value string, _ bool) bool { return pattern.MatchString(value) }, nil
+}
+
+// wildcardPattern 将 Sigma 通配符值 (* 和 ?，反斜杠转义) 转换为正则表达式。
+// 默认不区分大小写，contains、startswith、endswith 决定锚定方式
+func wildcardPattern(value, operator string, cased bool) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("(?s)")
+	if !cased {
+		pattern.WriteString("(?i)")
+	}
+	if operator == "" || operator == "startswith" {
+		pattern.WriteString("^")
+	}
+
+	runes := []rune(value)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\\':
+			if i+1 < len(runes) && (runes[i+1] == '*' || runes[i+1] == '?' || runes[i+1] == '\\') {
+				i++
+				pattern.WriteString(regexp.QuoteMeta(string(runes[i])))
+			} else {
+				pattern.WriteString(`\\`)
+			}
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	if operator == "" || operator == "endswith" {
+		pattern.WriteString("$")
+	}
+	return regexp.Compile(pattern.String())
+}
+
+// sigmaConditionParser 解析 detection.condition 表达式:
+//
+//	expr    = and { "or" and }
+//	and     = not { "and" not }
+//	not     = "not" not | primary
+//	primary = "(" expr ")" | ("1" | "all") "of" (pattern | "them") | identifier
+type sigmaConditionParser struct {
+	selections map[string]Condition
+	names      []string // 检测项的定义顺序
+	compiler   *sigmaCompiler
+	tokens     []string
+	pos        int
+}
+
+// parse 解析条件表达式。含有不支持构造的表达式记录在报告中并返回 nil
+func (p *sigmaConditionParser) parse(expression string) (Condition, error) {
+	p.tokens = tokenizeSigmaCondition(expression)
+	p.pos = 0
+	for _, token := range p.tokens {
+		switch strings.ToLower(token) {
+		case "|":
+			p.compiler.unsupported("detection.condition: 不支持聚合表达式 %q", expression)
+			return nil, nil
+		case "near":
+			p.compiler.unsupported("detection.condition: 不支持 near 表达式 %q", expression)
+			return nil, nil
+		}
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("条件表达式为空")
+	}
+
+	condition, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("位置 %d: 多余的 %q", p.pos+1, p.tokens[p.pos])
+	}
+	return condition, nil
+}
+
+func tokenizeSigmaCondition(expression string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range expression {
+		switch {
+		case r == '(' || r == ')' || r == '|':
+			flush()
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func (p *sigmaConditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *sigmaConditionParser) parseOr() (Condition, error) {
+	return p.parseBinary("or", p.parseAnd)
+}
+
+func (p *sigmaConditionParser) parseAnd() (Condition, error) {
+	return p.parseBinary("and", p.parseNot)
+}
+
+func (p *sigmaConditionParser) parseBinary(keyword string, operand func() (Condition, error)) (Condition, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	conditions := []Condition{first}
+	for p.peek() == keyword {
+		p.pos++
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, next)
+	}
+	if len(conditions) == 1 {
+		return first, nil
+	}
+	return NewConditionGroup(strings.ToUpper(keyword), conditions), nil
+}
+
+func (p *sigmaConditionParser) parseNot() (Condition, error) {
+	if p.peek() == "not" {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return NewConditionGroup("NOT", []Condition{operand}), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *sigmaConditionParser) parsePrimary() (Condition, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("表达式不完整")
+	}
+	token := p.tokens[p.pos]
+	position := p.pos + 1
+	p.pos++
+
+	switch strings.ToLower(token) {
+	case "(":
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("位置 %d: 括号未闭合", position)
+		}
+		p.pos++
+		return condition, nil
+	case ")", "and", "or", "of", "them":
+		return nil, fmt.Errorf("位置 %d: 意外的 %q", position, token)
+	case "1", "all":
+		if p.peek() != "of" {
+			return nil, fmt.Errorf("位置 %d: %s 后需要 of", position, token)
+		}
+		p.pos++
+		if p.pos >= len(p.tokens) {
+			return nil, fmt.Errorf("位置 %d: of 后缺少检测项", position)
+		}
+		target := p.tokens[p.pos]
+		p.pos++
+		return p.quantified(strings.ToLower(token), target, position)
+	}
+
+	if strings.Trim(token, "0123456789") == "" {
+		return nil, fmt.Errorf("位置 %d: 只支持 1 of 和 all of", position)
+	}
+	condition, ok := p.selections[token]
+	if !ok {
+		return nil, fmt.Errorf("位置 %d: 未定义的检测项 %q", position, token)
+	}
+	return condition, nil
+}
+
+// quantified 处理 "1 of" 和 "all of"。them 表示除下划线开头外的全部检测项
+func (p *sigmaConditionParser) quantified(quantifier, target string, position int) (Condition, error) {
+	var matcher *regexp.Regexp
+	if target != "them" {
+		pattern, err := wildcardPattern(target, "", true)
+		if err != nil {
+			return nil, fmt.Errorf("位置 %d: %v", position, err)
+		}
+		matcher = pattern
+	}
+
+	var conditions []Condition
+	for _, name := range p.names {
+		if matcher == nil && strings.HasPrefix(name, "_") {
+			continue
+		}
+		if matcher != nil && !matcher.MatchString(name) {
+			continue
+		}
+		conditions = append(conditions, p.selections[name])
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("位置 %d: %q 没有匹配的检测项", position, target)
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	if quantifier == "all" {
+		return NewConditionGroup("AND", conditions), nil
+	}
+	return NewConditionGroup("OR", conditions), nil
+}
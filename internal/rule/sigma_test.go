@@ -0,0 +1,295 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/repository"
+)
+
+func newSigmaEvent(user string, labels ...string) *entity.SecurityEvent {
+	event := newTestEvent("login", user, time.Now())
+	event.Port = 22
+	event.Description = "Failed password for root from 10.0.0.1"
+	for _, label := range labels {
+		key, value, _ := strings.Cut(label, "=")
+		event.SetLabel(key, value)
+	}
+	return event
+}
+
+func TestCompileSigma(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		event *entity.SecurityEvent
+		want  bool
+	}{
+		{
+			name: "keywords within the mapped log source",
+			rule: `
+logsource: {product: linux, service: sshd}
+detection:
+  keywords: ['failed password*']
+  condition: keywords`,
+			event: newSigmaEvent("root", "app=sshd"),
+			want:  true,
+		},
+		{
+			name: "event outside the mapped log source",
+			rule: `
+logsource: {product: linux, service: sshd}
+detection:
+  keywords: ['failed password*']
+  condition: keywords`,
+			event: newSigmaEvent("root", "app=cron"),
+			want:  false,
+		},
+		{
+			name: "mapped fields with a filter",
+			rule: `
+detection:
+  selection:
+    DestinationPort: [22, 3389]
+    SourceIp|cidr: 10.0.0.0/8
+  filter:
+    User|startswith: svc_
+  condition: selection and not filter`,
+			event: newSigmaEvent("alice"),
+			want:  true,
+		},
+		{
+			name: "filtered out",
+			rule: `
+detection:
+  selection:
+    DestinationPort: [22, 3389]
+  filter:
+    User|startswith: SVC_
+  condition: selection and not filter`,
+			event: newSigmaEvent("svc_backup"),
+			want:  false,
+		},
+		{
+			name: "one of a pattern",
+			rule: `
+detection:
+  selection_admin: {User|endswith: admin}
+  selection_root: {User|re: '^root$'}
+  condition: 1 of selection_*`,
+			event: newSigmaEvent("SysADMIN"),
+			want:  true,
+		},
+		{
+			name: "regular expressions are case sensitive",
+			rule: `
+detection:
+  selection: {User|re: '^root$'}
+  condition: selection`,
+			event: newSigmaEvent("Root"),
+			want:  false,
+		},
+		{
+			name: "all values",
+			rule: `
+detection:
+  selection:
+    description|contains|all: [failed, root]
+  condition: selection`,
+			event: newSigmaEvent("root"),
+			want:  true,
+		},
+		{
+			name: "all of them",
+			rule: `
+detection:
+  port: {DestinationPort|gte: 1024}
+  user: {User: root}
+  _ignored: {User: nobody}
+  condition: all of them`,
+			event: newSigmaEvent("root"),
+			want:  false,
+		},
+		{
+			name: "null matches a missing field",
+			rule: `
+detection:
+  selection: {flow_id: null}
+  condition: selection`,
+			event: newSigmaEvent("root"),
+			want:  true,
+		},
+		{
+			name: "Zeek service mappings",
+			rule: `
+logsource: {product: zeek, service: conn}
+detection:
+  selection:
+    id.orig_h: 10.0.0.1
+    conn_state: S0
+  condition: selection`,
+			event: func() *entity.SecurityEvent {
+				event := newSigmaEvent("", "parser=zeek")
+				event.EventType = "conn"
+				event.Status = "S0"
+				return event
+			}(),
+			want: true,
+		},
+		{
+			name: "several conditions match any",
+			rule: `
+detection:
+  a: {User: alice}
+  b: {User: bob}
+  condition: [a, b]`,
+			event: newSigmaEvent("bob"),
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "title: test\nid: sigma-test\n" + strings.TrimSpace(tt.rule)
+			compiled, report := compileSigma([]byte(source), DefaultSigmaConfig())
+			if compiled == nil {
+				t.Fatalf("not compiled: %v", report.err())
+			}
+			if got := compiled.condition.Evaluate(tt.event); got != tt.want {
+				t.Errorf("matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSigmaReport(t *testing.T) {
+	tests := []struct {
+		name            string
+		rule            string
+		wantError       string
+		wantUnsupported string
+		wantWarning     string
+	}{
+		{name: "missing id", rule: "title: t\ndetection: {s: {User: a}, condition: s}", wantError: "id"},
+		{name: "missing detection", rule: "title: t\nid: x", wantError: "detection"},
+		{name: "missing condition", rule: "title: t\nid: x\ndetection: {s: {User: a}}", wantError: "detection.condition"},
+		{name: "undefined selection", rule: "title: t\nid: x\ndetection: {s: {User: a}, condition: s and other}", wantError: `"other"`},
+		{name: "unbalanced parentheses", rule: "title: t\nid: x\ndetection: {s: {User: a}, condition: (s}", wantError: "括号"},
+		{name: "invalid regex", rule: "title: t\nid: x\ndetection: {s: {User|re: '('}, condition: s}", wantError: "detection.s.User|re"},
+		{name: "aggregation", rule: "title: t\nid: x\ndetection: {s: {User: a}, condition: s | count() > 5}", wantUnsupported: "聚合"},
+		{name: "timeframe", rule: "title: t\nid: x\ndetection: {s: {User: a}, timeframe: 5m, condition: s}", wantUnsupported: "timeframe"},
+		{name: "unknown modifier", rule: "title: t\nid: x\ndetection: {s: {User|base64offset: a}, condition: s}", wantUnsupported: "base64offset"},
+		{name: "rule collection", rule: "action: global\ntitle: t", wantUnsupported: "action"},
+		{name: "unmapped log source", rule: "title: t\nid: x\nlogsource: {product: windows}\ndetection: {s: {User: a}, condition: s}", wantWarning: "logsource"},
+		{name: "unknown level", rule: "title: t\nid: x\nlevel: severe\ndetection: {s: {User: a}, condition: s}", wantWarning: "level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, report := compileSigma([]byte(tt.rule), DefaultSigmaConfig())
+			if (compiled == nil) != (tt.wantError != "" || tt.wantUnsupported != "") {
+				t.Fatalf("compiled = %v, report %+v", compiled != nil, report)
+			}
+			if !strings.Contains(report.Error, tt.wantError) {
+				t.Errorf("error = %q, want %q", report.Error, tt.wantError)
+			}
+			if got := strings.Join(report.Unsupported, "; "); !strings.Contains(got, tt.wantUnsupported) {
+				t.Errorf("unsupported = %q, want %q", got, tt.wantUnsupported)
+			}
+			if got := strings.Join(report.Warnings, "; "); !strings.Contains(got, tt.wantWarning) {
+				t.Errorf("warnings = %q, want %q", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestAttackTags(t *testing.T) {
+	tactics, techniques := attackTags([]string{"attack.credential_access", "attack.t1110.001", "attack.G0016", "attack.s0002", "cve.2021.44228"})
+	if fmt.Sprint(tactics) != "[credential_access]" || fmt.Sprint(techniques) != "[T1110.001]" {
+		t.Errorf("tactics %v, techniques %v", tactics, techniques)
+	}
+}
+
+// memoryRuleStore 保存规则定义的内存存储
+type memoryRuleStore struct {
+	repository.RuleStore
+	rules map[string]*repository.RuleDefinition
+}
+
+func (s *memoryRuleStore) SaveRule(ctx context.Context, rule *repository.RuleDefinition) error {
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+func TestImportSigmaRules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ssh.yml": `title: SSH brute force
+id: ssh-brute-force
+level: high
+tags: [attack.credential_access, attack.t1110]
+logsource: {product: linux, service: sshd}
+detection:
+  keywords: ['failed password']
+  condition: keywords
+---
+title: Many failures
+id: many-failures
+detection:
+  keywords: ['failed password']
+  condition: keywords | count() > 10
+`,
+		"nested/old.yaml": `title: Old rule
+id: old-rule
+status: deprecated
+detection: {s: {User: root}, condition: s}
+`,
+		"readme.md": "not a rule",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &memoryRuleStore{rules: make(map[string]*repository.RuleDefinition)}
+	engine := NewEngine()
+	manager := NewRuleManager(store, engine)
+	report, err := manager.ImportSigmaRules(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Imported != 2 || report.Skipped != 1 {
+		t.Errorf("imported %d, skipped %d: %+v", report.Imported, report.Skipped, report.Rules)
+	}
+	if _, ok := store.rules["many-failures"]; ok {
+		t.Error("unsupported rule saved")
+	}
+	def := store.rules["ssh-brute-force"]
+	if def == nil || def.Severity != "high" || def.Status != "active" || def.Config.Type != "sigma" {
+		t.Fatalf("saved %+v", def)
+	}
+	if _, ok := engine.GetRuleByID("old-rule"); ok {
+		t.Error("deprecated rule loaded into the engine")
+	}
+
+	// 保存的规则定义可以重新编译
+	rule, err := manager.ConvertToEngineRule(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Evaluate(context.Background(), newSigmaEvent("root", "app=sshd")) {
+		t.Error("imported rule did not match")
+	}
+}
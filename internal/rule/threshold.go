@@ -0,0 +1,416 @@
+package rule
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+// 聚合函数
+const (
+	AggregateCount         = "count"
+	AggregateDistinctCount = "distinct_count"
+	AggregateSum           = "sum"
+)
+
+// 窗口类型
+const (
+	WindowSliding  = "sliding"
+	WindowTumbling = "tumbling"
+)
+
+// 默认内存上限
+const (
+	defaultMaxGroups         = 10000
+	defaultMaxEventsPerGroup = 1000
+)
+
+// StatefulRule 跨事件保存状态的规则。引擎通过 Observe 评估这类规则，以获得参与触发的事件
+type StatefulRule interface {
+	Rule
+	// Observe 记录事件，返回因该事件触发的匹配结果，未触发时返回 nil
+	Observe(ctx context.Context, event *entity.SecurityEvent) []RuleMatch
+}
+
+// BufferedRule 缓存事件、延迟触发的有状态规则。引擎定期调用 Advance，停止时调用 Flush
+type BufferedRule interface {
+	StatefulRule
+	// Advance 处理已等待足够久的缓存事件，返回因此完成的匹配结果
+	Advance(now time.Time) []RuleMatch
+	// Flush 立即处理所有缓存的事件
+	Flush() []RuleMatch
+}
+
+// RuleMatch 有状态规则的触发结果。规则的状态按租户隔离，参与触发的事件属于同一租户
+type RuleMatch struct {
+	TenantID    string    `json:"tenant_id"`
+	GroupKey    string    `json:"group_key"` // 例如 user=alice
+	Value       float64   `json:"value"`     // 触发时的聚合值，序列规则为步骤数
+	EventIDs    []string  `json:"event_ids"` // 参与触发的事件
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+}
+
+// ThresholdConfig 阈值规则配置
+type ThresholdConfig struct {
+	GroupBy   []string      // 分组字段，为空时所有事件为一组
+	Function  string        // count, distinct_count, sum
+	Field     string        // distinct_count 和 sum 的字段
+	Operator  string        // gt, gte (默认)
+	Threshold float64       // 阈值
+	Window    time.Duration // 窗口长度
+	// WindowType sliding: 统计最近 Window 内的事件，触发后重新计数;
+	// tumbling: 按固定窗口统计，每个窗口每组最多触发一次
+	WindowType string
+	// MaxGroups 同时跟踪的分组上限，超出时淘汰最久未更新的分组
+	MaxGroups int
+	// MaxEventsPerGroup 每组保留的事件上限，超出时丢弃最早的事件
+	MaxEventsPerGroup int
+}
+
+// ThresholdStats 阈值规则的状态统计
+type ThresholdStats struct {
+	Groups        int   `json:"groups"`
+	Events        int   `json:"events"`
+	EvictedGroups int64 `json:"evicted_groups"` // 因分组上限被淘汰的分组
+	DroppedEvents int64 `json:"dropped_events"` // 因事件上限被丢弃的事件
+	LateEvents    int64 `json:"late_events"`    // 早于窗口而被忽略的事件
+	Fired         int64 `json:"fired"`
+}
+
+// ThresholdRule 阈值规则，按分组在时间窗口内聚合满足条件的事件，例如
+// "每个用户 5 分钟内登录失败超过 20 次"。窗口按事件时间计算
+type ThresholdRule struct {
+	metadata   RuleMetadata
+	conditions []Condition
+	config     ThresholdConfig
+
+	mutex  sync.Mutex
+	groups map[string]*thresholdGroup
+	lru    *list.List // 最近更新的分组在前
+	stats  ThresholdStats
+}
+
+var _ StatefulRule = (*ThresholdRule)(nil)
+
+type thresholdEntry struct {
+	at    time.Time
+	id    string
+	value float64 // sum 的值
+	key   string  // distinct_count 的值
+}
+
+type thresholdGroup struct {
+	key         string
+	tenant      string
+	label       string
+	entries     []thresholdEntry // 按时间排序
+	sum         float64
+	distinct    map[string]int
+	windowStart time.Time // tumbling 窗口的起始时间
+	fired       bool      // tumbling 窗口内已触发
+	element     *list.Element
+}
+
+// NewThresholdRule 创建阈值规则，conditions 全部满足的事件参与聚合
+func NewThresholdRule(metadata RuleMetadata, conditions []Condition, config ThresholdConfig) (*ThresholdRule, error) {
+	switch config.Function {
+	case AggregateCount:
+	case AggregateDistinctCount, AggregateSum:
+		if config.Field == "" {
+			return nil, fmt.Errorf("聚合函数 %s 需要字段", config.Function)
+		}
+	default:
+		return nil, fmt.Errorf("未知的聚合函数 %q", config.Function)
+	}
+	switch config.Operator {
+	case "":
+		config.Operator = "gte"
+	case "gt", "gte":
+	default:
+		return nil, fmt.Errorf("未知的阈值操作符 %q", config.Operator)
+	}
+	switch config.WindowType {
+	case "":
+		config.WindowType = WindowSliding
+	case WindowSliding, WindowTumbling:
+	default:
+		return nil, fmt.Errorf("未知的窗口类型 %q", config.WindowType)
+	}
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("窗口长度必须大于0")
+	}
+	if config.Threshold <= 0 {
+		return nil, fmt.Errorf("阈值必须大于0")
+	}
+	if config.MaxGroups <= 0 {
+		config.MaxGroups = defaultMaxGroups
+	}
+	if config.MaxEventsPerGroup <= 0 {
+		config.MaxEventsPerGroup = defaultMaxEventsPerGroup
+	}
+	// 计数规则保留的事件不足时永远不会触发
+	if config.Function == AggregateCount && float64(config.MaxEventsPerGroup) < config.Threshold+1 {
+		return nil, fmt.Errorf("每组事件上限 %d 小于阈值 %v", config.MaxEventsPerGroup, config.Threshold)
+	}
+
+	return &ThresholdRule{
+		metadata:   metadata,
+		conditions: conditions,
+		config:     config,
+		groups:     make(map[string]*thresholdGroup),
+		lru:        list.New(),
+	}, nil
+}
+
+func (r *ThresholdRule) GetMetadata() RuleMetadata {
+	return r.metadata
+}
+
+// Evaluate 记录事件并返回规则是否触发
+func (r *ThresholdRule) Evaluate(ctx context.Context, event *entity.SecurityEvent) bool {
+	return len(r.Observe(ctx, event)) > 0
+}
+
+// Stats 返回当前状态统计
+func (r *ThresholdRule) Stats() ThresholdStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := r.stats
+	stats.Groups = len(r.groups)
+	for _, g := range r.groups {
+		stats.Events += len(g.entries)
+	}
+	return stats
+}
+
+// Observe 将满足条件的事件计入所属分组，聚合值达到阈值时触发
+func (r *ThresholdRule) Observe(ctx context.Context, event *entity.SecurityEvent) []RuleMatch {
+	for _, condition := range r.conditions {
+		if !condition.Evaluate(event) {
+			return nil
+		}
+	}
+
+	entry := thresholdEntry{at: event.Timestamp, id: event.ID}
+	if entry.at.IsZero() {
+		entry.at = time.Now()
+	}
+	switch r.config.Function {
+	case AggregateDistinctCount:
+		if entry.key = fieldString(event, r.config.Field); entry.key == "" {
+			return nil
+		}
+	case AggregateSum:
+		value, ok := toFloat(getFieldValue(event, r.config.Field))
+		if !ok {
+			return nil
+		}
+		entry.value = value
+	}
+	key, label := r.groupKey(event)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	g := r.group(key, label)
+	g.tenant = tenant.Normalize(event.TenantID)
+	if !r.advance(g, entry.at) {
+		r.stats.LateEvents++
+		r.expire(entry.at)
+		return nil
+	}
+	r.add(g, entry)
+
+	value := r.aggregate(g)
+	fired := (r.config.Operator == "gt" && value > r.config.Threshold) ||
+		(r.config.Operator == "gte" && value >= r.config.Threshold)
+	if !fired || g.fired {
+		r.expire(entry.at)
+		return nil
+	}
+
+	match := RuleMatch{
+		TenantID: g.tenant,
+		GroupKey: g.label,
+		Value:    value,
+		EventIDs: make([]string, 0, len(g.entries)),
+	}
+	// 去重折叠的重复事件共用一个事件ID
+	seen := make(map[string]bool, len(g.entries))
+	for _, e := range g.entries {
+		if !seen[e.id] {
+			seen[e.id] = true
+			match.EventIDs = append(match.EventIDs, e.id)
+		}
+	}
+	if r.config.WindowType == WindowTumbling {
+		match.WindowStart = g.windowStart
+		match.WindowEnd = g.windowStart.Add(r.config.Window)
+		g.fired = true
+	} else {
+		match.WindowStart = g.entries[0].at
+		match.WindowEnd = g.entries[len(g.entries)-1].at
+		r.remove(g)
+	}
+	r.stats.Fired++
+	r.expire(entry.at)
+	return []RuleMatch{match}
+}
+
+// groupKey 返回分组键和可读的分组描述。不同租户的事件不会进入同一分组
+func (r *ThresholdRule) groupKey(event *entity.SecurityEvent) (string, string) {
+	values := make([]string, len(r.config.GroupBy))
+	labels := make([]string, len(r.config.GroupBy))
+	for i, field := range r.config.GroupBy {
+		values[i] = fieldString(event, field)
+		labels[i] = field + "=" + values[i]
+	}
+	return tenantScope(event) + strings.Join(values, "\x1f"), strings.Join(labels, ",")
+}
+
+// tenantScope 返回状态键的租户前缀
+func tenantScope(event *entity.SecurityEvent) string {
+	return tenant.Normalize(event.TenantID) + "\x1e"
+}
+
+// group 返回分组并标记为最近更新，分组数超过上限时淘汰最久未更新的分组
+func (r *ThresholdRule) group(key, label string) *thresholdGroup {
+	if g, ok := r.groups[key]; ok {
+		r.lru.MoveToFront(g.element)
+		return g
+	}
+	g := &thresholdGroup{key: key, label: label}
+	if r.config.Function == AggregateDistinctCount {
+		g.distinct = make(map[string]int)
+	}
+	g.element = r.lru.PushFront(g)
+	r.groups[key] = g
+
+	for len(r.groups) > r.config.MaxGroups {
+		r.remove(r.lru.Back().Value.(*thresholdGroup))
+		r.stats.EvictedGroups++
+	}
+	return g
+}
+
+func (r *ThresholdRule) remove(g *thresholdGroup) {
+	r.lru.Remove(g.element)
+	delete(r.groups, g.key)
+}
+
+// advance 将分组的窗口推进到事件时间，事件早于窗口时返回 false
+func (r *ThresholdRule) advance(g *thresholdGroup, at time.Time) bool {
+	if r.config.WindowType == WindowTumbling {
+		start := at.Truncate(r.config.Window)
+		switch {
+		case g.windowStart.IsZero() || start.After(g.windowStart):
+			g.reset()
+			g.windowStart = start
+		case start.Before(g.windowStart):
+			return false
+		}
+		return true
+	}
+
+	newest := at
+	if n := len(g.entries); n > 0 && g.entries[n-1].at.After(newest) {
+		newest = g.entries[n-1].at
+	}
+	cutoff := newest.Add(-r.config.Window)
+	if !at.After(cutoff) {
+		return false
+	}
+	g.prune(cutoff)
+	return true
+}
+
+// add 按时间顺序加入事件，超出每组上限时丢弃最早的事件
+func (r *ThresholdRule) add(g *thresholdGroup, entry thresholdEntry) {
+	i := sort.Search(len(g.entries), func(i int) bool { return g.entries[i].at.After(entry.at) })
+	g.entries = append(g.entries, thresholdEntry{})
+	copy(g.entries[i+1:], g.entries[i:])
+	g.entries[i] = entry
+	g.sum += entry.value
+	if g.distinct != nil {
+		g.distinct[entry.key]++
+	}
+
+	for len(g.entries) > r.config.MaxEventsPerGroup {
+		g.drop(1)
+		r.stats.DroppedEvents++
+	}
+}
+
+func (r *ThresholdRule) aggregate(g *thresholdGroup) float64 {
+	switch r.config.Function {
+	case AggregateDistinctCount:
+		return float64(len(g.distinct))
+	case AggregateSum:
+		return g.sum
+	}
+	return float64(len(g.entries))
+}
+
+// expire 从最久未更新的分组开始清理已过期的分组。每次最多检查少量分组，分摊清理开销
+func (r *ThresholdRule) expire(now time.Time) {
+	for i := 0; i < 8; i++ {
+		back := r.lru.Back()
+		if back == nil {
+			return
+		}
+		g := back.Value.(*thresholdGroup)
+		var end time.Time
+		if r.config.WindowType == WindowTumbling {
+			end = g.windowStart.Add(r.config.Window)
+		} else if n := len(g.entries); n > 0 {
+			end = g.entries[n-1].at.Add(r.config.Window)
+		}
+		if end.After(now) {
+			return
+		}
+		r.remove(g)
+	}
+}
+
+// prune 移除不晚于 cutoff 的事件
+func (g *thresholdGroup) prune(cutoff time.Time) {
+	n := sort.Search(len(g.entries), func(i int) bool { return g.entries[i].at.After(cutoff) })
+	g.drop(n)
+}
+
+// drop 移除最早的 n 个事件
+func (g *thresholdGroup) drop(n int) {
+	for _, e := range g.entries[:n] {
+		g.sum -= e.value
+		if g.distinct != nil {
+			if g.distinct[e.key]--; g.distinct[e.key] == 0 {
+				delete(g.distinct, e.key)
+			}
+		}
+	}
+	g.entries = append(g.entries[:0], g.entries[n:]...)
+}
+
+func (g *thresholdGroup) reset() {
+	g.drop(len(g.entries))
+	g.sum = 0
+	g.fired = false
+}
+
+// fieldString 返回字段的字符串值，字段不存在时为空
+func fieldString(event *entity.SecurityEvent, field string) string {
+	value := getFieldValue(event, field)
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
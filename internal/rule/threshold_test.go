@@ -0,0 +1,226 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// thresholdStep 依次送入阈值规则的事件，at 为相对基准时间的分钟数
+type thresholdStep struct {
+	user string
+	at   int
+	port int
+}
+
+func TestThresholdRule(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config ThresholdConfig
+		steps  []thresholdStep
+		// wantFired 触发规则的步骤下标，wantValues 对应的聚合值
+		wantFired  []int
+		wantValues []float64
+		wantGroup  string
+	}{
+		{
+			name:       "count per group",
+			config:     ThresholdConfig{GroupBy: []string{"user"}, Function: AggregateCount, Threshold: 3, Window: 10 * time.Minute},
+			steps:      []thresholdStep{{"alice", 0, 0}, {"bob", 1, 0}, {"alice", 2, 0}, {"bob", 3, 0}, {"alice", 4, 0}},
+			wantFired:  []int{4},
+			wantValues: []float64{3},
+			wantGroup:  "user=alice",
+		},
+		{
+			name:      "greater than",
+			config:    ThresholdConfig{Function: AggregateCount, Operator: "gt", Threshold: 2, Window: 10 * time.Minute},
+			steps:     []thresholdStep{{"alice", 0, 0}, {"bob", 1, 0}, {"carol", 2, 0}},
+			wantFired: []int{2},
+		},
+		{
+			name:      "events slide out of the window",
+			config:    ThresholdConfig{GroupBy: []string{"user"}, Function: AggregateCount, Threshold: 3, Window: 10 * time.Minute},
+			steps:     []thresholdStep{{"alice", 0, 0}, {"alice", 5, 0}, {"alice", 10, 0}, {"alice", 14, 0}},
+			wantFired: []int{3},
+		},
+		{
+			name:      "sliding windows restart after firing",
+			config:    ThresholdConfig{Function: AggregateCount, Threshold: 2, Window: time.Hour},
+			steps:     []thresholdStep{{"a", 0, 0}, {"a", 1, 0}, {"a", 2, 0}, {"a", 3, 0}, {"a", 4, 0}},
+			wantFired: []int{1, 3},
+		},
+		{
+			name:      "tumbling windows fire once each",
+			config:    ThresholdConfig{Function: AggregateCount, Threshold: 2, Window: 10 * time.Minute, WindowType: WindowTumbling},
+			steps:     []thresholdStep{{"a", 0, 0}, {"a", 1, 0}, {"a", 2, 0}, {"a", 9, 0}, {"a", 10, 0}, {"a", 11, 0}},
+			wantFired: []int{1, 5},
+		},
+		{
+			name:       "distinct count",
+			config:     ThresholdConfig{GroupBy: []string{"source_ip"}, Function: AggregateDistinctCount, Field: "port", Threshold: 3, Window: time.Minute},
+			steps:      []thresholdStep{{"a", 0, 22}, {"a", 0, 22}, {"a", 0, 80}, {"a", 0, 80}, {"a", 0, 443}},
+			wantFired:  []int{4},
+			wantValues: []float64{3},
+			wantGroup:  "source_ip=10.0.0.1",
+		},
+		{
+			name:       "sum",
+			config:     ThresholdConfig{Function: AggregateSum, Field: "port", Threshold: 1000, Window: time.Hour},
+			steps:      []thresholdStep{{"a", 0, 400}, {"a", 1, 400}, {"a", 2, 400}},
+			wantFired:  []int{2},
+			wantValues: []float64{1200},
+		},
+		{
+			name:      "late events are ignored",
+			config:    ThresholdConfig{Function: AggregateCount, Threshold: 2, Window: 10 * time.Minute},
+			steps:     []thresholdStep{{"a", 30, 0}, {"a", 5, 0}, {"a", 31, 0}},
+			wantFired: []int{2},
+		},
+		{
+			name:      "out of order events within the window count",
+			config:    ThresholdConfig{Function: AggregateCount, Threshold: 3, Window: 10 * time.Minute},
+			steps:     []thresholdStep{{"a", 30, 0}, {"a", 25, 0}, {"a", 28, 0}},
+			wantFired: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewThresholdRule(RuleMetadata{ID: "threshold"}, nil, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fired []int
+			var matches []RuleMatch
+			for i, step := range tt.steps {
+				event := newTestEvent("login", step.user, base.Add(time.Duration(step.at)*time.Minute))
+				event.Port = step.port
+				if got := rule.Observe(context.Background(), event); len(got) > 0 {
+					fired = append(fired, i)
+					matches = append(matches, got...)
+				}
+			}
+
+			if fmt.Sprint(fired) != fmt.Sprint(tt.wantFired) {
+				t.Fatalf("fired at %v, want %v", fired, tt.wantFired)
+			}
+			for i, want := range tt.wantValues {
+				if matches[i].Value != want {
+					t.Errorf("match %d value = %v, want %v", i, matches[i].Value, want)
+				}
+			}
+			if tt.wantGroup != "" && matches[0].GroupKey != tt.wantGroup {
+				t.Errorf("group = %q, want %q", matches[0].GroupKey, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestThresholdRuleMatchDetails(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 7, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		window    string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{name: "sliding window spans the events", window: WindowSliding, wantStart: base, wantEnd: base.Add(2 * time.Minute)},
+		{name: "tumbling window is aligned", window: WindowTumbling, wantStart: base.Truncate(10 * time.Minute), wantEnd: base.Truncate(10 * time.Minute).Add(10 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewThresholdRule(RuleMetadata{ID: "threshold"},
+				[]Condition{NewFieldCondition("event_type", "eq", "login")},
+				ThresholdConfig{Function: AggregateCount, Threshold: 3, Window: 10 * time.Minute, WindowType: tt.window})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first := newTestEvent("login", "alice", base)
+			events := []*entity.SecurityEvent{first, newTestEvent("logout", "alice", base.Add(time.Minute)), first}
+			last := newTestEvent("login", "alice", base.Add(2*time.Minute))
+			events = append(events, last)
+
+			var matches []RuleMatch
+			for _, event := range events {
+				matches = append(matches, rule.Observe(context.Background(), event)...)
+			}
+			if len(matches) != 1 {
+				t.Fatalf("matches = %+v", matches)
+			}
+			match := matches[0]
+			// 重复事件只列出一次，不满足条件的事件不计入
+			if fmt.Sprint(match.EventIDs) != fmt.Sprint([]string{first.ID, last.ID}) || match.Value != 3 {
+				t.Errorf("event ids %v, value %v", match.EventIDs, match.Value)
+			}
+			if !match.WindowStart.Equal(tt.wantStart) || !match.WindowEnd.Equal(tt.wantEnd) {
+				t.Errorf("window %v - %v, want %v - %v", match.WindowStart, match.WindowEnd, tt.wantStart, tt.wantEnd)
+			}
+			if match.TenantID != "default" {
+				t.Errorf("tenant = %q", match.TenantID)
+			}
+		})
+	}
+}
+
+func TestThresholdRuleLimits(t *testing.T) {
+	base := time.Now()
+	rule, err := NewThresholdRule(RuleMetadata{ID: "threshold"}, nil, ThresholdConfig{
+		GroupBy: []string{"user"}, Function: AggregateDistinctCount, Field: "port", Threshold: 100, Window: time.Hour,
+		MaxGroups: 2, MaxEventsPerGroup: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, user := range []string{"alice", "bob", "carol"} {
+		event := newTestEvent("login", user, base.Add(time.Duration(i)*time.Second))
+		rule.Observe(context.Background(), event)
+	}
+	for i := 0; i < 5; i++ {
+		event := newTestEvent("login", "carol", base.Add(time.Minute+time.Duration(i)*time.Second))
+		event.Port = i
+		rule.Observe(context.Background(), event)
+	}
+	rule.Observe(context.Background(), newTestEvent("login", "dave", base.Add(2*time.Minute)))
+	rule.Observe(context.Background(), newTestEvent("login", "carol", base.Add(-2*time.Hour)))
+
+	stats := rule.Stats()
+	// alice 被 carol 淘汰, bob 被 dave 淘汰, carol 最后的事件早于窗口
+	if stats.Groups != 2 || stats.EvictedGroups != 2 || stats.Events != 4 || stats.DroppedEvents != 3 || stats.LateEvents != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestNewThresholdRuleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ThresholdConfig
+		want   string
+	}{
+		{name: "unknown function", config: ThresholdConfig{Function: "avg", Threshold: 1, Window: time.Minute}, want: "聚合函数"},
+		{name: "sum without field", config: ThresholdConfig{Function: AggregateSum, Threshold: 1, Window: time.Minute}, want: "需要字段"},
+		{name: "unknown operator", config: ThresholdConfig{Function: AggregateCount, Operator: "lt", Threshold: 1, Window: time.Minute}, want: "操作符"},
+		{name: "unknown window type", config: ThresholdConfig{Function: AggregateCount, WindowType: "session", Threshold: 1, Window: time.Minute}, want: "窗口类型"},
+		{name: "no window", config: ThresholdConfig{Function: AggregateCount, Threshold: 1}, want: "窗口长度"},
+		{name: "no threshold", config: ThresholdConfig{Function: AggregateCount, Window: time.Minute}, want: "阈值必须"},
+		{name: "unreachable threshold", config: ThresholdConfig{Function: AggregateCount, Threshold: 10, Window: time.Minute, MaxEventsPerGroup: 5}, want: "每组事件上限"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewThresholdRule(RuleMetadata{ID: "threshold"}, nil, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
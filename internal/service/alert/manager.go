@@ -2,10 +2,13 @@ package alert
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/rule"
 )
 
 // AlertManager 管理安全告警的生成和分发
@@ -18,6 +21,7 @@ type AlertManager struct {
 // AlertRule 定义告警规则
 type AlertRule struct {
 	ID          string
+	TenantID    string // 为空时适用于所有租户
 	Name        string
 	Description string
 	Severity    string
@@ -34,7 +38,9 @@ type Notifier interface {
 // Alert 表示一个安全告警
 type Alert struct {
 	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
 	EventID     string    `json:"event_id"`
+	EventIDs    []string  `json:"event_ids,omitempty"` // 有状态规则告警关联的全部事件
 	RuleID      string    `json:"rule_id"`
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
@@ -61,15 +67,20 @@ func (m *AlertManager) AddRule(rule AlertRule) {
 	m.rules = append(m.rules, rule)
 }
 
-// ProcessEvent 处理安全事件并生成告警
+// ProcessEvent 处理安全事件并生成告警，告警归属于事件所属租户
 func (m *AlertManager) ProcessEvent(ctx context.Context, event *entity.SecurityEvent) error {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
+	eventTenant := tenant.Normalize(event.TenantID)
 	for _, rule := range m.rules {
+		if rule.TenantID != "" && rule.TenantID != eventTenant {
+			continue
+		}
 		if rule.Condition(event) {
 			alert := &Alert{
 				ID:          generateID(),
+				TenantID:    eventTenant,
 				EventID:     event.ID,
 				RuleID:      rule.ID,
 				Title:       rule.Name,
@@ -80,18 +91,56 @@ func (m *AlertManager) ProcessEvent(ctx context.Context, event *entity.SecurityE
 			}
 
 			// 发送告警通知
-			for _, notifier := range m.notifiers {
-				if err := notifier.Send(ctx, alert); err != nil {
-					// 记录错误但继续处理其他通知器
-					continue
-				}
-			}
+			m.notify(ctx, alert)
 		}
 	}
 
 	return nil
 }
 
+// ProcessRuleResult 为阈值、序列等有状态规则的触发生成独立告警，告警关联参与触发的全部事件。
+// 可作为规则引擎的 MatchHandler
+func (m *AlertManager) ProcessRuleResult(ctx context.Context, result rule.RuleResult) {
+	alert := &Alert{
+		ID:          generateID(),
+		TenantID:    tenant.Normalize(result.TenantID),
+		EventIDs:    result.EventIDs,
+		RuleID:      result.RuleID,
+		Title:       result.RuleName,
+		Description: ruleResultDescription(result),
+		Severity:    result.Severity,
+		CreatedAt:   time.Now(),
+		Status:      "new",
+	}
+	if len(result.EventIDs) > 0 {
+		alert.EventID = result.EventIDs[len(result.EventIDs)-1]
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	m.notify(ctx, alert)
+}
+
+// ruleResultDescription 描述触发的分组、聚合值和时间窗口
+func ruleResultDescription(result rule.RuleResult) string {
+	description := fmt.Sprintf("%s 触发，值 %v，关联 %d 个事件", result.GroupKey, result.Value, len(result.EventIDs))
+	if result.WindowStart != nil && result.WindowEnd != nil {
+		description += fmt.Sprintf("，时间 %s 至 %s",
+			result.WindowStart.Format(time.RFC3339), result.WindowEnd.Format(time.RFC3339))
+	}
+	return description
+}
+
+// notify 将告警发送给所有通知器，调用方需持有 m.mutex 读锁
+func (m *AlertManager) notify(ctx context.Context, alert *Alert) {
+	for _, notifier := range m.notifiers {
+		if err := notifier.Send(ctx, alert); err != nil {
+			// 记录错误但继续处理其他通知器
+			continue
+		}
+	}
+}
+
 // DefaultRules 返回默认的告警规则集
 func DefaultRules() []AlertRule {
 	return []AlertRule{
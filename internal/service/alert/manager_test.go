@@ -0,0 +1,66 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/rule"
+)
+
+// recordingNotifier keeps the alerts it was sent
+type recordingNotifier struct {
+	alerts []*Alert
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, alert *Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func TestProcessRuleResult(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(5 * time.Minute)
+
+	tests := []struct {
+		name        string
+		result      rule.RuleResult
+		wantTenant  string
+		wantEventID string
+	}{
+		{
+			name: "threshold match",
+			result: rule.RuleResult{
+				RuleID: "brute-force", RuleName: "brute force", Severity: "high", TenantID: "acme",
+				GroupKey: "user=alice", Value: 20, EventIDs: []string{"e1", "e2"},
+				WindowStart: &start, WindowEnd: &end,
+			},
+			wantTenant:  "acme",
+			wantEventID: "e2",
+		},
+		{
+			name:       "match without tenant or events",
+			result:     rule.RuleResult{RuleID: "sequence", Severity: "critical"},
+			wantTenant: "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier := &recordingNotifier{}
+			NewAlertManager([]Notifier{notifier}).ProcessRuleResult(context.Background(), tt.result)
+
+			if len(notifier.alerts) != 1 {
+				t.Fatalf("sent %d alerts, want 1", len(notifier.alerts))
+			}
+			alert := notifier.alerts[0]
+			if alert.TenantID != tt.wantTenant || alert.EventID != tt.wantEventID || alert.RuleID != tt.result.RuleID {
+				t.Errorf("alert = %+v", alert)
+			}
+			if fmt.Sprint(alert.EventIDs) != fmt.Sprint(tt.result.EventIDs) {
+				t.Errorf("event ids = %v, want %v", alert.EventIDs, tt.result.EventIDs)
+			}
+		})
+	}
+}
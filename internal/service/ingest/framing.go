@@ -0,0 +1,112 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// errFrameTooLarge is returned for messages exceeding the configured maximum size
+var errFrameTooLarge = errors.New("syslog frame exceeds maximum message size")
+
+// frameReader splits a syslog byte stream into messages. It supports both
+// RFC 6587 framings and decides per message: octet counting ("LEN MSG") when
+// the frame starts with a digit, and newline termination otherwise.
+type frameReader struct {
+	reader  *bufio.Reader
+	maxSize int
+}
+
+func newFrameReader(r io.Reader, maxSize int) *frameReader {
+	return &frameReader{
+		reader:  bufio.NewReaderSize(r, maxSize+16),
+		maxSize: maxSize,
+	}
+}
+
+// Next returns the next message. errFrameTooLarge is not fatal; the stream is
+// positioned at the following frame.
+func (f *frameReader) Next() (string, error) {
+	// Skip stray terminators between frames
+	for {
+		c, err := f.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c != '\n' && c != '\r' && c != ' ' && c != 0 {
+			if err := f.reader.UnreadByte(); err != nil {
+				return "", err
+			}
+			break
+		}
+	}
+
+	if f.octetCounted() {
+		return f.readOctetCounted()
+	}
+	return f.readLine()
+}
+
+// octetCounted peeks at the next frame to see whether it starts with "LEN ".
+// Newline framed messages may also start with a digit, e.g. an ISO timestamp.
+func (f *frameReader) octetCounted() bool {
+	for i := 1; i <= 11; i++ {
+		buf, err := f.reader.Peek(i)
+		if err != nil {
+			return false
+		}
+		c := buf[i-1]
+		if c == ' ' {
+			return i > 1
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return false
+}
+
+func (f *frameReader) readOctetCounted() (string, error) {
+	prefix, err := f.reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+	if err != nil {
+		return "", fmt.Errorf("invalid octet count: %q", prefix)
+	}
+
+	if length > f.maxSize {
+		if _, err := f.reader.Discard(length); err != nil {
+			return "", err
+		}
+		return "", errFrameTooLarge
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(f.reader, buf); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+func (f *frameReader) readLine() (string, error) {
+	line, err := f.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > f.maxSize+1 {
+		// Discard the remainder of the oversized line
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = f.reader.ReadSlice('\n')
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return "", errFrameTooLarge
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
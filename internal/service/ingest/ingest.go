@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// sinkAttempts is how often a batch is handed to the sink before the lines
+// are dead-lettered
+const sinkAttempts = 3
+
+// Sink receives batches of raw log lines from ingestion sources.
+// *log.LogProcessor satisfies this interface.
+type Sink interface {
+	BatchProcessLogs(ctx context.Context, logs []string) ([]*log.ProcessResult, error)
+}
+
+// DeadLetterSink is a Sink that can dead-letter lines of batches it failed to
+// process. *log.LogProcessor and *log.Pipeline implement it.
+type DeadLetterSink interface {
+	Sink
+	DeadLetterLogs(ctx context.Context, logs []string, cause error) error
+}
+
+// Stats holds ingestion counters. All fields are updated atomically.
+type Stats struct {
+	Received    int64 `json:"received"`
+	Processed   int64 `json:"processed"`
+	Failed      int64 `json:"failed"`
+	Dropped     int64 `json:"dropped"`
+	RateLimited int64 `json:"rate_limited"`
+	TooLarge    int64 `json:"too_large"`
+	Connections int64 `json:"connections"`
+	SinkErrors  int64 `json:"sink_errors"`
+	// DeadLettered counts lines of failed batches written to the dead letter
+	// store; the other failed batches are lost
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+// Snapshot returns a consistent copy of the counters
+func (s *Stats) Snapshot() Stats {
+	return Stats{
+		Received:    atomic.LoadInt64(&s.Received),
+		Processed:   atomic.LoadInt64(&s.Processed),
+		Failed:      atomic.LoadInt64(&s.Failed),
+		Dropped:     atomic.LoadInt64(&s.Dropped),
+		RateLimited: atomic.LoadInt64(&s.RateLimited),
+		TooLarge:    atomic.LoadInt64(&s.TooLarge),
+		Connections: atomic.LoadInt64(&s.Connections),
+		SinkErrors:  atomic.LoadInt64(&s.SinkErrors),
+
+		DeadLettered: atomic.LoadInt64(&s.DeadLettered),
+	}
+}
+
+// batcher is a bounded queue of raw lines drained in batches by a pool of workers
+type batcher struct {
+	sink          Sink
+	lines         chan string
+	batchSize     int
+	flushInterval time.Duration
+	workers       int
+	stats         *Stats
+	wg            sync.WaitGroup
+}
+
+func newBatcher(sink Sink, queueSize, batchSize, workers int, flushInterval time.Duration, stats *Stats) *batcher {
+	return &batcher{
+		sink:          sink,
+		lines:         make(chan string, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		workers:       workers,
+		stats:         stats,
+	}
+}
+
+// start launches the workers. They exit once the queue is closed and drained.
+func (b *batcher) start(ctx context.Context) {
+	for i := 0; i < b.workers; i++ {
+		b.wg.Add(1)
+		go b.run(ctx)
+	}
+}
+
+// offer enqueues a line without blocking, dropping it when the queue is full
+func (b *batcher) offer(line string) bool {
+	select {
+	case b.lines <- line:
+		return true
+	default:
+		atomic.AddInt64(&b.stats.Dropped, 1)
+		return false
+	}
+}
+
+// put enqueues a line, blocking while the queue is full so that slow
+// processing pushes back on stream senders
+func (b *batcher) put(ctx context.Context, line string) error {
+	select {
+	case b.lines <- line:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&b.stats.Dropped, 1)
+		return ctx.Err()
+	}
+}
+
+// close stops accepting lines and waits for the workers to drain the queue.
+// Callers must ensure no producer is still writing.
+func (b *batcher) close() {
+	close(b.lines)
+	b.wg.Wait()
+}
+
+func (b *batcher) run(ctx context.Context) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]string, 0, b.batchSize)
+	for {
+		select {
+		case line, ok := <-b.lines:
+			if !ok {
+				b.flush(ctx, batch)
+				return
+			}
+			batch = append(batch, line)
+			if len(batch) >= b.batchSize {
+				b.flush(ctx, batch)
+				batch = make([]string, 0, b.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				b.flush(ctx, batch)
+				batch = make([]string, 0, b.batchSize)
+			}
+		}
+	}
+}
+
+func (b *batcher) flush(ctx context.Context, batch []string) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// Drain with a detached context so queued lines are not lost on shutdown.
+	// Failures of the whole batch are retried a few times; the sink does not
+	// dead-letter them since the batch is expected to come back. After the
+	// last attempt the lines are dead-lettered here.
+	ctx = context.WithoutCancel(ctx)
+	var results []*log.ProcessResult
+	var err error
+	for attempt := 1; ; attempt++ {
+		results, err = b.sink.BatchProcessLogs(ctx, batch)
+		if err == nil {
+			break
+		}
+		atomic.AddInt64(&b.stats.SinkErrors, 1)
+		if attempt == sinkAttempts {
+			atomic.AddInt64(&b.stats.Failed, int64(len(batch)))
+			b.deadLetter(ctx, batch, err)
+			return
+		}
+		time.Sleep(time.Duration(attempt) * b.flushInterval)
+	}
+
+	failed := int64(0)
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	atomic.AddInt64(&b.stats.Failed, failed)
+	atomic.AddInt64(&b.stats.Processed, int64(len(batch))-failed)
+}
+
+// deadLetter stores the lines of a batch the sink kept failing on
+func (b *batcher) deadLetter(ctx context.Context, batch []string, cause error) {
+	sink, ok := b.sink.(DeadLetterSink)
+	if !ok {
+		return
+	}
+	if err := sink.DeadLetterLogs(ctx, batch, cause); err != nil {
+		atomic.AddInt64(&b.stats.SinkErrors, 1)
+		return
+	}
+	atomic.AddInt64(&b.stats.DeadLettered, int64(len(batch)))
+}
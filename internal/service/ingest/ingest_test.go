@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// downSink fails every batch and dead-letters lines into memory
+type downSink struct {
+	mutex     sync.Mutex
+	attempts  int
+	letters   []string
+	letterErr error
+}
+
+func (s *downSink) BatchProcessLogs(ctx context.Context, logs []string) ([]*log.ProcessResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attempts++
+	return nil, errors.New("repository down")
+}
+
+func (s *downSink) DeadLetterLogs(ctx context.Context, logs []string, cause error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.letterErr != nil {
+		return s.letterErr
+	}
+	s.letters = append(s.letters, logs...)
+	return nil
+}
+
+func TestBatcherDeadLettersFailedBatches(t *testing.T) {
+	tests := []struct {
+		name             string
+		letterErr        error
+		wantLetters      int
+		wantDeadLettered int64
+		wantSinkErrors   int64
+	}{
+		{name: "dead-lettered after the last attempt", wantLetters: 2, wantDeadLettered: 2, wantSinkErrors: sinkAttempts},
+		{name: "dead letter store unavailable", letterErr: errors.New("disk full"), wantSinkErrors: sinkAttempts + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &downSink{letterErr: tt.letterErr}
+			stats := &Stats{}
+			b := newBatcher(sink, 10, 10, 1, time.Millisecond, stats)
+			b.start(context.Background())
+			b.offer("line 1")
+			b.offer("line 2")
+			b.close()
+
+			if sink.attempts != sinkAttempts || len(sink.letters) != tt.wantLetters {
+				t.Errorf("attempts %d, dead letters %q", sink.attempts, sink.letters)
+			}
+			got := stats.Snapshot()
+			if got.Failed != 2 || got.DeadLettered != tt.wantDeadLettered || got.SinkErrors != tt.wantSinkErrors {
+				t.Errorf("stats = %+v", got)
+			}
+		})
+	}
+}
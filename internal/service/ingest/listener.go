@@ -0,0 +1,436 @@
+package ingest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+// ListenerConfig configures the syslog listener. Empty addresses disable the
+// corresponding transport.
+type ListenerConfig struct {
+	UDPAddr string `json:"udp_addr" yaml:"udp_addr"`
+	TCPAddr string `json:"tcp_addr" yaml:"tcp_addr"`
+	TLSAddr string `json:"tls_addr" yaml:"tls_addr"`
+
+	// TLS settings. ClientCAFile enables mutual TLS.
+	CertFile     string `json:"cert_file"      yaml:"cert_file"`
+	KeyFile      string `json:"key_file"       yaml:"key_file"`
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+
+	// Limits
+	MaxMessageSize int           `json:"max_message_size" yaml:"max_message_size"`
+	MaxConnections int           `json:"max_connections"  yaml:"max_connections"`
+	RateLimit      float64       `json:"rate_limit"       yaml:"rate_limit"` // messages per second per connection or UDP peer, 0 disables
+	RateBurst      int           `json:"rate_burst"       yaml:"rate_burst"`
+	IdleTimeout    time.Duration `json:"idle_timeout"     yaml:"idle_timeout"`
+
+	// Queueing towards the log processor
+	QueueSize     int           `json:"queue_size"     yaml:"queue_size"`
+	BatchSize     int           `json:"batch_size"     yaml:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval" yaml:"flush_interval"`
+	Workers       int           `json:"workers"        yaml:"workers"`
+
+	// Tenant owns all messages received by the listener. Empty uses the
+	// tenant of the start context.
+	Tenant string `json:"tenant" yaml:"tenant"`
+}
+
+// DefaultListenerConfig returns a listener configuration with the standard syslog ports
+func DefaultListenerConfig() ListenerConfig {
+	return ListenerConfig{
+		UDPAddr:        ":514",
+		TCPAddr:        ":601",
+		MaxMessageSize: 64 * 1024,
+		MaxConnections: 1024,
+		RateLimit:      0,
+		RateBurst:      1000,
+		IdleTimeout:    5 * time.Minute,
+		QueueSize:      10000,
+		BatchSize:      500,
+		FlushInterval:  time.Second,
+		Workers:        4,
+	}
+}
+
+// withDefaults fills zero values from the default configuration
+func (c ListenerConfig) withDefaults() ListenerConfig {
+	d := DefaultListenerConfig()
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = d.MaxMessageSize
+	}
+	if c.MaxConnections <= 0 {
+		c.MaxConnections = d.MaxConnections
+	}
+	if c.RateBurst <= 0 {
+		c.RateBurst = d.RateBurst
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = d.IdleTimeout
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = d.QueueSize
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = d.FlushInterval
+	}
+	if c.Workers <= 0 {
+		c.Workers = d.Workers
+	}
+	return c
+}
+
+// SyslogListener accepts syslog messages over UDP, TCP and TLS and feeds them
+// to a Sink in batches.
+//
+// UDP datagrams are dropped when the queue is full or the peer exceeds its
+// rate limit, since UDP senders cannot be slowed down. Stream connections are
+// throttled instead: reads pause until the queue has room and the connection
+// has tokens, which pushes back on the sender through TCP flow control.
+type SyslogListener struct {
+	config  ListenerConfig
+	batcher *batcher
+	stats   Stats
+
+	udpConn   net.PacketConn
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	peers     map[string]*tokenBucket
+	stopped   bool
+	mutex     sync.Mutex
+
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewSyslogListener creates a new syslog listener feeding the given sink
+func NewSyslogListener(config ListenerConfig, sink Sink) *SyslogListener {
+	config = config.withDefaults()
+	l := &SyslogListener{
+		config: config,
+		conns:  make(map[net.Conn]struct{}),
+		peers:  make(map[string]*tokenBucket),
+	}
+	l.batcher = newBatcher(sink, config.QueueSize, config.BatchSize, config.Workers, config.FlushInterval, &l.stats)
+	return l
+}
+
+// Start opens the configured sockets and begins accepting messages
+func (l *SyslogListener) Start(ctx context.Context) error {
+	if l.config.Tenant != "" {
+		if err := tenant.Validate(l.config.Tenant); err != nil {
+			return err
+		}
+		ctx = tenant.WithTenant(ctx, l.config.Tenant)
+	}
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.batcher.start(ctx)
+
+	if l.config.UDPAddr != "" {
+		conn, err := net.ListenPacket("udp", l.config.UDPAddr)
+		if err != nil {
+			l.Stop()
+			return fmt.Errorf("listen udp %s: %w", l.config.UDPAddr, err)
+		}
+		l.mutex.Lock()
+		l.udpConn = conn
+		l.mutex.Unlock()
+		l.wg.Add(2)
+		go l.serveUDP(ctx, conn)
+		go l.prunePeers(ctx)
+	}
+
+	if l.config.TCPAddr != "" {
+		listener, err := net.Listen("tcp", l.config.TCPAddr)
+		if err != nil {
+			l.Stop()
+			return fmt.Errorf("listen tcp %s: %w", l.config.TCPAddr, err)
+		}
+		l.serveStream(ctx, listener)
+	}
+
+	if l.config.TLSAddr != "" {
+		tlsConfig, err := l.tlsConfig()
+		if err != nil {
+			l.Stop()
+			return err
+		}
+		listener, err := tls.Listen("tcp", l.config.TLSAddr, tlsConfig)
+		if err != nil {
+			l.Stop()
+			return fmt.Errorf("listen tls %s: %w", l.config.TLSAddr, err)
+		}
+		l.serveStream(ctx, listener)
+	}
+
+	return nil
+}
+
+// Stop closes all sockets, waits for open connections to finish and drains
+// the queue into the sink
+func (l *SyslogListener) Stop() {
+	l.stopOnce.Do(func() {
+		if l.cancel != nil {
+			l.cancel()
+		}
+
+		l.mutex.Lock()
+		l.stopped = true
+		if l.udpConn != nil {
+			l.udpConn.Close()
+		}
+		for _, listener := range l.listeners {
+			listener.Close()
+		}
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mutex.Unlock()
+
+		l.wg.Wait()
+		l.batcher.close()
+	})
+}
+
+// Stats returns a snapshot of the listener counters
+func (l *SyslogListener) Stats() Stats {
+	return l.stats.Snapshot()
+}
+
+// Addrs returns the addresses the listener is bound to
+func (l *SyslogListener) Addrs() []net.Addr {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	addrs := make([]net.Addr, 0, len(l.listeners)+1)
+	if l.udpConn != nil {
+		addrs = append(addrs, l.udpConn.LocalAddr())
+	}
+	for _, listener := range l.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+func (l *SyslogListener) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(l.config.CertFile, l.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if l.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(l.config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", l.config.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+func (l *SyslogListener) serveUDP(ctx context.Context, conn net.PacketConn) {
+	defer l.wg.Done()
+
+	buf := make([]byte, l.config.MaxMessageSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		atomic.AddInt64(&l.stats.Received, 1)
+		if n > l.config.MaxMessageSize {
+			atomic.AddInt64(&l.stats.TooLarge, 1)
+			continue
+		}
+		if !l.peerBucket(addr).Allow() {
+			atomic.AddInt64(&l.stats.RateLimited, 1)
+			continue
+		}
+
+		// A datagram may carry several newline separated messages
+		for _, line := range strings.Split(strings.TrimRight(string(buf[:n]), "\r\n\x00"), "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				l.batcher.offer(line)
+			}
+		}
+	}
+}
+
+// peerBucket returns the rate limiter for a UDP peer, keyed by IP address
+func (l *SyslogListener) peerBucket(addr net.Addr) *tokenBucket {
+	host := addr.String()
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		host = udpAddr.IP.String()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	bucket, ok := l.peers[host]
+	if !ok {
+		bucket = newTokenBucket(l.config.RateLimit, l.config.RateBurst)
+		l.peers[host] = bucket
+	}
+	return bucket
+}
+
+// prunePeers forgets rate limiters of UDP peers that have gone quiet
+func (l *SyslogListener) prunePeers(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mutex.Lock()
+			for host, bucket := range l.peers {
+				if now.Sub(bucket.idleSince()) > l.config.IdleTimeout {
+					delete(l.peers, host)
+				}
+			}
+			l.mutex.Unlock()
+		}
+	}
+}
+
+func (l *SyslogListener) serveStream(ctx context.Context, listener net.Listener) {
+	l.mutex.Lock()
+	l.listeners = append(l.listeners, listener)
+	l.mutex.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+
+			if !l.track(conn) {
+				conn.Close()
+				continue
+			}
+
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				defer l.untrack(conn)
+				l.handleConn(ctx, conn)
+			}()
+		}
+	}()
+}
+
+// track registers an accepted connection, refusing it above MaxConnections
+// and once Stop has closed the tracked connections
+func (l *SyslogListener) track(conn net.Conn) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stopped {
+		return false
+	}
+	if len(l.conns) >= l.config.MaxConnections {
+		atomic.AddInt64(&l.stats.Dropped, 1)
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	atomic.AddInt64(&l.stats.Connections, 1)
+	return true
+}
+
+func (l *SyslogListener) untrack(conn net.Conn) {
+	conn.Close()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.conns[conn]; ok {
+		delete(l.conns, conn)
+		atomic.AddInt64(&l.stats.Connections, -1)
+	}
+}
+
+func (l *SyslogListener) handleConn(ctx context.Context, conn net.Conn) {
+	limiter := newTokenBucket(l.config.RateLimit, l.config.RateBurst)
+	frames := newFrameReader(&deadlineReader{conn: conn, timeout: l.config.IdleTimeout}, l.config.MaxMessageSize)
+
+	for {
+		line, err := frames.Next()
+		if errors.Is(err, errFrameTooLarge) {
+			atomic.AddInt64(&l.stats.Received, 1)
+			atomic.AddInt64(&l.stats.TooLarge, 1)
+			continue
+		}
+		if err != nil {
+			// EOF, idle timeout, framing error or shutdown
+			return
+		}
+		if line == "" {
+			continue
+		}
+		atomic.AddInt64(&l.stats.Received, 1)
+
+		waited, err := limiter.Wait(ctx)
+		if waited {
+			atomic.AddInt64(&l.stats.RateLimited, 1)
+		}
+		if err != nil {
+			return
+		}
+		if err := l.batcher.put(ctx, line); err != nil {
+			return
+		}
+	}
+}
+
+// deadlineReader refreshes the read deadline before every read so idle
+// connections are closed
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
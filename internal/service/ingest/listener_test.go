@@ -0,0 +1,108 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// recordingSink collects the lines it receives
+type recordingSink struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (s *recordingSink) BatchProcessLogs(ctx context.Context, logs []string) ([]*log.ProcessResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	results := make([]*log.ProcessResult, len(logs))
+	for i := range logs {
+		results[i] = &log.ProcessResult{Index: i}
+	}
+	s.lines = append(s.lines, logs...)
+	return results, nil
+}
+
+func (s *recordingSink) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lines := append([]string(nil), s.lines...)
+	sort.Strings(lines)
+	return lines
+}
+
+func startTCPListener(t *testing.T, sink Sink) (*SyslogListener, string) {
+	t.Helper()
+	listener := NewSyslogListener(ListenerConfig{
+		TCPAddr:       "127.0.0.1:0",
+		FlushInterval: 10 * time.Millisecond,
+		Workers:       1,
+	}, sink)
+	if err := listener.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return listener, listener.Addrs()[0].String()
+}
+
+func TestSyslogListenerTCP(t *testing.T) {
+	sink := &recordingSink{}
+	listener, addr := startTCPListener(t, sink)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(conn, "<34>Oct 11 22:14:15 host app: message %d\n", i)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	listener.Stop()
+
+	if got := sink.received(); len(got) != 3 {
+		t.Fatalf("got %d lines, want 3: %q", len(got), got)
+	}
+	if stats := listener.Stats(); stats.Received != 3 || stats.Connections != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestSyslogListenerStopClosesConnections(t *testing.T) {
+	listener, addr := startTCPListener(t, &recordingSink{})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		listener.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return with an open connection")
+	}
+
+	// Connections accepted while stopping are refused
+	server, client := net.Pipe()
+	defer client.Close()
+	if listener.track(server) {
+		t.Error("track accepted a connection after Stop")
+	}
+}
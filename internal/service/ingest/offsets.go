@@ -0,0 +1,200 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fingerprintSize is the number of leading bytes hashed to recognise a file
+// after it has been renamed or the service restarted
+const fingerprintSize = 1024
+
+// rotatedRetention is how long offsets of rotated files are remembered, so a
+// renamed file that also matches the tail patterns is not read twice
+const rotatedRetention = 24 * time.Hour
+
+// FileOffset is the persisted read position of a tailed file
+type FileOffset struct {
+	Path           string    `json:"path"`
+	Offset         int64     `json:"offset"`
+	Fingerprint    string    `json:"fingerprint"`
+	FingerprintLen int       `json:"fingerprint_len"`
+	Rotated        bool      `json:"rotated,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// key identifies the offset in the store. Rotated files no longer own their
+// path, so they are keyed by path and fingerprint.
+func (o *FileOffset) key() string {
+	if o.Rotated {
+		return o.Path + "#" + o.Fingerprint
+	}
+	return o.Path
+}
+
+// offsetStore keeps file offsets in memory and persists them to a JSON file
+type offsetStore struct {
+	path    string
+	offsets map[string]*FileOffset
+	mutex   sync.Mutex
+}
+
+func newOffsetStore(path string) *offsetStore {
+	return &offsetStore{
+		path:    path,
+		offsets: make(map[string]*FileOffset),
+	}
+}
+
+// load reads persisted offsets. A missing file is not an error.
+func (s *offsetStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var offsets []*FileOffset
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, offset := range offsets {
+		s.offsets[offset.key()] = offset
+	}
+	return nil
+}
+
+// save writes the offsets atomically via a temporary file
+func (s *offsetStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mutex.Lock()
+	offsets := make([]*FileOffset, 0, len(s.offsets))
+	for key, offset := range s.offsets {
+		if offset.Rotated && time.Since(offset.UpdatedAt) > rotatedRetention {
+			delete(s.offsets, key)
+			continue
+		}
+		copied := *offset
+		offsets = append(offsets, &copied)
+	}
+	s.mutex.Unlock()
+
+	data, err := json.MarshalIndent(offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *offsetStore) get(path string) (*FileOffset, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offset, ok := s.offsets[path]
+	if !ok {
+		return nil, false
+	}
+	copied := *offset
+	return &copied, true
+}
+
+// findByFingerprint looks for a saved offset of a file that has since been
+// renamed. The returned offset keeps its old key until it is removed.
+func (s *offsetStore) findByFingerprint(file *os.File) (*FileOffset, bool) {
+	s.mutex.Lock()
+	candidates := make([]FileOffset, 0, len(s.offsets))
+	for _, offset := range s.offsets {
+		candidates = append(candidates, *offset)
+	}
+	s.mutex.Unlock()
+
+	for _, candidate := range candidates {
+		if candidate.FingerprintLen == 0 {
+			continue
+		}
+		fp, n, err := fingerprint(file, candidate.FingerprintLen)
+		if err == nil && n == candidate.FingerprintLen && fp == candidate.Fingerprint {
+			return &candidate, true
+		}
+	}
+	return nil, false
+}
+
+func (s *offsetStore) set(offset FileOffset) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offset.UpdatedAt = time.Now()
+	s.offsets[offset.key()] = &offset
+}
+
+func (s *offsetStore) remove(offset *FileOffset) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.offsets, offset.key())
+}
+
+// retire marks the offset of a path as belonging to a rotated file
+func (s *offsetStore) retire(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offset, ok := s.offsets[path]
+	if !ok {
+		return
+	}
+	delete(s.offsets, path)
+	offset.Rotated = true
+	offset.UpdatedAt = time.Now()
+	s.offsets[offset.key()] = offset
+}
+
+func (s *offsetStore) list() []FileOffset {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offsets := make([]FileOffset, 0, len(s.offsets))
+	for _, offset := range s.offsets {
+		offsets = append(offsets, *offset)
+	}
+	return offsets
+}
+
+// fingerprint hashes up to size leading bytes of a file and returns the hash
+// together with the number of bytes hashed
+func fingerprint(file *os.File, size int) (string, int, error) {
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(buf[:n])
+	return hex.EncodeToString(sum[:]), n, nil
+}
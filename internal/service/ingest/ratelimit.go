@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token bucket rate limiter. A zero rate means unlimited.
+type tokenBucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	lastUsed time.Time
+	mutex    sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	return &tokenBucket{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     now,
+		lastUsed: now,
+	}
+}
+
+// reserve takes a token if one is available, otherwise it reports how long
+// the caller has to wait for the next one
+func (b *tokenBucket) reserve() (bool, time.Duration) {
+	if b.rate <= 0 {
+		return true, 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.lastUsed = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Allow reports whether an event may happen now
+func (b *tokenBucket) Allow() bool {
+	ok, _ := b.reserve()
+	return ok
+}
+
+// Wait blocks until an event may happen or the context is done. It returns
+// true if the caller had to wait.
+func (b *tokenBucket) Wait(ctx context.Context) (bool, error) {
+	waited := false
+	for {
+		ok, delay := b.reserve()
+		if ok {
+			return waited, nil
+		}
+		waited = true
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		}
+	}
+}
+
+// idleSince returns when the bucket was last used
+func (b *tokenBucket) idleSince() time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.lastUsed
+}
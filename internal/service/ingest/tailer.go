@@ -0,0 +1,469 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// TailerConfig configures file tailing
+type TailerConfig struct {
+	// Paths are files, directories or glob patterns. Directories are expanded
+	// to the regular files directly inside them.
+	Paths   []string `json:"paths"   yaml:"paths"`
+	Exclude []string `json:"exclude" yaml:"exclude"` // glob patterns matched against the file name
+
+	// OffsetsFile persists read positions across restarts. Empty disables persistence.
+	OffsetsFile string `json:"offsets_file" yaml:"offsets_file"`
+
+	// StartAtEnd makes files found at startup without a saved offset start at
+	// their end, like tail -F. Files appearing later are always read from the start.
+	StartAtEnd bool `json:"start_at_end" yaml:"start_at_end"`
+
+	PollInterval       time.Duration `json:"poll_interval"       yaml:"poll_interval"`
+	RescanInterval     time.Duration `json:"rescan_interval"     yaml:"rescan_interval"`
+	CheckpointInterval time.Duration `json:"checkpoint_interval" yaml:"checkpoint_interval"`
+	MaxLineSize        int           `json:"max_line_size"       yaml:"max_line_size"`
+	BatchSize          int           `json:"batch_size"          yaml:"batch_size"`
+
+	// Tenant owns all tailed lines. Empty uses the tenant of the start context.
+	Tenant string `json:"tenant" yaml:"tenant"`
+}
+
+// DefaultTailerConfig returns the default tailing configuration
+func DefaultTailerConfig() TailerConfig {
+	return TailerConfig{
+		OffsetsFile:        "data/tail_offsets.json",
+		PollInterval:       time.Second,
+		RescanInterval:     10 * time.Second,
+		CheckpointInterval: 5 * time.Second,
+		MaxLineSize:        64 * 1024,
+		BatchSize:          500,
+	}
+}
+
+func (c TailerConfig) withDefaults() TailerConfig {
+	d := DefaultTailerConfig()
+	if c.PollInterval <= 0 {
+		c.PollInterval = d.PollInterval
+	}
+	if c.RescanInterval <= 0 {
+		c.RescanInterval = d.RescanInterval
+	}
+	if c.CheckpointInterval <= 0 {
+		c.CheckpointInterval = d.CheckpointInterval
+	}
+	if c.MaxLineSize <= 0 {
+		c.MaxLineSize = d.MaxLineSize
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	return c
+}
+
+// tailedFile is an open file being followed
+type tailedFile struct {
+	path     string
+	file     *os.File
+	info     os.FileInfo
+	offset   int64 // end of the last line handed to the sink
+	read     int64 // position up to which the file has been read
+	pending  []byte
+	skipping bool // discarding the rest of an oversized line
+}
+
+// tailedLine is a complete line and the file offset just past it
+type tailedLine struct {
+	text string
+	end  int64
+}
+
+// FileTailer follows log files like tail -F and feeds complete lines to a
+// Sink. It polls instead of relying on filesystem notifications, which keeps
+// it working on network filesystems and in containers.
+//
+// Both logrotate strategies are handled: after a rename the old file is read
+// to the end before switching to the new one, and a copytruncate is detected
+// by the file shrinking below the current offset. Offsets only advance after
+// the sink accepted a batch, so lines are delivered at least once.
+type FileTailer struct {
+	config  TailerConfig
+	sink    Sink
+	offsets *offsetStore
+	files   map[string]*tailedFile
+	stats   Stats
+	mutex   sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewFileTailer creates a new file tailer feeding the given sink
+func NewFileTailer(config TailerConfig, sink Sink) *FileTailer {
+	config = config.withDefaults()
+	return &FileTailer{
+		config:  config,
+		sink:    sink,
+		offsets: newOffsetStore(config.OffsetsFile),
+		files:   make(map[string]*tailedFile),
+	}
+}
+
+// Start loads saved offsets and begins following the configured paths
+func (t *FileTailer) Start(ctx context.Context) error {
+	if t.config.Tenant != "" {
+		if err := tenant.Validate(t.config.Tenant); err != nil {
+			return err
+		}
+		ctx = tenant.WithTenant(ctx, t.config.Tenant)
+	}
+	if err := t.offsets.load(); err != nil {
+		return err
+	}
+
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.done = make(chan struct{})
+
+	t.scan(ctx, true)
+	go t.run(ctx)
+	return nil
+}
+
+// Stop stops following files and persists the current offsets
+func (t *FileTailer) Stop() error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	<-t.done
+
+	t.mutex.Lock()
+	for path, tf := range t.files {
+		tf.file.Close()
+		delete(t.files, path)
+	}
+	t.mutex.Unlock()
+
+	return t.offsets.save()
+}
+
+// Stats returns a snapshot of the tailer counters
+func (t *FileTailer) Stats() Stats {
+	return t.stats.Snapshot()
+}
+
+// Offsets returns the current read position of every known file
+func (t *FileTailer) Offsets() []FileOffset {
+	offsets := t.offsets.list()
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].Path < offsets[j].Path })
+	return offsets
+}
+
+func (t *FileTailer) run(ctx context.Context) {
+	defer close(t.done)
+
+	poll := time.NewTicker(t.config.PollInterval)
+	defer poll.Stop()
+	rescan := time.NewTicker(t.config.RescanInterval)
+	defer rescan.Stop()
+	checkpoint := time.NewTicker(t.config.CheckpointInterval)
+	defer checkpoint.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			t.poll(ctx)
+		case <-rescan.C:
+			t.scan(ctx, false)
+		case <-checkpoint.C:
+			t.offsets.save()
+		}
+	}
+}
+
+// scan expands the configured paths and opens newly matched files
+func (t *FileTailer) scan(ctx context.Context, initial bool) {
+	for _, path := range t.expandPaths() {
+		t.mutex.Lock()
+		_, tracked := t.files[path]
+		t.mutex.Unlock()
+		if tracked {
+			continue
+		}
+		t.open(path, initial)
+	}
+}
+
+func (t *FileTailer) expandPaths() []string {
+	seen := make(map[string]bool)
+	paths := make([]string, 0)
+
+	add := func(path string) {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || seen[path] || t.excluded(path) {
+			return
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+
+	for _, pattern := range t.config.Paths {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+func (t *FileTailer) excluded(path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range t.config.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// open starts following a file, resuming from a saved offset when the file is
+// recognised by path or, after a rename, by fingerprint
+func (t *FileTailer) open(path string, initial bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	offset := int64(0)
+	saved, ok := t.offsets.get(path)
+	if ok && !sameFingerprint(file, saved) {
+		ok = false
+	}
+	if !ok {
+		if saved, ok = t.offsets.findByFingerprint(file); ok {
+			t.offsets.remove(saved)
+		}
+	}
+	switch {
+	case ok && saved.Offset <= info.Size():
+		offset = saved.Offset
+	case !ok && initial && t.config.StartAtEnd:
+		offset = info.Size()
+	}
+
+	tf := &tailedFile{
+		path:   path,
+		file:   file,
+		info:   info,
+		offset: offset,
+		read:   offset,
+	}
+
+	t.mutex.Lock()
+	t.files[path] = tf
+	t.mutex.Unlock()
+
+	t.commit(tf)
+}
+
+// poll reads new lines from every file and handles rotation
+func (t *FileTailer) poll(ctx context.Context) {
+	t.mutex.Lock()
+	files := make([]*tailedFile, 0, len(t.files))
+	for _, tf := range t.files {
+		files = append(files, tf)
+	}
+	t.mutex.Unlock()
+
+	for _, tf := range files {
+		if ctx.Err() != nil {
+			return
+		}
+
+		current, statErr := os.Stat(tf.path)
+		rotated := statErr != nil || !os.SameFile(tf.info, current)
+
+		if !rotated {
+			if info, err := tf.file.Stat(); err == nil && info.Size() < tf.read {
+				// copytruncate: the file was emptied in place
+				tf.offset, tf.read, tf.pending, tf.skipping = 0, 0, nil, false
+			}
+		}
+
+		if err := t.readLines(ctx, tf); err != nil {
+			continue
+		}
+
+		if rotated {
+			// The old file has been read to the end. Flush a trailing line
+			// without newline, then pick up the new file from its start.
+			if len(tf.pending) > 0 && !tf.skipping {
+				t.deliver(ctx, tf, []tailedLine{{text: string(tf.pending), end: tf.read}})
+			}
+			t.close(tf)
+			if statErr == nil {
+				t.open(tf.path, false)
+			}
+		}
+	}
+}
+
+// readLines reads everything appended since the last poll and hands the
+// complete lines to the sink
+func (t *FileTailer) readLines(ctx context.Context, tf *tailedFile) error {
+	buf := make([]byte, 64*1024)
+	lines := make([]tailedLine, 0, t.config.BatchSize)
+
+	for {
+		n, err := tf.file.ReadAt(buf, tf.read)
+		if n > 0 {
+			tf.read += int64(n)
+			lines = t.splitLines(tf, buf[:n], lines)
+			if len(lines) >= t.config.BatchSize {
+				if err := t.deliver(ctx, tf, lines); err != nil {
+					return err
+				}
+				lines = lines[:0]
+			}
+		}
+		if err == io.EOF || n == 0 {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(lines) > 0 {
+		return t.deliver(ctx, tf, lines)
+	}
+	return nil
+}
+
+// splitLines appends the complete lines in data to lines, keeping a partial
+// trailing line for the next read
+func (t *FileTailer) splitLines(tf *tailedFile, data []byte, lines []tailedLine) []tailedLine {
+	base := tf.read - int64(len(data))
+	start := 0
+	for {
+		idx := bytes.IndexByte(data[start:], '\n')
+		if idx < 0 {
+			break
+		}
+		end := start + idx
+		if tf.skipping {
+			tf.skipping = false
+		} else {
+			tf.pending = append(tf.pending, data[start:end]...)
+			if len(tf.pending) > t.config.MaxLineSize {
+				// The whole line arrived in one read
+				atomic.AddInt64(&t.stats.TooLarge, 1)
+			} else if line := string(bytes.TrimRight(tf.pending, "\r")); line != "" {
+				lines = append(lines, tailedLine{text: line, end: base + int64(end) + 1})
+			}
+		}
+		tf.pending = tf.pending[:0]
+		start = end + 1
+	}
+
+	if !tf.skipping {
+		tf.pending = append(tf.pending, data[start:]...)
+		if len(tf.pending) > t.config.MaxLineSize {
+			atomic.AddInt64(&t.stats.TooLarge, 1)
+			tf.pending = tf.pending[:0]
+			tf.skipping = true
+		}
+	}
+	return lines
+}
+
+// deliver sends lines to the sink and advances the committed offset. On a
+// sink error the read position is rewound so the lines are retried.
+func (t *FileTailer) deliver(ctx context.Context, tf *tailedFile, lines []tailedLine) error {
+	batch := make([]string, len(lines))
+	for i, line := range lines {
+		batch[i] = line.text
+	}
+	atomic.AddInt64(&t.stats.Received, int64(len(batch)))
+
+	// Stateful parsers such as Zeek keep the header of each file apart
+	results, err := t.sink.BatchProcessLogs(log.WithSource(ctx, tf.path), batch)
+	if err != nil {
+		atomic.AddInt64(&t.stats.SinkErrors, 1)
+		atomic.AddInt64(&t.stats.Received, -int64(len(batch)))
+		tf.read, tf.pending, tf.skipping = tf.offset, nil, false
+		return err
+	}
+
+	failed := int64(0)
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	atomic.AddInt64(&t.stats.Failed, failed)
+	atomic.AddInt64(&t.stats.Processed, int64(len(batch))-failed)
+
+	tf.offset = lines[len(lines)-1].end
+	t.commit(tf)
+	return nil
+}
+
+// commit records the offset of a file together with its current fingerprint
+func (t *FileTailer) commit(tf *tailedFile) {
+	fp, n, err := fingerprint(tf.file, fingerprintSize)
+	if err != nil {
+		return
+	}
+	t.offsets.set(FileOffset{
+		Path:           tf.path,
+		Offset:         tf.offset,
+		Fingerprint:    fp,
+		FingerprintLen: n,
+	})
+}
+
+func (t *FileTailer) close(tf *tailedFile) {
+	tf.file.Close()
+
+	t.mutex.Lock()
+	delete(t.files, tf.path)
+	t.mutex.Unlock()
+
+	t.offsets.retire(tf.path)
+}
+
+// sameFingerprint checks that a saved offset belongs to this file and not to
+// a different file that has since taken its path
+func sameFingerprint(file *os.File, saved *FileOffset) bool {
+	if saved.FingerprintLen == 0 {
+		return true
+	}
+	fp, n, err := fingerprint(file, saved.FingerprintLen)
+	return err == nil && n == saved.FingerprintLen && fp == saved.Fingerprint
+}
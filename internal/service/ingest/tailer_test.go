@@ -0,0 +1,265 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/service/log"
+)
+
+// failingSink fails the first batches it receives, then records lines
+type failingSink struct {
+	recordingSink
+	failures int
+}
+
+func (s *failingSink) BatchProcessLogs(ctx context.Context, logs []string) ([]*log.ProcessResult, error) {
+	s.mutex.Lock()
+	if s.failures > 0 {
+		s.failures--
+		s.mutex.Unlock()
+		return nil, errors.New("sink unavailable")
+	}
+	s.mutex.Unlock()
+	return s.recordingSink.BatchProcessLogs(ctx, logs)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTailer starts a tailer whose timers never fire, so the test drives
+// polling itself
+func startTailer(t *testing.T, config TailerConfig, sink Sink) *FileTailer {
+	t.Helper()
+	config.PollInterval = time.Hour
+	config.RescanInterval = time.Hour
+	config.CheckpointInterval = time.Hour
+	tailer := NewFileTailer(config, sink)
+	if err := tailer.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return tailer
+}
+
+func TestFileTailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TailerConfig
+		initial string
+		// steps change the file, each is followed by a poll
+		steps []func(t *testing.T, path string)
+		// want lists the received lines in sorted order
+		want         string
+		wantTooLarge int64
+	}{
+		{
+			name:    "partial lines wait for the newline",
+			initial: "a\nb\npartial",
+			steps: []func(t *testing.T, path string){
+				func(t *testing.T, path string) { appendFile(t, path, " line\n") },
+			},
+			want: "a,b,partial line",
+		},
+		{
+			name:    "carriage returns and blank lines",
+			initial: "a\r\n\r\n\nb\n",
+			want:    "a,b",
+		},
+		{
+			name:    "oversized lines are skipped",
+			config:  TailerConfig{MaxLineSize: 8},
+			initial: "short\n" + strings.Repeat("x", 20) + "\nok\n",
+			want:    "ok,short",
+			// the line is detected once, however many reads it spans
+			wantTooLarge: 1,
+		},
+		{
+			name:    "start at end skips existing lines",
+			config:  TailerConfig{StartAtEnd: true},
+			initial: "old\n",
+			steps: []func(t *testing.T, path string){
+				func(t *testing.T, path string) { appendFile(t, path, "new\n") },
+			},
+			want: "new",
+		},
+		{
+			name:    "rename rotation drains the old file",
+			initial: "a\n",
+			steps: []func(t *testing.T, path string){
+				func(t *testing.T, path string) {
+					if err := os.Rename(path, path+".1"); err != nil {
+						t.Fatal(err)
+					}
+					appendFile(t, path+".1", "b")
+					writeFile(t, path, "c\n")
+				},
+				func(t *testing.T, path string) {},
+			},
+			want: "a,b,c",
+		},
+		{
+			name:    "copytruncate restarts at the beginning",
+			initial: "a\nb\n",
+			steps: []func(t *testing.T, path string){
+				func(t *testing.T, path string) {
+					if err := os.Truncate(path, 0); err != nil {
+						t.Fatal(err)
+					}
+					appendFile(t, path, "c\n")
+				},
+			},
+			want: "a,b,c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			writeFile(t, path, tt.initial)
+
+			sink := &recordingSink{}
+			config := tt.config
+			config.Paths = []string{path}
+			tailer := startTailer(t, config, sink)
+			defer tailer.Stop()
+
+			tailer.poll(context.Background())
+			for _, step := range tt.steps {
+				step(t, path)
+				tailer.poll(context.Background())
+			}
+
+			got := sink.received()
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("lines = %q, want %s", got, tt.want)
+			}
+			if stats := tailer.Stats(); stats.TooLarge != tt.wantTooLarge || stats.Processed != int64(len(got)) {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestFileTailerResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// change modifies the file while the tailer is stopped and returns
+		// the path it is found at afterwards
+		change func(t *testing.T, path string) string
+		want   string
+	}{
+		{
+			name: "resumes from the saved offset",
+			change: func(t *testing.T, path string) string {
+				appendFile(t, path, "c\n")
+				return path
+			},
+			want: "c",
+		},
+		{
+			name: "replaced file is read from the start",
+			change: func(t *testing.T, path string) string {
+				writeFile(t, path, "x\ny\nz\n")
+				return path
+			},
+			want: "x,y,z",
+		},
+		{
+			name: "renamed file is recognised by its fingerprint",
+			change: func(t *testing.T, path string) string {
+				renamed := strings.TrimSuffix(path, ".log") + "-1.log"
+				if err := os.Rename(path, renamed); err != nil {
+					t.Fatal(err)
+				}
+				appendFile(t, renamed, "c\n")
+				return renamed
+			},
+			want: "c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "app.log")
+			writeFile(t, path, "a\nb\n")
+			config := TailerConfig{
+				Paths:       []string{filepath.Join(dir, "*.log")},
+				OffsetsFile: filepath.Join(dir, "state", "offsets.json"),
+			}
+
+			first := startTailer(t, config, &recordingSink{})
+			first.poll(context.Background())
+			if err := first.Stop(); err != nil {
+				t.Fatal(err)
+			}
+
+			current := tt.change(t, path)
+			sink := &recordingSink{}
+			second := startTailer(t, config, sink)
+			second.poll(context.Background())
+			if err := second.Stop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Join(sink.received(), ","); got != tt.want {
+				t.Errorf("lines = %s, want %s", got, tt.want)
+			}
+			offsets := second.Offsets()
+			if len(offsets) != 1 || offsets[0].Path != current {
+				t.Errorf("offsets = %+v", offsets)
+			}
+		})
+	}
+}
+
+func TestFileTailerSinkError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "a\nb\n")
+
+	sink := &failingSink{failures: 1}
+	tailer := startTailer(t, TailerConfig{Paths: []string{path}}, sink)
+	defer tailer.Stop()
+
+	tailer.poll(context.Background())
+	if got := sink.received(); len(got) != 0 {
+		t.Fatalf("lines delivered by a failing sink: %q", got)
+	}
+	if offsets := tailer.Offsets(); offsets[0].Offset != 0 {
+		t.Errorf("offset advanced to %d after a sink error", offsets[0].Offset)
+	}
+
+	// The lines are retried, not lost or duplicated
+	tailer.poll(context.Background())
+	if got := strings.Join(sink.received(), ","); got != "a,b" {
+		t.Errorf("lines = %s, want a,b", got)
+	}
+	stats := tailer.Stats()
+	if stats.SinkErrors != 1 || stats.Received != 2 || stats.Processed != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if offsets := tailer.Offsets(); offsets[0].Offset != 4 {
+		t.Errorf("offset = %d, want 4", offsets[0].Offset)
+	}
+}
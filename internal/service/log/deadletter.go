@@ -0,0 +1,246 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/service/redact"
+)
+
+// Processing stages recorded on failed records
+const (
+	StageParse  = "parse"
+	StageEnrich = "enrich"
+	StageDetect = "detect"
+	StageSave   = "save"
+	// StageIngest marks records whose batch could not be handed to the
+	// processor at all
+	StageIngest = "ingest"
+)
+
+// ErrDeadLetterNotFound is returned for unknown dead letter IDs
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a raw log record that could not be processed
+type DeadLetter struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	Raw         string    `json:"raw"`
+	Error       string    `json:"error"`
+	Stage       string    `json:"stage"`
+	Parser      string    `json:"parser,omitempty"`
+	SourceType  string    `json:"source_type,omitempty"` // parser the record was submitted for, reused by redrives
+	FailedAt    time.Time `json:"failed_at"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+}
+
+// DeadLetterFilter selects dead letters. Zero values match everything.
+type DeadLetterFilter struct {
+	Tenant string
+	Stage  string
+	Parser string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// Match reports whether a dead letter passes the filter, ignoring paging
+func (f DeadLetterFilter) Match(entry *DeadLetter) bool {
+	if f.Tenant != "" && tenant.Normalize(entry.TenantID) != f.Tenant {
+		return false
+	}
+	if f.Stage != "" && entry.Stage != f.Stage {
+		return false
+	}
+	if f.Parser != "" && entry.Parser != f.Parser {
+		return false
+	}
+	if !f.Since.IsZero() && entry.FailedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.FailedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// DeadLetterStore persists records rejected by the processor
+type DeadLetterStore interface {
+	// Put stores dead letters, replacing entries with the same ID
+	Put(ctx context.Context, entries ...*DeadLetter) error
+
+	// Get retrieves a dead letter by ID
+	Get(ctx context.Context, id string) (*DeadLetter, error)
+
+	// List returns matching dead letters, newest first, and the total number of matches
+	List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, int, error)
+
+	// Delete removes dead letters
+	Delete(ctx context.Context, ids ...string) error
+}
+
+// RedriveResult reports the outcome of reprocessing a dead letter
+type RedriveResult struct {
+	ID       string         `json:"id"`
+	Resolved bool           `json:"resolved"`
+	Result   *ProcessResult `json:"result,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// SetDeadLetterStore enables dead-lettering of records that fail processing
+func (p *LogProcessor) SetDeadLetterStore(store DeadLetterStore) {
+	p.deadLetters = store
+}
+
+// DeadLetters returns the dead letter store, or nil if none is configured
+func (p *LogProcessor) DeadLetters() DeadLetterStore {
+	return p.deadLetters
+}
+
+// deadLetter stores every failed record of a batch; results[i] belongs to
+// logs[i]. Records are redacted for storage, so a redrive reprocesses the
+// redacted record. A failure to write the dead letters is recorded on the
+// results of the failed records and returned.
+func (p *LogProcessor) deadLetter(ctx context.Context, logs []string, results []*ProcessResult) error {
+	if p.deadLetters == nil {
+		return nil
+	}
+
+	now := time.Now()
+	entries := make([]*DeadLetter, 0)
+	failed := make([]*ProcessResult, 0)
+	for i, result := range results {
+		if result.Error == "" {
+			continue
+		}
+		failed = append(failed, result)
+		raw, _ := p.redactor.RedactString(redact.DestinationStorage, logs[i])
+		entries = append(entries, &DeadLetter{
+			ID:         uuid.New().String(),
+			TenantID:   result.tenant,
+			Raw:        raw,
+			Error:      result.Error,
+			Stage:      result.Stage,
+			Parser:     result.Parser,
+			SourceType: result.sourceType,
+			FailedAt:   now,
+			Attempts:   1,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := p.deadLetters.Put(ctx, entries...); err != nil {
+		for _, result := range failed {
+			result.DeadLetterError = err.Error()
+		}
+		return err
+	}
+	return nil
+}
+
+// DeadLetterLogs stores raw logs that were never processed, for example
+// because every attempt to process their batch failed as a whole. They belong
+// to the tenant of the context.
+func (p *LogProcessor) DeadLetterLogs(ctx context.Context, logs []string, cause error) error {
+	if p.deadLetters == nil {
+		return errors.New("dead letter store not configured")
+	}
+
+	results := make([]*ProcessResult, len(logs))
+	for i := range logs {
+		results[i] = &ProcessResult{Index: i, Stage: StageIngest, Error: cause.Error(), tenant: tenant.FromContext(ctx)}
+	}
+	return p.deadLetter(ctx, logs, results)
+}
+
+// Redrive reprocesses dead letters, typically after a parser fix. Records that
+// now succeed are removed from the store; the others are kept with the new
+// error and an incremented attempt count. Records submitted for a source type
+// are parsed with that parser again. Only dead letters of the context's tenant
+// are redriven; others are reported as not found.
+func (p *LogProcessor) Redrive(ctx context.Context, ids []string) ([]*RedriveResult, error) {
+	if p.deadLetters == nil {
+		return nil, errors.New("dead letter store not configured")
+	}
+
+	redriven := make([]*RedriveResult, len(ids))
+	entries := make([]*DeadLetter, 0, len(ids))
+	for i, id := range ids {
+		redriven[i] = &RedriveResult{ID: id}
+		entry, err := p.deadLetters.Get(ctx, id)
+		if err == nil && tenant.Normalize(entry.TenantID) != tenant.FromContext(ctx) {
+			err = ErrDeadLetterNotFound
+		}
+		if err != nil {
+			redriven[i].Error = err.Error()
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	// Records are reprocessed in one batch per source type. A failure of the
+	// whole batch is recorded on every result as well.
+	results := make([]*ProcessResult, len(entries))
+	bySourceType := make(map[string][]int)
+	sourceTypes := make([]string, 0)
+	for i, entry := range entries {
+		if _, ok := bySourceType[entry.SourceType]; !ok {
+			sourceTypes = append(sourceTypes, entry.SourceType)
+		}
+		bySourceType[entry.SourceType] = append(bySourceType[entry.SourceType], i)
+	}
+	for _, sourceType := range sourceTypes {
+		indexes := bySourceType[sourceType]
+		logs := make([]string, len(indexes))
+		for j, i := range indexes {
+			logs[j] = entries[i].Raw
+		}
+		batch, _ := p.batchProcess(ctx, sourceType, logs)
+		for j, i := range indexes {
+			results[i] = batch[j]
+		}
+	}
+
+	now := time.Now()
+	resolved := make([]string, 0, len(entries))
+	failed := make([]*DeadLetter, 0, len(entries))
+	byID := make(map[string]*RedriveResult, len(redriven))
+	for _, r := range redriven {
+		byID[r.ID] = r
+	}
+	for i, entry := range entries {
+		result := results[i]
+		r := byID[entry.ID]
+		r.Result = result
+
+		if result.Error == "" {
+			r.Resolved = true
+			resolved = append(resolved, entry.ID)
+			continue
+		}
+		entry.Error = result.Error
+		entry.Stage = result.Stage
+		entry.Parser = result.Parser
+		entry.Attempts++
+		entry.LastAttempt = now
+		failed = append(failed, entry)
+	}
+
+	if len(resolved) > 0 {
+		if err := p.deadLetters.Delete(ctx, resolved...); err != nil {
+			return redriven, err
+		}
+	}
+	if len(failed) > 0 {
+		if err := p.deadLetters.Put(ctx, failed...); err != nil {
+			return redriven, err
+		}
+	}
+	return redriven, nil
+}
@@ -0,0 +1,388 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const deadLetterSegmentExt = ".dlq"
+
+// DeadLetterConfig configures the file based dead letter store
+type DeadLetterConfig struct {
+	Dir            string `json:"dir"              yaml:"dir"`
+	MaxSegmentSize int64  `json:"max_segment_size" yaml:"max_segment_size"` // bytes before a new segment is started
+	MaxSegments    int    `json:"max_segments"     yaml:"max_segments"`     // oldest segments are dropped beyond this, 0 keeps all
+}
+
+// DefaultDeadLetterConfig returns the default dead letter store configuration
+func DefaultDeadLetterConfig() DeadLetterConfig {
+	return DeadLetterConfig{
+		Dir:            "data/dlq",
+		MaxSegmentSize: 16 * 1024 * 1024,
+		MaxSegments:    64,
+	}
+}
+
+// deadLetterRecord is one line of a segment file. Deletions are appended as
+// tombstones so segments are never rewritten.
+type deadLetterRecord struct {
+	Entry   *DeadLetter `json:"entry,omitempty"`
+	Deleted string      `json:"deleted,omitempty"`
+}
+
+// deadLetterRef locates an entry on disk and keeps the fields needed for
+// filtering in memory
+type deadLetterRef struct {
+	segment  *deadLetterSegment
+	offset   int64
+	length   int
+	tenant   string
+	stage    string
+	parser   string
+	failedAt time.Time
+}
+
+type deadLetterSegment struct {
+	seq  int64
+	path string
+	size int64
+	live int // entries in this segment that are still current
+}
+
+// FileDeadLetterStore stores dead letters in append-only JSON lines segment
+// files. An in-memory index maps IDs to their latest record; it is rebuilt
+// from the segments on startup. Segments are removed from the front once none
+// of their entries is current any more.
+type FileDeadLetterStore struct {
+	config   DeadLetterConfig
+	index    map[string]*deadLetterRef
+	segments []*deadLetterSegment
+	current  *os.File
+	mutex    sync.Mutex
+}
+
+// NewFileDeadLetterStore opens or creates a dead letter store in config.Dir
+func NewFileDeadLetterStore(config DeadLetterConfig) (*FileDeadLetterStore, error) {
+	d := DefaultDeadLetterConfig()
+	if config.Dir == "" {
+		config.Dir = d.Dir
+	}
+	if config.MaxSegmentSize <= 0 {
+		config.MaxSegmentSize = d.MaxSegmentSize
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("create dead letter dir: %v", err)
+	}
+
+	s := &FileDeadLetterStore{
+		config: config,
+		index:  make(map[string]*deadLetterRef),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the active segment
+func (s *FileDeadLetterStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
+
+// load rebuilds the index from the segment files
+func (s *FileDeadLetterStore) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.config.Dir, "*"+deadLetterSegmentExt))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), deadLetterSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, &deadLetterSegment{seq: seq, path: path})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	for _, segment := range s.segments {
+		if err := s.loadSegment(segment); err != nil {
+			return fmt.Errorf("load dead letter segment %s: %v", segment.path, err)
+		}
+	}
+	s.compact()
+	return nil
+}
+
+func (s *FileDeadLetterStore) loadSegment(segment *deadLetterSegment) error {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var record deadLetterRecord
+		if json.Unmarshal(line, &record) == nil {
+			s.apply(segment, offset, len(line), &record)
+		}
+		offset += int64(len(line))
+	}
+	segment.size = offset
+
+	// Cut off a torn write so that the next record starts on a fresh line
+	if info, err := file.Stat(); err == nil && info.Size() > offset {
+		return os.Truncate(segment.path, offset)
+	}
+	return nil
+}
+
+// apply updates the index with a record written at offset in segment
+func (s *FileDeadLetterStore) apply(segment *deadLetterSegment, offset int64, length int, record *deadLetterRecord) {
+	id := record.Deleted
+	if record.Entry != nil {
+		id = record.Entry.ID
+	}
+	if old, ok := s.index[id]; ok {
+		old.segment.live--
+		delete(s.index, id)
+	}
+	if record.Entry == nil {
+		return
+	}
+
+	segment.live++
+	s.index[id] = &deadLetterRef{
+		segment:  segment,
+		offset:   offset,
+		length:   length,
+		tenant:   record.Entry.TenantID,
+		stage:    record.Entry.Stage,
+		parser:   record.Entry.Parser,
+		failedAt: record.Entry.FailedAt,
+	}
+}
+
+// Put stores dead letters, replacing entries with the same ID
+func (s *FileDeadLetterStore) Put(ctx context.Context, entries ...*DeadLetter) error {
+	records := make([]*deadLetterRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = &deadLetterRecord{Entry: entry}
+	}
+	return s.append(records)
+}
+
+// Delete removes dead letters. Unknown IDs are ignored.
+func (s *FileDeadLetterStore) Delete(ctx context.Context, ids ...string) error {
+	s.mutex.Lock()
+	records := make([]*deadLetterRecord, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := s.index[id]; ok {
+			records = append(records, &deadLetterRecord{Deleted: id})
+		}
+	}
+	s.mutex.Unlock()
+
+	return s.append(records)
+}
+
+func (s *FileDeadLetterStore) append(records []*deadLetterRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		segment, err := s.writable(int64(len(line)))
+		if err != nil {
+			return err
+		}
+		if _, err := s.current.Write(line); err != nil {
+			return fmt.Errorf("write dead letter: %v", err)
+		}
+		s.apply(segment, segment.size, len(line), record)
+		segment.size += int64(len(line))
+	}
+
+	if err := s.current.Sync(); err != nil {
+		return fmt.Errorf("sync dead letters: %v", err)
+	}
+	s.compact()
+	return nil
+}
+
+// writable returns the active segment, starting a new one when the record
+// would not fit
+func (s *FileDeadLetterStore) writable(size int64) (*deadLetterSegment, error) {
+	var last *deadLetterSegment
+	if len(s.segments) > 0 {
+		last = s.segments[len(s.segments)-1]
+	}
+
+	if last != nil && (last.size == 0 || last.size+size <= s.config.MaxSegmentSize) {
+		if s.current == nil {
+			file, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("open dead letter segment: %v", err)
+			}
+			s.current = file
+		}
+		return last, nil
+	}
+
+	seq := time.Now().UnixNano()
+	if last != nil && seq <= last.seq {
+		seq = last.seq + 1
+	}
+	segment := &deadLetterSegment{
+		seq:  seq,
+		path: filepath.Join(s.config.Dir, fmt.Sprintf("%020d%s", seq, deadLetterSegmentExt)),
+	}
+	file, err := os.OpenFile(segment.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("create dead letter segment: %v", err)
+	}
+	if s.current != nil {
+		s.current.Close()
+	}
+	s.current = file
+	s.segments = append(s.segments, segment)
+	return segment, nil
+}
+
+// compact removes leading segments without current entries and enforces
+// MaxSegments. Only leading segments may go, since their tombstones could
+// otherwise resurrect entries of older segments on reload.
+func (s *FileDeadLetterStore) compact() {
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		overLimit := s.config.MaxSegments > 0 && len(s.segments) > s.config.MaxSegments
+		if oldest.live > 0 && !overLimit {
+			break
+		}
+
+		if oldest.live > 0 {
+			for id, ref := range s.index {
+				if ref.segment == oldest {
+					delete(s.index, id)
+				}
+			}
+		}
+		os.Remove(oldest.path)
+		s.segments = s.segments[1:]
+	}
+}
+
+// Get retrieves a dead letter by ID
+func (s *FileDeadLetterStore) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	s.mutex.Lock()
+	ref, ok := s.index[id]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	return s.read(ref)
+}
+
+// List returns matching dead letters, newest first, and the total number of matches
+func (s *FileDeadLetterStore) List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, int, error) {
+	s.mutex.Lock()
+	refs := make([]*deadLetterRef, 0, len(s.index))
+	for _, ref := range s.index {
+		probe := &DeadLetter{TenantID: ref.tenant, Stage: ref.stage, Parser: ref.parser, FailedAt: ref.failedAt}
+		if filter.Match(probe) {
+			refs = append(refs, ref)
+		}
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(refs, func(i, j int) bool {
+		if !refs[i].failedAt.Equal(refs[j].failedAt) {
+			return refs[i].failedAt.After(refs[j].failedAt)
+		}
+		if refs[i].segment.seq != refs[j].segment.seq {
+			return refs[i].segment.seq > refs[j].segment.seq
+		}
+		return refs[i].offset > refs[j].offset
+	})
+
+	total := len(refs)
+	if filter.Offset > 0 {
+		if filter.Offset >= len(refs) {
+			refs = nil
+		} else {
+			refs = refs[filter.Offset:]
+		}
+	}
+	if filter.Limit > 0 && len(refs) > filter.Limit {
+		refs = refs[:filter.Limit]
+	}
+
+	entries := make([]*DeadLetter, 0, len(refs))
+	for _, ref := range refs {
+		entry, err := s.read(ref)
+		if err != nil {
+			// The segment may have been compacted meanwhile
+			continue
+		}
+		if !filter.Match(entry) {
+			// The index only mirrors the filtered fields; the entry decides
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, nil
+}
+
+func (s *FileDeadLetterStore) read(ref *deadLetterRef) (*DeadLetter, error) {
+	file, err := os.Open(ref.segment.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, ref.length)
+	if _, err := file.ReadAt(buf, ref.offset); err != nil {
+		return nil, err
+	}
+
+	var record deadLetterRecord
+	if err := json.Unmarshal(buf, &record); err != nil {
+		return nil, err
+	}
+	if record.Entry == nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	return record.Entry, nil
+}
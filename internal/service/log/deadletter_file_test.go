@@ -0,0 +1,110 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+func newTestDeadLetter(id, tenantID, stage string, failedAt time.Time) *DeadLetter {
+	return &DeadLetter{ID: id, TenantID: tenantID, Raw: "raw " + id, Error: "bad", Stage: stage, FailedAt: failedAt, Attempts: 1}
+}
+
+func TestFileDeadLetterStoreList(t *testing.T) {
+	store, err := NewFileDeadLetterStore(DeadLetterConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	err = store.Put(ctx,
+		newTestDeadLetter("a", "", StageParse, base),
+		newTestDeadLetter("b", "acme", StageParse, base.Add(time.Minute)),
+		newTestDeadLetter("c", "acme", StageSave, base.Add(2*time.Minute)),
+		newTestDeadLetter("d", tenant.Default, StageEnrich, base.Add(3*time.Minute)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		filter    DeadLetterFilter
+		want      []string
+		wantTotal int
+	}{
+		{name: "default tenant only sees its own", filter: DeadLetterFilter{Tenant: tenant.Default}, want: []string{"d", "a"}, wantTotal: 2},
+		{name: "other tenant", filter: DeadLetterFilter{Tenant: "acme"}, want: []string{"c", "b"}, wantTotal: 2},
+		{name: "stage", filter: DeadLetterFilter{Tenant: "acme", Stage: StageSave}, want: []string{"c"}, wantTotal: 1},
+		{name: "since", filter: DeadLetterFilter{Since: base.Add(90 * time.Second)}, want: []string{"d", "c"}, wantTotal: 2},
+		{name: "paging", filter: DeadLetterFilter{Offset: 1, Limit: 2}, want: []string{"c", "b"}, wantTotal: 4},
+		{name: "offset past end", filter: DeadLetterFilter{Offset: 9}, want: nil, wantTotal: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, total, err := store.List(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || total != tt.wantTotal {
+				t.Errorf("got %v (total %d), want %v (total %d)", got, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestFileDeadLetterStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	store, err := NewFileDeadLetterStore(DeadLetterConfig{Dir: dir, MaxSegmentSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := store.Put(ctx, newTestDeadLetter(fmt.Sprint(i), "acme", StageParse, base.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "1", "3"); err != nil {
+		t.Fatal(err)
+	}
+	updated := newTestDeadLetter("4", "acme", StageSave, base)
+	updated.Attempts = 2
+	if err := store.Put(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	reopened, err := NewFileDeadLetterStore(DeadLetterConfig{Dir: dir, MaxSegmentSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	_, total, err := reopened.List(ctx, DeadLetterFilter{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Errorf("total = %d, want 3", total)
+	}
+	if _, err := reopened.Get(ctx, "1"); err != ErrDeadLetterNotFound {
+		t.Errorf("deleted entry: err = %v", err)
+	}
+	entry, err := reopened.Get(ctx, "4")
+	if err != nil || entry.Attempts != 2 || entry.Stage != StageSave {
+		t.Errorf("updated entry = %+v, %v", entry, err)
+	}
+}
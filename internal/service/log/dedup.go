@@ -0,0 +1,336 @@
+package log
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/repository"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/service/redact"
+)
+
+// Dedup window modes
+const (
+	// DedupFixed groups repeats into consecutive windows aligned on the event time
+	DedupFixed = "fixed"
+	// DedupSliding keeps a group open while repeats arrive within one window of
+	// the previous repeat
+	DedupSliding = "sliding"
+)
+
+// DedupPolicy decides which events are repeats of each other. The first event
+// of a group carries count, first_seen and last_seen labels; repeats are not
+// analysed again and update these labels instead. Repeats still count towards
+// stateful rules, such as thresholds, one occurrence each.
+type DedupPolicy struct {
+	// Source is the parser name the policy applies to. It is empty for the
+	// default policy.
+	Source string `json:"source,omitempty" yaml:"source"`
+	// Fields are the event fields that identify repeats: source_ip, dest_ip,
+	// protocol, port, action, status, user, event_type, description, severity
+	// or labels.<key>. Source policies without fields use the fields of the
+	// default policy.
+	Fields []string      `json:"fields"   yaml:"fields"`
+	Window time.Duration `json:"window"   yaml:"window"`
+	Mode   string        `json:"mode"     yaml:"mode"`
+	// Disabled processes every event of the source
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled"`
+}
+
+// DedupConfig configures deduplication per log source
+type DedupConfig struct {
+	Default  DedupPolicy   `json:"default"  yaml:"default"`
+	Policies []DedupPolicy `json:"policies" yaml:"policies"`
+}
+
+// DefaultDedupConfig returns the default deduplication configuration, which
+// groups events of the same type between the same endpoints per minute
+func DefaultDedupConfig() DedupConfig {
+	return DedupConfig{
+		Default: DedupPolicy{
+			Fields: []string{"source_ip", "dest_ip", "protocol", "event_type"},
+			Window: time.Minute,
+			Mode:   DedupFixed,
+		},
+	}
+}
+
+func (c DedupPolicy) withDefaults() (DedupPolicy, error) {
+	if c.Disabled {
+		return c, nil
+	}
+	if c.Mode == "" {
+		c.Mode = DedupFixed
+	}
+	if c.Mode != DedupFixed && c.Mode != DedupSliding {
+		return c, fmt.Errorf("invalid dedup mode %q", c.Mode)
+	}
+	if c.Window < 0 {
+		return c, fmt.Errorf("invalid dedup window %v", c.Window)
+	}
+	if c.Window == 0 {
+		c.Window = time.Minute
+	}
+	if len(c.Fields) == 0 {
+		return c, fmt.Errorf("dedup policy has no fields")
+	}
+	probe := entity.NewSecurityEvent()
+	for _, field := range c.Fields {
+		if field == "timestamp" {
+			return c, fmt.Errorf("dedup field timestamp is covered by the window")
+		}
+		if _, ok := mappedFieldString(probe, field); !ok || field == labelTargetPrefix {
+			return c, fmt.Errorf("unknown dedup field %q, expected one of %s", field, dedupFieldNames())
+		}
+	}
+	return c, nil
+}
+
+// Deduplicator keys events by their tenant and their source's policy and
+// keeps the state of open groups in the cache repository, so repeats are
+// recognised across replicas
+type Deduplicator struct {
+	cache    repository.CacheRepository
+	fallback DedupPolicy
+	policies map[string]DedupPolicy
+	redactor *redact.Redactor
+
+	// locks serialise updates of a group within this process
+	locks [64]sync.Mutex
+}
+
+// NewDeduplicator creates a deduplicator from a configuration
+func NewDeduplicator(cache repository.CacheRepository, config DedupConfig) (*Deduplicator, error) {
+	if len(config.Default.Fields) == 0 {
+		config.Default.Fields = DefaultDedupConfig().Default.Fields
+	}
+	fallback, err := config.Default.withDefaults()
+	if err != nil {
+		return nil, fmt.Errorf("default dedup policy: %w", err)
+	}
+
+	d := &Deduplicator{
+		cache:    cache,
+		fallback: fallback,
+		policies: make(map[string]DedupPolicy, len(config.Policies)),
+	}
+	for _, policy := range config.Policies {
+		if policy.Source == "" {
+			return nil, fmt.Errorf("dedup policy without source")
+		}
+		if _, exists := d.policies[policy.Source]; exists {
+			return nil, fmt.Errorf("duplicate dedup policy for source %s", policy.Source)
+		}
+		if len(policy.Fields) == 0 {
+			policy.Fields = fallback.Fields
+		}
+		if policy, err = policy.withDefaults(); err != nil {
+			return nil, fmt.Errorf("dedup policy %s: %w", policy.Source, err)
+		}
+		d.policies[policy.Source] = policy
+	}
+	return d, nil
+}
+
+// SetRedactor redacts the aggregated events for the storage destination
+// before they are cached
+func (d *Deduplicator) SetRedactor(redactor *redact.Redactor) {
+	d.redactor = redactor
+}
+
+// Policy returns the policy applied to events of a source
+func (d *Deduplicator) Policy(source string) DedupPolicy {
+	if policy, ok := d.policies[source]; ok {
+		return policy
+	}
+	return d.fallback
+}
+
+// key returns the group key of an event, or an empty key when the source is
+// not deduplicated
+func (d *Deduplicator) key(source string, event *entity.SecurityEvent) (string, DedupPolicy) {
+	policy := d.Policy(source)
+	if policy.Disabled {
+		return "", policy
+	}
+
+	hash := sha1.New()
+	for _, field := range policy.Fields {
+		value, _ := mappedFieldString(event, field)
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+
+	key := "dedup:" + tenant.Normalize(event.TenantID) + ":" + source + ":" + hex.EncodeToString(hash.Sum(nil))
+	if policy.Mode == DedupFixed {
+		bucket := event.Timestamp.Truncate(policy.Window).Unix()
+		key += ":" + strconv.FormatInt(bucket, 10)
+	}
+	return key, policy
+}
+
+// Observe records an event in the group of its source's policy. It returns
+// nil when the event opens a new group and has to be analysed; the event is
+// then labelled as the first of its group. When the event is a repeat it
+// returns the aggregated first event of the group, with updated count,
+// first_seen and last_seen labels. The cache offers no compare-and-set, so
+// replicas racing on one group may undercount.
+func (d *Deduplicator) Observe(ctx context.Context, source string, event *entity.SecurityEvent) (*entity.SecurityEvent, string, error) {
+	key, policy := d.key(source, event)
+	if key == "" {
+		return nil, "", nil
+	}
+
+	unlock := d.lock(key)
+	defer unlock()
+
+	state, ok := d.load(ctx, key, policy, event)
+	if !ok {
+		return nil, key, d.store(ctx, key, policy, newDedupState(event))
+	}
+
+	state.add(event)
+	if err := d.store(ctx, key, policy, state); err != nil {
+		return nil, key, err
+	}
+	return state.Event, key, nil
+}
+
+// Update gives the aggregate of a group the severity and score its first
+// event was analysed with, so that the aggregate saved for later repeats
+// keeps them. Closed groups are left alone.
+func (d *Deduplicator) Update(ctx context.Context, source, key string, event *entity.SecurityEvent) error {
+	if key == "" {
+		return nil
+	}
+
+	unlock := d.lock(key)
+	defer unlock()
+
+	policy := d.Policy(source)
+	state, ok := d.load(ctx, key, policy, event)
+	if !ok {
+		return nil
+	}
+	adoptAnalysis(state.Event, event)
+	return d.store(ctx, key, policy, state)
+}
+
+// Forget closes a group, so that the next event of the group is analysed again
+func (d *Deduplicator) Forget(ctx context.Context, key string) error {
+	if key == "" {
+		return nil
+	}
+	return d.cache.Delete(ctx, key)
+}
+
+// lock serialises updates of a group and returns the unlock function
+func (d *Deduplicator) lock(key string) func() {
+	var sum uint32
+	for i := 0; i < len(key); i++ {
+		sum = sum*31 + uint32(key[i])
+	}
+	mu := &d.locks[sum%uint32(len(d.locks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// load returns the open group an event belongs to
+func (d *Deduplicator) load(ctx context.Context, key string, policy DedupPolicy, event *entity.SecurityEvent) (*dedupState, bool) {
+	value, err := d.cache.Get(ctx, key)
+	if err != nil {
+		return nil, false
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return nil, false
+	}
+
+	var state dedupState
+	if err := json.Unmarshal(data, &state); err != nil || state.Event == nil {
+		return nil, false
+	}
+	if policy.Mode == DedupSliding && event.Timestamp.Sub(state.LastSeen) > policy.Window {
+		return nil, false
+	}
+	return &state, true
+}
+
+// store saves the state of a group for the length of one window. The cached
+// event is redacted, the state itself keeps the event as observed.
+func (d *Deduplicator) store(ctx context.Context, key string, policy DedupPolicy, state *dedupState) error {
+	cached := *state
+	cached.Event = d.redactor.Redact(redact.DestinationStorage, state.Event)
+	data, err := json.Marshal(&cached)
+	if err != nil {
+		return err
+	}
+	return d.cache.Set(ctx, key, string(data), policy.Window)
+}
+
+// dedupState is the aggregate of a group of repeated events
+type dedupState struct {
+	Event     *entity.SecurityEvent `json:"event"`
+	Count     int                   `json:"count"`
+	FirstSeen time.Time             `json:"first_seen"`
+	LastSeen  time.Time             `json:"last_seen"`
+}
+
+func newDedupState(event *entity.SecurityEvent) *dedupState {
+	state := &dedupState{
+		Event:     event,
+		Count:     1,
+		FirstSeen: event.Timestamp,
+		LastSeen:  event.Timestamp,
+	}
+	state.label()
+	return state
+}
+
+// add counts a repeat and updates the aggregated event
+func (s *dedupState) add(event *entity.SecurityEvent) {
+	s.Count++
+	if event.Timestamp.Before(s.FirstSeen) {
+		s.FirstSeen = event.Timestamp
+	}
+	if event.Timestamp.After(s.LastSeen) {
+		s.LastSeen = event.Timestamp
+	}
+
+	s.label()
+	s.Event.UpdatedAt = time.Now()
+}
+
+// label writes the group counters to the aggregated event
+func (s *dedupState) label() {
+	s.Event.SetLabel("count", strconv.Itoa(s.Count))
+	s.Event.SetLabel("first_seen", s.FirstSeen.UTC().Format(time.RFC3339Nano))
+	s.Event.SetLabel("last_seen", s.LastSeen.UTC().Format(time.RFC3339Nano))
+}
+
+// adoptAnalysis copies the analysis results of the first event of a group to
+// its aggregate
+func adoptAnalysis(aggregate, event *entity.SecurityEvent) {
+	aggregate.Severity = event.Severity
+	aggregate.Score = event.Score
+}
+
+// dedupFieldNames lists the fields a policy can key on, for error messages
+func dedupFieldNames() string {
+	return strings.Join([]string{"source_ip", "dest_ip", "protocol", "port", "action", "status",
+		"user", "event_type", "description", "severity", labelTargetPrefix + "<key>"}, ", ")
+}
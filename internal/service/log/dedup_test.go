@@ -0,0 +1,186 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+func dedupEvent(sourceIP string, at time.Time) *entity.SecurityEvent {
+	event := entity.NewSecurityEvent()
+	event.Timestamp = at
+	event.SourceIP = sourceIP
+	event.DestIP = "10.0.0.2"
+	event.Protocol = "TCP"
+	event.EventType = "login"
+	return event
+}
+
+func TestDeduplicatorObserve(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		policy    DedupPolicy
+		events    []*entity.SecurityEvent
+		repeats   []bool
+		wantCount string
+	}{
+		{
+			name:      "single event is labelled as its own group",
+			policy:    DedupPolicy{Fields: []string{"source_ip"}, Window: time.Minute},
+			events:    []*entity.SecurityEvent{dedupEvent("10.0.0.1", base)},
+			repeats:   []bool{false},
+			wantCount: "1",
+		},
+		{
+			name:   "fixed window folds repeats",
+			policy: DedupPolicy{Fields: []string{"source_ip"}, Window: time.Minute},
+			events: []*entity.SecurityEvent{
+				dedupEvent("10.0.0.1", base),
+				dedupEvent("10.0.0.1", base.Add(10*time.Second)),
+				dedupEvent("10.0.0.1", base.Add(20*time.Second)),
+			},
+			repeats:   []bool{false, true, true},
+			wantCount: "3",
+		},
+		{
+			name:   "different key opens a new group",
+			policy: DedupPolicy{Fields: []string{"source_ip"}, Window: time.Minute},
+			events: []*entity.SecurityEvent{
+				dedupEvent("10.0.0.1", base),
+				dedupEvent("10.0.0.9", base.Add(time.Second)),
+			},
+			repeats:   []bool{false, false},
+			wantCount: "1",
+		},
+		{
+			name:   "fixed window boundary opens a new group",
+			policy: DedupPolicy{Fields: []string{"source_ip"}, Window: time.Minute},
+			events: []*entity.SecurityEvent{
+				dedupEvent("10.0.0.1", base.Add(50*time.Second)),
+				dedupEvent("10.0.0.1", base.Add(70*time.Second)),
+			},
+			repeats:   []bool{false, false},
+			wantCount: "1",
+		},
+		{
+			name:   "sliding window follows the last repeat",
+			policy: DedupPolicy{Fields: []string{"source_ip"}, Window: time.Minute, Mode: DedupSliding},
+			events: []*entity.SecurityEvent{
+				dedupEvent("10.0.0.1", base),
+				dedupEvent("10.0.0.1", base.Add(50*time.Second)),
+				dedupEvent("10.0.0.1", base.Add(100*time.Second)),
+				dedupEvent("10.0.0.1", base.Add(200*time.Second)),
+			},
+			repeats:   []bool{false, true, true, false},
+			wantCount: "1",
+		},
+		{
+			name:      "disabled policy never folds",
+			policy:    DedupPolicy{Disabled: true},
+			events:    []*entity.SecurityEvent{dedupEvent("10.0.0.1", base), dedupEvent("10.0.0.1", base)},
+			repeats:   []bool{false, false},
+			wantCount: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDeduplicator(newMemoryCache(), DedupConfig{Default: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var last *entity.SecurityEvent
+			for i, event := range tt.events {
+				aggregate, _, err := d.Observe(context.Background(), "json", event)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if repeat := aggregate != nil; repeat != tt.repeats[i] {
+					t.Fatalf("event %d: repeat = %v, want %v", i, repeat, tt.repeats[i])
+				}
+				last = event
+				if aggregate != nil {
+					last = aggregate
+				}
+			}
+			count, _ := last.GetLabel("count")
+			if count != tt.wantCount {
+				t.Errorf("count = %q, want %q", count, tt.wantCount)
+			}
+			if tt.wantCount != "" {
+				if _, ok := last.GetLabel("first_seen"); !ok {
+					t.Error("missing first_seen label")
+				}
+				if _, ok := last.GetLabel("last_seen"); !ok {
+					t.Error("missing last_seen label")
+				}
+			}
+		})
+	}
+}
+
+func TestDedupPolicyValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DedupPolicy
+	}{
+		{"unknown field", DedupPolicy{Fields: []string{"colour"}}},
+		{"timestamp field", DedupPolicy{Fields: []string{"timestamp"}}},
+		{"invalid mode", DedupPolicy{Fields: []string{"source_ip"}, Mode: "tumbling"}},
+		{"negative window", DedupPolicy{Fields: []string{"source_ip"}, Window: -time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDeduplicator(newMemoryCache(), DedupConfig{Default: tt.policy}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestSetDedupConfigWhileProcessing(t *testing.T) {
+	processor, events, _ := newTestProcessor(&stubDetector{})
+	pipeline := NewPipeline(processor, PipelineConfig{Workers: 4, Writers: 2, BatchSize: 8, FlushInterval: time.Millisecond, SubmitTimeout: time.Minute})
+	pipeline.Start(context.Background())
+
+	const submitters, logs = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, submitters*logs)
+	for s := 0; s < submitters; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for i := 0; i < logs; i++ {
+				if _, err := pipeline.ProcessLog(context.Background(), testLog(s*logs+i)); err != nil {
+					errs <- err
+				}
+			}
+		}(s)
+	}
+
+	// Policies are replaced while the workers observe events
+	for i := 0; i < 20; i++ {
+		policy := DedupPolicy{Fields: []string{"source_ip", "event_type"}, Window: time.Duration(i+1) * time.Minute}
+		if err := processor.SetDedupConfig(DedupConfig{Default: policy}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	pipeline.Stop()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if window := processor.Deduplicator().Policy("json").Window; window != 20*time.Minute {
+		t.Errorf("window = %v, want %v", window, 20*time.Minute)
+	}
+	if events.saves != submitters*logs {
+		t.Errorf("saved %d events, want %d", events.saves, submitters*logs)
+	}
+}
@@ -2,162 +2,301 @@ package log
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/repository"
 )
 
 // LogEnricher enriches security events with additional context
 type LogEnricher struct {
 	geoIPDB      GeoIPDatabase
 	reputationDB ThreatDB
-	cache        map[string]interface{}
-	mutex        sync.RWMutex
+	assets       AssetInventory
+	users        UserDirectory
+	// cache is replaced by SetCacheConfig while lookups may be running
+	cache   atomic.Pointer[enrichmentCache]
+	scoring *ScoringPolicy
+
+	// chainMutex serialises changes of the chain; the built chain is
+	// swapped in whole so that running enrichments keep their stages
+	chainMutex    sync.Mutex
+	enrichers     map[string]Enricher
+	enricherOrder []string
+	chainConfig   EnrichmentChainConfig
+	stages        atomic.Pointer[[]enrichmentStage]
 }
 
 // NewLogEnricher creates a new log enricher
 func NewLogEnricher(geoIP GeoIPDatabase, threatDB ThreatDB) *LogEnricher {
-	return &LogEnricher{
+	cache, _ := newEnrichmentCache(DefaultEnrichmentCacheConfig(), nil)
+	e := &LogEnricher{
 		geoIPDB:      geoIP,
 		reputationDB: threatDB,
-		cache:        make(map[string]interface{}),
+		scoring:      DefaultScoringPolicy(),
+		enrichers:    make(map[string]Enricher),
+	}
+	e.cache.Store(cache)
+
+	// Built-in stages
+	e.RegisterEnricher(enricherFunc{EnricherGeoIP, e.enrichGeo})
+	e.RegisterEnricher(enricherFunc{EnricherReputation, e.enrichReputation})
+	e.RegisterEnricher(enricherFunc{EnricherAsset, e.enrichAssetInfo})
+	e.RegisterEnricher(enricherFunc{EnricherIdentity, e.enrichUserInfo})
+	e.SetChainConfig(DefaultEnrichmentChainConfig())
+	return e
+}
+
+// SetCacheConfig replaces the lookup caches. The shared repository is only
+// used when the configuration enables sharing. Cached results are dropped;
+// lookups in flight finish with the previous caches.
+func (e *LogEnricher) SetCacheConfig(config EnrichmentCacheConfig, shared repository.CacheRepository) error {
+	cache, err := newEnrichmentCache(config, shared)
+	if err != nil {
+		return err
+	}
+	e.cache.Store(cache)
+	return nil
+}
+
+// SetAssetInventory enables asset enrichment of internal addresses and of
+// the reporting host
+func (e *LogEnricher) SetAssetInventory(assets AssetInventory) {
+	e.assets = assets
+}
+
+// SetUserDirectory enables identity enrichment of the user field
+func (e *LogEnricher) SetUserDirectory(users UserDirectory) {
+	e.users = users
+}
+
+// SetScoringPolicy replaces the policy deriving event severity
+func (e *LogEnricher) SetScoringPolicy(policy *ScoringPolicy) error {
+	if policy == nil {
+		return errors.New("scoring policy must not be nil")
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	e.scoring = policy
+	return nil
+}
+
+// Score derives the severity of an event from its enrichment and the given
+// detection results, replacing any earlier score
+func (e *LogEnricher) Score(event *entity.SecurityEvent, input ScoreInput) *entity.SeverityScore {
+	return e.scoring.Score(event, input)
+}
+
+// CacheStats returns the hit and miss counts of the lookup caches
+func (e *LogEnricher) CacheStats() EnrichmentCacheStats {
+	cache := e.cache.Load()
+	return EnrichmentCacheStats{
+		Geo:        cache.geo.stats(),
+		Reputation: cache.reputation.stats(),
 	}
 }
 
-// Enrich adds additional context to a security event
+// Enrich adds additional context to a security event. Only stages with the
+// fail policy can make it return an error.
 func (e *LogEnricher) Enrich(ctx context.Context, event *entity.SecurityEvent) error {
-	// Enrich IP information
-	if event.SourceIP != "" {
-		if err := e.enrichIPInfo(ctx, event, event.SourceIP, "source"); err != nil {
-			return err
-		}
+	// Run the enrichment chain
+	if err := e.runChain(ctx, event); err != nil {
+		return err
 	}
 
+	// Score severity based on enriched data
+	e.scoring.Score(event, ScoreInput{})
+
+	return nil
+}
+
+// eventIPs returns the source and destination addresses of an event with
+// the label prefix of each
+func eventIPs(event *entity.SecurityEvent) [][2]string {
+	var ips [][2]string
+	if event.SourceIP != "" {
+		ips = append(ips, [2]string{event.SourceIP, "source"})
+	}
 	if event.DestIP != "" {
-		if err := e.enrichIPInfo(ctx, event, event.DestIP, "dest"); err != nil {
+		ips = append(ips, [2]string{event.DestIP, "dest"})
+	}
+	return ips
+}
+
+// enrichGeo adds geographical data for the source and destination IPs
+func (e *LogEnricher) enrichGeo(ctx context.Context, event *entity.SecurityEvent) error {
+	if e.geoIPDB == nil {
+		return ErrEnricherUnavailable
+	}
+	for _, ip := range eventIPs(event) {
+		geoData, err := e.lookupGeo(ctx, ip[0])
+		if err != nil {
 			return err
 		}
+		prefix := ip[1] + "_"
+		setLabelIfPresent(event, prefix+"country", geoData.Country)
+		setLabelIfPresent(event, prefix+"city", geoData.City)
+		setLabelIfPresent(event, prefix+"asn", geoData.ASN)
 	}
+	return nil
+}
 
-	// Enrich user information
-	if event.User != "" {
-		if err := e.enrichUserInfo(ctx, event); err != nil {
+// enrichReputation adds threat intelligence for the source and destination
+// IPs. Addresses without intelligence get no labels.
+func (e *LogEnricher) enrichReputation(ctx context.Context, event *entity.SecurityEvent) error {
+	if e.reputationDB == nil {
+		return ErrEnricherUnavailable
+	}
+	for _, ip := range eventIPs(event) {
+		threatInfo, err := e.lookupReputation(ctx, ip[0])
+		if err != nil {
 			return err
 		}
+		prefix := ip[1] + "_"
+		if threatInfo.Score > 0 {
+			event.SetLabel(prefix+"reputation", strconv.FormatFloat(float64(threatInfo.Score), 'f', 2, 32))
+		}
+		setLabelIfPresent(event, prefix+"threat_last_seen", threatInfo.LastSeen)
+		for _, category := range threatInfo.Categories {
+			event.Labels = appendLabel(event.Labels, prefix+"category:"+category)
+		}
 	}
-
-	// Calculate severity based on enriched data
-	e.calculateSeverity(event)
-
 	return nil
 }
 
-// enrichIPInfo adds geographical and reputation data for an IP
-func (e *LogEnricher) enrichIPInfo(ctx context.Context, event *entity.SecurityEvent, ip string, direction string) error {
-	// Check cache first
-	cacheKey := "ip:" + ip
-	e.mutex.RLock()
-	if cached, ok := e.cache[cacheKey]; ok {
-		e.mutex.RUnlock()
-		e.applyIPInfo(event, cached.(IPInfo), direction)
-		return nil
+// appendLabel appends a label unless the event already has it
+func appendLabel(labels []string, label string) []string {
+	for _, existing := range labels {
+		if existing == label {
+			return labels
+		}
 	}
-	e.mutex.RUnlock()
+	return append(labels, label)
+}
 
-	// Get geolocation data
-	geoData, err := e.geoIPDB.Lookup(ip)
-	if err != nil {
-		return err
+// enrichAssetInfo adds the inventory records of the source, destination and
+// reporting host. The highest criticality among them is exposed as the
+// asset_criticality label for severity scoring and rules.
+func (e *LogEnricher) enrichAssetInfo(ctx context.Context, event *entity.SecurityEvent) error {
+	if e.assets == nil {
+		return ErrEnricherUnavailable
 	}
 
-	// Get threat intelligence data
-	threatInfo, err := e.reputationDB.LookupIP(ctx, ip)
-	if err != nil {
-		return err
+	highest := ""
+	apply := func(asset *AssetInfo, prefix string) {
+		if asset == nil {
+			return
+		}
+		setLabelIfPresent(event, prefix+"_asset_id", asset.ID)
+		setLabelIfPresent(event, prefix+"_asset_hostname", asset.Hostname)
+		setLabelIfPresent(event, prefix+"_asset_owner", asset.Owner)
+		setLabelIfPresent(event, prefix+"_asset_system", asset.BusinessSystem)
+		setLabelIfPresent(event, prefix+"_asset_env", asset.Environment)
+		setLabelIfPresent(event, prefix+"_asset_criticality", asset.Criticality)
+		setLabelIfPresent(event, prefix+"_asset_zone", asset.NetworkZone)
+		if entity.CriticalityRank(asset.Criticality) > entity.CriticalityRank(highest) {
+			highest = asset.Criticality
+		}
 	}
 
-	// Combine information
-	ipInfo := IPInfo{
-		Country:    geoData.Country,
-		City:       geoData.City,
-		ASN:        geoData.ASN,
-		Reputation: threatInfo.Score,
-		Categories: threatInfo.Categories,
-		LastSeen:   threatInfo.LastSeen,
+	for _, subject := range []struct{ ip, prefix string }{
+		{event.SourceIP, "source"},
+		{event.DestIP, "dest"},
+	} {
+		if subject.ip == "" {
+			continue
+		}
+		asset, err := e.assets.LookupIP(ctx, subject.ip)
+		if err != nil {
+			return err
+		}
+		apply(asset, subject.prefix)
 	}
 
-	// Cache the result
-	e.mutex.Lock()
-	e.cache[cacheKey] = ipInfo
-	e.mutex.Unlock()
-
-	// Apply the information to the event
-	e.applyIPInfo(event, ipInfo, direction)
+	if host, ok := event.GetLabel("host"); ok && host != "" {
+		asset, err := e.assets.LookupHost(ctx, host)
+		if err != nil {
+			return err
+		}
+		apply(asset, "host")
+	}
 
+	if highest != "" {
+		event.SetLabel("asset_criticality", highest)
+	}
 	return nil
 }
 
-// calculateSeverity determines event severity based on enriched data
-func (e *LogEnricher) calculateSeverity(event *entity.SecurityEvent) {
-	// Start with a base score
-	score := 0.0
-
-	// Check IP reputation
-	if strings.Contains(event.Labels["source_reputation"], "malicious") {
-		score += 0.4
+// enrichUserInfo adds the directory record of the event user, so that rules
+// can target privileged or disabled accounts
+func (e *LogEnricher) enrichUserInfo(ctx context.Context, event *entity.SecurityEvent) error {
+	if e.users == nil {
+		return ErrEnricherUnavailable
 	}
-	if strings.Contains(event.Labels["dest_reputation"], "malicious") {
-		score += 0.3
+	if event.User == "" {
+		return nil
 	}
 
-	// Check action type
-	switch strings.ToLower(event.Action) {
-	case "block", "deny", "alert":
-		score += 0.2
-	case "warning":
-		score += 0.1
+	user, err := e.users.LookupUser(ctx, event.User)
+	if err != nil {
+		return err
 	}
-
-	// Set severity based on final score
-	switch {
-	case score >= 0.7:
-		event.Severity = "critical"
-	case score >= 0.5:
-		event.Severity = "high"
-	case score >= 0.3:
-		event.Severity = "medium"
-	default:
-		event.Severity = "low"
+	if user == nil {
+		event.SetLabel("user_known", "false")
+		return nil
 	}
-}
 
-// IPInfo represents enriched IP address information
-type IPInfo struct {
-	Country    string
-	City       string
-	ASN        string
-	Reputation float32
-	Categories []string
-	LastSeen   string
+	event.SetLabel("user_known", "true")
+	setLabelIfPresent(event, "user_display_name", user.DisplayName)
+	setLabelIfPresent(event, "user_email", user.Email)
+	setLabelIfPresent(event, "user_department", user.Department)
+	setLabelIfPresent(event, "user_title", user.Title)
+	setLabelIfPresent(event, "user_manager", user.Manager)
+	setLabelIfPresent(event, "user_status", user.Status)
+	event.SetLabel("user_privileged", strconv.FormatBool(user.Privileged))
+	// One label per group, so that label conditions can match any group
+	for _, group := range user.Groups {
+		event.Labels = appendLabel(event.Labels, "user_group:"+group)
+	}
+	return nil
 }
 
-// Apply IP information to the event
-func (e *LogEnricher) applyIPInfo(event *entity.SecurityEvent, info IPInfo, direction string) {
-	prefix := direction + "_"
+// lookupGeo returns the geolocation of an IP, from the cache when possible.
+// Addresses the database does not know are cached as negative entries.
+func (e *LogEnricher) lookupGeo(ctx context.Context, ip string) (*GeoData, error) {
+	cache := e.cache.Load()
+	if cached, _, ok := cache.geo.get(ctx, ip); ok {
+		return &cached, nil
+	}
 
-	// Add labels
-	if event.Labels == nil {
-		event.Labels = make([]string, 0)
+	geoData, err := e.geoIPDB.Lookup(ip)
+	if err != nil {
+		return nil, err
 	}
+	negative := geoData.Country == "" && geoData.City == "" && geoData.ASN == ""
+	// A failing shared cache only costs a repeated lookup
+	cache.geo.set(ctx, ip, *geoData, negative)
+	return geoData, nil
+}
 
-	event.Labels = append(event.Labels,
-		prefix+"country:"+info.Country,
-		prefix+"city:"+info.City,
-		prefix+"asn:"+info.ASN,
-	)
+// lookupReputation returns the threat intelligence of an IP, from the cache
+// when possible. Addresses without intelligence are cached as negative
+// entries.
+func (e *LogEnricher) lookupReputation(ctx context.Context, ip string) (*ThreatInfo, error) {
+	cache := e.cache.Load()
+	if cached, _, ok := cache.reputation.get(ctx, ip); ok {
+		return &cached, nil
+	}
 
-	for _, category := range info.Categories {
-		event.Labels = append(event.Labels, prefix+"category:"+category)
+	threatInfo, err := e.reputationDB.LookupIP(ctx, ip)
+	if err != nil {
+		return nil, err
 	}
+	negative := threatInfo.Score == 0 && len(threatInfo.Categories) == 0 && len(threatInfo.References) == 0
+	cache.reputation.set(ctx, ip, *threatInfo, negative)
+	return threatInfo, nil
 }
@@ -0,0 +1,245 @@
+package log
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/repository"
+)
+
+// EnrichmentCacheConfig bounds the caches of enrichment lookups
+type EnrichmentCacheConfig struct {
+	// MaxEntries bounds each cache; the least recently used entries are
+	// evicted first
+	MaxEntries int `json:"max_entries" yaml:"max_entries"`
+	// GeoTTL is how long geolocation results are kept
+	GeoTTL time.Duration `json:"geo_ttl" yaml:"geo_ttl"`
+	// ReputationTTL is how long threat intelligence results are kept.
+	// Reputation changes faster than location, so it is usually shorter.
+	ReputationTTL time.Duration `json:"reputation_ttl" yaml:"reputation_ttl"`
+	// NegativeTTL is how long addresses unknown to a database are kept
+	NegativeTTL time.Duration `json:"negative_ttl" yaml:"negative_ttl"`
+	// Shared also keeps results in the cache repository, so that instances
+	// share their lookups
+	Shared bool `json:"shared" yaml:"shared"`
+}
+
+// DefaultEnrichmentCacheConfig returns the default cache configuration
+func DefaultEnrichmentCacheConfig() EnrichmentCacheConfig {
+	return EnrichmentCacheConfig{
+		MaxEntries:    100000,
+		GeoTTL:        24 * time.Hour,
+		ReputationTTL: time.Hour,
+		NegativeTTL:   10 * time.Minute,
+	}
+}
+
+// CacheStats counts the lookups of one cache
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negative_hits"`
+	SharedHits   uint64 `json:"shared_hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Expirations  uint64 `json:"expirations"`
+	Entries      int    `json:"entries"`
+}
+
+// EnrichmentCacheStats are the statistics of the geolocation and reputation
+// caches
+type EnrichmentCacheStats struct {
+	Geo        CacheStats `json:"geo"`
+	Reputation CacheStats `json:"reputation"`
+}
+
+// cachedValue is a cache entry. Negative entries record that the database
+// had nothing for the key.
+type cachedValue[V any] struct {
+	Key      string    `json:"-"`
+	Value    V         `json:"value"`
+	Negative bool      `json:"negative,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// lruCache is a size bounded cache with per-entry expiry, optionally backed
+// by a shared cache repository
+type lruCache[V any] struct {
+	name        string
+	maxEntries  int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	shared      repository.CacheRepository
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+
+	hits, negativeHits, sharedHits, misses, evictions, expirations atomic.Uint64
+}
+
+func newLRUCache[V any](name string, maxEntries int, ttl, negativeTTL time.Duration, shared repository.CacheRepository) *lruCache[V] {
+	return &lruCache[V]{
+		name:        name,
+		maxEntries:  maxEntries,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		shared:      shared,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// get returns a cached value and whether it is a negative entry
+func (c *lruCache[V]) get(ctx context.Context, key string) (V, bool, bool) {
+	now := time.Now()
+
+	c.mutex.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cachedValue[V])
+		if now.Before(entry.Expires) {
+			c.order.MoveToFront(element)
+			c.mutex.Unlock()
+			c.countHit(entry.Negative)
+			return entry.Value, entry.Negative, true
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.expirations.Add(1)
+	}
+	c.mutex.Unlock()
+
+	if entry, ok := c.getShared(ctx, key, now); ok {
+		c.store(entry)
+		c.sharedHits.Add(1)
+		c.countHit(entry.Negative)
+		return entry.Value, entry.Negative, true
+	}
+
+	c.misses.Add(1)
+	var zero V
+	return zero, false, false
+}
+
+func (c *lruCache[V]) countHit(negative bool) {
+	if negative {
+		c.negativeHits.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+}
+
+func (c *lruCache[V]) getShared(ctx context.Context, key string, now time.Time) (*cachedValue[V], bool) {
+	if c.shared == nil {
+		return nil, false
+	}
+	value, err := c.shared.Get(ctx, c.sharedKey(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return nil, false
+	}
+
+	var entry cachedValue[V]
+	if err := json.Unmarshal(data, &entry); err != nil || !now.Before(entry.Expires) {
+		return nil, false
+	}
+	entry.Key = key
+	return &entry, true
+}
+
+// set caches a value. Negative entries expire after the negative TTL.
+func (c *lruCache[V]) set(ctx context.Context, key string, value V, negative bool) error {
+	ttl := c.ttl
+	if negative {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	entry := &cachedValue[V]{Key: key, Value: value, Negative: negative, Expires: time.Now().Add(ttl)}
+	c.store(entry)
+
+	if c.shared == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.shared.Set(ctx, c.sharedKey(key), string(data), ttl)
+}
+
+func (c *lruCache[V]) store(entry *cachedValue[V]) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[entry.Key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.Key] = c.order.PushFront(entry)
+
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedValue[V]).Key)
+		c.evictions.Add(1)
+	}
+}
+
+func (c *lruCache[V]) sharedKey(key string) string {
+	return "enrich:" + c.name + ":" + key
+}
+
+func (c *lruCache[V]) stats() CacheStats {
+	c.mutex.Lock()
+	entries := c.order.Len()
+	c.mutex.Unlock()
+
+	return CacheStats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		SharedHits:   c.sharedHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Expirations:  c.expirations.Load(),
+		Entries:      entries,
+	}
+}
+
+// enrichmentCache holds the geolocation and reputation caches of an enricher
+type enrichmentCache struct {
+	geo        *lruCache[GeoData]
+	reputation *lruCache[ThreatInfo]
+}
+
+func newEnrichmentCache(config EnrichmentCacheConfig, shared repository.CacheRepository) (*enrichmentCache, error) {
+	if config.MaxEntries < 0 || config.GeoTTL < 0 || config.ReputationTTL < 0 || config.NegativeTTL < 0 {
+		return nil, errors.New("enrichment cache limits must not be negative")
+	}
+	if !config.Shared {
+		shared = nil
+	} else if shared == nil {
+		return nil, errors.New("shared enrichment cache requires a cache repository")
+	}
+
+	return &enrichmentCache{
+		geo:        newLRUCache[GeoData]("geo", config.MaxEntries, config.GeoTTL, config.NegativeTTL, shared),
+		reputation: newLRUCache[ThreatInfo]("reputation", config.MaxEntries, config.ReputationTTL, config.NegativeTTL, shared),
+	}, nil
+}
@@ -0,0 +1,245 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// Enrichment error policies
+const (
+	// EnrichFail aborts processing of the event
+	EnrichFail = "fail"
+	// EnrichSkip continues without recording the failure
+	EnrichSkip = "skip"
+	// EnrichAnnotate continues and records the failure in event labels
+	EnrichAnnotate = "annotate"
+)
+
+// Built-in enrichment stages
+const (
+	EnricherGeoIP      = "geoip"
+	EnricherReputation = "reputation"
+	EnricherAsset      = "asset"
+	EnricherIdentity   = "identity"
+)
+
+// ErrEnricherUnavailable is returned by enrichers whose data source is not
+// configured. Such stages count neither as succeeded nor as failed.
+var ErrEnricherUnavailable = errors.New("enricher data source not configured")
+
+// Enricher is one stage of the enrichment chain
+type Enricher interface {
+	// Name identifies the stage in the chain configuration and event labels
+	Name() string
+	// Enrich adds context to an event
+	Enrich(ctx context.Context, event *entity.SecurityEvent) error
+}
+
+// EnricherStageConfig configures one stage of the chain
+type EnricherStageConfig struct {
+	Name     string `json:"name" yaml:"name"`
+	Disabled bool   `json:"disabled" yaml:"disabled"`
+	// Timeout bounds the stage. Zero runs it without a time limit.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+	// OnError is the error policy: fail, skip or annotate (default)
+	OnError string `json:"on_error" yaml:"on_error"`
+}
+
+// EnrichmentChainConfig orders the enrichment stages. Registered enrichers
+// missing from the list run after the listed ones with default settings.
+type EnrichmentChainConfig struct {
+	Stages []EnricherStageConfig `json:"stages" yaml:"stages"`
+}
+
+// DefaultEnrichmentChainConfig returns the built-in stages with timeouts
+// sized for local databases and a possibly remote reputation service
+func DefaultEnrichmentChainConfig() EnrichmentChainConfig {
+	return EnrichmentChainConfig{
+		Stages: []EnricherStageConfig{
+			{Name: EnricherGeoIP, Timeout: 200 * time.Millisecond, OnError: EnrichAnnotate},
+			{Name: EnricherReputation, Timeout: time.Second, OnError: EnrichAnnotate},
+			{Name: EnricherAsset, Timeout: 200 * time.Millisecond, OnError: EnrichAnnotate},
+			{Name: EnricherIdentity, Timeout: 500 * time.Millisecond, OnError: EnrichAnnotate},
+		},
+	}
+}
+
+// enricherFunc adapts a method of the log enricher to a stage
+type enricherFunc struct {
+	name string
+	fn   func(ctx context.Context, event *entity.SecurityEvent) error
+}
+
+func (f enricherFunc) Name() string { return f.name }
+
+func (f enricherFunc) Enrich(ctx context.Context, event *entity.SecurityEvent) error {
+	return f.fn(ctx, event)
+}
+
+// enrichmentStage is a configured stage of the chain
+type enrichmentStage struct {
+	enricher Enricher
+	config   EnricherStageConfig
+}
+
+// RegisterEnricher adds an enrichment plugin. Unless the chain
+// configuration places it, it runs after the configured stages.
+func (e *LogEnricher) RegisterEnricher(enricher Enricher) error {
+	name := enricher.Name()
+	if name == "" {
+		return errors.New("enricher name must not be empty")
+	}
+
+	e.chainMutex.Lock()
+	defer e.chainMutex.Unlock()
+
+	if _, exists := e.enrichers[name]; exists {
+		return fmt.Errorf("enricher %q already registered", name)
+	}
+	e.enrichers[name] = enricher
+	e.enricherOrder = append(e.enricherOrder, name)
+
+	stages, err := e.buildChain(e.chainConfig)
+	if err != nil {
+		return err
+	}
+	e.stages.Store(&stages)
+	return nil
+}
+
+// SetChainConfig replaces the order, timeouts and error policies of the
+// enrichment stages
+func (e *LogEnricher) SetChainConfig(config EnrichmentChainConfig) error {
+	e.chainMutex.Lock()
+	defer e.chainMutex.Unlock()
+
+	stages, err := e.buildChain(config)
+	if err != nil {
+		return err
+	}
+	e.chainConfig = config
+	e.stages.Store(&stages)
+	return nil
+}
+
+// buildChain resolves a configuration into stages. The caller holds
+// chainMutex.
+func (e *LogEnricher) buildChain(config EnrichmentChainConfig) ([]enrichmentStage, error) {
+	var stages []enrichmentStage
+	placed := make(map[string]bool)
+	for i, stage := range config.Stages {
+		enricher, ok := e.enrichers[stage.Name]
+		if !ok {
+			return nil, fmt.Errorf("stages[%d]: unknown enricher %q", i, stage.Name)
+		}
+		if placed[stage.Name] {
+			return nil, fmt.Errorf("stages[%d]: enricher %q listed twice", i, stage.Name)
+		}
+		placed[stage.Name] = true
+
+		switch stage.OnError {
+		case "":
+			stage.OnError = EnrichAnnotate
+		case EnrichFail, EnrichSkip, EnrichAnnotate:
+		default:
+			return nil, fmt.Errorf("stages[%d]: unknown error policy %q", i, stage.OnError)
+		}
+		if stage.Timeout < 0 {
+			return nil, fmt.Errorf("stages[%d]: timeout must not be negative", i)
+		}
+		if !stage.Disabled {
+			stages = append(stages, enrichmentStage{enricher: enricher, config: stage})
+		}
+	}
+
+	for _, name := range e.enricherOrder {
+		if !placed[name] {
+			stages = append(stages, enrichmentStage{
+				enricher: e.enrichers[name],
+				config:   EnricherStageConfig{Name: name, OnError: EnrichAnnotate},
+			})
+		}
+	}
+	return stages, nil
+}
+
+// runChain runs the enrichment stages in order. The stages that succeeded
+// are recorded in the "enrichments" label; with the annotate policy failed
+// stages are recorded in "enrichment_failed" and "enrichment_error.<name>".
+func (e *LogEnricher) runChain(ctx context.Context, event *entity.SecurityEvent) error {
+	var succeeded, failed []string
+	for _, stage := range *e.stages.Load() {
+		err := stage.run(ctx, event)
+		switch {
+		case err == nil:
+			succeeded = append(succeeded, stage.config.Name)
+		case errors.Is(err, ErrEnricherUnavailable):
+		case stage.config.OnError == EnrichFail:
+			return fmt.Errorf("enrichment %s: %w", stage.config.Name, err)
+		case stage.config.OnError == EnrichAnnotate:
+			failed = append(failed, stage.config.Name)
+			event.SetLabel("enrichment_error."+stage.config.Name, err.Error())
+		}
+	}
+
+	if len(succeeded) > 0 {
+		event.SetLabel("enrichments", strings.Join(succeeded, ","))
+	}
+	if len(failed) > 0 {
+		event.SetLabel("enrichment_failed", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// run runs a stage within its timeout. The stage works on a copy of the
+// event that only replaces it on success, so a failed stage leaves no
+// partial changes and a stage still running after its deadline cannot
+// modify the event.
+func (s *enrichmentStage) run(ctx context.Context, event *entity.SecurityEvent) error {
+	work := *event
+	work.Labels = append([]string(nil), event.Labels...)
+
+	if s.config.Timeout <= 0 {
+		err := safeEnrich(ctx, s.enricher, &work)
+		if err == nil {
+			*event = work
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- safeEnrich(ctx, s.enricher, &work)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			*event = work
+		}
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", s.config.Timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// safeEnrich turns a panicking plugin into a failed stage
+func safeEnrich(ctx context.Context, enricher Enricher, event *entity.SecurityEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("enricher panic: %v", r)
+		}
+	}()
+	return enricher.Enrich(ctx, event)
+}
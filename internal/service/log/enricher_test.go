@@ -0,0 +1,115 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// countingGeoIP resolves every address to the same place and counts lookups
+type countingGeoIP struct {
+	lookups atomic.Int64
+}
+
+func (g *countingGeoIP) Lookup(ip string) (*GeoData, error) {
+	g.lookups.Add(1)
+	return &GeoData{Country: "GB", City: "London", ASN: "AS20712"}, nil
+}
+
+func TestLogEnricherGeoCache(t *testing.T) {
+	geo := &countingGeoIP{}
+	enricher := NewLogEnricher(geo, emptyIntel{})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		event := entity.NewSecurityEvent()
+		event.SourceIP = "81.2.69.142"
+		if err := enricher.Enrich(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		if country, _ := event.GetLabel("source_country"); country != "GB" {
+			t.Fatalf("source_country = %q", country)
+		}
+	}
+	if got := geo.lookups.Load(); got != 1 {
+		t.Errorf("lookups = %d, want 1", got)
+	}
+	if stats := enricher.CacheStats(); stats.Geo.Hits != 2 || stats.Geo.Misses != 1 {
+		t.Errorf("geo stats = %+v", stats.Geo)
+	}
+
+	// A new cache configuration drops cached results
+	if err := enricher.SetCacheConfig(DefaultEnrichmentCacheConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	event := entity.NewSecurityEvent()
+	event.SourceIP = "81.2.69.142"
+	enricher.Enrich(ctx, event)
+	if got := geo.lookups.Load(); got != 2 {
+		t.Errorf("lookups after reconfiguration = %d, want 2", got)
+	}
+}
+
+// namedEnricher sets a label named after itself
+type namedEnricher string
+
+func (n namedEnricher) Name() string { return string(n) }
+
+func (n namedEnricher) Enrich(ctx context.Context, event *entity.SecurityEvent) error {
+	event.SetLabel(string(n), "yes")
+	return nil
+}
+
+func TestLogEnricherReconfigureWhileEnriching(t *testing.T) {
+	enricher := NewLogEnricher(&countingGeoIP{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	var enriched atomic.Int64
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				event := entity.NewSecurityEvent()
+				event.SourceIP = fmt.Sprintf("10.%d.%d.1", w, i%200)
+				if err := enricher.Enrich(ctx, event); err != nil {
+					t.Error(err)
+					return
+				}
+				enriched.Add(1)
+			}
+		}(w)
+	}
+	for enriched.Load() < 100 {
+		runtime.Gosched()
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := enricher.SetCacheConfig(DefaultEnrichmentCacheConfig(), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := enricher.RegisterEnricher(namedEnricher(fmt.Sprintf("plugin%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := enricher.SetChainConfig(DefaultEnrichmentChainConfig()); err != nil {
+			t.Fatal(err)
+		}
+		enricher.CacheStats()
+	}
+	cancel()
+	wg.Wait()
+
+	event := entity.NewSecurityEvent()
+	if err := enricher.Enrich(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := event.GetLabel("plugin49"); value != "yes" {
+		t.Errorf("registered plugin did not run: %v", event.Labels)
+	}
+}
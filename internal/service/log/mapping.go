@@ -0,0 +1,612 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"gopkg.in/yaml.v2"
+)
+
+// MappingFile is the YAML document holding declarative field mappings
+type MappingFile struct {
+	Mappings []*MappingSpec `yaml:"mappings" json:"mappings"`
+}
+
+// MappingSpec maps the fields of one JSON source type onto SecurityEvent.
+// Each spec becomes a parser named after the source type.
+type MappingSpec struct {
+	Name string `yaml:"name" json:"name"`
+	// Match selects lines of this source during format detection. All rules
+	// must hold. Without rules the mapping is only used when the source type
+	// is given explicitly.
+	Match   []MatchRule       `yaml:"match"   json:"match"`
+	Fields  []FieldMapping    `yaml:"fields"  json:"fields"`
+	Labels  map[string]string `yaml:"labels"  json:"labels"` // static labels added to every event
+	Samples []MappingSample   `yaml:"samples" json:"samples"`
+}
+
+// MatchRule checks a single field of a JSON line. Without Equals or Prefix
+// the field only has to be present.
+type MatchRule struct {
+	Path   string `yaml:"path"   json:"path"`
+	Equals string `yaml:"equals" json:"equals"`
+	Prefix string `yaml:"prefix" json:"prefix"`
+}
+
+// FieldMapping copies one source value into an event field
+type FieldMapping struct {
+	// Source is a JSONPath-like expression: $.actor.name, $.targets[0].id or
+	// $["dotted.key"]. It may be empty for constant fields using Default.
+	Source string `yaml:"source" json:"source"`
+	// Target is an event field (timestamp, source_ip, dest_ip, protocol, port,
+	// action, status, user, event_type, description, severity) or labels.<key>
+	Target string `yaml:"target" json:"target"`
+	// Type is the conversion applied: string, int, ip, lower, upper or
+	// timestamp. It defaults to the natural type of the target.
+	Type string `yaml:"type" json:"type"`
+	// Layouts are tried in order for timestamps. Besides Go layouts the names
+	// rfc3339, unix, unix_ms and unix_ns are accepted.
+	Layouts []string `yaml:"layouts" json:"layouts"`
+	// Values translates source values before conversion, e.g. SUCCESS: allow
+	Values   map[string]string `yaml:"values"   json:"values"`
+	Default  string            `yaml:"default"  json:"default"`
+	Required bool              `yaml:"required" json:"required"`
+}
+
+// MappingSample is an example line checked when the mapping is loaded.
+// Expect maps targets to their expected string values.
+type MappingSample struct {
+	Log    string            `yaml:"log"    json:"log"`
+	Expect map[string]string `yaml:"expect" json:"expect"`
+}
+
+// MappingError lists everything wrong with a mapping spec
+type MappingError struct {
+	Mapping  string
+	Problems []string
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("invalid mapping %q: %s", e.Mapping, strings.Join(e.Problems, "; "))
+}
+
+// mappingTargets lists the event fields a mapping may write with their default type
+var mappingTargets = map[string]string{
+	"timestamp":   "timestamp",
+	"source_ip":   "ip",
+	"dest_ip":     "ip",
+	"protocol":    "upper", // as emitted by the built-in parsers
+	"port":        "int",
+	"action":      "string",
+	"status":      "string",
+	"user":        "string",
+	"event_type":  "string",
+	"description": "string",
+	"severity":    "lower",
+}
+
+var mappingTypes = map[string]bool{
+	"string":    true,
+	"int":       true,
+	"ip":        true,
+	"lower":     true,
+	"upper":     true,
+	"timestamp": true,
+}
+
+const labelTargetPrefix = "labels."
+
+// MappingParser is a parser driven by a MappingSpec
+type MappingParser struct {
+	spec   *MappingSpec
+	match  []compiledMatch
+	fields []compiledField
+}
+
+type compiledMatch struct {
+	rule MatchRule
+	path jsonPath
+}
+
+type compiledField struct {
+	FieldMapping
+	path jsonPath
+}
+
+// LoadMappingFile reads and validates a YAML mapping file
+func LoadMappingFile(path string) ([]*MappingParser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mapping file: %v", err)
+	}
+	return LoadMappings(data)
+}
+
+// LoadMappings parses and validates YAML mapping specs. All specs are checked
+// and their samples run; the first invalid spec aborts loading.
+func LoadMappings(data []byte) ([]*MappingParser, error) {
+	var file MappingFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parse mapping file: %v", err)
+	}
+
+	parsers := make([]*MappingParser, 0, len(file.Mappings))
+	names := make(map[string]bool, len(file.Mappings))
+	for _, spec := range file.Mappings {
+		if names[spec.Name] {
+			return nil, &MappingError{Mapping: spec.Name, Problems: []string{"duplicate mapping name"}}
+		}
+		names[spec.Name] = true
+
+		parser, err := NewMappingParser(spec)
+		if err != nil {
+			return nil, err
+		}
+		parsers = append(parsers, parser)
+	}
+	return parsers, nil
+}
+
+// NewMappingParser validates a spec, compiles its paths and checks its samples
+func NewMappingParser(spec *MappingSpec) (*MappingParser, error) {
+	p := &MappingParser{spec: spec}
+	problems := make([]string, 0)
+
+	if spec.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	if len(spec.Fields) == 0 {
+		problems = append(problems, "at least one field is required")
+	}
+
+	for i, rule := range spec.Match {
+		path, err := compileJSONPath(rule.Path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("match %d: %v", i, err))
+			continue
+		}
+		p.match = append(p.match, compiledMatch{rule: rule, path: path})
+	}
+
+	for i, field := range spec.Fields {
+		compiled, err := compileField(field)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("field %d (%s): %v", i, field.Target, err))
+			continue
+		}
+		p.fields = append(p.fields, compiled)
+	}
+
+	for key := range spec.Labels {
+		if key == "" || strings.Contains(key, ":") {
+			problems = append(problems, fmt.Sprintf("invalid static label %q", key))
+		}
+	}
+
+	// Samples are only meaningful once the spec itself is valid
+	if len(problems) == 0 {
+		for i, sample := range spec.Samples {
+			if err := p.checkSample(sample); err != nil {
+				problems = append(problems, fmt.Sprintf("sample %d: %v", i, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &MappingError{Mapping: spec.Name, Problems: problems}
+	}
+	return p, nil
+}
+
+func compileField(field FieldMapping) (compiledField, error) {
+	compiled := compiledField{FieldMapping: field}
+
+	defaultType, ok := mappingTargets[field.Target]
+	if !ok {
+		if !strings.HasPrefix(field.Target, labelTargetPrefix) || len(field.Target) == len(labelTargetPrefix) {
+			return compiled, fmt.Errorf("unknown target %q", field.Target)
+		}
+		defaultType = "string"
+	}
+	if compiled.Type == "" {
+		compiled.Type = defaultType
+	}
+	if !mappingTypes[compiled.Type] {
+		return compiled, fmt.Errorf("unknown type %q", compiled.Type)
+	}
+	// Labels take any type; event fields need a conversion yielding their Go type
+	if ok && mappedKind(compiled.Type) != mappedKind(defaultType) {
+		return compiled, fmt.Errorf("type %q cannot be used for %s", compiled.Type, field.Target)
+	}
+	if compiled.Type == "timestamp" && len(compiled.Layouts) == 0 {
+		compiled.Layouts = []string{"rfc3339"}
+	}
+
+	if field.Source == "" {
+		if field.Default == "" {
+			return compiled, fmt.Errorf("either source or default is required")
+		}
+	} else {
+		path, err := compileJSONPath(field.Source)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.path = path
+	}
+
+	// A default must survive its own conversion
+	if field.Default != "" {
+		if _, err := convertMappedValue(compiled, field.Default); err != nil {
+			return compiled, fmt.Errorf("default: %v", err)
+		}
+	}
+	return compiled, nil
+}
+
+// checkSample parses a sample line and compares the expected values
+func (p *MappingParser) checkSample(sample MappingSample) error {
+	event, err := p.Parse(sample.Log)
+	if err != nil {
+		return err
+	}
+	for _, target := range sortedKeys(sample.Expect) {
+		want := sample.Expect[target]
+		got, ok := mappedFieldString(event, target)
+		if !ok {
+			return fmt.Errorf("unknown expected target %q", target)
+		}
+		if got != want {
+			return fmt.Errorf("%s: expected %q, got %q", target, want, got)
+		}
+	}
+	return nil
+}
+
+// Name returns the source type the mapping was written for
+func (p *MappingParser) Name() string {
+	return p.spec.Name
+}
+
+// Spec returns the mapping specification
+func (p *MappingParser) Spec() *MappingSpec {
+	return p.spec
+}
+
+// Detect reports a high confidence when all match rules hold
+func (p *MappingParser) Detect(rawLog string) float64 {
+	if len(p.match) == 0 {
+		return 0
+	}
+	trimmed := strings.TrimSpace(rawLog)
+	if !strings.HasPrefix(trimmed, "{") {
+		return 0
+	}
+	doc, err := decodeMappedJSON(trimmed)
+	if err != nil {
+		return 0
+	}
+	for _, m := range p.match {
+		value, ok := m.path.lookup(doc)
+		if !ok {
+			return 0
+		}
+		text := mappedValueString(value)
+		if m.rule.Equals != "" && text != m.rule.Equals {
+			return 0
+		}
+		if m.rule.Prefix != "" && !strings.HasPrefix(text, m.rule.Prefix) {
+			return 0
+		}
+	}
+	return 0.95
+}
+
+// Parse applies the mapping to a JSON line
+func (p *MappingParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	doc, err := decodeMappedJSON(strings.TrimSpace(rawLog))
+	if err != nil {
+		return nil, err
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+
+	for _, field := range p.fields {
+		text := ""
+		if field.path != nil {
+			if value, ok := field.path.lookup(doc); ok && value != nil {
+				text = mappedValueString(value)
+			}
+		}
+		if translated, ok := field.Values[text]; ok {
+			text = translated
+		}
+		if text == "" {
+			text = field.Default
+		}
+		if text == "" {
+			if field.Required {
+				return nil, fmt.Errorf("missing required field %s", field.Source)
+			}
+			continue
+		}
+
+		value, err := convertMappedValue(field, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", field.Source, err)
+		}
+		setMappedField(event, field.Target, value)
+	}
+
+	for _, key := range sortedKeys(p.spec.Labels) {
+		event.SetLabel(key, p.spec.Labels[key])
+	}
+	return event, nil
+}
+
+func decodeMappedJSON(raw string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	return doc, nil
+}
+
+// mappedValueString renders a decoded JSON value as text. Objects and arrays
+// are kept as compact JSON.
+func mappedValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+}
+
+// mappedKind groups conversion types by the Go type they produce
+func mappedKind(typ string) string {
+	switch typ {
+	case "timestamp", "int":
+		return typ
+	default:
+		return "string"
+	}
+}
+
+// convertMappedValue converts text according to the field type. Timestamps
+// are returned as time.Time, ints as int and everything else as string.
+func convertMappedValue(field compiledField, text string) (interface{}, error) {
+	switch field.Type {
+	case "int":
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", text)
+		}
+		return int(n), nil
+	case "ip":
+		if host, _, err := net.SplitHostPort(text); err == nil {
+			text = host
+		}
+		if net.ParseIP(text) == nil {
+			return nil, fmt.Errorf("invalid ip address %q", text)
+		}
+		return text, nil
+	case "lower":
+		return strings.ToLower(text), nil
+	case "upper":
+		return strings.ToUpper(text), nil
+	case "timestamp":
+		return parseMappedTime(text, field.Layouts)
+	default:
+		return text, nil
+	}
+}
+
+func parseMappedTime(text string, layouts []string) (time.Time, error) {
+	for _, layout := range layouts {
+		switch strings.ToLower(layout) {
+		case "rfc3339":
+			if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+				return t, nil
+			}
+		case "unix", "unix_ms", "unix_ns":
+			unit := time.Second
+			switch strings.ToLower(layout) {
+			case "unix_ms":
+				unit = time.Millisecond
+			case "unix_ns":
+				unit = time.Nanosecond
+			}
+			// Integers are converted exactly, fractions through float64
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				return time.Unix(0, n*int64(unit)), nil
+			}
+			if f, err := strconv.ParseFloat(text, 64); err == nil {
+				return time.Unix(0, int64(f*float64(unit))), nil
+			}
+		default:
+			if t, err := time.Parse(layout, text); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp %q matches none of the layouts %v", text, layouts)
+}
+
+func setMappedField(event *entity.SecurityEvent, target string, value interface{}) {
+	if strings.HasPrefix(target, labelTargetPrefix) {
+		text, ok := value.(string)
+		if !ok {
+			text = fmt.Sprintf("%v", value)
+			if t, isTime := value.(time.Time); isTime {
+				text = t.Format(time.RFC3339)
+			}
+		}
+		event.SetLabel(strings.TrimPrefix(target, labelTargetPrefix), text)
+		return
+	}
+
+	switch target {
+	case "timestamp":
+		event.Timestamp = value.(time.Time)
+	case "port":
+		event.Port = value.(int)
+	case "source_ip":
+		event.SourceIP = value.(string)
+	case "dest_ip":
+		event.DestIP = value.(string)
+	case "protocol":
+		event.Protocol = value.(string)
+	case "action":
+		event.Action = value.(string)
+	case "status":
+		event.Status = value.(string)
+	case "user":
+		event.User = value.(string)
+	case "event_type":
+		event.EventType = value.(string)
+	case "description":
+		event.Description = value.(string)
+	case "severity":
+		event.Severity = value.(string)
+	}
+}
+
+// mappedFieldString reads back a mapped field for sample checks
+func mappedFieldString(event *entity.SecurityEvent, target string) (string, bool) {
+	if strings.HasPrefix(target, labelTargetPrefix) {
+		value, _ := event.GetLabel(strings.TrimPrefix(target, labelTargetPrefix))
+		return value, true
+	}
+
+	switch target {
+	case "timestamp":
+		return event.Timestamp.UTC().Format(time.RFC3339Nano), true
+	case "port":
+		return strconv.Itoa(event.Port), true
+	case "source_ip":
+		return event.SourceIP, true
+	case "dest_ip":
+		return event.DestIP, true
+	case "protocol":
+		return event.Protocol, true
+	case "action":
+		return event.Action, true
+	case "status":
+		return event.Status, true
+	case "user":
+		return event.User, true
+	case "event_type":
+		return event.EventType, true
+	case "description":
+		return event.Description, true
+	case "severity":
+		return event.Severity, true
+	}
+	return "", false
+}
+
+// jsonPath is a compiled path of object keys and array indexes
+type jsonPath []jsonPathStep
+
+type jsonPathStep struct {
+	key   string
+	index int
+	array bool
+}
+
+// compileJSONPath compiles $.a.b[0]["c.d"] style expressions. The leading $
+// is optional.
+func compileJSONPath(expr string) (jsonPath, error) {
+	rest := strings.TrimSpace(expr)
+	if rest == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	rest = strings.TrimPrefix(rest, "$")
+
+	path := make(jsonPath, 0)
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in path %q", expr)
+			}
+			path = append(path, jsonPathStep{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in path %q", expr)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				path = append(path, jsonPathStep{key: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %q in path %q", inner, expr)
+			}
+			path = append(path, jsonPathStep{index: index, array: true})
+		default:
+			if len(path) > 0 {
+				return nil, fmt.Errorf("unexpected %q in path %q", rest[:1], expr)
+			}
+			// Bare first key without "$."
+			rest = "." + rest
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("path %q selects nothing", expr)
+	}
+	return path, nil
+}
+
+func (p jsonPath) lookup(doc interface{}) (interface{}, bool) {
+	current := doc
+	for _, step := range p {
+		if step.array {
+			list, ok := current.([]interface{})
+			if !ok || step.index >= len(list) {
+				return nil, false
+			}
+			current = list[step.index]
+			continue
+		}
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[step.key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
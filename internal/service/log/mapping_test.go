@@ -0,0 +1,153 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+const testMappingYAML = `
+mappings:
+  - name: okta
+    match:
+      - path: $.eventType
+        prefix: user.
+    fields:
+      - source: $.published
+        target: timestamp
+        layouts: [rfc3339]
+      - source: $.client.ipAddress
+        target: source_ip
+      - source: $.client.proto
+        target: protocol
+      - source: $.client.port
+        target: port
+      - source: $.actor.alternateId
+        target: user
+        required: true
+      - source: $.outcome.result
+        target: action
+        values:
+          SUCCESS: allow
+          FAILURE: deny
+      - source: $.eventType
+        target: event_type
+      - source: $["debugContext.requestId"]
+        target: labels.request_id
+    labels:
+      vendor: okta
+`
+
+func TestMappingParser(t *testing.T) {
+	parsers, err := LoadMappings([]byte(testMappingYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := parsers[0]
+
+	tests := []struct {
+		name    string
+		log     string
+		wantErr bool
+		check   func(t *testing.T, got map[string]string)
+	}{
+		{
+			name: "full record",
+			log:  `{"published":"2024-05-01T10:00:00Z","eventType":"user.session.start","actor":{"alternateId":"alice"},"client":{"ipAddress":"10.0.0.1","proto":"tcp","port":443},"outcome":{"result":"FAILURE"},"debugContext.requestId":"r-1"}`,
+			check: func(t *testing.T, got map[string]string) {
+				want := map[string]string{
+					"source_ip": "10.0.0.1", "protocol": "TCP", "port": "443", "user": "alice",
+					"action": "deny", "event_type": "user.session.start", "labels.request_id": "r-1",
+					"labels.vendor": "okta",
+				}
+				for field, value := range want {
+					if got[field] != value {
+						t.Errorf("%s = %q, want %q", field, got[field], value)
+					}
+				}
+			},
+		},
+		{
+			name: "integer port from string",
+			log:  `{"eventType":"user.x","actor":{"alternateId":"bob"},"client":{"port":" 22 "}}`,
+			check: func(t *testing.T, got map[string]string) {
+				if got["port"] != "22" {
+					t.Errorf("port = %q, want 22", got["port"])
+				}
+			},
+		},
+		{
+			name:    "fractional port is rejected",
+			log:     `{"eventType":"user.x","actor":{"alternateId":"bob"},"client":{"port":22.9}}`,
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric port is rejected",
+			log:     `{"eventType":"user.x","actor":{"alternateId":"bob"},"client":{"port":"ssh"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing required field",
+			log:     `{"eventType":"user.x"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid ip",
+			log:     `{"eventType":"user.x","actor":{"alternateId":"bob"},"client":{"ipAddress":"not-an-ip"}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := parser.Parse(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, field := range []string{"source_ip", "protocol", "port", "user", "action", "event_type", "labels.request_id", "labels.vendor"} {
+				got[field], _ = mappedFieldString(event, field)
+			}
+			tt.check(t, got)
+		})
+	}
+}
+
+func TestMappingParserTimestamp(t *testing.T) {
+	parsers, err := LoadMappings([]byte(testMappingYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := parsers[0].Parse(`{"published":"2024-05-01T10:00:00Z","eventType":"user.x","actor":{"alternateId":"bob"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC); !event.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %v, want %v", event.Timestamp, want)
+	}
+}
+
+func TestMappingDetect(t *testing.T) {
+	parsers, err := LoadMappings([]byte(testMappingYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		log  string
+		want bool
+	}{
+		{`{"eventType":"user.session.start"}`, true},
+		{`{"eventType":"system.x"}`, false},
+		{`not json`, false},
+	}
+	for _, tt := range tests {
+		if got := parsers[0].Detect(tt.log) > 0; got != tt.want {
+			t.Errorf("Detect(%s) = %v, want %v", tt.log, got, tt.want)
+		}
+	}
+}
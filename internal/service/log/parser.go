@@ -0,0 +1,271 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// Parser converts raw log lines of a particular format into security events
+type Parser interface {
+	// Name returns the name the parser is registered under
+	Name() string
+
+	// Detect returns a confidence between 0 and 1 that the raw log is in the
+	// parser's format. Zero means the parser does not recognise the line.
+	Detect(rawLog string) float64
+
+	// Parse converts the raw log into a security event
+	Parse(rawLog string) (*entity.SecurityEvent, error)
+}
+
+// SourceParser is implemented by parsers that keep state per log stream, such
+// as the column layout declared by a Zeek TSV header. The source names the
+// stream a line came from, for example the path of a tailed file.
+type SourceParser interface {
+	Parser
+	ParseSource(source, rawLog string) (*entity.SecurityEvent, error)
+}
+
+type sourceContextKey struct{}
+
+// WithSource returns a context whose logs come from the named stream
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceContextKey{}, source)
+}
+
+// SourceFromContext returns the stream the logs of a context come from, or ""
+// when unknown
+func SourceFromContext(ctx context.Context) string {
+	source, _ := ctx.Value(sourceContextKey{}).(string)
+	return source
+}
+
+// ErrSkipLine is returned by parsers for lines that carry no event, such as
+// the header lines of a Zeek TSV log
+var ErrSkipLine = errors.New("line carries no event")
+
+// ErrUnknownParser is returned when a log is parsed with an unregistered parser
+var ErrUnknownParser = errors.New("unknown parser")
+
+// DetectionError is returned when no registered parser recognises a log line
+type DetectionError struct {
+	Excerpt string   `json:"excerpt"`
+	Tried   []string `json:"tried"`
+}
+
+func (e *DetectionError) Error() string {
+	if len(e.Tried) == 0 {
+		return "no parsers registered"
+	}
+	return fmt.Sprintf("no parser recognised log %q (tried: %s)", e.Excerpt, strings.Join(e.Tried, ", "))
+}
+
+// ParseError is returned when the selected parser fails to parse a log line
+type ParseError struct {
+	Parser string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parser %s: %v", e.Parser, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParserRegistry holds the named parsers available to the log processor
+type ParserRegistry struct {
+	parsers map[string]Parser
+	order   []string
+	mutex   sync.RWMutex
+}
+
+// NewParserRegistry creates an empty parser registry
+func NewParserRegistry() *ParserRegistry {
+	return &ParserRegistry{
+		parsers: make(map[string]Parser),
+		order:   make([]string, 0),
+	}
+}
+
+// DefaultParserRegistry creates a registry with all built-in parsers registered
+func DefaultParserRegistry() *ParserRegistry {
+	registry := NewParserRegistry()
+	registry.MustRegister(NewJSONParser())
+	registry.MustRegister(NewSyslogParser())
+	registry.MustRegister(NewCEFParser())
+	registry.MustRegister(NewLEEFParser())
+	registry.MustRegister(NewSuricataParser())
+	registry.MustRegister(NewZeekParser())
+	registry.MustRegister(NewECSParser())
+	registry.MustRegister(NewOCSFParser())
+	return registry
+}
+
+// Register adds a parser to the registry. Names must be unique.
+func (r *ParserRegistry) Register(parser Parser) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name := parser.Name()
+	if name == "" {
+		return fmt.Errorf("parser name must not be empty")
+	}
+	if _, exists := r.parsers[name]; exists {
+		return fmt.Errorf("parser %s already registered", name)
+	}
+
+	r.parsers[name] = parser
+	r.order = append(r.order, name)
+	return nil
+}
+
+// MustRegister is like Register but panics on error
+func (r *ParserRegistry) MustRegister(parser Parser) {
+	if err := r.Register(parser); err != nil {
+		panic(err)
+	}
+}
+
+// Replace registers a parser, swapping out an existing parser of the same
+// name in place so its detection priority is kept
+func (r *ParserRegistry) Replace(parser Parser) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	name := parser.Name()
+	if name == "" {
+		return fmt.Errorf("parser name must not be empty")
+	}
+	if _, exists := r.parsers[name]; !exists {
+		r.order = append(r.order, name)
+	}
+	r.parsers[name] = parser
+	return nil
+}
+
+// Unregister removes a parser from the registry
+func (r *ParserRegistry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.parsers[name]; !exists {
+		return
+	}
+	delete(r.parsers, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Get returns the parser registered under the given name
+func (r *ParserRegistry) Get(name string) (Parser, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	parser, ok := r.parsers[name]
+	return parser, ok
+}
+
+// Names returns the registered parser names in sorted order
+func (r *ParserRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, len(r.order))
+	copy(names, r.order)
+	sort.Strings(names)
+	return names
+}
+
+// Detect picks the parser with the highest confidence for the raw log.
+// Ties are resolved in registration order.
+func (r *ParserRegistry) Detect(rawLog string) (Parser, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var best Parser
+	bestScore := 0.0
+	for _, name := range r.order {
+		parser := r.parsers[name]
+		if score := parser.Detect(rawLog); score > bestScore {
+			best = parser
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		tried := make([]string, len(r.order))
+		copy(tried, r.order)
+		return nil, &DetectionError{Excerpt: excerpt(rawLog, 64), Tried: tried}
+	}
+	return best, nil
+}
+
+// Parse detects the format of the raw log and parses it. The name of the
+// parser that handled the log is returned even when parsing fails.
+func (r *ParserRegistry) Parse(rawLog string) (*entity.SecurityEvent, string, error) {
+	return r.ParseSource("", rawLog)
+}
+
+// ParseSource is like Parse for a log read from the named stream
+func (r *ParserRegistry) ParseSource(source, rawLog string) (*entity.SecurityEvent, string, error) {
+	parser, err := r.Detect(rawLog)
+	if err != nil {
+		return nil, "", err
+	}
+	return parseWith(parser, source, rawLog)
+}
+
+// ParseWith parses the raw log with the named parser, skipping detection
+func (r *ParserRegistry) ParseWith(name, rawLog string) (*entity.SecurityEvent, error) {
+	return r.ParseWithSource(name, "", rawLog)
+}
+
+// ParseWithSource is like ParseWith for a log read from the named stream
+func (r *ParserRegistry) ParseWithSource(name, source, rawLog string) (*entity.SecurityEvent, error) {
+	parser, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownParser, name)
+	}
+	event, _, err := parseWith(parser, source, rawLog)
+	return event, err
+}
+
+func parseWith(parser Parser, source, rawLog string) (*entity.SecurityEvent, string, error) {
+	name := parser.Name()
+	var event *entity.SecurityEvent
+	var err error
+	if sourceParser, ok := parser.(SourceParser); ok {
+		event, err = sourceParser.ParseSource(source, rawLog)
+	} else {
+		event, err = parser.Parse(rawLog)
+	}
+	if err != nil {
+		return nil, name, &ParseError{Parser: name, Err: err}
+	}
+	if event.RawData == "" {
+		event.RawData = rawLog
+	}
+	event.SetLabel("parser", name)
+	return event, name, nil
+}
+
+// excerpt shortens a log line for inclusion in error messages
+func excerpt(s string, n int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,351 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// cefFields maps standard CEF extension keys onto SecurityEvent fields
+var cefFields = map[string]string{
+	"src":     "source_ip",
+	"dst":     "dest_ip",
+	"spt":     "source_port",
+	"dpt":     "dest_port",
+	"proto":   "protocol",
+	"act":     "action",
+	"suser":   "user",
+	"duser":   "dest_user",
+	"outcome": "status",
+	"msg":     "description",
+	"cat":     "event_type",
+	"rt":      "timestamp",
+}
+
+// CEFParser parses ArcSight Common Event Format messages, with or without a
+// syslog header in front of them
+type CEFParser struct {
+	syslog *SyslogParser
+}
+
+// NewCEFParser creates a new CEF parser
+func NewCEFParser() *CEFParser {
+	return &CEFParser{syslog: NewSyslogParser()}
+}
+
+// Name returns the parser name
+func (p *CEFParser) Name() string {
+	return "cef"
+}
+
+// Detect recognises lines containing a CEF header
+func (p *CEFParser) Detect(rawLog string) float64 {
+	idx := strings.Index(rawLog, "CEF:")
+	if idx < 0 || strings.Count(rawLog[idx:], "|") < 7 {
+		return 0
+	}
+	return 0.9
+}
+
+// Parse parses a CEF message into a SecurityEvent
+func (p *CEFParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	idx := strings.Index(rawLog, "CEF:")
+	if idx < 0 {
+		return nil, fmt.Errorf("missing CEF header")
+	}
+
+	header := splitEscaped(strings.TrimRight(rawLog[idx:], "\r\n"), '|', 8)
+	if len(header) < 7 {
+		return nil, fmt.Errorf("CEF header has %d fields, expected 7", len(header))
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+	event.EventType = "cef"
+	applySyslogPrefix(p.syslog, event, rawLog[:idx])
+
+	event.SetLabel("cef_version", strings.TrimPrefix(header[0], "CEF:"))
+	event.SetLabel("device_vendor", unescapeCEF(header[1]))
+	event.SetLabel("device_product", unescapeCEF(header[2]))
+	event.SetLabel("device_version", unescapeCEF(header[3]))
+	event.SetLabel("signature_id", unescapeCEF(header[4]))
+	event.Description = unescapeCEF(header[5])
+	event.Severity = applianceSeverity(unescapeCEF(header[6]))
+
+	if len(header) > 7 {
+		applyExtensions(event, parseCEFExtension(header[7]), cefFields, "")
+	}
+
+	return event, nil
+}
+
+// applySyslogPrefix takes the timestamp and host from a syslog header
+// preceding a CEF or LEEF payload
+func applySyslogPrefix(parser *SyslogParser, event *entity.SecurityEvent, prefix string) {
+	if strings.TrimSpace(prefix) == "" {
+		return
+	}
+	msg, err := parser.Decode(prefix)
+	if err != nil {
+		return
+	}
+	if !msg.Timestamp.IsZero() {
+		event.Timestamp = msg.Timestamp
+	}
+	if msg.Hostname != "" {
+		event.SetLabel("host", msg.Hostname)
+	}
+}
+
+// parseCEFExtension splits "key=value key2=value with spaces" into a map.
+// A value runs until the next unescaped "key=" token.
+func parseCEFExtension(s string) map[string]string {
+	type keyPos struct {
+		key        string
+		start, val int
+	}
+
+	keys := make([]keyPos, 0)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] != '=' {
+			continue
+		}
+		j := i
+		for j > 0 && isExtensionKeyChar(s[j-1]) {
+			j--
+		}
+		if j == i || (j > 0 && s[j-1] != ' ') {
+			continue
+		}
+		keys = append(keys, keyPos{key: s[j:i], start: j, val: i + 1})
+	}
+
+	ext := make(map[string]string, len(keys))
+	for n, k := range keys {
+		end := len(s)
+		if n+1 < len(keys) {
+			end = keys[n+1].start
+		}
+		ext[k.key] = unescapeCEF(strings.TrimRight(s[k.val:end], " "))
+	}
+	return ext
+}
+
+func isExtensionKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '[' || c == ']' || c == '-'
+}
+
+// splitEscaped splits s on sep into at most n fields, ignoring backslash-escaped separators
+func splitEscaped(s string, sep byte, n int) []string {
+	fields := make([]string, 0, n)
+	start := 0
+	for i := 0; i < len(s) && len(fields) < n-1; i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// unescapeCEF resolves the backslash escapes allowed in CEF and LEEF values
+func unescapeCEF(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// applyExtensions maps key/value attributes onto event fields using fieldMap.
+// Attributes without a mapping are kept as labels. Custom fields such as cs1
+// are labelled with the name from their companion cs1Label attribute.
+func applyExtensions(event *entity.SecurityEvent, ext map[string]string, fieldMap map[string]string, timeFormat string) {
+	for _, key := range sortedKeys(ext) {
+		value := ext[key]
+		if value == "" || strings.HasSuffix(key, "Label") && ext[strings.TrimSuffix(key, "Label")] != "" {
+			continue
+		}
+
+		switch fieldMap[key] {
+		case "source_ip":
+			event.SourceIP = value
+		case "dest_ip":
+			event.DestIP = value
+		case "source_port":
+			event.SetLabel("source_port", value)
+		case "dest_port":
+			if port, err := strconv.Atoi(value); err == nil {
+				event.Port = port
+			}
+		case "protocol":
+			event.Protocol = protocolName(value)
+		case "action":
+			event.Action = value
+		case "user":
+			event.User = value
+		case "dest_user":
+			if event.User == "" {
+				event.User = value
+			}
+			event.SetLabel("dest_user", value)
+		case "status":
+			event.Status = value
+		case "description":
+			event.Description = value
+		case "event_type":
+			event.EventType = value
+		case "severity":
+			event.Severity = applianceSeverity(value)
+		case "timestamp":
+			if t, err := parseApplianceTime(value, timeFormat); err == nil {
+				event.Timestamp = t
+			} else {
+				event.SetLabel(key, value)
+			}
+		default:
+			name := key
+			if label := ext[key+"Label"]; label != "" {
+				name = label
+			}
+			event.SetLabel(name, value)
+		}
+	}
+}
+
+// applianceSeverity maps a 0-10 numeric or named appliance severity onto the event severity scale
+func applianceSeverity(s string) string {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		switch {
+		case n >= 9:
+			return "critical"
+		case n >= 7:
+			return "high"
+		case n >= 4:
+			return "medium"
+		case n >= 1:
+			return "low"
+		default:
+			return "info"
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "very-high", "critical":
+		return "critical"
+	case "high":
+		return "high"
+	case "medium":
+		return "medium"
+	case "low":
+		return "low"
+	default:
+		return "info"
+	}
+}
+
+// protocolName converts IANA protocol numbers to names and normalises case
+func protocolName(s string) string {
+	switch strings.TrimSpace(s) {
+	case "1":
+		return "ICMP"
+	case "6":
+		return "TCP"
+	case "17":
+		return "UDP"
+	case "58":
+		return "IPV6-ICMP"
+	}
+	return strings.ToUpper(s)
+}
+
+// applianceTimeLayouts are the timestamp formats commonly sent by security appliances
+var applianceTimeLayouts = []string{
+	time.RFC3339Nano,
+	"Jan 02 2006 15:04:05.000 MST",
+	"Jan 02 2006 15:04:05 MST",
+	"Jan 02 2006 15:04:05.000",
+	"Jan 02 2006 15:04:05",
+	"Jan 02 15:04:05.000 MST",
+	"Jan 02 15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// parseApplianceTime parses epoch milliseconds, a Java SimpleDateFormat
+// layout if given, or one of the common appliance layouts
+func parseApplianceTime(value, javaLayout string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+
+	if javaLayout != "" {
+		if t, err := time.Parse(javaDateLayout(javaLayout), value); err == nil {
+			return t, nil
+		}
+	}
+
+	for _, layout := range applianceTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp: %s", value)
+}
+
+// javaDateTokens maps SimpleDateFormat tokens to Go layout fragments, longest first
+var javaDateTokens = []struct{ java, golang string }{
+	{"yyyy", "2006"}, {"yy", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"},
+	{"dd", "02"}, {"HH", "15"}, {"hh", "03"}, {"mm", "04"}, {"ss", "05"},
+	{"SSS", "000"}, {"zzz", "MST"}, {"Z", "-0700"}, {"a", "PM"},
+}
+
+// javaDateLayout converts a Java SimpleDateFormat pattern into a Go time layout
+func javaDateLayout(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		matched := false
+		for _, tok := range javaDateTokens {
+			if strings.HasPrefix(pattern[i:], tok.java) {
+				b.WriteString(tok.golang)
+				i += len(tok.java)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(pattern[i])
+			i++
+		}
+	}
+	return b.String()
+}
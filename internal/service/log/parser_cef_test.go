@@ -0,0 +1,89 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCEFParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       string
+		wantErr   bool
+		wantTime  time.Time
+		want      map[string]string
+		wantLabel map[string]string
+	}{
+		{
+			name: "extension with spaces and custom fields",
+			log:  `CEF:0|Acme|Firewall|1.0|100|Blocked connection|8|src=10.0.0.1 dst=10.0.0.2 dpt=443 spt=51000 proto=6 act=block suser=alice msg=Denied by policy 7 cs1Label=policy cs1=Inbound default`,
+			want: map[string]string{
+				"source_ip": "10.0.0.1", "dest_ip": "10.0.0.2", "port": "443", "protocol": "TCP",
+				"action": "block", "user": "alice", "description": "Denied by policy 7", "severity": "high",
+				"event_type": "cef",
+			},
+			wantLabel: map[string]string{
+				"device_vendor": "Acme", "device_product": "Firewall", "signature_id": "100",
+				"source_port": "51000", "policy": "Inbound default", "cef_version": "0",
+			},
+		},
+		{
+			name: "escaped header and extension values",
+			log:  `CEF:0|Acme|Web\|Proxy|2.1|sig\\1|Path a\=b|Medium|request=/a\=b cat=web`,
+			want: map[string]string{"description": "Path a=b", "severity": "medium", "event_type": "web"},
+			wantLabel: map[string]string{
+				"device_product": "Web|Proxy", "signature_id": `sig\1`, "request": "/a=b",
+			},
+		},
+		{
+			name:     "syslog header and receipt time",
+			log:      `<134>May  1 10:00:00 fw01 CEF:0|Acme|FW|1|1|x|3|rt=2024-05-01T10:00:05Z`,
+			wantTime: time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC),
+			want:     map[string]string{"severity": "low"},
+			wantLabel: map[string]string{
+				"host": "fw01",
+			},
+		},
+		{
+			name:    "too few header fields",
+			log:     `CEF:0|Acme|FW|1`,
+			wantErr: true,
+		},
+		{
+			name:    "no CEF header",
+			log:     `hello`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := NewCEFParser().Parse(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantTime.IsZero() && !event.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %v, want %v", event.Timestamp, tt.wantTime)
+			}
+			checkEvent(t, event, tt.want, tt.wantLabel)
+		})
+	}
+}
+
+func TestApplianceSeverity(t *testing.T) {
+	tests := map[string]string{
+		"0": "info", "1": "low", "4": "medium", "7": "high", "9": "critical", "10": "critical",
+		"Very-High": "critical", "high": "high", "unknown": "info",
+	}
+	for value, want := range tests {
+		if got := applianceSeverity(value); got != want {
+			t.Errorf("applianceSeverity(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package log
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// JSONParser parses the generic JSON log format accepted by the API
+type JSONParser struct{}
+
+// NewJSONParser creates a new generic JSON parser
+func NewJSONParser() *JSONParser {
+	return &JSONParser{}
+}
+
+// Name returns the parser name
+func (p *JSONParser) Name() string {
+	return "json"
+}
+
+// Detect recognises JSON objects, preferring ones that use the known field names
+func (p *JSONParser) Detect(rawLog string) float64 {
+	trimmed := strings.TrimSpace(rawLog)
+	if !strings.HasPrefix(trimmed, "{") || !json.Valid([]byte(trimmed)) {
+		return 0
+	}
+	if strings.Contains(trimmed, `"source_ip"`) || strings.Contains(trimmed, `"event_type"`) {
+		return 0.5
+	}
+	return 0.2
+}
+
+// Parse parses a JSON log entry into a SecurityEvent
+func (p *JSONParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+
+	var logData struct {
+		Timestamp   string `json:"timestamp"`
+		SourceIP    string `json:"source_ip"`
+		DestIP      string `json:"dest_ip"`
+		Protocol    string `json:"protocol"`
+		Port        int    `json:"port"`
+		Action      string `json:"action"`
+		Status      string `json:"status"`
+		User        string `json:"user"`
+		EventType   string `json:"event_type"`
+		Description string `json:"description"`
+	}
+
+	if err := json.Unmarshal([]byte(rawLog), &logData); err != nil {
+		return nil, err
+	}
+
+	// Parse timestamp
+	if t, err := time.Parse(time.RFC3339, logData.Timestamp); err == nil {
+		event.Timestamp = t
+	}
+
+	// Map other fields
+	event.SourceIP = logData.SourceIP
+	event.DestIP = logData.DestIP
+	event.Protocol = logData.Protocol
+	event.Port = logData.Port
+	event.Action = logData.Action
+	event.Status = logData.Status
+	event.User = logData.User
+	event.EventType = logData.EventType
+	event.Description = logData.Description
+
+	return event, nil
+}
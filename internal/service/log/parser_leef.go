@@ -0,0 +1,152 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// leefFields maps LEEF predefined attributes onto SecurityEvent fields. Many
+// devices also emit CEF style keys, so those are accepted too.
+var leefFields = map[string]string{
+	"src":     "source_ip",
+	"dst":     "dest_ip",
+	"srcPort": "source_port",
+	"dstPort": "dest_port",
+	"spt":     "source_port",
+	"dpt":     "dest_port",
+	"proto":   "protocol",
+	"action":  "action",
+	"act":     "action",
+	"usrName": "user",
+	"suser":   "user",
+	"duser":   "dest_user",
+	"cat":     "event_type",
+	"sev":     "severity",
+	"devTime": "timestamp",
+	"msg":     "description",
+	"outcome": "status",
+}
+
+// LEEFParser parses IBM QRadar Log Event Extended Format 1.0 and 2.0 messages
+type LEEFParser struct {
+	syslog *SyslogParser
+}
+
+// NewLEEFParser creates a new LEEF parser
+func NewLEEFParser() *LEEFParser {
+	return &LEEFParser{syslog: NewSyslogParser()}
+}
+
+// Name returns the parser name
+func (p *LEEFParser) Name() string {
+	return "leef"
+}
+
+// Detect recognises lines containing a LEEF header
+func (p *LEEFParser) Detect(rawLog string) float64 {
+	idx := strings.Index(rawLog, "LEEF:")
+	if idx < 0 || strings.Count(rawLog[idx:], "|") < 5 {
+		return 0
+	}
+	return 0.9
+}
+
+// Parse parses a LEEF message into a SecurityEvent
+func (p *LEEFParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	idx := strings.Index(rawLog, "LEEF:")
+	if idx < 0 {
+		return nil, fmt.Errorf("missing LEEF header")
+	}
+	payload := strings.TrimRight(rawLog[idx:], "\r\n")
+
+	version := strings.TrimPrefix(splitEscaped(payload, '|', 2)[0], "LEEF:")
+	fieldCount := 6
+	if !strings.HasPrefix(version, "1") {
+		// LEEF 2.0 adds a delimiter field before the attributes
+		fieldCount = 7
+	}
+
+	header := splitEscaped(payload, '|', fieldCount)
+	if len(header) < fieldCount-1 {
+		return nil, fmt.Errorf("LEEF header has %d fields, expected %d", len(header), fieldCount-1)
+	}
+
+	delimiter := "\t"
+	attrs := ""
+	if fieldCount == 7 && strings.Contains(header[5], "=") {
+		// Senders that keep the default delimiter often leave out its field,
+		// so the attributes, including any unescaped pipes, start right away
+		attrs = strings.Join(header[5:], "|")
+	} else if fieldCount == 7 {
+		if len(header) == 7 {
+			attrs = header[6]
+		}
+		d, err := leefDelimiter(header[5])
+		if err != nil {
+			return nil, err
+		}
+		delimiter = d
+	} else if len(header) == 6 {
+		attrs = header[5]
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+	event.EventType = "leef"
+	applySyslogPrefix(p.syslog, event, rawLog[:idx])
+
+	event.SetLabel("leef_version", version)
+	event.SetLabel("device_vendor", unescapeCEF(header[1]))
+	event.SetLabel("device_product", unescapeCEF(header[2]))
+	event.SetLabel("device_version", unescapeCEF(header[3]))
+	event.SetLabel("signature_id", unescapeCEF(header[4]))
+
+	var ext map[string]string
+	if delimiter == "\t" && !strings.Contains(attrs, "\t") {
+		// Some senders replace tabs with spaces, which is ambiguous unless
+		// values are split on the following key the way CEF does it
+		ext = parseCEFExtension(attrs)
+	} else {
+		ext = parseLEEFAttributes(attrs, delimiter)
+	}
+
+	timeFormat := ext["devTimeFormat"]
+	delete(ext, "devTimeFormat")
+	applyExtensions(event, ext, leefFields, timeFormat)
+
+	return event, nil
+}
+
+// parseLEEFAttributes splits delimiter separated key=value attributes
+func parseLEEFAttributes(s, delimiter string) map[string]string {
+	attrs := make(map[string]string)
+	for _, pair := range strings.Split(s, delimiter) {
+		kv := splitEscaped(pair, '=', 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		attrs[strings.TrimSpace(kv[0])] = unescapeCEF(kv[1])
+	}
+	return attrs
+}
+
+// leefDelimiter decodes the LEEF 2.0 delimiter field, which is either a
+// single character or a hex code such as x09 or 0x5E
+func leefDelimiter(field string) (string, error) {
+	switch {
+	case field == "":
+		return "\t", nil
+	case len(field) == 1:
+		return field, nil
+	}
+
+	hex := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(field), "0"), "x")
+	code, err := strconv.ParseUint(hex, 16, 8)
+	if err != nil {
+		return "", fmt.Errorf("invalid LEEF delimiter: %s", field)
+	}
+	return string(rune(code)), nil
+}
@@ -0,0 +1,102 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLEEFParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       string
+		wantErr   bool
+		wantTime  time.Time
+		want      map[string]string
+		wantLabel map[string]string
+	}{
+		{
+			name: "LEEF 1.0 with tab separated attributes",
+			log:  "LEEF:1.0|Acme|IPS|3.2|4001|src=10.0.0.1\tdst=10.0.0.2\tdstPort=22\tproto=TCP\tusrName=bob\tsev=9\tcat=intrusion",
+			want: map[string]string{
+				"source_ip": "10.0.0.1", "dest_ip": "10.0.0.2", "port": "22", "protocol": "TCP",
+				"user": "bob", "severity": "critical", "event_type": "intrusion",
+			},
+			wantLabel: map[string]string{
+				"leef_version": "1.0", "device_vendor": "Acme", "signature_id": "4001",
+			},
+		},
+		{
+			name: "LEEF 1.0 with tabs replaced by spaces",
+			log:  "LEEF:1.0|Acme|IPS|3.2|4001|src=10.0.0.1 msg=port scan detected dst=10.0.0.2",
+			want: map[string]string{"source_ip": "10.0.0.1", "dest_ip": "10.0.0.2", "description": "port scan detected"},
+		},
+		{
+			name:     "LEEF 2.0 with hex delimiter and time format",
+			log:      "LEEF:2.0|Acme|WAF|1|blocked|x5E|src=10.0.0.1^action=deny^devTime=01/05/2024 10:00:00^devTimeFormat=dd/MM/yyyy HH:mm:ss",
+			wantTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			want:     map[string]string{"source_ip": "10.0.0.1", "action": "deny"},
+			wantLabel: map[string]string{
+				"leef_version": "2.0", "signature_id": "blocked",
+			},
+		},
+		{
+			name: "LEEF 2.0 with character delimiter",
+			log:  "LEEF:2.0|Acme|WAF|1|blocked|;|src=10.0.0.3;dst=10.0.0.4",
+			want: map[string]string{"source_ip": "10.0.0.3", "dest_ip": "10.0.0.4"},
+		},
+		{
+			name: "LEEF 2.0 without delimiter field",
+			log:  "LEEF:2.0|Acme|WAF|1|blocked|src=10.0.0.5\tdst=10.0.0.6\tmsg=path /a|b",
+			want: map[string]string{"source_ip": "10.0.0.5", "dest_ip": "10.0.0.6", "description": "path /a|b"},
+		},
+		{
+			name:    "invalid LEEF 2.0 delimiter",
+			log:     "LEEF:2.0|Acme|WAF|1|blocked|xZZ|src=10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name:    "too few header fields",
+			log:     "LEEF:1.0|Acme|IPS",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := NewLEEFParser().Parse(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantTime.IsZero() && !event.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %v, want %v", event.Timestamp, tt.wantTime)
+			}
+			checkEvent(t, event, tt.want, tt.wantLabel)
+		})
+	}
+}
+
+func TestLEEFDelimiter(t *testing.T) {
+	tests := []struct {
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{field: "", want: "\t"},
+		{field: "|", want: "|"},
+		{field: "x09", want: "\t"},
+		{field: "0x5E", want: "^"},
+		{field: "xZZ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := leefDelimiter(tt.field)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("leefDelimiter(%q) = %q, %v, want %q", tt.field, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/schema"
+)
+
+// ECSParser parses documents already in Elastic Common Schema, as shipped by
+// Beats and Elastic Agent
+type ECSParser struct{}
+
+// NewECSParser creates a new ECS document parser
+func NewECSParser() *ECSParser {
+	return &ECSParser{}
+}
+
+// Name returns the parser name
+func (p *ECSParser) Name() string {
+	return "ecs"
+}
+
+// Detect recognises JSON objects carrying @timestamp and an ECS version
+func (p *ECSParser) Detect(rawLog string) float64 {
+	trimmed := strings.TrimSpace(rawLog)
+	if !strings.HasPrefix(trimmed, "{") || !strings.Contains(trimmed, `"@timestamp"`) {
+		return 0
+	}
+	doc, err := schema.ParseDocument([]byte(trimmed))
+	if err != nil {
+		return 0
+	}
+	if _, ok := doc.Get("ecs.version"); ok {
+		return 0.85
+	}
+	return 0
+}
+
+// Parse converts an ECS document into a SecurityEvent
+func (p *ECSParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	doc, err := schema.ParseDocument([]byte(strings.TrimSpace(rawLog)))
+	if err != nil {
+		return nil, err
+	}
+	event := schema.FromECS(doc)
+	if event.RawData == "" {
+		event.RawData = rawLog
+	}
+	return event, nil
+}
+
+// OCSFParser parses OCSF events
+type OCSFParser struct{}
+
+// NewOCSFParser creates a new OCSF event parser
+func NewOCSFParser() *OCSFParser {
+	return &OCSFParser{}
+}
+
+// Name returns the parser name
+func (p *OCSFParser) Name() string {
+	return "ocsf"
+}
+
+// Detect recognises JSON objects with an OCSF class and metadata
+func (p *OCSFParser) Detect(rawLog string) float64 {
+	trimmed := strings.TrimSpace(rawLog)
+	if !strings.HasPrefix(trimmed, "{") || !strings.Contains(trimmed, `"class_uid"`) {
+		return 0
+	}
+	doc, err := schema.ParseDocument([]byte(trimmed))
+	if err != nil {
+		return 0
+	}
+	_, hasMetadata := doc.Get("metadata.version")
+	_, hasTime := doc.Get("time")
+	if hasMetadata && hasTime {
+		return 0.85
+	}
+	return 0
+}
+
+// Parse converts an OCSF event into a SecurityEvent
+func (p *OCSFParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	doc, err := schema.ParseDocument([]byte(strings.TrimSpace(rawLog)))
+	if err != nil {
+		return nil, err
+	}
+	event := schema.FromOCSF(doc)
+	if event.RawData == "" {
+		event.RawData = rawLog
+	}
+	return event, nil
+}
@@ -0,0 +1,248 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// suricataTimeLayout is the EVE timestamp format, which has no colon in the zone offset
+const suricataTimeLayout = "2006-01-02T15:04:05.999999999-0700"
+
+// eveRecord is the subset of a Suricata EVE JSON record that is mapped onto events
+type eveRecord struct {
+	Timestamp   string          `json:"timestamp"`
+	FlowID      json.Number     `json:"flow_id"`
+	InIface     string          `json:"in_iface"`
+	EventType   string          `json:"event_type"`
+	SrcIP       string          `json:"src_ip"`
+	SrcPort     int             `json:"src_port"`
+	DestIP      string          `json:"dest_ip"`
+	DestPort    int             `json:"dest_port"`
+	Proto       string          `json:"proto"`
+	AppProto    string          `json:"app_proto"`
+	CommunityID string          `json:"community_id"`
+	Alert       *eveAlert       `json:"alert"`
+	Flow        *eveFlow        `json:"flow"`
+	DNS         json.RawMessage `json:"dns"`
+	HTTP        *eveHTTP        `json:"http"`
+	TLS         *eveTLS         `json:"tls"`
+}
+
+type eveAlert struct {
+	Action      string `json:"action"`
+	GID         int    `json:"gid"`
+	SignatureID int    `json:"signature_id"`
+	Rev         int    `json:"rev"`
+	Signature   string `json:"signature"`
+	Category    string `json:"category"`
+	Severity    int    `json:"severity"`
+}
+
+type eveFlow struct {
+	PktsToServer  int64  `json:"pkts_toserver"`
+	PktsToClient  int64  `json:"pkts_toclient"`
+	BytesToServer int64  `json:"bytes_toserver"`
+	BytesToClient int64  `json:"bytes_toclient"`
+	State         string `json:"state"`
+	Reason        string `json:"reason"`
+}
+
+type eveDNS struct {
+	Type    string `json:"type"`
+	RRName  string `json:"rrname"`
+	RRType  string `json:"rrtype"`
+	RCode   string `json:"rcode"`
+	Queries []struct {
+		RRName string `json:"rrname"`
+		RRType string `json:"rrtype"`
+	} `json:"queries"`
+}
+
+type eveHTTP struct {
+	Hostname  string `json:"hostname"`
+	URL       string `json:"url"`
+	UserAgent string `json:"http_user_agent"`
+	Method    string `json:"http_method"`
+	Status    int    `json:"status"`
+}
+
+type eveTLS struct {
+	Subject  string `json:"subject"`
+	IssuerDN string `json:"issuerdn"`
+	SNI      string `json:"sni"`
+	Version  string `json:"version"`
+	JA3      *struct {
+		Hash string `json:"hash"`
+	} `json:"ja3"`
+	JA3S *struct {
+		Hash string `json:"hash"`
+	} `json:"ja3s"`
+}
+
+// SuricataParser parses Suricata EVE JSON records
+type SuricataParser struct{}
+
+// NewSuricataParser creates a new Suricata EVE parser
+func NewSuricataParser() *SuricataParser {
+	return &SuricataParser{}
+}
+
+// Name returns the parser name
+func (p *SuricataParser) Name() string {
+	return "suricata"
+}
+
+// Detect recognises JSON objects carrying the EVE event_type together with flow or address fields
+func (p *SuricataParser) Detect(rawLog string) float64 {
+	trimmed := strings.TrimSpace(rawLog)
+	if !strings.HasPrefix(trimmed, "{") || !strings.Contains(trimmed, `"event_type"`) {
+		return 0
+	}
+	if strings.Contains(trimmed, `"flow_id"`) || strings.Contains(trimmed, `"src_ip"`) {
+		return 0.9
+	}
+	return 0
+}
+
+// Parse parses an EVE record into a SecurityEvent
+func (p *SuricataParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	var rec eveRecord
+	if err := json.Unmarshal([]byte(rawLog), &rec); err != nil {
+		return nil, err
+	}
+	if rec.EventType == "" {
+		return nil, fmt.Errorf("missing event_type")
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+	event.EventType = rec.EventType
+	event.SourceIP = rec.SrcIP
+	event.DestIP = rec.DestIP
+	event.Port = rec.DestPort
+	event.Protocol = protocolName(rec.Proto)
+
+	if t, err := time.Parse(suricataTimeLayout, rec.Timestamp); err == nil {
+		event.Timestamp = t
+	} else if t, err := time.Parse(time.RFC3339Nano, rec.Timestamp); err == nil {
+		event.Timestamp = t
+	}
+
+	if rec.SrcPort != 0 {
+		event.SetLabel("source_port", strconv.Itoa(rec.SrcPort))
+	}
+	setLabelIfPresent(event, "flow_id", rec.FlowID.String())
+	setLabelIfPresent(event, "community_id", rec.CommunityID)
+	setLabelIfPresent(event, "in_iface", rec.InIface)
+	setLabelIfPresent(event, "app_proto", rec.AppProto)
+
+	switch rec.EventType {
+	case "alert":
+		if rec.Alert != nil {
+			p.applyAlert(event, rec.Alert)
+		}
+	case "flow":
+		if rec.Flow != nil {
+			p.applyFlow(event, rec.Flow)
+		}
+	case "dns":
+		p.applyDNS(event, rec.DNS)
+	case "http":
+		if rec.HTTP != nil {
+			p.applyHTTP(event, rec.HTTP)
+		}
+	case "tls":
+		if rec.TLS != nil {
+			p.applyTLS(event, rec.TLS)
+		}
+	}
+
+	return event, nil
+}
+
+func (p *SuricataParser) applyAlert(event *entity.SecurityEvent, alert *eveAlert) {
+	event.Action = alert.Action
+	event.Description = alert.Signature
+	event.SetLabel("signature_id", strconv.Itoa(alert.SignatureID))
+	event.SetLabel("alert.gid", strconv.Itoa(alert.GID))
+	event.SetLabel("alert.rev", strconv.Itoa(alert.Rev))
+	setLabelIfPresent(event, "alert.category", alert.Category)
+
+	// Suricata severity runs from 1 (highest) to 3 (lowest)
+	switch alert.Severity {
+	case 1:
+		event.Severity = "high"
+	case 2:
+		event.Severity = "medium"
+	case 3:
+		event.Severity = "low"
+	}
+}
+
+func (p *SuricataParser) applyFlow(event *entity.SecurityEvent, flow *eveFlow) {
+	event.Status = flow.State
+	setLabelIfPresent(event, "flow.reason", flow.Reason)
+	event.SetLabel("flow.pkts_toserver", strconv.FormatInt(flow.PktsToServer, 10))
+	event.SetLabel("flow.pkts_toclient", strconv.FormatInt(flow.PktsToClient, 10))
+	event.SetLabel("flow.bytes_toserver", strconv.FormatInt(flow.BytesToServer, 10))
+	event.SetLabel("flow.bytes_toclient", strconv.FormatInt(flow.BytesToClient, 10))
+}
+
+func (p *SuricataParser) applyDNS(event *entity.SecurityEvent, raw json.RawMessage) {
+	if len(raw) == 0 {
+		return
+	}
+	var dns eveDNS
+	if err := json.Unmarshal(raw, &dns); err != nil {
+		return
+	}
+
+	// EVE version 3 moved the query name into a queries array
+	if dns.RRName == "" && len(dns.Queries) > 0 {
+		dns.RRName = dns.Queries[0].RRName
+		dns.RRType = dns.Queries[0].RRType
+	}
+
+	event.Description = dns.RRName
+	setLabelIfPresent(event, "dns.type", dns.Type)
+	setLabelIfPresent(event, "dns.rrname", dns.RRName)
+	setLabelIfPresent(event, "dns.rrtype", dns.RRType)
+	setLabelIfPresent(event, "dns.rcode", dns.RCode)
+}
+
+func (p *SuricataParser) applyHTTP(event *entity.SecurityEvent, http *eveHTTP) {
+	event.Description = strings.TrimSpace(http.Method + " " + http.Hostname + http.URL)
+	setLabelIfPresent(event, "http.hostname", http.Hostname)
+	setLabelIfPresent(event, "http.url", http.URL)
+	setLabelIfPresent(event, "http.method", http.Method)
+	setLabelIfPresent(event, "http.user_agent", http.UserAgent)
+	if http.Status != 0 {
+		event.Status = strconv.Itoa(http.Status)
+	}
+}
+
+func (p *SuricataParser) applyTLS(event *entity.SecurityEvent, tls *eveTLS) {
+	event.Description = tls.SNI
+	setLabelIfPresent(event, "tls.sni", tls.SNI)
+	setLabelIfPresent(event, "tls.subject", tls.Subject)
+	setLabelIfPresent(event, "tls.issuerdn", tls.IssuerDN)
+	setLabelIfPresent(event, "tls.version", tls.Version)
+	if tls.JA3 != nil {
+		setLabelIfPresent(event, "tls.ja3", tls.JA3.Hash)
+	}
+	if tls.JA3S != nil {
+		setLabelIfPresent(event, "tls.ja3s", tls.JA3S.Hash)
+	}
+}
+
+// setLabelIfPresent sets a label only when the value is meaningful
+func setLabelIfPresent(event *entity.SecurityEvent, key, value string) {
+	if value != "" && value != "-" {
+		event.SetLabel(key, value)
+	}
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuricataParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       string
+		wantErr   bool
+		wantTime  time.Time
+		want      map[string]string
+		wantLabel map[string]string
+	}{
+		{
+			name:     "alert",
+			log:      `{"timestamp":"2024-05-01T10:00:00.123456+0200","flow_id":1234567890123456,"in_iface":"eth0","event_type":"alert","src_ip":"10.0.0.1","src_port":51000,"dest_ip":"10.0.0.2","dest_port":22,"proto":"TCP","community_id":"1:abc=","alert":{"action":"allowed","gid":1,"signature_id":2001219,"rev":20,"signature":"ET SCAN Potential SSH Scan","category":"Attempted Information Leak","severity":2}}`,
+			wantTime: time.Date(2024, 5, 1, 8, 0, 0, 123456000, time.UTC),
+			want: map[string]string{
+				"event_type": "alert", "source_ip": "10.0.0.1", "dest_ip": "10.0.0.2", "port": "22", "protocol": "TCP",
+				"action": "allowed", "description": "ET SCAN Potential SSH Scan", "severity": "medium",
+			},
+			wantLabel: map[string]string{
+				"source_port": "51000", "flow_id": "1234567890123456", "community_id": "1:abc=", "in_iface": "eth0",
+				"signature_id": "2001219", "alert.gid": "1", "alert.rev": "20", "alert.category": "Attempted Information Leak",
+			},
+		},
+		{
+			name: "flow",
+			log:  `{"timestamp":"2024-05-01T10:00:00Z","event_type":"flow","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","dest_port":443,"proto":"6","app_proto":"tls","flow":{"pkts_toserver":5,"pkts_toclient":4,"bytes_toserver":600,"bytes_toclient":4200,"state":"closed","reason":"timeout"}}`,
+			want: map[string]string{"protocol": "TCP", "status": "closed"},
+			wantLabel: map[string]string{
+				"app_proto": "tls", "flow.reason": "timeout", "flow.pkts_toserver": "5", "flow.bytes_toclient": "4200",
+			},
+		},
+		{
+			name:      "DNS version 2",
+			log:       `{"event_type":"dns","src_ip":"10.0.0.1","dns":{"type":"query","rrname":"example.com","rrtype":"A"}}`,
+			want:      map[string]string{"description": "example.com"},
+			wantLabel: map[string]string{"dns.type": "query", "dns.rrname": "example.com", "dns.rrtype": "A"},
+		},
+		{
+			name:      "DNS version 3 queries",
+			log:       `{"event_type":"dns","src_ip":"10.0.0.1","dns":{"type":"answer","rcode":"NOERROR","queries":[{"rrname":"example.org","rrtype":"AAAA"}]}}`,
+			want:      map[string]string{"description": "example.org"},
+			wantLabel: map[string]string{"dns.rrname": "example.org", "dns.rrtype": "AAAA", "dns.rcode": "NOERROR"},
+		},
+		{
+			name:      "HTTP",
+			log:       `{"event_type":"http","src_ip":"10.0.0.1","http":{"hostname":"example.com","url":"/login","http_user_agent":"curl/8.0","http_method":"POST","status":401}}`,
+			want:      map[string]string{"description": "POST example.com/login", "status": "401"},
+			wantLabel: map[string]string{"http.method": "POST", "http.user_agent": "curl/8.0"},
+		},
+		{
+			name:      "TLS",
+			log:       `{"event_type":"tls","src_ip":"10.0.0.1","tls":{"subject":"CN=example.com","issuerdn":"-","sni":"example.com","version":"TLS 1.3","ja3":{"hash":"abc"}}}`,
+			want:      map[string]string{"description": "example.com"},
+			wantLabel: map[string]string{"tls.sni": "example.com", "tls.version": "TLS 1.3", "tls.ja3": "abc", "tls.issuerdn": ""},
+		},
+		{name: "missing event type", log: `{"src_ip":"10.0.0.1","flow_id":1}`, wantErr: true},
+		{name: "invalid JSON", log: `{"event_type":"alert"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := NewSuricataParser().Parse(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantTime.IsZero() && !event.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %v, want %v", event.Timestamp, tt.wantTime)
+			}
+			checkEvent(t, event, tt.want, tt.wantLabel)
+		})
+	}
+}
+
+func TestSuricataParserDetect(t *testing.T) {
+	tests := []struct {
+		log  string
+		want float64
+	}{
+		{`{"timestamp":"2024-05-01T10:00:00Z","flow_id":1,"event_type":"flow"}`, 0.9},
+		{`{"event_type":"dns","src_ip":"10.0.0.1"}`, 0.9},
+		{`{"event_type":"login","source_ip":"10.0.0.1"}`, 0},
+		{`<13>1 - - - - - - event_type`, 0},
+	}
+	for _, tt := range tests {
+		if got := NewSuricataParser().Detect(tt.log); got != tt.want {
+			t.Errorf("Detect(%q) = %v, want %v", tt.log, got, tt.want)
+		}
+	}
+}
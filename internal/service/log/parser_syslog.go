@@ -0,0 +1,393 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+var (
+	syslogPriPattern  = regexp.MustCompile(`^<(\d{1,3})>`)
+	syslog3164Pattern = regexp.MustCompile(`^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2} `)
+	syslogTagPattern  = regexp.MustCompile(`^([^\s\[\]:]+)(?:\[([^\]]*)\])?:`)
+)
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// SyslogMessage is a decoded RFC 5424 or RFC 3164 syslog message
+type SyslogMessage struct {
+	Facility       int
+	Severity       int
+	Version        int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// SyslogParser parses RFC 5424 and RFC 3164 syslog messages
+type SyslogParser struct {
+	// now is used to infer the year of RFC 3164 timestamps
+	now func() time.Time
+}
+
+// NewSyslogParser creates a new syslog parser
+func NewSyslogParser() *SyslogParser {
+	return &SyslogParser{now: time.Now}
+}
+
+// Name returns the parser name
+func (p *SyslogParser) Name() string {
+	return "syslog"
+}
+
+// Detect recognises messages starting with a PRI header, and BSD style
+// lines without one such as those written to /var/log/messages
+func (p *SyslogParser) Detect(rawLog string) float64 {
+	if m := syslogPriPattern.FindStringSubmatch(rawLog); m != nil {
+		if pri, err := strconv.Atoi(m[1]); err != nil || pri > 191 {
+			return 0
+		}
+		return 0.8
+	}
+	if syslog3164Pattern.MatchString(rawLog) {
+		return 0.6
+	}
+	return 0
+}
+
+// Parse parses a syslog message into a SecurityEvent
+func (p *SyslogParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	msg, err := p.Decode(rawLog)
+	if err != nil {
+		return nil, err
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+	if !msg.Timestamp.IsZero() {
+		event.Timestamp = msg.Timestamp
+	}
+	event.Severity = syslogSeverityLevel(msg.Severity)
+	event.Description = msg.Message
+	event.EventType = "syslog"
+	if msg.MsgID != "" {
+		event.EventType = msg.MsgID
+	}
+
+	event.SetLabel("syslog_facility", syslogFacilityName(msg.Facility))
+	event.SetLabel("syslog_severity", syslogSeverities[msg.Severity])
+	if msg.Hostname != "" {
+		event.SetLabel("host", msg.Hostname)
+	}
+	if msg.AppName != "" {
+		event.SetLabel("app", msg.AppName)
+	}
+	if msg.ProcID != "" {
+		event.SetLabel("procid", msg.ProcID)
+	}
+	for _, id := range sortedKeys(msg.StructuredData) {
+		params := msg.StructuredData[id]
+		for _, name := range sortedKeys(params) {
+			event.SetLabel("sd."+id+"."+name, params[name])
+		}
+	}
+
+	applyKeyValues(event, parseKeyValues(msg.Message))
+
+	return event, nil
+}
+
+// Decode splits a raw syslog line into its header fields and message
+func (p *SyslogParser) Decode(rawLog string) (*SyslogMessage, error) {
+	line := strings.TrimRight(rawLog, "\r\n")
+	msg := &SyslogMessage{Facility: 1, Severity: 5}
+
+	// The PRI header is optional for locally written BSD syslog files
+	if m := syslogPriPattern.FindStringSubmatch(line); m != nil {
+		pri, err := strconv.Atoi(m[1])
+		if err != nil || pri > 191 {
+			return nil, fmt.Errorf("invalid syslog priority: %s", m[1])
+		}
+		msg.Facility = pri / 8
+		msg.Severity = pri % 8
+		line = line[len(m[0]):]
+	}
+
+	if strings.HasPrefix(line, "1 ") {
+		if err := p.decode5424(line[2:], msg); err != nil {
+			return nil, err
+		}
+		msg.Version = 1
+		return msg, nil
+	}
+
+	p.decode3164(line, msg)
+	return msg, nil
+}
+
+// decode5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG]"
+func (p *SyslogParser) decode5424(line string, msg *SyslogMessage) error {
+	fields := make([]string, 0, 5)
+	rest := line
+	for i := 0; i < 5; i++ {
+		idx := strings.IndexByte(rest, ' ')
+		if idx < 0 {
+			return fmt.Errorf("truncated RFC 5424 header")
+		}
+		fields = append(fields, rest[:idx])
+		rest = rest[idx+1:]
+	}
+
+	if fields[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid RFC 5424 timestamp: %v", err)
+		}
+		msg.Timestamp = t
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.AppName = nilValue(fields[2])
+	msg.ProcID = nilValue(fields[3])
+	msg.MsgID = nilValue(fields[4])
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		sd, n, err := parseStructuredData(rest)
+		if err != nil {
+			return err
+		}
+		msg.StructuredData = sd
+		rest = rest[n:]
+	}
+
+	rest = strings.TrimPrefix(rest, " ")
+	msg.Message = strings.TrimPrefix(rest, "\ufeff")
+	return nil
+}
+
+// decode3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". RFC 3164 is
+// loosely specified, so anything that does not fit is kept in the message.
+func (p *SyslogParser) decode3164(line string, msg *SyslogMessage) {
+	rest := line
+	if syslog3164Pattern.MatchString(rest) {
+		stamp := rest[:15]
+		rest = rest[16:]
+		if t, err := time.ParseInLocation(time.Stamp, stamp, time.Local); err == nil {
+			msg.Timestamp = p.inferYear(t)
+		}
+	} else if idx := strings.IndexByte(rest, ' '); idx > 0 {
+		// Some senders use an RFC 3339 timestamp with the BSD layout
+		if t, err := time.Parse(time.RFC3339Nano, rest[:idx]); err == nil {
+			msg.Timestamp = t
+			rest = rest[idx+1:]
+		}
+	}
+
+	if !msg.Timestamp.IsZero() {
+		if idx := strings.IndexByte(rest, ' '); idx > 0 && !strings.HasSuffix(rest[:idx], ":") {
+			msg.Hostname = rest[:idx]
+			rest = rest[idx+1:]
+		}
+	}
+
+	if m := syslogTagPattern.FindStringSubmatch(rest); m != nil {
+		msg.AppName = m[1]
+		msg.ProcID = m[2]
+		rest = rest[len(m[0]):]
+	}
+
+	msg.Message = strings.TrimSpace(rest)
+}
+
+// inferYear adds the current year to a timestamp without one, rolling back a
+// year when the result would lie in the future (e.g. December logs read in January)
+func (p *SyslogParser) inferYear(t time.Time) time.Time {
+	now := p.now()
+	t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// parseStructuredData parses one or more SD-ELEMENTs and returns the number of bytes consumed
+func parseStructuredData(s string) (map[string]map[string]string, int, error) {
+	sd := make(map[string]map[string]string)
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		i++
+		start := i
+		for i < len(s) && s[i] != ' ' && s[i] != ']' {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated structured data element")
+		}
+		id := s[start:i]
+		params := make(map[string]string)
+
+		for i < len(s) && s[i] == ' ' {
+			i++
+			eq := strings.IndexByte(s[i:], '=')
+			if eq < 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+				return nil, 0, fmt.Errorf("invalid structured data param in %s", id)
+			}
+			name := s[i : i+eq]
+			i += eq + 2
+
+			var value strings.Builder
+			closed := false
+			for i < len(s) {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					value.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				i++
+				if c == '"' {
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, 0, fmt.Errorf("unterminated structured data value in %s", id)
+			}
+			params[name] = value.String()
+		}
+
+		if i >= len(s) || s[i] != ']' {
+			return nil, 0, fmt.Errorf("unterminated structured data element %s", id)
+		}
+		i++
+		sd[id] = params
+	}
+	return sd, i, nil
+}
+
+// parseKeyValues extracts key=value tokens such as those in iptables or
+// firewall messages. Values may be double quoted.
+func parseKeyValues(s string) map[string]string {
+	kv := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			break
+		}
+		key := s[:eq]
+		if sp := strings.LastIndexByte(key, ' '); sp >= 0 {
+			key = key[sp+1:]
+		}
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if sp := strings.IndexByte(s, ' '); sp >= 0 {
+			value, s = s[:sp], s[sp:]
+		} else {
+			value, s = s, ""
+		}
+		if key != "" {
+			kv[key] = value
+		}
+	}
+	return kv
+}
+
+// applyKeyValues maps well-known key=value names onto event fields
+func applyKeyValues(event *entity.SecurityEvent, kv map[string]string) {
+	for key, value := range kv {
+		switch strings.ToLower(key) {
+		case "src", "src_ip", "srcip", "source_ip":
+			if event.SourceIP == "" {
+				event.SourceIP = value
+			}
+		case "dst", "dst_ip", "dstip", "dest_ip":
+			if event.DestIP == "" {
+				event.DestIP = value
+			}
+		case "proto", "protocol":
+			if event.Protocol == "" {
+				event.Protocol = strings.ToUpper(value)
+			}
+		case "dpt", "dst_port", "dstport", "dport":
+			if port, err := strconv.Atoi(value); err == nil && event.Port == 0 {
+				event.Port = port
+			}
+		case "user", "usr", "username":
+			if event.User == "" {
+				event.User = value
+			}
+		case "action":
+			if event.Action == "" {
+				event.Action = value
+			}
+		}
+	}
+}
+
+// syslogSeverityLevel maps a syslog severity onto the event severity scale
+func syslogSeverityLevel(severity int) string {
+	switch {
+	case severity <= 2:
+		return "critical"
+	case severity == 3:
+		return "high"
+	case severity == 4:
+		return "medium"
+	case severity == 5:
+		return "low"
+	default:
+		return "info"
+	}
+}
+
+func syslogFacilityName(facility int) string {
+	if facility >= 0 && facility < len(syslogFacilities) {
+		return syslogFacilities[facility]
+	}
+	return strconv.Itoa(facility)
+}
+
+// sortedKeys returns map keys in sorted order so labels are deterministic
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
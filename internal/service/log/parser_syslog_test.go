@@ -0,0 +1,129 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyslogParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       string
+		wantErr   bool
+		wantTime  time.Time
+		want      map[string]string
+		wantLabel map[string]string
+	}{
+		{
+			name:     "RFC 5424 with structured data",
+			log:      `<165>1 2024-05-01T10:00:00.5Z fw01 sshd 4242 ID47 [auth@32473 user="alice" reason="bad \"pw\""] Failed password src=10.0.0.1 dst=10.0.0.2 dpt=22 proto=tcp`,
+			wantTime: time.Date(2024, 5, 1, 10, 0, 0, 500000000, time.UTC),
+			want: map[string]string{
+				"source_ip": "10.0.0.1", "dest_ip": "10.0.0.2", "port": "22", "protocol": "TCP",
+				"event_type": "ID47", "severity": "low",
+			},
+			wantLabel: map[string]string{
+				"host": "fw01", "app": "sshd", "procid": "4242", "syslog_facility": "local4",
+				"syslog_severity": "notice", "sd.auth@32473.user": "alice", "sd.auth@32473.reason": `bad "pw"`,
+			},
+		},
+		{
+			name: "RFC 5424 with nil values",
+			log:  `<11>1 - - - - - - disk failure`,
+			want: map[string]string{"event_type": "syslog", "severity": "high", "description": "disk failure"},
+			wantLabel: map[string]string{
+				"syslog_facility": "user", "syslog_severity": "err",
+			},
+		},
+		{
+			name:     "RFC 3164 with tag and pid",
+			log:      `<38>Apr  3 08:15:00 web-1 sudo[881]: alice : user=alice action=allow`,
+			wantTime: time.Date(2024, 4, 3, 8, 15, 0, 0, time.Local),
+			want:     map[string]string{"user": "alice", "action": "allow", "severity": "info"},
+			wantLabel: map[string]string{
+				"host": "web-1", "app": "sudo", "procid": "881", "syslog_facility": "auth",
+			},
+		},
+		{
+			name:      "BSD file line without priority",
+			log:       `Apr  3 08:15:00 web-1 kernel: IN=eth0 SRC=10.1.1.1 DST=10.1.1.2 PROTO=UDP DPT=53`,
+			want:      map[string]string{"source_ip": "10.1.1.1", "dest_ip": "10.1.1.2", "protocol": "UDP", "port": "53"},
+			wantLabel: map[string]string{"host": "web-1", "app": "kernel"},
+		},
+		{
+			name:      "RFC 3339 timestamp in BSD layout",
+			log:       `<13>2024-05-01T10:00:00Z host-2 app: hello`,
+			wantTime:  time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			want:      map[string]string{"description": "hello"},
+			wantLabel: map[string]string{"host": "host-2", "app": "app"},
+		},
+		{name: "priority out of range", log: `<192>1 - - - - - -`, wantErr: true},
+		{name: "truncated RFC 5424 header", log: `<13>1 2024-05-01T10:00:00Z host`, wantErr: true},
+		{name: "invalid RFC 5424 timestamp", log: `<13>1 yesterday host app - - -`, wantErr: true},
+		{name: "unterminated structured data", log: `<13>1 - host app - - [id a="b"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewSyslogParser()
+			parser.now = func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local) }
+
+			event, err := parser.Parse(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantTime.IsZero() && !event.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %v, want %v", event.Timestamp, tt.wantTime)
+			}
+			checkEvent(t, event, tt.want, tt.wantLabel)
+		})
+	}
+}
+
+func TestSyslogParserInferYear(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		log  string
+		want int
+	}{
+		{name: "same year", now: time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), log: "Apr  3 08:15:00 h app: x", want: 2024},
+		{name: "December read in January", now: time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local), log: "Dec 31 23:59:59 h app: x", want: 2024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewSyslogParser()
+			parser.now = func() time.Time { return tt.now }
+			event, err := parser.Parse(tt.log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Timestamp.Year() != tt.want {
+				t.Errorf("year = %d, want %d", event.Timestamp.Year(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSyslogParserDetect(t *testing.T) {
+	tests := []struct {
+		log  string
+		want float64
+	}{
+		{`<34>1 2024-05-01T10:00:00Z h a - - - m`, 0.8},
+		{`<999>oops`, 0},
+		{`Apr  3 08:15:00 web-1 sshd: x`, 0.6},
+		{`{"json":true}`, 0},
+	}
+	for _, tt := range tests {
+		if got := NewSyslogParser().Detect(tt.log); got != tt.want {
+			t.Errorf("Detect(%q) = %v, want %v", tt.log, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package log
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+func TestParserRegistryDetect(t *testing.T) {
+	registry := DefaultParserRegistry()
+
+	tests := []struct {
+		name string
+		log  string
+		want string
+	}{
+		{name: "generic JSON", log: `{"timestamp":"2024-05-01T10:00:00Z","source_ip":"10.0.0.1","event_type":"login"}`, want: "json"},
+		{name: "RFC 5424 syslog", log: `<34>1 2024-05-01T10:00:00Z host app - - - message`, want: "syslog"},
+		{name: "BSD syslog", log: `Apr  3 08:15:00 web-1 sshd[1]: Failed password`, want: "syslog"},
+		{name: "CEF", log: `CEF:0|Vendor|Product|1.0|100|Blocked|5|src=10.0.0.1`, want: "cef"},
+		{name: "CEF behind a syslog header", log: `<13>Apr  3 08:15:00 fw CEF:0|Vendor|Product|1.0|100|Blocked|5|src=10.0.0.1`, want: "cef"},
+		{name: "LEEF", log: "LEEF:1.0|Vendor|Product|1.0|100|src=10.0.0.1\tdst=10.0.0.2", want: "leef"},
+		{name: "Suricata EVE", log: `{"timestamp":"2024-05-01T10:00:00Z","flow_id":1,"event_type":"flow","src_ip":"10.0.0.1"}`, want: "suricata"},
+		{name: "Zeek header", log: "#separator \\x09", want: "zeek"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := registry.Detect(tt.log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parser.Name() != tt.want {
+				t.Errorf("detected %s, want %s", parser.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParserRegistryErrors(t *testing.T) {
+	registry := NewParserRegistry()
+	registry.MustRegister(NewJSONParser())
+
+	tests := []struct {
+		name  string
+		parse func() error
+		check func(err error) bool
+	}{
+		{
+			name: "no parser recognises the log",
+			parse: func() error {
+				_, _, err := registry.Parse("plain text")
+				return err
+			},
+			check: func(err error) bool {
+				var detection *DetectionError
+				return errors.As(err, &detection) && len(detection.Tried) == 1 && detection.Excerpt == "plain text"
+			},
+		},
+		{
+			name: "selected parser fails",
+			parse: func() error {
+				_, err := registry.ParseWith("json", `{"port":"x"}`)
+				return err
+			},
+			check: func(err error) bool {
+				var parseErr *ParseError
+				return errors.As(err, &parseErr) && parseErr.Parser == "json"
+			},
+		},
+		{
+			name: "unknown parser",
+			parse: func() error {
+				_, err := registry.ParseWith("missing", `{}`)
+				return err
+			},
+			check: func(err error) bool { return errors.Is(err, ErrUnknownParser) },
+		},
+		{
+			name: "duplicate name",
+			parse: func() error {
+				return registry.Register(NewJSONParser())
+			},
+			check: func(err error) bool { return err != nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.parse(); !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+// namedParser accepts every line with a fixed confidence
+type namedParser struct {
+	name       string
+	confidence float64
+}
+
+func (p *namedParser) Name() string                 { return p.name }
+func (p *namedParser) Detect(rawLog string) float64 { return p.confidence }
+func (p *namedParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	event := entity.NewSecurityEvent()
+	event.Description = p.name
+	return event, nil
+}
+
+func TestParserRegistryOrder(t *testing.T) {
+	registry := NewParserRegistry()
+	registry.MustRegister(&namedParser{name: "first", confidence: 0.5})
+	registry.MustRegister(&namedParser{name: "second", confidence: 0.5})
+
+	event, name, err := registry.Parse("anything")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "first" {
+		t.Errorf("tie resolved to %s, want the first registered parser", name)
+	}
+	if got, _ := event.GetLabel("parser"); got != "first" || event.RawData != "anything" {
+		t.Errorf("parser label %q, raw data %q", got, event.RawData)
+	}
+
+	// Replacing keeps the detection priority of the parser it swaps out
+	if err := registry.Replace(&namedParser{name: "first", confidence: 0.4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, name, _ := registry.Parse("anything"); name != "second" {
+		t.Errorf("after replace detected %s, want second", name)
+	}
+
+	registry.Unregister("second")
+	if _, name, _ := registry.Parse("anything"); name != "first" {
+		t.Errorf("after unregister detected %s, want first", name)
+	}
+	if names := registry.Names(); len(names) != 1 || names[0] != "first" {
+		t.Errorf("names = %v", names)
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      string
+		wantErr  bool
+		wantTime time.Time
+		want     map[string]string
+	}{
+		{
+			name:     "all fields",
+			log:      `{"timestamp":"2024-05-01T10:00:00Z","source_ip":"10.0.0.1","dest_ip":"10.0.0.2","protocol":"TCP","port":22,"action":"deny","status":"failed","user":"alice","event_type":"login","description":"bad password"}`,
+			wantTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			want: map[string]string{
+				"source_ip": "10.0.0.1", "dest_ip": "10.0.0.2", "protocol": "TCP", "port": "22", "action": "deny",
+				"status": "failed", "user": "alice", "event_type": "login", "description": "bad password",
+			},
+		},
+		{
+			name: "unparseable timestamp is ignored",
+			log:  `{"timestamp":"yesterday","user":"bob"}`,
+			want: map[string]string{"user": "bob"},
+		},
+		{name: "wrong field type", log: `{"port":"22"}`, wantErr: true},
+		{name: "not JSON", log: `user=alice`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := NewJSONParser().Parse(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantTime.IsZero() && !event.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %v, want %v", event.Timestamp, tt.wantTime)
+			}
+			checkEvent(t, event, tt.want, nil)
+		})
+	}
+}
+
+// checkEvent compares event fields, named like the keys of the JSON log
+// format, and labels with the wanted values
+func checkEvent(t *testing.T, event *entity.SecurityEvent, fields, labels map[string]string) {
+	t.Helper()
+	got := map[string]string{
+		"source_ip": event.SourceIP, "dest_ip": event.DestIP, "protocol": event.Protocol,
+		"port": strconv.Itoa(event.Port), "action": event.Action, "status": event.Status,
+		"user": event.User, "event_type": event.EventType, "description": event.Description,
+		"severity": event.Severity,
+	}
+	for field, want := range fields {
+		if got[field] != want {
+			t.Errorf("%s = %q, want %q", field, got[field], want)
+		}
+	}
+	for key, want := range labels {
+		if got, _ := event.GetLabel(key); got != want {
+			t.Errorf("label %s = %q, want %q (labels %v)", key, got, want, event.Labels)
+		}
+	}
+}
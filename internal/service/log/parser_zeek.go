@@ -0,0 +1,293 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+var zeekDataPattern = regexp.MustCompile(`^\d{9,10}\.\d+\t`)
+
+// maxZeekSources bounds the number of streams whose headers are remembered
+const maxZeekSources = 1024
+
+// zeekHeader holds the layout declared by the "#" lines at the top of a Zeek TSV log
+type zeekHeader struct {
+	path   string
+	fields []string
+}
+
+// zeekSource holds the headers seen on one log stream. Header fields are
+// replaced, never modified, so a copied header stays valid.
+type zeekSource struct {
+	separator string
+	headers   map[string]*zeekHeader
+	lastPath  string
+}
+
+// ZeekParser parses Zeek conn, dns and http logs in either TSV or JSON form.
+// TSV header lines are remembered per source so that the data lines following
+// them can be decoded; they produce no event themselves. Lines parsed without
+// a source share one set of headers.
+type ZeekParser struct {
+	sources map[string]*zeekSource
+	mutex   sync.Mutex
+}
+
+// NewZeekParser creates a new Zeek log parser
+func NewZeekParser() *ZeekParser {
+	return &ZeekParser{
+		sources: make(map[string]*zeekSource),
+	}
+}
+
+// Name returns the parser name
+func (p *ZeekParser) Name() string {
+	return "zeek"
+}
+
+// Detect recognises Zeek TSV header and data lines and Zeek JSON records
+func (p *ZeekParser) Detect(rawLog string) float64 {
+	switch {
+	case strings.HasPrefix(rawLog, "#separator"), strings.HasPrefix(rawLog, "#fields"),
+		strings.HasPrefix(rawLog, "#path"), strings.HasPrefix(rawLog, "#types"),
+		strings.HasPrefix(rawLog, "#set_separator"), strings.HasPrefix(rawLog, "#empty_field"),
+		strings.HasPrefix(rawLog, "#unset_field"), strings.HasPrefix(rawLog, "#open"),
+		strings.HasPrefix(rawLog, "#close"):
+		return 0.95
+	case strings.HasPrefix(strings.TrimSpace(rawLog), "{") && strings.Contains(rawLog, `"id.orig_h"`):
+		return 0.9
+	case zeekDataPattern.MatchString(rawLog):
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		for _, source := range p.sources {
+			if len(source.headers) > 0 {
+				return 0.8
+			}
+		}
+	}
+	return 0
+}
+
+// Parse parses a Zeek log line into a SecurityEvent. Header lines return ErrSkipLine.
+func (p *ZeekParser) Parse(rawLog string) (*entity.SecurityEvent, error) {
+	return p.ParseSource("", rawLog)
+}
+
+// ParseSource parses a Zeek log line read from the named stream, decoding TSV
+// lines with the headers seen on that stream
+func (p *ZeekParser) ParseSource(source, rawLog string) (*entity.SecurityEvent, error) {
+	line := strings.TrimRight(rawLog, "\r\n")
+	if strings.HasPrefix(line, "#") {
+		p.parseHeader(source, line)
+		return nil, ErrSkipLine
+	}
+
+	var path string
+	var record map[string]string
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		var err error
+		if record, err = parseZeekJSON(line); err != nil {
+			return nil, err
+		}
+		path = guessZeekPath(record)
+	} else {
+		header, separator, err := p.headerFor(source, line)
+		if err != nil {
+			return nil, err
+		}
+		path = header.path
+		values := strings.Split(line, separator)
+		if len(values) != len(header.fields) {
+			return nil, fmt.Errorf("line has %d columns, #fields header declares %d", len(values), len(header.fields))
+		}
+		record = make(map[string]string, len(values))
+		for i, name := range header.fields {
+			if values[i] != "-" && values[i] != "(empty)" {
+				record[name] = values[i]
+			}
+		}
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = rawLog
+	event.EventType = path
+	p.applyCommon(event, record)
+
+	switch path {
+	case "conn":
+		event.Status = record["conn_state"]
+		setLabelIfPresent(event, "conn.service", record["service"])
+		setLabelIfPresent(event, "conn.duration", record["duration"])
+		setLabelIfPresent(event, "conn.orig_bytes", record["orig_bytes"])
+		setLabelIfPresent(event, "conn.resp_bytes", record["resp_bytes"])
+		setLabelIfPresent(event, "conn.history", record["history"])
+	case "dns":
+		event.Description = record["query"]
+		setLabelIfPresent(event, "dns.query", record["query"])
+		setLabelIfPresent(event, "dns.qtype", record["qtype_name"])
+		setLabelIfPresent(event, "dns.rcode", record["rcode_name"])
+		setLabelIfPresent(event, "dns.answers", record["answers"])
+	case "http":
+		event.Description = strings.TrimSpace(record["method"] + " " + record["host"] + record["uri"])
+		event.Status = record["status_code"]
+		setLabelIfPresent(event, "http.method", record["method"])
+		setLabelIfPresent(event, "http.hostname", record["host"])
+		setLabelIfPresent(event, "http.url", record["uri"])
+		setLabelIfPresent(event, "http.user_agent", record["user_agent"])
+	}
+
+	return event, nil
+}
+
+// applyCommon maps the connection tuple shared by all Zeek logs
+func (p *ZeekParser) applyCommon(event *entity.SecurityEvent, record map[string]string) {
+	if ts, err := strconv.ParseFloat(record["ts"], 64); err == nil {
+		sec, frac := math.Modf(ts)
+		event.Timestamp = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	} else if t, err := time.Parse(time.RFC3339Nano, record["ts"]); err == nil {
+		event.Timestamp = t
+	}
+
+	event.SourceIP = record["id.orig_h"]
+	event.DestIP = record["id.resp_h"]
+	if port, err := strconv.Atoi(record["id.resp_p"]); err == nil {
+		event.Port = port
+	}
+	event.Protocol = strings.ToUpper(record["proto"])
+
+	setLabelIfPresent(event, "source_port", record["id.orig_p"])
+	setLabelIfPresent(event, "zeek_uid", record["uid"])
+	setLabelIfPresent(event, "community_id", record["community_id"])
+}
+
+// parseHeader records a "#" directive from a TSV log header
+func (p *ZeekParser) parseHeader(name, line string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	source, ok := p.sources[name]
+	if !ok {
+		// Forget an arbitrary stream rather than growing without bound
+		if len(p.sources) >= maxZeekSources {
+			for other := range p.sources {
+				delete(p.sources, other)
+				break
+			}
+		}
+		source = &zeekSource{separator: "\t", headers: make(map[string]*zeekHeader)}
+		p.sources[name] = source
+	}
+
+	directive, value, _ := strings.Cut(line, source.separator)
+	if directive == line {
+		directive, value, _ = strings.Cut(line, " ")
+	}
+
+	switch directive {
+	case "#separator":
+		source.separator = decodeZeekEscapes(value)
+	case "#path":
+		if _, ok := source.headers[value]; !ok {
+			source.headers[value] = &zeekHeader{path: value}
+		}
+		source.lastPath = value
+	case "#fields":
+		header, ok := source.headers[source.lastPath]
+		if !ok {
+			header = &zeekHeader{path: source.lastPath}
+			source.headers[source.lastPath] = header
+		}
+		header.fields = strings.Split(value, source.separator)
+	}
+}
+
+// headerFor picks a copy of the header describing a data line, together with
+// the separator of its stream. The most recent header is preferred; otherwise
+// the header whose column count matches is used, which keeps interleaved logs
+// working as long as their layouts differ.
+func (p *ZeekParser) headerFor(name, line string) (zeekHeader, string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	source, ok := p.sources[name]
+	if !ok {
+		return zeekHeader{}, "", fmt.Errorf("no #fields header seen")
+	}
+	columns := strings.Count(line, source.separator) + 1
+	if header, ok := source.headers[source.lastPath]; ok && len(header.fields) == columns {
+		return *header, source.separator, nil
+	}
+	for _, header := range source.headers {
+		if len(header.fields) == columns {
+			return *header, source.separator, nil
+		}
+	}
+	return zeekHeader{}, "", fmt.Errorf("no #fields header with %d columns seen", columns)
+}
+
+// parseZeekJSON flattens a Zeek JSON record into string values
+func parseZeekJSON(line string) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+
+	record := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			record[key] = v
+		case float64:
+			record[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			record[key] = strconv.FormatBool(v)
+		case []interface{}:
+			parts := make([]string, 0, len(v))
+			for _, item := range v {
+				parts = append(parts, fmt.Sprintf("%v", item))
+			}
+			record[key] = strings.Join(parts, ",")
+		}
+	}
+	return record, nil
+}
+
+// guessZeekPath infers the log type of a JSON record, which carries no #path
+func guessZeekPath(record map[string]string) string {
+	switch {
+	case record["_path"] != "":
+		return record["_path"]
+	case record["query"] != "" || record["qtype_name"] != "":
+		return "dns"
+	case record["method"] != "" || record["uri"] != "":
+		return "http"
+	case record["conn_state"] != "" || record["history"] != "":
+		return "conn"
+	default:
+		return "zeek"
+	}
+}
+
+// decodeZeekEscapes decodes \xHH sequences used in the #separator directive
+func decodeZeekEscapes(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if code, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var zeekConnHeader = []string{
+	"#separator \\x09",
+	"#path\tconn",
+	"#fields\tts\tuid\tid.orig_h\tid.orig_p\tid.resp_h\tid.resp_p\tproto\tconn_state",
+}
+
+func TestZeekParserTSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantErr bool
+		check   func(t *testing.T, source, dest, protocol, status string, port int)
+	}{
+		{
+			name: "conn line",
+			line: "1700000000.123456\tCx1\t10.0.0.1\t51000\t10.0.0.2\t22\ttcp\tS0",
+			check: func(t *testing.T, source, dest, protocol, status string, port int) {
+				if source != "10.0.0.1" || dest != "10.0.0.2" || port != 22 || protocol != "TCP" || status != "S0" {
+					t.Errorf("got %s -> %s:%d %s %s", source, dest, port, protocol, status)
+				}
+			},
+		},
+		{
+			name:    "too few columns",
+			line:    "1700000000.123456\tCx1\t10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name:    "too many columns",
+			line:    "1700000000.123456\tCx1\t10.0.0.1\t51000\t10.0.0.2\t22\ttcp\tS0\textra",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewZeekParser()
+			for _, line := range zeekConnHeader {
+				if _, err := parser.Parse(line); !errors.Is(err, ErrSkipLine) {
+					t.Fatalf("header %q: got %v, want ErrSkipLine", line, err)
+				}
+			}
+
+			event, err := parser.Parse(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, event.SourceIP, event.DestIP, event.Protocol, event.Status, event.Port)
+		})
+	}
+}
+
+func TestZeekParserDataWithoutHeader(t *testing.T) {
+	if _, err := NewZeekParser().Parse("1700000000.1\tCx1\t10.0.0.1"); err == nil {
+		t.Fatal("expected error for a data line without header")
+	}
+}
+
+func TestZeekParserJSON(t *testing.T) {
+	event, err := NewZeekParser().Parse(`{"ts":1700000000.5,"id.orig_h":"10.0.0.1","id.resp_h":"10.0.0.2","id.resp_p":53,"proto":"udp","query":"example.com"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "dns" || event.Description != "example.com" || event.Protocol != "UDP" {
+		t.Errorf("got type %q description %q protocol %q", event.EventType, event.Description, event.Protocol)
+	}
+}
+
+func TestZeekParserHeadersPerSource(t *testing.T) {
+	parser := NewZeekParser()
+	for _, line := range zeekConnHeader {
+		parser.ParseSource("a.log", line)
+	}
+	parser.ParseSource("b.log", "#path\tdns")
+	parser.ParseSource("b.log", "#fields\tts\tid.orig_h\tid.resp_h\tquery")
+
+	event, err := parser.ParseSource("b.log", "1700000000.1\t10.0.0.1\t10.0.0.53\texample.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "dns" {
+		t.Errorf("b.log: got type %q, want dns", event.EventType)
+	}
+
+	event, err = parser.ParseSource("a.log", "1700000000.1\tCx1\t10.0.0.1\t51000\t10.0.0.2\t22\ttcp\tS0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "conn" {
+		t.Errorf("a.log: got type %q, want conn", event.EventType)
+	}
+
+	// Headers of one source do not decode lines of another
+	if _, err := parser.ParseSource("c.log", "1700000000.1\t10.0.0.1\t10.0.0.53\texample.com"); err == nil {
+		t.Error("c.log: expected error without header")
+	}
+}
+
+func TestZeekParserConcurrentSources(t *testing.T) {
+	parser := NewZeekParser()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			source := fmt.Sprintf("conn-%d.log", i)
+			for round := 0; round < 50; round++ {
+				// Alternate between two layouts to change the header mid-stream
+				fields := zeekConnHeader[2]
+				line := "1700000000.1\tCx1\t10.0.0.1\t51000\t10.0.0.2\t22\ttcp\tS0"
+				if round%2 == 1 {
+					fields = "#fields\tts\tid.orig_h\tid.resp_h"
+					line = "1700000000.1\t10.0.0.1\t10.0.0.2"
+				}
+				parser.ParseSource(source, zeekConnHeader[1])
+				parser.ParseSource(source, fields)
+				event, err := parser.ParseSource(source, line)
+				if err != nil {
+					t.Errorf("%s round %d: %v", source, round, err)
+					return
+				}
+				if !strings.HasPrefix(event.SourceIP, "10.0.0.") {
+					t.Errorf("%s round %d: source ip %q", source, round, event.SourceIP)
+				}
+				parser.Detect(line)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,453 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+var (
+	// ErrBackpressure is returned when the pipeline queue stayed full for longer
+	// than the submit timeout. Callers should retry later.
+	ErrBackpressure = errors.New("log pipeline is saturated")
+
+	// ErrPipelineClosed is returned for logs submitted after Stop
+	ErrPipelineClosed = errors.New("log pipeline is closed")
+)
+
+// PipelineConfig configures the concurrent processing pipeline
+type PipelineConfig struct {
+	// QueueSize bounds the number of raw logs waiting to be parsed. Logs of a
+	// named source, such as a tailed file, wait in per-worker queues that hold
+	// up to another QueueSize logs in total.
+	QueueSize int `json:"queue_size" yaml:"queue_size"`
+	// Workers parse and enrich logs concurrently
+	Workers int `json:"workers" yaml:"workers"`
+	// Writers run anomaly detection and save events, one micro-batch at a time
+	Writers int `json:"writers" yaml:"writers"`
+	// BatchSize and FlushInterval bound the size and age of micro-batches
+	BatchSize     int           `json:"batch_size"     yaml:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval" yaml:"flush_interval"`
+	// SubmitTimeout is how long a caller waits for queue space before
+	// ErrBackpressure is returned. Zero fails immediately when the queue is full.
+	SubmitTimeout time.Duration `json:"submit_timeout" yaml:"submit_timeout"`
+}
+
+// DefaultPipelineConfig returns the default pipeline configuration
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		QueueSize:     10000,
+		Workers:       8,
+		Writers:       2,
+		BatchSize:     200,
+		FlushInterval: 500 * time.Millisecond,
+		SubmitTimeout: 2 * time.Second,
+	}
+}
+
+func (c PipelineConfig) withDefaults() PipelineConfig {
+	d := DefaultPipelineConfig()
+	if c.QueueSize <= 0 {
+		c.QueueSize = d.QueueSize
+	}
+	if c.Workers <= 0 {
+		c.Workers = d.Workers
+	}
+	if c.Writers <= 0 {
+		c.Writers = d.Writers
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = d.FlushInterval
+	}
+	if c.SubmitTimeout < 0 {
+		c.SubmitTimeout = 0
+	}
+	return c
+}
+
+// PipelineStats holds pipeline counters and the current queue depth
+type PipelineStats struct {
+	Submitted int64 `json:"submitted"`
+	Rejected  int64 `json:"rejected"`
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+	Batches   int64 `json:"batches"`
+	// DeadLetterErrors counts failed records that could not be dead-lettered
+	DeadLetterErrors int64   `json:"dead_letter_errors"`
+	Queued           int     `json:"queued"`
+	QueueSize        int     `json:"queue_size"`
+	Saturation       float64 `json:"saturation"`
+}
+
+// pipelineItem is a raw log travelling through the pipeline
+type pipelineItem struct {
+	raw      string
+	source   string
+	result   *ProcessResult
+	prepared *preparedEvent
+	err      error
+	// batchErr is the failure of the whole micro-batch the item was part of
+	batchErr error
+	done     *sync.WaitGroup
+}
+
+// Pipeline processes logs through a staged worker pool:
+//
+//	submit -> [queue] -> parse/enrich workers -> [events] -> writers
+//
+// Workers parse, enrich and deduplicate logs concurrently. Logs of a named
+// source are queued for one worker chosen by the source, so stateful parsers
+// such as Zeek see each stream in order. Writers collect the
+// resulting events into micro-batches bounded by size and age and hand each
+// batch to the anomaly detector and the repository in one go. Both channels
+// are bounded, so a slow repository eventually fills the queue and callers
+// get ErrBackpressure instead of unbounded memory growth.
+type Pipeline struct {
+	processor *LogProcessor
+	config    PipelineConfig
+
+	queue   chan *pipelineItem
+	sources []chan *pipelineItem // per-worker queues of logs with a source
+	events  chan *pipelineItem
+
+	submitted int64
+	rejected  int64
+	processed int64
+	failed    int64
+	batches   int64
+	// deadLetterErrors counts failed records lost because the dead letter
+	// store rejected them
+	deadLetterErrors int64
+
+	quit      chan struct{}
+	closed    bool
+	mutex     sync.RWMutex
+	workersWg sync.WaitGroup
+	writersWg sync.WaitGroup
+	stopOnce  sync.Once
+}
+
+// NewPipeline creates a processing pipeline on top of a log processor
+func NewPipeline(processor *LogProcessor, config PipelineConfig) *Pipeline {
+	config = config.withDefaults()
+	sources := make([]chan *pipelineItem, config.Workers)
+	for i := range sources {
+		sources[i] = make(chan *pipelineItem, max(1, config.QueueSize/config.Workers))
+	}
+	return &Pipeline{
+		processor: processor,
+		config:    config,
+		queue:     make(chan *pipelineItem, config.QueueSize),
+		sources:   sources,
+		events:    make(chan *pipelineItem, config.BatchSize*config.Writers),
+		quit:      make(chan struct{}),
+	}
+}
+
+// Start launches the workers and writers. Queued logs are processed with a
+// context detached from ctx so that Stop can drain them.
+func (p *Pipeline) Start(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+
+	for i := 0; i < p.config.Workers; i++ {
+		p.workersWg.Add(1)
+		go p.work(ctx, p.sources[i])
+	}
+	for i := 0; i < p.config.Writers; i++ {
+		p.writersWg.Add(1)
+		go p.write(ctx)
+	}
+}
+
+// Stop rejects new submissions and waits until everything already queued has
+// been processed
+func (p *Pipeline) Stop() {
+	p.stopOnce.Do(func() {
+		// Release submitters blocked on a full queue before taking the lock
+		close(p.quit)
+
+		p.mutex.Lock()
+		p.closed = true
+		close(p.queue)
+		for _, queue := range p.sources {
+			close(queue)
+		}
+		p.mutex.Unlock()
+
+		p.workersWg.Wait()
+		close(p.events)
+		p.writersWg.Wait()
+	})
+}
+
+// Stats returns the pipeline counters
+func (p *Pipeline) Stats() PipelineStats {
+	queued, capacity := len(p.queue), cap(p.queue)
+	for _, queue := range p.sources {
+		queued += len(queue)
+		capacity += cap(queue)
+	}
+	return PipelineStats{
+		Submitted:        atomic.LoadInt64(&p.submitted),
+		Rejected:         atomic.LoadInt64(&p.rejected),
+		Processed:        atomic.LoadInt64(&p.processed),
+		Failed:           atomic.LoadInt64(&p.failed),
+		Batches:          atomic.LoadInt64(&p.batches),
+		DeadLetterErrors: atomic.LoadInt64(&p.deadLetterErrors),
+		Queued:           queued,
+		QueueSize:        capacity,
+		Saturation:       float64(queued) / float64(capacity),
+	}
+}
+
+// DeadLetterLogs stores raw logs that were never processed in the dead letter
+// store of the processor
+func (p *Pipeline) DeadLetterLogs(ctx context.Context, logs []string, cause error) error {
+	return p.processor.DeadLetterLogs(ctx, logs, cause)
+}
+
+// RetryAfter suggests how long a rejected caller should wait before retrying
+func (p *Pipeline) RetryAfter() time.Duration {
+	return p.config.FlushInterval + p.config.SubmitTimeout
+}
+
+// ProcessLog submits a single log and waits for it to be processed
+func (p *Pipeline) ProcessLog(ctx context.Context, rawLog string) (*ProcessResult, error) {
+	items, err := p.process(ctx, []string{rawLog})
+	if err != nil {
+		return items[0].result, err
+	}
+	return items[0].result, items[0].err
+}
+
+// BatchProcessLogs submits logs to the pipeline and waits until all accepted
+// logs have been processed. If the queue stays full, the remaining logs are
+// rejected and ErrBackpressure is returned together with the results of the
+// logs that were accepted. As with LogProcessor.BatchProcessLogs, an error is
+// also returned when a micro-batch failed as a whole, for example while the
+// repository is down. Its records are not dead-lettered; the caller is
+// expected to retry the logs.
+func (p *Pipeline) BatchProcessLogs(ctx context.Context, logs []string) ([]*ProcessResult, error) {
+	items, err := p.process(ctx, logs)
+
+	results := make([]*ProcessResult, len(items))
+	for i, item := range items {
+		results[i] = item.result
+	}
+	return results, err
+}
+
+func (p *Pipeline) process(ctx context.Context, logs []string) ([]*pipelineItem, error) {
+	var done sync.WaitGroup
+	source := SourceFromContext(ctx)
+	items := make([]*pipelineItem, len(logs))
+	for i, raw := range logs {
+		result := &ProcessResult{Index: i, tenant: tenant.FromContext(ctx)}
+		items[i] = &pipelineItem{raw: raw, source: source, result: result, done: &done}
+	}
+
+	accepted, err := p.submit(ctx, items)
+	done.Wait()
+
+	if rejected := len(items) - accepted; rejected > 0 {
+		atomic.AddInt64(&p.rejected, int64(rejected))
+		for _, item := range items[accepted:] {
+			item.err = err
+			item.result.Error = err.Error()
+		}
+	}
+	if err == nil {
+		for _, item := range items {
+			if item.batchErr != nil {
+				return items, item.batchErr
+			}
+		}
+	}
+	return items, err
+}
+
+// submit enqueues items and returns how many were accepted
+func (p *Pipeline) submit(ctx context.Context, items []*pipelineItem) (int, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return 0, ErrPipelineClosed
+	}
+
+	var timeout <-chan time.Time
+	for i, item := range items {
+		item.done.Add(1)
+		queue := p.queue
+		if item.source != "" {
+			queue = p.sourceQueue(item.source)
+		}
+
+		// Fast path while the queue has room
+		select {
+		case queue <- item:
+			atomic.AddInt64(&p.submitted, 1)
+			continue
+		default:
+		}
+
+		if timeout == nil {
+			timer := time.NewTimer(p.config.SubmitTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case queue <- item:
+			atomic.AddInt64(&p.submitted, 1)
+		case <-timeout:
+			item.done.Done()
+			return i, ErrBackpressure
+		case <-p.quit:
+			item.done.Done()
+			return i, ErrPipelineClosed
+		case <-ctx.Done():
+			item.done.Done()
+			return i, ctx.Err()
+		}
+	}
+	return len(items), nil
+}
+
+// sourceQueue returns the worker queue of a named source
+func (p *Pipeline) sourceQueue(source string) chan *pipelineItem {
+	hash := fnv.New32a()
+	hash.Write([]byte(source))
+	return p.sources[hash.Sum32()%uint32(len(p.sources))]
+}
+
+// work is the parse and enrich stage. It takes logs from the shared queue
+// and from its own queue of logs with a source until both are closed.
+func (p *Pipeline) work(ctx context.Context, sources chan *pipelineItem) {
+	defer p.workersWg.Done()
+
+	queue := p.queue
+	for queue != nil || sources != nil {
+		select {
+		case item, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			p.prepare(ctx, item)
+		case item, ok := <-sources:
+			if !ok {
+				sources = nil
+				continue
+			}
+			p.prepare(ctx, item)
+		}
+	}
+}
+
+// prepare parses and enriches a log in the tenant and source it was submitted
+// with and hands it to the writers
+func (p *Pipeline) prepare(ctx context.Context, item *pipelineItem) {
+	ctx = tenant.WithTenant(ctx, item.result.tenant)
+	if item.source != "" {
+		ctx = WithSource(ctx, item.source)
+	}
+
+	prepared, err := p.processor.prepare(ctx, "", item.raw, item.result)
+	item.err = err
+	if prepared == nil {
+		if err != nil {
+			p.processor.deadLetter(ctx, []string{item.raw}, []*ProcessResult{item.result})
+		}
+		p.finish(item)
+		return
+	}
+	item.prepared = prepared
+	p.events <- item
+}
+
+// write is the detect and save stage. It collects events into micro-batches
+// bounded by BatchSize and FlushInterval.
+func (p *Pipeline) write(ctx context.Context) {
+	defer p.writersWg.Done()
+
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*pipelineItem, 0, p.config.BatchSize)
+	for {
+		select {
+		case item, ok := <-p.events:
+			if !ok {
+				p.flush(ctx, batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= p.config.BatchSize {
+				p.flush(ctx, batch)
+				batch = make([]*pipelineItem, 0, p.config.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(ctx, batch)
+				batch = make([]*pipelineItem, 0, p.config.BatchSize)
+			}
+		}
+	}
+}
+
+func (p *Pipeline) flush(ctx context.Context, batch []*pipelineItem) {
+	if len(batch) == 0 {
+		return
+	}
+
+	prepared := make([]*preparedEvent, len(batch))
+	for i, item := range batch {
+		prepared[i] = item.prepared
+	}
+	err := p.processor.commit(ctx, prepared)
+	atomic.AddInt64(&p.batches, 1)
+
+	// Every record failed with the batch. The error is returned to the
+	// submitters, who retry, so nothing is dead-lettered.
+	if err != nil {
+		for _, item := range batch {
+			item.err, item.batchErr = err, err
+			p.finish(item)
+		}
+		return
+	}
+
+	raws := make([]string, len(batch))
+	results := make([]*ProcessResult, len(batch))
+	for i, item := range batch {
+		raws[i], results[i] = item.raw, item.result
+	}
+	p.processor.deadLetter(ctx, raws, results)
+
+	for _, item := range batch {
+		item.err = item.prepared.err
+		p.finish(item)
+	}
+}
+
+// finish records the outcome of an item and releases its submitter
+func (p *Pipeline) finish(item *pipelineItem) {
+	if item.result.Error != "" {
+		atomic.AddInt64(&p.failed, 1)
+		if item.result.DeadLetterError != "" {
+			atomic.AddInt64(&p.deadLetterErrors, 1)
+		}
+	} else {
+		atomic.AddInt64(&p.processed, 1)
+	}
+	item.done.Done()
+}
@@ -0,0 +1,312 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/tenant"
+)
+
+// testLog returns the n-th of a series of log lines that are not repeats of
+// each other
+func testLog(n int) string {
+	return fmt.Sprintf(`{"timestamp":"2024-05-01T10:00:00Z","source_ip":"10.0.%d.%d","event_type":"login","user":"user%d"}`, n/256, n%256, n)
+}
+
+func TestPipelineConcurrentSubmitters(t *testing.T) {
+	tests := []struct {
+		name   string
+		config PipelineConfig
+	}{
+		{name: "single writer", config: PipelineConfig{QueueSize: 16, Workers: 4, Writers: 1, BatchSize: 8, FlushInterval: 5 * time.Millisecond, SubmitTimeout: time.Minute}},
+		{name: "many writers", config: PipelineConfig{QueueSize: 64, Workers: 8, Writers: 4, BatchSize: 3, FlushInterval: time.Millisecond, SubmitTimeout: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, events, _ := newTestProcessor(&stubDetector{score: map[string]float32{"login": 0.9}})
+			pipeline := NewPipeline(processor, tt.config)
+			pipeline.Start(context.Background())
+
+			const submitters, logs = 8, 25
+			var wg sync.WaitGroup
+			errs := make(chan error, submitters*logs)
+			for s := 0; s < submitters; s++ {
+				wg.Add(1)
+				go func(s int) {
+					defer wg.Done()
+					ctx := tenant.WithTenant(context.Background(), fmt.Sprintf("tenant%d", s%2))
+					batch := make([]string, 0, logs)
+					for i := 0; i < logs; i++ {
+						batch = append(batch, testLog(s*logs+i))
+					}
+					// Half of the submitters send single logs, the others one batch
+					if s%2 == 0 {
+						for _, raw := range batch {
+							if result, err := pipeline.ProcessLog(ctx, raw); err != nil || result.EventID == "" {
+								errs <- fmt.Errorf("process %s: %v", raw, err)
+							}
+						}
+						return
+					}
+					results, err := pipeline.BatchProcessLogs(ctx, batch)
+					if err != nil {
+						errs <- err
+					}
+					for _, result := range results {
+						if result.Error != "" || result.Anomalies != 1 {
+							errs <- fmt.Errorf("result %+v", result)
+						}
+						if saved := events.get(result.EventID); saved == nil || saved.TenantID != tenant.FromContext(ctx) {
+							errs <- fmt.Errorf("event %s saved as %+v", result.EventID, saved)
+						}
+					}
+				}(s)
+			}
+			wg.Wait()
+			pipeline.Stop()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			stats := pipeline.Stats()
+			if stats.Submitted != submitters*logs || stats.Processed != submitters*logs || stats.Failed != 0 || stats.Rejected != 0 {
+				t.Errorf("stats = %+v", stats)
+			}
+			if events.saves != submitters*logs || len(events.anomalies) != submitters*logs {
+				t.Errorf("saved %d events and %d anomalies, want %d", events.saves, len(events.anomalies), submitters*logs)
+			}
+		})
+	}
+}
+
+// gatedDetector blocks every batch until released
+type gatedDetector struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (d *gatedDetector) ProcessEvents(ctx context.Context, events []*entity.SecurityEvent) ([]*entity.AnomalyResult, error) {
+	d.once.Do(func() { close(d.entered) })
+	<-d.release
+	return nil, nil
+}
+
+func TestPipelineBackpressure(t *testing.T) {
+	detector := &gatedDetector{entered: make(chan struct{}), release: make(chan struct{})}
+	processor, events, _ := newTestProcessor(detector)
+	pipeline := NewPipeline(processor, PipelineConfig{
+		QueueSize: 1, Workers: 1, Writers: 1, BatchSize: 1,
+		FlushInterval: time.Millisecond, SubmitTimeout: 10 * time.Millisecond,
+	})
+	pipeline.Start(context.Background())
+
+	// The writer blocks in the detector; the events channel, the worker and
+	// the queue then hold one log each before submitters are turned away
+	go func() {
+		<-detector.entered
+		time.Sleep(100 * time.Millisecond)
+		close(detector.release)
+	}()
+
+	logs := make([]string, 10)
+	for i := range logs {
+		logs[i] = testLog(i)
+	}
+	results, err := pipeline.BatchProcessLogs(context.Background(), logs)
+	if !errors.Is(err, ErrBackpressure) {
+		t.Fatalf("err = %v, want ErrBackpressure", err)
+	}
+
+	accepted := 0
+	for _, result := range results {
+		if result.Error == "" {
+			accepted++
+		} else if result.Error != ErrBackpressure.Error() {
+			t.Errorf("result %+v", result)
+		}
+	}
+	if accepted == 0 || accepted == len(logs) {
+		t.Errorf("accepted %d of %d logs", accepted, len(logs))
+	}
+
+	pipeline.Stop()
+	stats := pipeline.Stats()
+	if stats.Rejected != int64(len(logs)-accepted) || stats.Processed != int64(accepted) {
+		t.Errorf("stats = %+v with %d accepted", stats, accepted)
+	}
+	if events.saves != accepted {
+		t.Errorf("saved %d events, want %d", events.saves, accepted)
+	}
+	if _, err := pipeline.ProcessLog(context.Background(), logs[0]); !errors.Is(err, ErrPipelineClosed) {
+		t.Errorf("after stop err = %v, want ErrPipelineClosed", err)
+	}
+}
+
+func TestPipelineFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		detector    *stubDetector
+		logs        []string
+		wantErr     bool
+		wantFailed  int64
+		wantLetters int
+	}{
+		{
+			name:        "unparseable record is dead-lettered",
+			detector:    &stubDetector{},
+			logs:        []string{testLog(0), "not a log line \x00"},
+			wantFailed:  1,
+			wantLetters: 1,
+		},
+		{
+			name:       "batch failure is returned without dead-lettering",
+			detector:   &stubDetector{err: errors.New("model unavailable")},
+			logs:       []string{testLog(0), testLog(1)},
+			wantErr:    true,
+			wantFailed: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/single", func(t *testing.T) {
+			processor, _, deadLetters := newTestProcessor(tt.detector)
+			pipeline := NewPipeline(processor, PipelineConfig{Workers: 2, Writers: 1, BatchSize: len(tt.logs), FlushInterval: time.Millisecond})
+			pipeline.Start(context.Background())
+
+			var wg sync.WaitGroup
+			errs := make([]error, len(tt.logs))
+			for i, raw := range tt.logs {
+				wg.Add(1)
+				go func(i int, raw string) {
+					defer wg.Done()
+					_, errs[i] = pipeline.ProcessLog(context.Background(), raw)
+				}(i, raw)
+			}
+			wg.Wait()
+			pipeline.Stop()
+
+			if tt.wantErr {
+				for i, err := range errs {
+					if err == nil {
+						t.Errorf("log %d: expected error", i)
+					}
+				}
+			}
+			if got := pipeline.Stats().Failed; got != tt.wantFailed {
+				t.Errorf("failed = %d, want %d", got, tt.wantFailed)
+			}
+			if got := deadLetters.len(); got != tt.wantLetters {
+				t.Errorf("dead letters = %d, want %d", got, tt.wantLetters)
+			}
+		})
+
+		t.Run(tt.name+"/batch", func(t *testing.T) {
+			processor, _, deadLetters := newTestProcessor(tt.detector)
+			pipeline := NewPipeline(processor, PipelineConfig{Workers: 2, Writers: 1, BatchSize: len(tt.logs), FlushInterval: time.Millisecond})
+			pipeline.Start(context.Background())
+
+			results, err := pipeline.BatchProcessLogs(context.Background(), tt.logs)
+			pipeline.Stop()
+
+			// Like LogProcessor.BatchProcessLogs, only a failure of the whole
+			// batch is returned
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			failed := int64(0)
+			for _, result := range results {
+				if result.Error != "" {
+					failed++
+				}
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("failed results = %d, want %d", failed, tt.wantFailed)
+			}
+			if got := deadLetters.len(); got != tt.wantLetters {
+				t.Errorf("dead letters = %d, want %d", got, tt.wantLetters)
+			}
+		})
+	}
+}
+
+func TestPipelineSources(t *testing.T) {
+	processor, events, _ := newTestProcessor(&stubDetector{})
+	pipeline := NewPipeline(processor, PipelineConfig{Workers: 8, Writers: 2, BatchSize: 16, FlushInterval: time.Millisecond, SubmitTimeout: time.Minute})
+	pipeline.Start(context.Background())
+	defer pipeline.Stop()
+
+	// Every file lists its columns in a different order, so a data line parsed
+	// with the header of another file or before its own header fails
+	const files, lines = 6, 40
+	header := func(f int) []string {
+		orig, resp := "id.orig_h", "id.resp_h"
+		if f%2 == 1 {
+			orig, resp = resp, orig
+		}
+		return []string{"#separator \\x09", "#path\tconn", fmt.Sprintf("#fields\tts\tuid\t%s\tid.orig_p\t%s\tid.resp_p\tproto\tconn_state", orig, resp)}
+	}
+	data := func(f, round int) []string {
+		batch := make([]string, 0, lines)
+		for i := 0; i < lines; i++ {
+			source, dest := fmt.Sprintf("10.%d.%d.%d", f, round, i), "192.168.0.1"
+			if f%2 == 1 {
+				source, dest = dest, source
+			}
+			batch = append(batch, fmt.Sprintf("1700000000.%06d\tC%d\t%s\t51000\t%s\t22\ttcp\tS0", i, i, source, dest))
+		}
+		return batch
+	}
+	source := func(f, round int) context.Context {
+		return WithSource(context.Background(), fmt.Sprintf("/var/log/zeek/conn-%d.%d.log", f, round))
+	}
+
+	// Round 0 sends the headers of all files before their data, round 1
+	// sends each file in one batch
+	for f := 0; f < files; f++ {
+		if _, err := pipeline.BatchProcessLogs(source(f, 0), header(f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*files*lines)
+	for f := 0; f < files; f++ {
+		for round := 0; round < 2; round++ {
+			batch, skip := data(f, round), 0
+			if round == 1 {
+				batch, skip = append(header(f), batch...), len(header(f))
+			}
+			wg.Add(1)
+			go func(f, round int, batch []string, skip int) {
+				defer wg.Done()
+				results, err := pipeline.BatchProcessLogs(source(f, round), batch)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for i, result := range results[skip:] {
+					if result.Error != "" {
+						errs <- fmt.Errorf("file %d.%d line %d: %s", f, round, i, result.Error)
+						continue
+					}
+					if saved := events.get(result.EventID); saved == nil || saved.SourceIP != fmt.Sprintf("10.%d.%d.%d", f, round, i) {
+						errs <- fmt.Errorf("file %d.%d line %d saved as %+v", f, round, i, saved)
+					}
+				}
+			}(f, round, batch, skip)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -2,145 +2,441 @@ package log
 
 import (
 	"context"
-	"encoding/json"
-	"time"
+	"errors"
+	"fmt"
+	"sync/atomic"
 
-	"github.com/jinye/securityai/internal/ai/anomaly"
 	"github.com/jinye/securityai/internal/domain/entity"
 	"github.com/jinye/securityai/internal/domain/repository"
+	"github.com/jinye/securityai/internal/domain/tenant"
+	"github.com/jinye/securityai/internal/service/redact"
 )
 
 // LogProcessor handles log processing and analysis
 type LogProcessor struct {
-	detector   *anomaly.AnomalyDetector
-	repository repository.EventRepository
-	cache      repository.CacheRepository
-	enricher   *LogEnricher
+	detector    EventDetector
+	repository  repository.EventRepository
+	cache       repository.CacheRepository
+	enricher    *LogEnricher
+	rules       RuleEvaluator
+	parsers     *ParserRegistry
+	dedup       atomic.Pointer[Deduplicator]
+	deadLetters DeadLetterStore
+	redactor    *redact.Redactor
 }
 
 // NewLogProcessor creates a new log processor instance
 func NewLogProcessor(
-	detector *anomaly.AnomalyDetector,
+	detector EventDetector,
 	repository repository.EventRepository,
 	cache repository.CacheRepository,
 	enricher *LogEnricher,
 ) *LogProcessor {
-	return &LogProcessor{
+	dedup, _ := NewDeduplicator(cache, DefaultDedupConfig())
+	p := &LogProcessor{
 		detector:   detector,
 		repository: repository,
 		cache:      cache,
 		enricher:   enricher,
+		parsers:    DefaultParserRegistry(),
 	}
+	p.dedup.Store(dedup)
+	return p
+}
+
+// Parsers returns the parser registry used to decode raw logs
+func (p *LogProcessor) Parsers() *ParserRegistry {
+	return p.parsers
+}
+
+// SetDedupConfig replaces the deduplication policies while logs are being
+// processed. Events already prepared finish with the previous deduplicator;
+// groups opened under the previous policies expire on their own.
+func (p *LogProcessor) SetDedupConfig(config DedupConfig) error {
+	dedup, err := NewDeduplicator(p.cache, config)
+	if err != nil {
+		return err
+	}
+	dedup.SetRedactor(p.redactor)
+	p.dedup.Store(dedup)
+	return nil
+}
+
+// SetRedactor redacts events for the storage destination before they are
+// saved, cached as the aggregate of a dedup group or dead-lettered. Events are
+// analysed before redaction. It must be called once, before processing starts.
+func (p *LogProcessor) SetRedactor(redactor *redact.Redactor) {
+	p.redactor = redactor
+	p.repository = redact.NewEventRepository(p.repository, redactor)
+	p.dedup.Load().SetRedactor(redactor)
+}
+
+// SetRuleEvaluator enables rule matching of new events. Rule hits feed the
+// severity score of the event. Repeats are counted by the stateful rules, and
+// rules they make fire raise the severity of their group's aggregate.
+func (p *LogProcessor) SetRuleEvaluator(rules RuleEvaluator) {
+	p.rules = rules
+}
+
+// Deduplicator returns the deduplicator folding repeated events
+func (p *LogProcessor) Deduplicator() *Deduplicator {
+	return p.dedup.Load()
+}
+
+// ProcessResult reports how a single raw log was handled
+type ProcessResult struct {
+	Index     int    `json:"index"`
+	Parser    string `json:"parser,omitempty"`
+	EventID   string `json:"event_id,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Anomalies int    `json:"anomalies,omitempty"`
+	Stage     string `json:"stage,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// DeadLetterError is set when the failed record could not be written to
+	// the dead letter store and is lost unless the caller keeps it
+	DeadLetterError string `json:"dead_letter_error,omitempty"`
+
+	// tenant owns the log and sourceType names the parser the caller chose,
+	// for dead-lettering
+	tenant     string
+	sourceType string
+}
+
+// preparedEvent is a parsed and enriched event waiting to be analysed and
+// saved. A repeat carries the aggregated event of its group, which is saved
+// again without being analysed.
+type preparedEvent struct {
+	event *entity.SecurityEvent
+	// observed is the repeat itself, under the ID of the aggregate, as the
+	// stateful rules count it
+	observed *entity.SecurityEvent
+	source   string
+	// dedup is the deduplicator that observed the event, which closes or
+	// updates its group
+	dedup    *Deduplicator
+	dedupKey string
+	repeat   bool
+	result   *ProcessResult
+	err      error
+}
+
+func (e *preparedEvent) fail(stage string, err error) {
+	e.err = err
+	e.result.Stage = stage
+	e.result.Error = err.Error()
 }
 
 // ProcessLog processes a single log entry
-func (p *LogProcessor) ProcessLog(ctx context.Context, rawLog string) error {
+func (p *LogProcessor) ProcessLog(ctx context.Context, rawLog string) (*ProcessResult, error) {
+	return p.ProcessLogAs(ctx, "", rawLog)
+}
+
+// ProcessLogAs processes a single log entry of a known source type, parsing it
+// with the parser of that name instead of detecting the format
+func (p *LogProcessor) ProcessLogAs(ctx context.Context, sourceType, rawLog string) (*ProcessResult, error) {
+	result := &ProcessResult{}
+
+	prepared, err := p.prepare(ctx, sourceType, rawLog, result)
+	if err == nil && prepared != nil {
+		if err = p.commit(ctx, []*preparedEvent{prepared}); err != nil {
+			// The caller retries a failed commit
+			return result, err
+		}
+		err = prepared.err
+	}
+
+	p.deadLetter(ctx, []string{rawLog}, []*ProcessResult{result})
+	return result, err
+}
+
+// BatchProcessLogs processes multiple log entries in batch. Records that
+// cannot be parsed, enriched or saved are reported in the results and sent to
+// the dead letter store; an error is only returned when the whole batch failed.
+// Nothing is dead-lettered then, since the caller is expected to retry the
+// batch and would otherwise dead-letter the same records again.
+func (p *LogProcessor) BatchProcessLogs(ctx context.Context, logs []string) ([]*ProcessResult, error) {
+	return p.BatchProcessLogsAs(ctx, "", logs)
+}
+
+// BatchProcessLogsAs processes log entries that all belong to a known source type
+func (p *LogProcessor) BatchProcessLogsAs(ctx context.Context, sourceType string, logs []string) ([]*ProcessResult, error) {
+	results, err := p.batchProcess(ctx, sourceType, logs)
+	if err != nil {
+		return results, err
+	}
+	p.deadLetter(ctx, logs, results)
+	return results, nil
+}
+
+func (p *LogProcessor) batchProcess(ctx context.Context, sourceType string, logs []string) ([]*ProcessResult, error) {
+	batch := make([]*preparedEvent, 0, len(logs))
+	results := make([]*ProcessResult, 0, len(logs))
+
+	for i, log := range logs {
+		result := &ProcessResult{Index: i}
+		results = append(results, result)
+
+		prepared, err := p.prepare(ctx, sourceType, log, result)
+		if err != nil || prepared == nil {
+			continue
+		}
+		batch = append(batch, prepared)
+	}
+
+	if err := p.commit(ctx, batch); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// prepare parses, enriches and deduplicates a raw log. It returns nil when
+// the log does not need further processing; the result tells why. An empty
+// source type detects the log format. The event belongs to the tenant of the
+// context.
+func (p *LogProcessor) prepare(ctx context.Context, sourceType, rawLog string, result *ProcessResult) (*preparedEvent, error) {
+	result.tenant = tenant.FromContext(ctx)
+	result.sourceType = sourceType
+
 	// Parse log entry
-	event, err := p.parseLog(rawLog)
+	event, parser, err := p.parse(SourceFromContext(ctx), sourceType, rawLog)
+	result.Parser = parser
+	if errors.Is(err, ErrSkipLine) {
+		result.Skipped = true
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		result.Stage = StageParse
+		result.Error = err.Error()
+		return nil, err
 	}
+	result.EventID = event.ID
+	event.TenantID = result.tenant
 
 	// Enrich log data
 	if err := p.enricher.Enrich(ctx, event); err != nil {
-		return err
+		result.Stage = StageEnrich
+		result.Error = err.Error()
+		return nil, err
 	}
 
-	// Check cache for recent similar events
-	cacheKey := p.generateCacheKey(event)
-	if _, err := p.cache.Get(ctx, cacheKey); err == nil {
-		// Similar event recently processed, skip analysis
-		return nil
+	// Fold repeats into the aggregated event of their group. Events are
+	// analysed without deduplication while the cache is unavailable.
+	dedup := p.dedup.Load()
+	aggregate, dedupKey, err := dedup.Observe(ctx, parser, event)
+	if err != nil {
+		return &preparedEvent{event: event, dedup: dedup, result: result}, nil
+	}
+	if aggregate != nil {
+		result.Duplicate = true
+		result.EventID = aggregate.ID
+		observed := *event
+		observed.ID = aggregate.ID
+		return &preparedEvent{
+			event: aggregate, observed: &observed, source: parser,
+			dedup: dedup, dedupKey: dedupKey, repeat: true, result: result,
+		}, nil
 	}
 
-	// Process event for anomalies
-	anomalies, err := p.detector.ProcessEvents(ctx, []*entity.SecurityEvent{event})
-	if err != nil {
-		return err
+	return &preparedEvent{event: event, source: parser, dedup: dedup, dedupKey: dedupKey, result: result}, nil
+}
+
+// commit runs anomaly detection over the new events of a batch, saves the
+// events and their anomalies and then saves the aggregated events of repeats.
+// Failures of single events are recorded on the event; the returned error
+// means the whole batch failed.
+func (p *LogProcessor) commit(ctx context.Context, batch []*preparedEvent) error {
+	events := make([]*entity.SecurityEvent, 0, len(batch))
+	byID := make(map[string]*preparedEvent, len(batch))
+	repeats := make([]*preparedEvent, 0)
+	for _, prepared := range batch {
+		if prepared.repeat {
+			repeats = append(repeats, prepared)
+			continue
+		}
+		events = append(events, prepared.event)
+		byID[prepared.event.ID] = prepared
 	}
 
-	// Save event
-	if err := p.repository.SaveEvent(ctx, event); err != nil {
+	failed, err := p.commitEvents(ctx, events, byID)
+	if err != nil {
+		for _, prepared := range repeats {
+			prepared.fail(StageDetect, err)
+		}
 		return err
 	}
 
-	// Cache event signature
-	p.cache.Set(ctx, cacheKey, true, 5*time.Minute)
-
-	// Handle detected anomalies
-	if len(anomalies) > 0 {
-		for _, anomaly := range anomalies {
-			if err := p.repository.SaveAnomaly(ctx, anomaly); err != nil {
-				return err
-			}
+	// Aggregates of groups opened in this batch were taken before their
+	// first event was analysed
+	analysed := make(map[string]*entity.SecurityEvent)
+	for _, prepared := range byID {
+		if prepared.dedupKey != "" && prepared.err == nil {
+			analysed[prepared.dedupKey] = prepared.event
 		}
 	}
-
+	p.commitRepeats(ctx, repeats, failed, analysed)
 	return nil
 }
 
-// BatchProcessLogs processes multiple log entries in batch
-func (p *LogProcessor) BatchProcessLogs(ctx context.Context, logs []string) error {
-	events := make([]*entity.SecurityEvent, 0, len(logs))
+// commitEvents analyses and saves new events. It returns the dedup keys of the
+// events that could not be saved; their groups are closed again.
+func (p *LogProcessor) commitEvents(ctx context.Context, events []*entity.SecurityEvent, byID map[string]*preparedEvent) (map[string]bool, error) {
+	failed := make(map[string]bool)
+	if len(events) == 0 {
+		return failed, nil
+	}
 
-	for _, log := range logs {
-		event, err := p.parseLog(log)
-		if err != nil {
+	// Process events for anomalies
+	anomalies, err := p.detector.ProcessEvents(ctx, events)
+	if err != nil {
+		for _, prepared := range byID {
+			prepared.fail(StageDetect, err)
+			prepared.dedup.Forget(ctx, prepared.dedupKey)
+		}
+		return nil, err
+	}
+
+	// Rescore events with their rule hits and anomalies
+	eventAnomalies := make(map[string][]*entity.AnomalyResult)
+	for _, anomaly := range anomalies {
+		eventAnomalies[anomaly.EventID] = append(eventAnomalies[anomaly.EventID], anomaly)
+	}
+	for _, event := range events {
+		input := ScoreInput{Anomalies: eventAnomalies[event.ID]}
+		if p.rules != nil {
+			input.RuleHits = p.rules.MatchEvent(ctx, event)
+		}
+		if len(input.RuleHits) > 0 || len(input.Anomalies) > 0 {
+			p.enricher.Score(event, input)
+		}
+	}
+
+	// Save events
+	for _, event := range events {
+		prepared := byID[event.ID]
+		if err := p.repository.SaveEvent(ctx, event); err != nil {
+			prepared.fail(StageSave, err)
+			if prepared.dedupKey != "" {
+				failed[prepared.dedupKey] = true
+				prepared.dedup.Forget(ctx, prepared.dedupKey)
+			}
 			continue
 		}
+		// Later repeats carry the severity the event was analysed with
+		prepared.dedup.Update(ctx, prepared.source, prepared.dedupKey, event)
+	}
 
-		if err := p.enricher.Enrich(ctx, event); err != nil {
+	// Handle detected anomalies
+	for _, anomaly := range anomalies {
+		prepared, ok := byID[anomaly.EventID]
+		if ok && prepared.err != nil {
 			continue
 		}
+		if ok {
+			anomaly.TenantID = prepared.event.TenantID
+		}
+		if err := p.repository.SaveAnomaly(ctx, anomaly); err != nil {
+			if ok {
+				prepared.fail(StageSave, err)
+			}
+			continue
+		}
+		if ok {
+			prepared.result.Anomalies++
+		}
+	}
 
-		events = append(events, event)
+	return failed, nil
+}
+
+// commitRepeats counts repeats towards the stateful rules and saves the
+// aggregated events of repeats. Only the latest aggregate of a group within
+// the batch is written, with the analysis of the group's first event when that
+// was analysed in this batch; repeats of a group whose first event failed in
+// this batch fail as well.
+func (p *LogProcessor) commitRepeats(ctx context.Context, repeats []*preparedEvent, failed map[string]bool, analysed map[string]*entity.SecurityEvent) {
+	latest := make(map[string]*preparedEvent, len(repeats))
+	order := make([]string, 0, len(repeats))
+	hits := make(map[string][]entity.RuleHit)
+	for _, prepared := range repeats {
+		if failed[prepared.dedupKey] {
+			prepared.fail(StageSave, fmt.Errorf("first event of the group was not saved"))
+			continue
+		}
+		if _, ok := latest[prepared.event.ID]; !ok {
+			order = append(order, prepared.event.ID)
+		}
+		latest[prepared.event.ID] = prepared
+		if p.rules != nil {
+			hits[prepared.event.ID] = append(hits[prepared.event.ID], p.rules.ObserveEvent(ctx, prepared.observed)...)
+		}
 	}
 
-	if len(events) > 0 {
-		if _, err := p.detector.ProcessEvents(ctx, events); err != nil {
-			return err
+	for _, id := range order {
+		aggregate := latest[id]
+		if first, ok := analysed[aggregate.dedupKey]; ok {
+			adoptAnalysis(aggregate.event, first)
+		}
+		if len(hits[id]) > 0 && p.escalate(aggregate.event, hits[id]) {
+			aggregate.dedup.Update(ctx, aggregate.source, aggregate.dedupKey, aggregate.event)
 		}
+		if err := p.repository.SaveEvent(ctx, aggregate.event); err != nil {
+			for _, prepared := range repeats {
+				if prepared.event.ID == id && prepared.err == nil {
+					prepared.fail(StageSave, err)
+				}
+			}
+		}
+	}
+}
+
+// escalate rescores an aggregate with the rules its repeats made fire. It
+// only ever raises the severity, since the hits and anomalies of the group's
+// first event are not known here.
+func (p *LogProcessor) escalate(aggregate *entity.SecurityEvent, hits []entity.RuleHit) bool {
+	rescored := *aggregate
+	score := p.enricher.Score(&rescored, ScoreInput{RuleHits: hits})
+	if aggregate.Score != nil && score.Total <= aggregate.Score.Total {
+		return false
 	}
+	aggregate.Severity = rescored.Severity
+	aggregate.Score = rescored.Score
+	return true
+}
 
-	return nil
+func (p *LogProcessor) parse(source, sourceType, rawLog string) (*entity.SecurityEvent, string, error) {
+	if sourceType == "" {
+		return p.parsers.ParseSource(source, rawLog)
+	}
+	event, err := p.parsers.ParseWithSource(sourceType, source, rawLog)
+	if errors.Is(err, ErrUnknownParser) {
+		return nil, "", err
+	}
+	return event, sourceType, err
 }
 
-// parseLog parses a raw log entry into a SecurityEvent
-func (p *LogProcessor) parseLog(rawLog string) (*entity.SecurityEvent, error) {
-	event := entity.NewSecurityEvent()
-	event.RawData = rawLog
-	
-	var logData struct {
-		Timestamp   string `json:"timestamp"`
-		SourceIP    string `json:"source_ip"`
-		DestIP      string `json:"dest_ip"`
-		Protocol    string `json:"protocol"`
-		EventType   string `json:"event_type"`
-		Description string `json:"description"`
-	}
-	
-	if err := json.Unmarshal([]byte(rawLog), &logData); err != nil {
+// LoadMappings loads declarative JSON field mappings from a YAML file and
+// registers one parser per source type. Reloading replaces mappings of the
+// same name; nothing is registered if any mapping is invalid.
+func (p *LogProcessor) LoadMappings(path string) ([]string, error) {
+	parsers, err := LoadMappingFile(path)
+	if err != nil {
 		return nil, err
 	}
-	
-	// Parse timestamp
-	if t, err := time.Parse(time.RFC3339, logData.Timestamp); err == nil {
-		event.Timestamp = t
-	}
-	
-	// Map other fields
-	event.SourceIP = logData.SourceIP
-	event.DestIP = logData.DestIP
-	event.Protocol = logData.Protocol
-	event.EventType = logData.EventType
-	event.Description = logData.Description
-	
-	return event, nil
-}
-
-// generateCacheKey generates a cache key for deduplication
-func (p *LogProcessor) generateCacheKey(event *entity.SecurityEvent) string {
-	// Using a simplified key for demonstration, consider more unique fields in a real scenario
-	// Using timestamp up to minute to group events within a short time frame
-	return "event:" + event.SourceIP + ":" + event.DestIP + ":" + event.Protocol + ":" + event.EventType + ":" + event.Timestamp.Format("200601021504")
+
+	names := make([]string, 0, len(parsers))
+	for _, parser := range parsers {
+		if existing, ok := p.parsers.Get(parser.Name()); ok {
+			if _, isMapping := existing.(*MappingParser); !isMapping {
+				return nil, fmt.Errorf("mapping %s would shadow a built-in parser", parser.Name())
+			}
+		}
+	}
+	for _, parser := range parsers {
+		if err := p.parsers.Replace(parser); err != nil {
+			return names, err
+		}
+		names = append(names, parser.Name())
+	}
+	return names, nil
 }
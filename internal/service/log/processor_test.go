@@ -0,0 +1,526 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/service/redact"
+)
+
+// memoryEvents is an in-memory event repository
+type memoryEvents struct {
+	mutex     sync.Mutex
+	events    map[string]*entity.SecurityEvent
+	anomalies []*entity.AnomalyResult
+	saves     int
+}
+
+func newMemoryEvents() *memoryEvents {
+	return &memoryEvents{events: make(map[string]*entity.SecurityEvent)}
+}
+
+func (r *memoryEvents) SaveEvent(ctx context.Context, event *entity.SecurityEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	copied := *event
+	copied.Labels = append([]string(nil), event.Labels...)
+	r.events[event.ID] = &copied
+	r.saves++
+	return nil
+}
+
+func (r *memoryEvents) FindEventByID(ctx context.Context, id string) (*entity.SecurityEvent, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	event, ok := r.events[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return event, nil
+}
+
+func (r *memoryEvents) FindEventsByTimeRange(ctx context.Context, start, end time.Time) ([]*entity.SecurityEvent, error) {
+	return nil, nil
+}
+
+func (r *memoryEvents) SaveAnomaly(ctx context.Context, anomaly *entity.AnomalyResult) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.anomalies = append(r.anomalies, anomaly)
+	return nil
+}
+
+func (r *memoryEvents) FindAnomaliesByEventID(ctx context.Context, eventID string) ([]*entity.AnomalyResult, error) {
+	return nil, nil
+}
+
+func (r *memoryEvents) get(id string) *entity.SecurityEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.events[id]
+}
+
+// memoryCache is an in-memory cache repository ignoring expiration
+type memoryCache struct {
+	mutex  sync.Mutex
+	values map[string]interface{}
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{values: make(map[string]interface{})}
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[key] = value
+	return nil
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	value, ok := c.values[key]
+	if !ok {
+		return nil, errors.New("cache miss")
+	}
+	return value, nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.values, key)
+	return nil
+}
+
+// stubDetector returns fixed anomalies or fails every batch
+type stubDetector struct {
+	err   error
+	score map[string]float32 // anomaly score by event type
+}
+
+func (d *stubDetector) ProcessEvents(ctx context.Context, events []*entity.SecurityEvent) ([]*entity.AnomalyResult, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	var anomalies []*entity.AnomalyResult
+	for _, event := range events {
+		if score, ok := d.score[event.EventType]; ok {
+			anomaly := entity.NewAnomalyResult(event.ID, score)
+			anomaly.AnomalyType = "test"
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies, nil
+}
+
+// emptyIntel knows nothing about any IP address
+type emptyIntel struct{}
+
+func (emptyIntel) Lookup(ip string) (*GeoData, error) { return &GeoData{}, nil }
+
+func (emptyIntel) LookupIP(ctx context.Context, ip string) (*ThreatInfo, error) {
+	return &ThreatInfo{}, nil
+}
+
+// memoryDeadLetters is an in-memory dead letter store
+type memoryDeadLetters struct {
+	mutex   sync.Mutex
+	entries map[string]*DeadLetter
+	// err fails every Put
+	err error
+}
+
+func newMemoryDeadLetters() *memoryDeadLetters {
+	return &memoryDeadLetters{entries: make(map[string]*DeadLetter)}
+}
+
+func (s *memoryDeadLetters) Put(ctx context.Context, entries ...*DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for _, entry := range entries {
+		s.entries[entry.ID] = entry
+	}
+	return nil
+}
+
+func (s *memoryDeadLetters) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	return entry, nil
+}
+
+func (s *memoryDeadLetters) List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var entries []*DeadLetter
+	for _, entry := range s.entries {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, len(entries), nil
+}
+
+func (s *memoryDeadLetters) Delete(ctx context.Context, ids ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		delete(s.entries, id)
+	}
+	return nil
+}
+
+func (s *memoryDeadLetters) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+func newTestProcessor(detector EventDetector) (*LogProcessor, *memoryEvents, *memoryDeadLetters) {
+	events := newMemoryEvents()
+	deadLetters := newMemoryDeadLetters()
+	processor := NewLogProcessor(detector, events, newMemoryCache(), NewLogEnricher(emptyIntel{}, emptyIntel{}))
+	processor.SetDeadLetterStore(deadLetters)
+	return processor, events, deadLetters
+}
+
+const testLogLine = `{"timestamp":"2024-05-01T10:00:00Z","source_ip":"10.0.0.1","dest_ip":"10.0.0.2","protocol":"TCP","port":22,"event_type":"login","action":"deny"}`
+
+func TestBatchProcessLogsDeadLetters(t *testing.T) {
+	tests := []struct {
+		name        string
+		detector    *stubDetector
+		logs        []string
+		attempts    int
+		wantErr     bool
+		wantLetters int
+	}{
+		{
+			name:        "record failure is dead-lettered once",
+			detector:    &stubDetector{},
+			logs:        []string{testLogLine, "not a log line \x00"},
+			attempts:    1,
+			wantLetters: 1,
+		},
+		{
+			name:        "batch failure is left to the retrying caller",
+			detector:    &stubDetector{err: errors.New("model unavailable")},
+			logs:        []string{testLogLine, "not a log line \x00"},
+			attempts:    3,
+			wantErr:     true,
+			wantLetters: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, _, deadLetters := newTestProcessor(tt.detector)
+			for i := 0; i < tt.attempts; i++ {
+				_, err := processor.BatchProcessLogs(context.Background(), tt.logs)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("attempt %d: err = %v, wantErr %v", i, err, tt.wantErr)
+				}
+			}
+			if got := deadLetters.len(); got != tt.wantLetters {
+				t.Errorf("dead letters = %d, want %d", got, tt.wantLetters)
+			}
+		})
+	}
+}
+
+func TestRedriveSourceType(t *testing.T) {
+	// The mapping has no match rules, so its records are only parsed with it
+	// when submitted for its source type
+	mapping := func(t *testing.T, required bool) Parser {
+		parsers, err := LoadMappings([]byte(fmt.Sprintf(`
+mappings:
+  - name: okta
+    fields:
+      - {source: $.user, target: user}
+      - {source: $.ip, target: source_ip, required: %v}
+`, required)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsers[0]
+	}
+	const raw = `{"user":"alice"}`
+
+	tests := []struct {
+		name         string
+		fixed        bool
+		wantResolved bool
+	}{
+		{name: "still failing with the submitted parser", wantResolved: false},
+		{name: "resolved after the parser fix", fixed: true, wantResolved: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, events, deadLetters := newTestProcessor(&stubDetector{})
+			processor.Parsers().MustRegister(mapping(t, true))
+			if results, _ := processor.BatchProcessLogsAs(context.Background(), "okta", []string{raw}); results[0].Error == "" {
+				t.Fatalf("record without the required field parsed: %+v", results[0])
+			}
+			entries, _, _ := deadLetters.List(context.Background(), DeadLetterFilter{})
+			if len(entries) != 1 || entries[0].SourceType != "okta" {
+				t.Fatalf("dead letters = %+v", entries)
+			}
+
+			if tt.fixed {
+				if err := processor.Parsers().Replace(mapping(t, false)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			redriven, err := processor.Redrive(context.Background(), []string{entries[0].ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if redriven[0].Resolved != tt.wantResolved || redriven[0].Result.Parser != "okta" {
+				t.Errorf("redriven %+v, result %+v", redriven[0], redriven[0].Result)
+			}
+			wantLetters, wantSaves := 1, 0
+			if tt.wantResolved {
+				wantLetters, wantSaves = 0, 1
+			}
+			if deadLetters.len() != wantLetters || events.saves != wantSaves {
+				t.Errorf("dead letters %d, saved events %d", deadLetters.len(), events.saves)
+			}
+		})
+	}
+}
+
+func TestDeadLetterWriteFailure(t *testing.T) {
+	logs := []string{testLogLine, "not a log line \x00"}
+	tests := []struct {
+		name    string
+		process func(processor *LogProcessor) []*ProcessResult
+	}{
+		{
+			name: "processor",
+			process: func(processor *LogProcessor) []*ProcessResult {
+				results, _ := processor.BatchProcessLogs(context.Background(), logs)
+				return results
+			},
+		},
+		{
+			name: "pipeline",
+			process: func(processor *LogProcessor) []*ProcessResult {
+				pipeline := NewPipeline(processor, PipelineConfig{Workers: 1, Writers: 1, FlushInterval: time.Millisecond})
+				pipeline.Start(context.Background())
+				defer pipeline.Stop()
+				results, _ := pipeline.BatchProcessLogs(context.Background(), logs)
+				if got := pipeline.Stats().DeadLetterErrors; got != 1 {
+					t.Errorf("dead letter errors = %d, want 1", got)
+				}
+				return results
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, _, deadLetters := newTestProcessor(&stubDetector{})
+			deadLetters.err = errors.New("disk full")
+
+			results := tt.process(processor)
+			if results[0].DeadLetterError != "" {
+				t.Errorf("successful record: %+v", results[0])
+			}
+			if results[1].Error == "" || results[1].DeadLetterError != "disk full" {
+				t.Errorf("failed record: %+v", results[1])
+			}
+		})
+	}
+}
+
+func TestProcessorRedaction(t *testing.T) {
+	redactor, err := redact.New(redact.Config{Destinations: map[string]redact.Policy{
+		redact.DestinationStorage: {Rules: []redact.Rule{{Detector: redact.DetectorEmail}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor, events, deadLetters := newTestProcessor(&stubDetector{})
+	cache := newMemoryCache()
+	processor.cache = cache
+	if err := processor.SetDedupConfig(DefaultDedupConfig()); err != nil {
+		t.Fatal(err)
+	}
+	processor.SetRedactor(redactor)
+
+	line := `{"timestamp":"2024-05-01T10:00:00Z","source_ip":"10.0.0.1","dest_ip":"10.0.0.2","protocol":"TCP","event_type":"login","user":"alice@example.com"}`
+	results, err := processor.BatchProcessLogs(context.Background(), []string{line, line, "bad alice@example.com \x00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := events.get(results[0].EventID)
+	if saved == nil || strings.Contains(saved.User+saved.RawData, "alice@") {
+		t.Errorf("saved event was not redacted: %+v", saved)
+	}
+	for key, value := range cache.values {
+		if strings.Contains(fmt.Sprint(value), "alice@") {
+			t.Errorf("cached aggregate %s was not redacted", key)
+		}
+	}
+	letters, _, _ := deadLetters.List(context.Background(), DeadLetterFilter{})
+	if len(letters) != 1 || strings.Contains(letters[0].Raw, "alice@") {
+		t.Errorf("dead letters = %+v", letters)
+	}
+}
+
+// stubRules hits every event with one rule of a fixed severity
+type stubRules struct {
+	severity string
+}
+
+func (r *stubRules) MatchEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit {
+	return []entity.RuleHit{{RuleID: "r-1", RuleName: "test", Severity: r.severity}}
+}
+
+func (r *stubRules) ObserveEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit {
+	return nil
+}
+
+// countingRules is a threshold rule firing once every limit occurrences
+type countingRules struct {
+	mutex sync.Mutex
+	limit int
+	ids   []string
+}
+
+func (r *countingRules) MatchEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit {
+	return r.ObserveEvent(ctx, event)
+}
+
+func (r *countingRules) ObserveEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.ids = append(r.ids, event.ID)
+	if len(r.ids) < r.limit {
+		return nil
+	}
+	hit := entity.RuleHit{RuleID: "threshold", Severity: "critical", EventIDs: r.ids}
+	r.ids = nil
+	return []entity.RuleHit{hit}
+}
+
+func TestProcessorRepeatsKeepAnalysis(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]string
+	}{
+		{name: "repeats in the same batch", batches: [][]string{{testLogLine, testLogLine, testLogLine}}},
+		{name: "repeats in later batches", batches: [][]string{{testLogLine}, {testLogLine}, {testLogLine}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, events, _ := newTestProcessor(&stubDetector{score: map[string]float32{"login": 0.95}})
+			processor.SetRuleEvaluator(&stubRules{severity: "critical"})
+
+			var id string
+			for _, batch := range tt.batches {
+				results, err := processor.BatchProcessLogs(context.Background(), batch)
+				if err != nil {
+					t.Fatal(err)
+				}
+				id = results[0].EventID
+			}
+
+			saved := events.get(id)
+			if saved == nil {
+				t.Fatal("event was not saved")
+			}
+			if count, _ := saved.GetLabel("count"); count != "3" {
+				t.Errorf("count = %q, want 3", count)
+			}
+			if saved.Severity != "critical" || saved.Score == nil || len(saved.Score.Components) != 3 {
+				t.Errorf("aggregate lost the analysis: severity %q, score %+v", saved.Severity, saved.Score)
+			}
+		})
+	}
+}
+
+func TestProcessorRepeatsReachStatefulRules(t *testing.T) {
+	tests := []struct {
+		name         string
+		batches      [][]string
+		wantSeverity string
+	}{
+		{
+			name:         "threshold reached by repeats in one batch",
+			batches:      [][]string{{testLogLine, testLogLine, testLogLine, testLogLine, testLogLine}},
+			wantSeverity: "high",
+		},
+		{
+			name:         "threshold reached across batches",
+			batches:      [][]string{{testLogLine, testLogLine}, {testLogLine, testLogLine}, {testLogLine}},
+			wantSeverity: "high",
+		},
+		{
+			name:         "escalation survives later repeats",
+			batches:      [][]string{{testLogLine, testLogLine, testLogLine, testLogLine, testLogLine}, {testLogLine}},
+			wantSeverity: "high",
+		},
+		{
+			name:         "below the threshold",
+			batches:      [][]string{{testLogLine, testLogLine, testLogLine, testLogLine}},
+			wantSeverity: "low",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor, events, _ := newTestProcessor(&stubDetector{})
+			rules := &countingRules{limit: 5}
+			processor.SetRuleEvaluator(rules)
+
+			var id string
+			for _, batch := range tt.batches {
+				results, err := processor.BatchProcessLogs(context.Background(), batch)
+				if err != nil {
+					t.Fatal(err)
+				}
+				id = results[0].EventID
+			}
+
+			saved := events.get(id)
+			if saved == nil {
+				t.Fatal("event was not saved")
+			}
+			if saved.Severity != tt.wantSeverity {
+				t.Errorf("severity = %q, want %q (score %+v)", saved.Severity, tt.wantSeverity, saved.Score)
+			}
+			for _, observed := range rules.ids {
+				if observed != id {
+					t.Errorf("repeat counted as %s, want the aggregate %s", observed, id)
+				}
+			}
+		})
+	}
+}
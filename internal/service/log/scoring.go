@@ -0,0 +1,286 @@
+package log
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"gopkg.in/yaml.v2"
+)
+
+// Scoring factor types
+const (
+	// FactorReputation reads a 0-1 reputation score from a label
+	FactorReputation = "reputation"
+	// FactorCriticality maps the criticality level in a label
+	FactorCriticality = "criticality"
+	// FactorAction maps the event action
+	FactorAction = "action"
+	// FactorLabel maps the value of a label, or counts its presence
+	FactorLabel = "label"
+	// FactorRuleHits maps the highest severity of the matched rules
+	FactorRuleHits = "rule_hits"
+	// FactorAnomaly takes the highest anomaly score of the event
+	FactorAnomaly = "anomaly"
+)
+
+// ScoringFactor is one weighted input of the severity score. Its value is
+// between 0 and 1 and contributes value*weight to the total.
+type ScoringFactor struct {
+	Name   string  `yaml:"name" json:"name"`
+	Type   string  `yaml:"type" json:"type"`
+	Weight float64 `yaml:"weight" json:"weight"`
+	// Field is the label read by reputation, criticality and label factors
+	Field string `yaml:"field,omitempty" json:"field,omitempty"`
+	// Values maps actions, criticality levels, label values or rule
+	// severities to factor values
+	Values map[string]float64 `yaml:"values,omitempty" json:"values,omitempty"`
+}
+
+// ScoringPolicy turns enrichment, rule hits and anomalies into a severity.
+// The severity is the highest threshold the total score reaches.
+type ScoringPolicy struct {
+	Factors []ScoringFactor `yaml:"factors" json:"factors"`
+	// Thresholds map severities to the minimum total score
+	Thresholds map[string]float64 `yaml:"thresholds" json:"thresholds"`
+	// DefaultSeverity applies when no threshold is reached
+	DefaultSeverity string `yaml:"default_severity" json:"default_severity"`
+
+	// levels are the thresholds, highest first
+	levels []severityLevel
+}
+
+type severityLevel struct {
+	severity string
+	min      float64
+}
+
+// ScoreInput carries the results of detection that become available after
+// enrichment
+type ScoreInput struct {
+	RuleHits  []entity.RuleHit
+	Anomalies []*entity.AnomalyResult
+}
+
+// DefaultScoringPolicy returns the former fixed weights of reputation, asset
+// criticality and action, extended by rule hits and anomalies
+func DefaultScoringPolicy() *ScoringPolicy {
+	policy := &ScoringPolicy{
+		Factors: []ScoringFactor{
+			{Name: "source_reputation", Type: FactorReputation, Field: "source_reputation", Weight: 0.4},
+			{Name: "dest_reputation", Type: FactorReputation, Field: "dest_reputation", Weight: 0.3},
+			{Name: "asset_criticality", Type: FactorCriticality, Field: "asset_criticality", Weight: 0.2,
+				Values: map[string]float64{entity.CriticalityCritical: 1, entity.CriticalityHigh: 0.5}},
+			{Name: "action", Type: FactorAction, Weight: 0.2,
+				Values: map[string]float64{"block": 1, "deny": 1, "alert": 1, "warning": 0.5}},
+			{Name: "rule_hits", Type: FactorRuleHits, Weight: 0.4},
+			{Name: "anomaly", Type: FactorAnomaly, Weight: 0.3},
+		},
+		Thresholds:      map[string]float64{"critical": 0.7, "high": 0.5, "medium": 0.3},
+		DefaultSeverity: "low",
+	}
+	policy.compile()
+	return policy
+}
+
+// defaultRuleSeverities are the values of rule hits by rule severity
+var defaultRuleSeverities = map[string]float64{"critical": 1, "high": 0.75, "medium": 0.5, "low": 0.25}
+
+// LoadScoringPolicyFile reads and validates a YAML scoring policy
+func LoadScoringPolicyFile(path string) (*ScoringPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scoring policy: %v", err)
+	}
+	return LoadScoringPolicy(data)
+}
+
+// LoadScoringPolicy parses and validates a YAML scoring policy
+func LoadScoringPolicy(data []byte) (*ScoringPolicy, error) {
+	var policy ScoringPolicy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse scoring policy: %v", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate checks the policy and prepares it for scoring
+func (p *ScoringPolicy) Validate() error {
+	names := make(map[string]bool, len(p.Factors))
+	for i, factor := range p.Factors {
+		if factor.Name == "" {
+			return fmt.Errorf("factors[%d]: name is required", i)
+		}
+		if names[factor.Name] {
+			return fmt.Errorf("factors[%d]: duplicate factor %q", i, factor.Name)
+		}
+		names[factor.Name] = true
+
+		switch factor.Type {
+		case FactorReputation, FactorCriticality, FactorLabel:
+			if factor.Field == "" && factor.Type != FactorCriticality {
+				return fmt.Errorf("factor %s: %s factors need a field", factor.Name, factor.Type)
+			}
+		case FactorAction:
+			if len(factor.Values) == 0 {
+				return fmt.Errorf("factor %s: action factors need values", factor.Name)
+			}
+		case FactorRuleHits, FactorAnomaly:
+		default:
+			return fmt.Errorf("factor %s: unknown type %q", factor.Name, factor.Type)
+		}
+		if factor.Weight < 0 || math.IsNaN(factor.Weight) {
+			return fmt.Errorf("factor %s: weight must not be negative", factor.Name)
+		}
+		for key, value := range factor.Values {
+			if value < 0 || value > 1 {
+				return fmt.Errorf("factor %s: value of %q must be between 0 and 1", factor.Name, key)
+			}
+		}
+	}
+
+	if len(p.Thresholds) == 0 {
+		return fmt.Errorf("scoring policy needs at least one threshold")
+	}
+	if p.DefaultSeverity == "" {
+		p.DefaultSeverity = "low"
+	}
+	p.compile()
+	return nil
+}
+
+func (p *ScoringPolicy) compile() {
+	p.levels = p.levels[:0]
+	for severity, min := range p.Thresholds {
+		p.levels = append(p.levels, severityLevel{severity: severity, min: min})
+	}
+	sort.Slice(p.levels, func(i, j int) bool {
+		if p.levels[i].min != p.levels[j].min {
+			return p.levels[i].min > p.levels[j].min
+		}
+		return p.levels[i].severity < p.levels[j].severity
+	})
+}
+
+// Score computes the severity of an event and records the breakdown on it
+func (p *ScoringPolicy) Score(event *entity.SecurityEvent, input ScoreInput) *entity.SeverityScore {
+	score := &entity.SeverityScore{Components: make([]entity.ScoreComponent, 0)}
+	for _, factor := range p.Factors {
+		value, detail := factor.value(event, input)
+		if value == 0 || factor.Weight == 0 {
+			continue
+		}
+		contribution := round(value * factor.Weight)
+		score.Total += contribution
+		score.Components = append(score.Components, entity.ScoreComponent{
+			Factor:       factor.Name,
+			Type:         factor.Type,
+			Value:        round(value),
+			Weight:       factor.Weight,
+			Contribution: contribution,
+			Detail:       detail,
+		})
+	}
+	score.Total = round(score.Total)
+
+	score.Severity = p.DefaultSeverity
+	for _, level := range p.levels {
+		if score.Total >= level.min {
+			score.Severity = level.severity
+			break
+		}
+	}
+
+	event.Severity = score.Severity
+	event.Score = score
+	return score
+}
+
+// value returns the factor value for an event and what it was derived from
+func (f *ScoringFactor) value(event *entity.SecurityEvent, input ScoreInput) (float64, string) {
+	switch f.Type {
+	case FactorReputation:
+		label, ok := event.GetLabel(f.Field)
+		if !ok {
+			return 0, ""
+		}
+		reputation, err := strconv.ParseFloat(label, 64)
+		if err != nil {
+			return 0, ""
+		}
+		return clamp(reputation), f.Field + "=" + label
+
+	case FactorCriticality:
+		field := f.Field
+		if field == "" {
+			field = "asset_criticality"
+		}
+		level, ok := event.GetLabel(field)
+		if !ok {
+			return 0, ""
+		}
+		values := f.Values
+		if len(values) == 0 {
+			values = map[string]float64{
+				entity.CriticalityCritical: 1, entity.CriticalityHigh: 0.75,
+				entity.CriticalityMedium: 0.5, entity.CriticalityLow: 0.25,
+			}
+		}
+		return values[level], field + "=" + level
+
+	case FactorAction:
+		action := strings.ToLower(event.Action)
+		return f.Values[action], "action=" + action
+
+	case FactorLabel:
+		label, ok := event.GetLabel(f.Field)
+		if !ok || label == "" {
+			return 0, ""
+		}
+		if len(f.Values) == 0 {
+			if label == "false" {
+				return 0, ""
+			}
+			return 1, f.Field + "=" + label
+		}
+		return f.Values[label], f.Field + "=" + label
+
+	case FactorRuleHits:
+		values := f.Values
+		if len(values) == 0 {
+			values = defaultRuleSeverities
+		}
+		best, ids := 0.0, make([]string, 0, len(input.RuleHits))
+		for _, hit := range input.RuleHits {
+			ids = append(ids, hit.RuleID)
+			best = math.Max(best, values[strings.ToLower(hit.Severity)])
+		}
+		return best, strings.Join(ids, ",")
+
+	case FactorAnomaly:
+		best, kind := 0.0, ""
+		for _, anomaly := range input.Anomalies {
+			if float64(anomaly.Score) > best {
+				best, kind = float64(anomaly.Score), anomaly.AnomalyType
+			}
+		}
+		return clamp(best), kind
+	}
+	return 0, ""
+}
+
+func clamp(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
+
+// round keeps scores readable in stored breakdowns
+func round(value float64) float64 {
+	return math.Round(value*1e4) / 1e4
+}
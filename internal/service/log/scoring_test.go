@@ -0,0 +1,180 @@
+package log
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+func TestDefaultScoringPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		action       string
+		input        ScoreInput
+		wantTotal    float64
+		wantSeverity string
+		wantFactors  string
+	}{
+		{name: "no signals", wantTotal: 0, wantSeverity: "low"},
+		{
+			name:         "bad reputation and a denied action",
+			labels:       map[string]string{"source_reputation": "0.9"},
+			action:       "DENY",
+			wantTotal:    0.56,
+			wantSeverity: "high",
+			wantFactors:  "source_reputation,action",
+		},
+		{
+			name:         "reputation is clamped",
+			labels:       map[string]string{"source_reputation": "5"},
+			wantTotal:    0.4,
+			wantSeverity: "medium",
+			wantFactors:  "source_reputation",
+		},
+		{
+			name:         "unreadable reputation is ignored",
+			labels:       map[string]string{"source_reputation": "bad"},
+			wantSeverity: "low",
+		},
+		{
+			name:         "critical asset",
+			labels:       map[string]string{"asset_criticality": entity.CriticalityCritical},
+			wantTotal:    0.2,
+			wantSeverity: "low",
+			wantFactors:  "asset_criticality",
+		},
+		{
+			name: "highest rule hit and anomaly",
+			input: ScoreInput{
+				RuleHits:  []entity.RuleHit{{RuleID: "r1", Severity: "low"}, {RuleID: "r2", Severity: "Critical"}},
+				Anomalies: []*entity.AnomalyResult{entity.NewAnomalyResult("e", 0.2), entity.NewAnomalyResult("e", 1)},
+			},
+			wantTotal:    0.7,
+			wantSeverity: "critical",
+			wantFactors:  "rule_hits,anomaly",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := entity.NewSecurityEvent()
+			event.Action = tt.action
+			for key, value := range tt.labels {
+				event.SetLabel(key, value)
+			}
+
+			score := DefaultScoringPolicy().Score(event, tt.input)
+			if score.Total != tt.wantTotal || score.Severity != tt.wantSeverity {
+				t.Errorf("score = %v %s, want %v %s", score.Total, score.Severity, tt.wantTotal, tt.wantSeverity)
+			}
+			if event.Severity != score.Severity || event.Score != score {
+				t.Errorf("score not recorded on the event")
+			}
+			factors := make([]string, 0, len(score.Components))
+			for _, component := range score.Components {
+				factors = append(factors, component.Factor)
+			}
+			if got := strings.Join(factors, ","); got != tt.wantFactors {
+				t.Errorf("factors = %s, want %s", got, tt.wantFactors)
+			}
+		})
+	}
+}
+
+func TestScoringPolicyRuleHitDetail(t *testing.T) {
+	event := entity.NewSecurityEvent()
+	score := DefaultScoringPolicy().Score(event, ScoreInput{RuleHits: []entity.RuleHit{
+		{RuleID: "r1", Severity: "medium"}, {RuleID: "r2", Severity: "high"},
+	}})
+	if len(score.Components) != 1 {
+		t.Fatalf("components = %+v", score.Components)
+	}
+	component := score.Components[0]
+	if component.Value != 0.75 || component.Contribution != 0.3 || component.Detail != "r1,r2" {
+		t.Errorf("component = %+v", component)
+	}
+}
+
+func TestLoadScoringPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+		labels  map[string]string
+		want    string
+	}{
+		{
+			name: "label factors",
+			yaml: `
+factors:
+  - name: tor
+    type: label
+    field: threat_tor
+    weight: 0.6
+  - name: zone
+    type: label
+    field: zone
+    weight: 0.5
+    values: {dmz: 1, lan: 0.2}
+thresholds: {critical: 0.9, high: 0.5}
+`,
+			labels: map[string]string{"threat_tor": "true", "zone": "dmz"},
+			want:   "critical",
+		},
+		{
+			name: "presence of false does not count",
+			yaml: `
+factors:
+  - {name: tor, type: label, field: threat_tor, weight: 1}
+thresholds: {high: 0.5}
+default_severity: info
+`,
+			labels: map[string]string{"threat_tor": "false"},
+			want:   "info",
+		},
+		{
+			name: "criticality without a field reads the asset label",
+			yaml: `
+factors:
+  - {name: asset, type: criticality, weight: 1}
+thresholds: {high: 0.7, medium: 0.4}
+`,
+			labels: map[string]string{"asset_criticality": entity.CriticalityMedium},
+			want:   "medium",
+		},
+		{name: "unknown key", yaml: "factors: []\nthreshold: {high: 1}", wantErr: "parse scoring policy"},
+		{name: "no thresholds", yaml: "factors: []", wantErr: "at least one threshold"},
+		{name: "missing name", yaml: "factors: [{type: anomaly, weight: 1}]\nthresholds: {high: 1}", wantErr: "factors[0]: name is required"},
+		{name: "duplicate name", yaml: "factors: [{name: a, type: anomaly}, {name: a, type: rule_hits}]\nthresholds: {high: 1}", wantErr: "duplicate factor"},
+		{name: "unknown type", yaml: "factors: [{name: a, type: geo}]\nthresholds: {high: 1}", wantErr: `unknown type "geo"`},
+		{name: "label without field", yaml: "factors: [{name: a, type: label}]\nthresholds: {high: 1}", wantErr: "need a field"},
+		{name: "action without values", yaml: "factors: [{name: a, type: action}]\nthresholds: {high: 1}", wantErr: "need values"},
+		{name: "negative weight", yaml: "factors: [{name: a, type: anomaly, weight: -1}]\nthresholds: {high: 1}", wantErr: "must not be negative"},
+		{name: "value out of range", yaml: "factors: [{name: a, type: action, values: {deny: 2}}]\nthresholds: {high: 1}", wantErr: "between 0 and 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := LoadScoringPolicy([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event := entity.NewSecurityEvent()
+			for key, value := range tt.labels {
+				event.SetLabel(key, value)
+			}
+			if got := policy.Score(event, ScoreInput{}).Severity; got != tt.want {
+				t.Errorf("severity = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
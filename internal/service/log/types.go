@@ -3,6 +3,8 @@ package log
 import (
 	"context"
 	"time"
+
+	"github.com/jinye/securityai/internal/domain/entity"
 )
 
 // GeoIPDatabase represents a geolocation database
@@ -17,6 +19,36 @@ type ThreatDB interface {
 	LookupIP(ctx context.Context, ip string) (*ThreatInfo, error)
 }
 
+// AssetInventory represents an inventory of internal assets
+type AssetInventory interface {
+	// LookupIP retrieves the asset owning an IP address, or nil if unknown
+	LookupIP(ctx context.Context, ip string) (*AssetInfo, error)
+	// LookupHost retrieves an asset by hostname, or nil if unknown
+	LookupHost(ctx context.Context, hostname string) (*AssetInfo, error)
+}
+
+// UserDirectory represents a directory of user accounts
+type UserDirectory interface {
+	// LookupUser retrieves an account by user name, or nil if unknown
+	LookupUser(ctx context.Context, user string) (*UserInfo, error)
+}
+
+// EventDetector finds anomalies in batches of events. *anomaly.AnomalyDetector
+// implements it.
+type EventDetector interface {
+	// ProcessEvents returns the anomalies found in the events
+	ProcessEvents(ctx context.Context, events []*entity.SecurityEvent) ([]*entity.AnomalyResult, error)
+}
+
+// RuleEvaluator matches events against detection rules
+type RuleEvaluator interface {
+	// MatchEvent returns the rules an event matches
+	MatchEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit
+	// ObserveEvent counts a repeat folded by deduplication towards the
+	// stateful rules only and returns the rules it makes fire
+	ObserveEvent(ctx context.Context, event *entity.SecurityEvent) []entity.RuleHit
+}
+
 // GeoData represents geolocation information
 type GeoData struct {
 	Country   string    `json:"country"`
@@ -38,3 +70,35 @@ type ThreatInfo struct {
 	References []string  `json:"references"`
 	Confidence float32   `json:"confidence"`
 }
+
+// AssetInfo represents the inventory record of an asset
+type AssetInfo struct {
+	ID             string `json:"id"`
+	Hostname       string `json:"hostname"`
+	Owner          string `json:"owner"`
+	BusinessSystem string `json:"business_system"`
+	Environment    string `json:"environment"`
+	Criticality    string `json:"criticality"`
+	NetworkZone    string `json:"network_zone"`
+}
+
+// UserInfo represents the directory record of a user account
+type UserInfo struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	Email       string   `json:"email"`
+	Department  string   `json:"department"`
+	Title       string   `json:"title"`
+	Manager     string   `json:"manager"`
+	Groups      []string `json:"groups"`
+	Privileged  bool     `json:"privileged"`
+	Status      string   `json:"status"`
+}
+
+// Account statuses
+const (
+	UserStatusEnabled  = "enabled"
+	UserStatusDisabled = "disabled"
+	UserStatusLocked   = "locked"
+	UserStatusExpired  = "expired"
+)
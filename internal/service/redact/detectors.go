@@ -0,0 +1,127 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Built-in detectors
+const (
+	DetectorIDCard      = "cn_id_card"
+	DetectorPhone       = "cn_phone"
+	DetectorEmail       = "email"
+	DetectorBankCard    = "bank_card"
+	DetectorBearerToken = "bearer_token"
+	DetectorURLPassword = "url_password"
+)
+
+// detector finds one kind of sensitive value. When the pattern has a "secret"
+// group only that group is redacted, e.g. the password of a URL.
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// valid rejects false positives the pattern cannot rule out
+	valid func(secret string) bool
+	// keepPrefix and keepSuffix are the characters left visible by mask mode
+	keepPrefix int
+	keepSuffix int
+	// mask overrides the default masking of keepPrefix and keepSuffix
+	mask func(secret string) string
+}
+
+var builtinDetectors = map[string]*detector{
+	DetectorIDCard: {
+		name:       DetectorIDCard,
+		pattern:    regexp.MustCompile(`(?:^|[^0-9A-Za-z])(?P<secret>[1-9]\d{5}(?:19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx])(?:$|[^0-9A-Za-z])`),
+		valid:      validIDCard,
+		keepPrefix: 6,
+		keepSuffix: 4,
+	},
+	DetectorPhone: {
+		name:       DetectorPhone,
+		pattern:    regexp.MustCompile(`(?:^|[^0-9])(?:(?:\+|00)86[- ]?)?(?P<secret>1[3-9]\d{9})(?:$|[^0-9])`),
+		keepPrefix: 3,
+		keepSuffix: 4,
+	},
+	DetectorEmail: {
+		name:    DetectorEmail,
+		pattern: regexp.MustCompile(`(?P<secret>[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,})`),
+		mask:    maskEmail,
+	},
+	DetectorBankCard: {
+		name:       DetectorBankCard,
+		pattern:    regexp.MustCompile(`(?:^|[^0-9])(?P<secret>\d{4}(?:[ -]?\d{4}){2}[ -]?\d{1,7})(?:$|[^0-9])`),
+		valid:      validLuhn,
+		keepPrefix: 6,
+		keepSuffix: 4,
+	},
+	DetectorBearerToken: {
+		name:    DetectorBearerToken,
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`),
+	},
+	DetectorURLPassword: {
+		name:    DetectorURLPassword,
+		pattern: regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.\-]*://[^:/@\s]+:(?P<secret>[^@\s/]+)@`),
+	},
+}
+
+// DetectorNames lists the built-in detectors
+func DetectorNames() []string {
+	return []string{DetectorIDCard, DetectorPhone, DetectorEmail, DetectorBankCard, DetectorBearerToken, DetectorURLPassword}
+}
+
+// validIDCard checks the GB 11643 check digit of an 18 digit resident ID
+func validIDCard(id string) bool {
+	weights := [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(id[i]-'0') * weights[i]
+	}
+	return "10X98765432"[sum%11] == strings.ToUpper(id[17:])[0]
+}
+
+// validLuhn checks the Luhn checksum of a card number that may contain
+// separators
+func validLuhn(number string) bool {
+	digits := make([]int, 0, len(number))
+	for _, c := range number {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, int(c-'0'))
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if (len(digits)-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// maskEmail keeps the first character of the local part and the domain
+func maskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return maskMiddle(email, 0, 0)
+	}
+	return email[:1] + strings.Repeat("*", at-1) + email[at:]
+}
+
+// maskMiddle replaces all but the first prefix and last suffix characters
+// with asterisks. Values too short to keep anything are masked completely.
+func maskMiddle(value string, prefix, suffix int) string {
+	runes := []rune(value)
+	if prefix+suffix >= len(runes) {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:prefix]) + strings.Repeat("*", len(runes)-prefix-suffix) + string(runes[len(runes)-suffix:])
+}
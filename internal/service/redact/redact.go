@@ -0,0 +1,353 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+// Destinations events are redacted for
+const (
+	// DestinationStorage is applied before events are saved
+	DestinationStorage = "storage"
+	// DestinationLLM is applied before events are sent to the security LLM
+	DestinationLLM = "llm"
+)
+
+// Redaction modes
+const (
+	// ModeMask replaces the sensitive value with asterisks, keeping a few
+	// characters visible
+	ModeMask = "mask"
+	// ModeHash replaces the sensitive value with a keyed HMAC, so that equal
+	// values stay joinable without being readable
+	ModeHash = "hash"
+	// ModeDrop clears the whole field containing the sensitive value
+	ModeDrop = "drop"
+)
+
+// redactedLabel lists the rules that matched an event
+const redactedLabel = "redacted"
+
+// Rule redacts the values found by a built-in detector or a custom regular
+// expression. A custom expression with a "secret" group only redacts that
+// group.
+type Rule struct {
+	// Name identifies the rule in the redacted label; it defaults to the
+	// detector name
+	Name     string `json:"name,omitempty"     yaml:"name"`
+	Detector string `json:"detector,omitempty" yaml:"detector"`
+	Pattern  string `json:"pattern,omitempty"  yaml:"pattern"`
+	Mode     string `json:"mode"               yaml:"mode"`
+	// KeepPrefix and KeepSuffix override the characters mask mode leaves visible
+	KeepPrefix int `json:"keep_prefix,omitempty" yaml:"keep_prefix"`
+	KeepSuffix int `json:"keep_suffix,omitempty" yaml:"keep_suffix"`
+}
+
+// Policy is the set of rules applied for one destination
+type Policy struct {
+	// Fields are the event fields to scan: raw_data, description, user,
+	// action, status and labels. All of them are scanned by default.
+	Fields []string `json:"fields,omitempty" yaml:"fields"`
+	Rules  []Rule   `json:"rules"            yaml:"rules"`
+}
+
+// Config configures redaction per destination
+type Config struct {
+	// HashKey is the HMAC key of hash mode. It is required when any rule
+	// hashes and must be the same on all instances for hashes to join.
+	HashKey      string            `json:"-"            yaml:"hash_key"`
+	Destinations map[string]Policy `json:"destinations" yaml:"destinations"`
+}
+
+var defaultFields = []string{"raw_data", "description", "user", "action", "status", "labels"}
+
+// Redactor removes personal data and secrets from events before they leave
+// the service
+type Redactor struct {
+	hashKey  []byte
+	policies map[string]*policy
+}
+
+type policy struct {
+	fields []string
+	rules  []*rule
+}
+
+type rule struct {
+	name       string
+	detector   *detector
+	mode       string
+	keepPrefix int
+	keepSuffix int
+}
+
+// New creates a redactor from a configuration
+func New(config Config) (*Redactor, error) {
+	r := &Redactor{
+		hashKey:  []byte(config.HashKey),
+		policies: make(map[string]*policy, len(config.Destinations)),
+	}
+
+	for destination, spec := range config.Destinations {
+		compiled, err := r.compile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("redaction policy %s: %w", destination, err)
+		}
+		r.policies[destination] = compiled
+	}
+	return r, nil
+}
+
+func (r *Redactor) compile(spec Policy) (*policy, error) {
+	p := &policy{fields: spec.Fields}
+	if len(p.fields) == 0 {
+		p.fields = defaultFields
+	}
+	for _, field := range p.fields {
+		if !validField(field) {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(defaultFields, ", "))
+		}
+	}
+
+	for i, spec := range spec.Rules {
+		compiled, err := r.compileRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func (r *Redactor) compileRule(spec Rule) (*rule, error) {
+	compiled := &rule{name: spec.Name, mode: spec.Mode}
+
+	switch {
+	case spec.Detector != "" && spec.Pattern != "":
+		return nil, fmt.Errorf("rule has both a detector and a pattern")
+	case spec.Detector != "":
+		builtin, ok := builtinDetectors[spec.Detector]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q, expected one of %s", spec.Detector, strings.Join(DetectorNames(), ", "))
+		}
+		compiled.detector = builtin
+		if compiled.name == "" {
+			compiled.name = builtin.name
+		}
+	case spec.Pattern != "":
+		pattern, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		if compiled.name == "" {
+			return nil, fmt.Errorf("custom rule needs a name")
+		}
+		compiled.detector = &detector{name: compiled.name, pattern: pattern}
+	default:
+		return nil, fmt.Errorf("rule needs a detector or a pattern")
+	}
+
+	switch compiled.mode {
+	case "":
+		compiled.mode = ModeMask
+	case ModeMask, ModeDrop:
+	case ModeHash:
+		if len(r.hashKey) == 0 {
+			return nil, fmt.Errorf("hash mode requires a hash key")
+		}
+	default:
+		return nil, fmt.Errorf("invalid mode %q", compiled.mode)
+	}
+
+	compiled.keepPrefix = compiled.detector.keepPrefix
+	compiled.keepSuffix = compiled.detector.keepSuffix
+	if spec.KeepPrefix > 0 || spec.KeepSuffix > 0 {
+		compiled.keepPrefix, compiled.keepSuffix = spec.KeepPrefix, spec.KeepSuffix
+	}
+	return compiled, nil
+}
+
+func validField(field string) bool {
+	for _, known := range defaultFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled reports whether a destination has a redaction policy
+func (r *Redactor) Enabled(destination string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.policies[destination]
+	return ok
+}
+
+// Redact returns a copy of the event redacted for a destination. The event
+// itself is not modified; it is returned as is when the destination has no
+// policy. The copy carries a "redacted" label naming the rules that matched.
+func (r *Redactor) Redact(destination string, event *entity.SecurityEvent) *entity.SecurityEvent {
+	if !r.Enabled(destination) || event == nil {
+		return event
+	}
+	p := r.policies[destination]
+
+	redacted := *event
+	redacted.Labels = append([]string(nil), event.Labels...)
+	matched := make(map[string]bool)
+
+	for _, field := range p.fields {
+		switch field {
+		case "raw_data":
+			redacted.RawData = r.apply(p, redacted.RawData, matched)
+		case "description":
+			redacted.Description = r.apply(p, redacted.Description, matched)
+		case "user":
+			redacted.User = r.apply(p, redacted.User, matched)
+		case "action":
+			redacted.Action = r.apply(p, redacted.Action, matched)
+		case "status":
+			redacted.Status = r.apply(p, redacted.Status, matched)
+		case "labels":
+			redacted.Labels = r.applyLabels(p, redacted.Labels, matched)
+		}
+	}
+
+	if len(matched) > 0 {
+		names := make([]string, 0, len(matched))
+		for name := range matched {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		redacted.SetLabel(redactedLabel, strings.Join(names, ","))
+	}
+	return &redacted
+}
+
+// RedactString redacts a single value for a destination, e.g. a raw log that
+// is stored outside of an event. It returns the names of the rules that
+// matched.
+func (r *Redactor) RedactString(destination, value string) (string, []string) {
+	if !r.Enabled(destination) {
+		return value, nil
+	}
+
+	matched := make(map[string]bool)
+	value = r.apply(r.policies[destination], value, matched)
+
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return value, names
+}
+
+// applyLabels redacts the values of "key:value" labels; dropped labels are
+// removed
+func (r *Redactor) applyLabels(p *policy, labels []string, matched map[string]bool) []string {
+	kept := labels[:0]
+	for _, label := range labels {
+		key, value := "", label
+		if i := strings.IndexByte(label, ':'); i >= 0 {
+			key, value = label[:i+1], label[i+1:]
+		}
+		value = r.apply(p, value, matched)
+		if value == "" && label != key {
+			continue
+		}
+		kept = append(kept, key+value)
+	}
+	return kept
+}
+
+// apply runs all rules of a policy over a value
+func (r *Redactor) apply(p *policy, value string, matched map[string]bool) string {
+	for _, rule := range p.rules {
+		if value == "" {
+			return value
+		}
+		redacted, hit := r.applyRule(rule, value)
+		if hit {
+			matched[rule.name] = true
+			value = redacted
+		}
+	}
+	return value
+}
+
+// applyRule replaces every secret a rule finds in a value. Searching resumes
+// right after each secret, so that the boundary characters of the patterns
+// may be shared between neighbouring secrets.
+func (r *Redactor) applyRule(rule *rule, value string) (string, bool) {
+	pattern := rule.detector.pattern
+	group := pattern.SubexpIndex("secret")
+
+	var out strings.Builder
+	hit := false
+	pos := 0
+	for pos <= len(value) {
+		loc := pattern.FindStringSubmatchIndex(value[pos:])
+		if loc == nil {
+			break
+		}
+
+		start, end := loc[0], loc[1]
+		if group > 0 && loc[2*group] >= 0 {
+			start, end = loc[2*group], loc[2*group+1]
+		}
+		start, end = start+pos, end+pos
+		if end == start {
+			// Empty matches cannot be redacted; move past them
+			if loc[1]+pos >= len(value) {
+				break
+			}
+			out.WriteString(value[pos : loc[1]+pos+1])
+			pos = loc[1] + pos + 1
+			continue
+		}
+
+		secret := value[start:end]
+		if rule.detector.valid != nil && !rule.detector.valid(secret) {
+			out.WriteString(value[pos:end])
+			pos = end
+			continue
+		}
+
+		if rule.mode == ModeDrop {
+			return "", true
+		}
+		hit = true
+		out.WriteString(value[pos:start])
+		out.WriteString(r.replace(rule, secret))
+		pos = end
+	}
+
+	if !hit {
+		return value, false
+	}
+	out.WriteString(value[pos:])
+	return out.String(), true
+}
+
+// replace returns the replacement of a secret in mask or hash mode
+func (r *Redactor) replace(rule *rule, secret string) string {
+	if rule.mode == ModeHash {
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(secret))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	if rule.detector.mask != nil {
+		return rule.detector.mask(secret)
+	}
+	return maskMiddle(secret, rule.keepPrefix, rule.keepSuffix)
+}
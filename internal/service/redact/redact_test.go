@@ -0,0 +1,153 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		key     string
+		value   string
+		want    string
+		matched bool
+	}{
+		{
+			name:    "email is masked",
+			rule:    Rule{Detector: DetectorEmail},
+			value:   "login by alice@example.com",
+			want:    "login by a****@example.com",
+			matched: true,
+		},
+		{
+			name:    "phone keeps prefix and suffix",
+			rule:    Rule{Detector: DetectorPhone},
+			value:   "tel 13812345678.",
+			want:    "tel 138****5678.",
+			matched: true,
+		},
+		{
+			name:    "bank card failing luhn is kept",
+			rule:    Rule{Detector: DetectorBankCard},
+			value:   "card 4111 1111 1111 1112",
+			want:    "card 4111 1111 1111 1112",
+			matched: false,
+		},
+		{
+			name:    "bank card passing luhn is masked",
+			rule:    Rule{Detector: DetectorBankCard},
+			value:   "card 4111111111111111",
+			want:    "card 411111******1111",
+			matched: true,
+		},
+		{
+			name:    "url password only",
+			rule:    Rule{Detector: DetectorURLPassword},
+			value:   "dsn=postgres://app:s3cret@db/app",
+			want:    "dsn=postgres://app:******@db/app",
+			matched: true,
+		},
+		{
+			name:    "drop clears the value",
+			rule:    Rule{Detector: DetectorBearerToken, Mode: ModeDrop},
+			value:   "Authorization: Bearer abc.def",
+			want:    "",
+			matched: true,
+		},
+		{
+			name:    "custom pattern with secret group",
+			rule:    Rule{Name: "api_key", Pattern: `key=(?P<secret>\w+)`},
+			value:   "GET /?key=abcdef123",
+			want:    "GET /?key=*********",
+			matched: true,
+		},
+		{
+			name:    "hash is keyed and stable",
+			rule:    Rule{Detector: DetectorEmail, Mode: ModeHash},
+			key:     "k",
+			value:   "bob@example.com",
+			matched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redactor, err := New(Config{
+				HashKey:      tt.key,
+				Destinations: map[string]Policy{DestinationStorage: {Rules: []Rule{tt.rule}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, names := redactor.RedactString(DestinationStorage, tt.value)
+			if tt.rule.Mode == ModeHash {
+				again, _ := redactor.RedactString(DestinationStorage, tt.value)
+				if !strings.HasPrefix(got, "hmac:") || got != again {
+					t.Errorf("hash = %q, %q", got, again)
+				}
+			} else if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if (len(names) > 0) != tt.matched {
+				t.Errorf("matched rules = %v, want matched %v", names, tt.matched)
+			}
+		})
+	}
+}
+
+func TestRedactEvent(t *testing.T) {
+	redactor, err := New(Config{Destinations: map[string]Policy{
+		DestinationStorage: {Fields: []string{"raw_data", "labels"}, Rules: []Rule{{Detector: DetectorEmail}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := entity.NewSecurityEvent()
+	event.RawData = "user=alice@example.com"
+	event.User = "alice@example.com"
+	event.Labels = []string{"mail:bob@example.com"}
+
+	redacted := redactor.Redact(DestinationStorage, event)
+	if redacted.RawData != "user=a****@example.com" {
+		t.Errorf("raw data = %q", redacted.RawData)
+	}
+	if redacted.User != event.User {
+		t.Errorf("user outside the policy fields was redacted: %q", redacted.User)
+	}
+	if value, _ := redacted.GetLabel("mail"); value != "b**@example.com" {
+		t.Errorf("label = %q", value)
+	}
+	if value, _ := redacted.GetLabel(redactedLabel); value != DetectorEmail {
+		t.Errorf("redacted label = %q", value)
+	}
+	if event.RawData != "user=alice@example.com" || len(event.Labels) != 1 {
+		t.Errorf("original event was modified: %+v", event)
+	}
+	if redactor.Redact(DestinationLLM, event) != event {
+		t.Error("destination without policy should return the event as is")
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"unknown detector", Config{Destinations: map[string]Policy{"x": {Rules: []Rule{{Detector: "ssn"}}}}}},
+		{"hash without key", Config{Destinations: map[string]Policy{"x": {Rules: []Rule{{Detector: DetectorEmail, Mode: ModeHash}}}}}},
+		{"unnamed pattern", Config{Destinations: map[string]Policy{"x": {Rules: []Rule{{Pattern: "a+"}}}}}},
+		{"unknown field", Config{Destinations: map[string]Policy{"x": {Fields: []string{"port"}, Rules: []Rule{{Detector: DetectorEmail}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
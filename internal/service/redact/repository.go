@@ -0,0 +1,28 @@
+package redact
+
+import (
+	"context"
+
+	"github.com/jinye/securityai/internal/domain/entity"
+	"github.com/jinye/securityai/internal/domain/repository"
+)
+
+// EventRepository redacts events for the storage destination before they
+// are saved by the wrapped repository
+type EventRepository struct {
+	repository.EventRepository
+	redactor *Redactor
+}
+
+// NewEventRepository wraps an event repository with redaction
+func NewEventRepository(repo repository.EventRepository, redactor *Redactor) *EventRepository {
+	return &EventRepository{
+		EventRepository: repo,
+		redactor:        redactor,
+	}
+}
+
+// SaveEvent saves the redacted copy of an event
+func (r *EventRepository) SaveEvent(ctx context.Context, event *entity.SecurityEvent) error {
+	return r.EventRepository.SaveEvent(ctx, r.redactor.Redact(DestinationStorage, event))
+}